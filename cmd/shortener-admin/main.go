@@ -0,0 +1,122 @@
+package main
+
+// Cmd/shortener-admin/main.go.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const usage = `usage: shortener-admin [-addr URL] [-token TOKEN] command [args]
+
+commands:
+  list <userID>        list links of a user
+  delete <shortID>     delete a short link on behalf of its owner
+  stats                show record counts
+  rotate-key [secret]  rotate the cookie signing key (random if omitted)
+
+ADMIN_TOKEN and SHORTENER_ADDR may be used instead of the flags.
+`
+
+type client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+func main() {
+	addr := flag.String("addr", envOr("SHORTENER_ADDR", "http://localhost:8080"), "shortener base URL")
+	token := flag.String("token", os.Getenv("ADMIN_TOKEN"), "admin API token")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	c := &client{
+		baseURL: strings.TrimSuffix(*addr, "/"),
+		token:   *token,
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}
+	if err := c.run(flag.Arg(0), flag.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "shortener-admin:", err)
+		os.Exit(1)
+	}
+}
+
+func (c *client) run(cmd string, args []string) error {
+	switch {
+	case cmd == "list" && len(args) == 1:
+		return c.do(http.MethodGet, "/api/admin/users/"+url.PathEscape(args[0])+"/urls", nil)
+	case cmd == "delete" && len(args) == 1:
+		return c.do(http.MethodDelete, "/api/admin/urls/"+url.PathEscape(args[0]), nil)
+	case cmd == "stats" && len(args) == 0:
+		return c.do(http.MethodGet, "/api/admin/stats", nil)
+	case cmd == "rotate-key" && len(args) <= 1:
+		var body any
+		if len(args) == 1 {
+			body = map[string]string{"secret": args[0]}
+		}
+		return c.do(http.MethodPost, "/api/admin/keys/rotate", body)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("bad command or arguments: %s %s", cmd, strings.Join(args, " "))
+	}
+}
+
+// do выполняет запрос к админскому API и печатает тело ответа в stdout.
+func (c *client) do(method, path string, body any) error {
+	var reqBody io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("encode request: %w", err)
+		}
+		reqBody = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequest(method, c.baseURL+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	// Ответы короткие, а сжатые ошибки сервер отдаёт без Content-Encoding.
+	req.Header.Set("Accept-Encoding", "identity")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return errors.New(resp.Status + ": " + strings.TrimSpace(string(respBody)))
+	}
+	if len(respBody) > 0 {
+		_, _ = os.Stdout.Write(respBody)
+	}
+	return nil
+}
+
+func envOr(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,128 @@
+package main
+
+// Cmd/shortener/backup.go.
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/dkolesni-prog/transformer/internal/config"
+	"github.com/dkolesni-prog/transformer/internal/logging"
+	"github.com/dkolesni-prog/transformer/internal/store"
+)
+
+const restoreBatchSize = 500
+
+// backup выгружает все записи хранилища построчно в JSON: shortener backup -o dump.jsonl.
+func backup(cfg *config.Config, logger logging.Logger, args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	output := fs.String("o", "-", "dump file, - for stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	storage, err := openStorage(ctx, cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = storage.Close(ctx) }()
+
+	var out io.Writer = os.Stdout
+	if *output != "-" {
+		f, createErr := os.OpenFile(*output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+		if createErr != nil {
+			return fmt.Errorf("create dump: %w", createErr)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	w := bufio.NewWriter(out)
+	enc := json.NewEncoder(w)
+	count := 0
+	exportErr := storage.ExportRecords(ctx, func(rec store.Record) error {
+		count++
+		return enc.Encode(rec)
+	})
+	if exportErr != nil {
+		return fmt.Errorf("export records: %w", exportErr)
+	}
+	if flushErr := w.Flush(); flushErr != nil {
+		return fmt.Errorf("write dump: %w", flushErr)
+	}
+	logger.Info("Backup finished", "records", count, "output", *output)
+	return nil
+}
+
+// restore загружает дамп в хранилище: shortener restore -i dump.jsonl.
+// Уже существующие shortID не перезаписываются.
+func restore(cfg *config.Config, logger logging.Logger, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	input := fs.String("i", "-", "dump file, - for stdin")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	storage, err := openStorage(ctx, cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = storage.Close(ctx) }()
+
+	var in io.Reader = os.Stdin
+	if *input != "-" {
+		f, openErr := os.Open(*input)
+		if openErr != nil {
+			return fmt.Errorf("open dump: %w", openErr)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	count := 0
+	dec := json.NewDecoder(bufio.NewReader(in))
+	batch := make([]store.Record, 0, restoreBatchSize)
+	for {
+		var rec store.Record
+		decErr := dec.Decode(&rec)
+		if errors.Is(decErr, io.EOF) {
+			break
+		}
+		if decErr != nil {
+			return fmt.Errorf("decode dump at record %d: %w", count+len(batch)+1, decErr)
+		}
+		batch = append(batch, rec)
+		if len(batch) == restoreBatchSize {
+			if importErr := storage.ImportRecords(ctx, batch); importErr != nil {
+				return fmt.Errorf("import records: %w", importErr)
+			}
+			count += len(batch)
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		if importErr := storage.ImportRecords(ctx, batch); importErr != nil {
+			return fmt.Errorf("import records: %w", importErr)
+		}
+		count += len(batch)
+	}
+	logger.Info("Restore finished", "records", count, "input", *input)
+	return nil
+}
+
+// openStorage открывает настроенное хранилище без фоновых переподключений:
+// сервисные команды должны падать сразу, если БД недоступна.
+func openStorage(ctx context.Context, cfg *config.Config, logger logging.Logger) (store.Store, error) {
+	if cfg.DatabaseDSN == "" {
+		return newLocalStorage(cfg, logger), nil
+	}
+	return openDB(ctx, cfg, logger)
+}
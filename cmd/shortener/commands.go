@@ -0,0 +1,166 @@
+package main
+
+// Cmd/shortener/commands.go.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/dkolesni-prog/transformer/internal/config"
+	"github.com/dkolesni-prog/transformer/internal/jobs"
+	"github.com/dkolesni-prog/transformer/internal/logging"
+	"github.com/dkolesni-prog/transformer/internal/retention"
+	"github.com/dkolesni-prog/transformer/internal/store"
+)
+
+// command — подкоманда бинаря. Конфиг (флаги и env) уже разобран, в args — всё после имени команды.
+type command struct {
+	name  string
+	usage string
+	run   func(cfg *config.Config, logger logging.Logger, args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"serve", "run the HTTP server (default)", run},
+		{"migrate", "create or upgrade the storage schema / file format", migrate},
+		{"backup", "dump all records as JSON lines: backup [-o dump.jsonl]", backup},
+		{"restore", "load records from a dump: restore [-i dump.jsonl]", restore},
+		{"migrate-store", "copy records between backends: migrate-store --from URL --to URL", migrateStore},
+		{"purge", "hard-delete soft-deleted links", purge},
+		{"retention", "delete links with no recent clicks: retention [-dry-run]", retentionRun},
+		{"stats", "print record counts as JSON", stats},
+		{"help", "show this message", func(*config.Config, logging.Logger, []string) error {
+			printUsage(os.Stdout)
+			return nil
+		}},
+	}
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: shortener [flags] [command] [command flags]")
+	fmt.Fprintln(w, "\ncommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-14s %s\n", cmd.name, cmd.usage)
+	}
+}
+
+// migrate создаёт схему БД вместе с таблицами переходов (и её разделами) и заданий;
+// файловое хранилище при открытии само переписывается в текущий формат.
+func migrate(cfg *config.Config, logger logging.Logger, _ []string) error {
+	ctx := context.Background()
+	storage, err := openStorage(ctx, cfg, logger)
+	if err != nil {
+		return err
+	}
+	if bootErr := storage.Bootstrap(ctx); bootErr != nil {
+		_ = storage.Close(ctx)
+		return fmt.Errorf("bootstrap: %w", bootErr)
+	}
+	if rdb, ok := storage.(*store.RDB); ok {
+		if _, clicksErr := openClickLog(ctx, cfg, rdb, logger); clicksErr != nil {
+			_ = storage.Close(ctx)
+			return fmt.Errorf("bootstrap clicks: %w", clicksErr)
+		}
+		if jobsErr := jobs.NewDBQueue(rdb.Pool(), logger).Bootstrap(ctx); jobsErr != nil {
+			_ = storage.Close(ctx)
+			return fmt.Errorf("bootstrap jobs: %w", jobsErr)
+		}
+	}
+	if closeErr := storage.Close(ctx); closeErr != nil {
+		return fmt.Errorf("close storage: %w", closeErr)
+	}
+	logger.Info("Storage is up to date")
+	return nil
+}
+
+func purge(cfg *config.Config, logger logging.Logger, _ []string) error {
+	ctx := context.Background()
+	storage, err := openStorage(ctx, cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = storage.Close(ctx) }()
+
+	purger, ok := storage.(store.Purger)
+	if !ok {
+		return fmt.Errorf("storage %T does not support purge", storage)
+	}
+	purged, err := purger.PurgeDeleted(ctx)
+	if err != nil {
+		return fmt.Errorf("purge: %w", err)
+	}
+	logger.Info("Purge finished", "purged", purged)
+	return nil
+}
+
+func stats(cfg *config.Config, logger logging.Logger, _ []string) error {
+	ctx := context.Background()
+	storage, err := openStorage(ctx, cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = storage.Close(ctx) }()
+
+	st, err := store.CollectStats(ctx, storage)
+	if err != nil {
+		return fmt.Errorf("collect stats: %w", err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(st)
+}
+
+// retentionRun — разовый проход политики хранения с отчётом в stdout. Переходы есть только в БД,
+// поэтому без DATABASE_DSN команда не работает.
+func retentionRun(cfg *config.Config, logger logging.Logger, args []string) error {
+	fs := flag.NewFlagSet("retention", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", cfg.RetentionDryRun, "only report links that would be deleted")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if cfg.RetentionIdle <= 0 {
+		return errors.New("set -retention-idle or RETENTION_IDLE")
+	}
+	if cfg.DatabaseDSN == "" {
+		return errors.New("retention needs click history, which is kept only in the database")
+	}
+
+	ctx := context.Background()
+	db, err := openDB(ctx, cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close(ctx) }()
+	// Клики пишутся только в шард 0, ссылки удаляются на всех шардах.
+	rdb, _ := primaryDB(db).(*store.RDB)
+	log, err := openClickLog(ctx, cfg, rdb, logger)
+	if err != nil {
+		return fmt.Errorf("bootstrap clicks: %w", err)
+	}
+
+	policy := retention.Policy{Idle: cfg.RetentionIdle, MinAge: cfg.RetentionMinAge, DryRun: *dryRun}
+	rep, err := retention.Run(ctx, db, log, policy)
+	if err != nil {
+		return fmt.Errorf("retention: %w", err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rep)
+}
@@ -5,114 +5,799 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"net/http"
 	"time"
 
+	"github.com/dkolesni-prog/transformer/internal/account"
+	"github.com/dkolesni-prog/transformer/internal/apitoken"
 	"github.com/dkolesni-prog/transformer/internal/app/endpoints"
 	"github.com/dkolesni-prog/transformer/internal/app/middleware"
+	"github.com/dkolesni-prog/transformer/internal/audit"
+	"github.com/dkolesni-prog/transformer/internal/bloom"
+	"github.com/dkolesni-prog/transformer/internal/breaker"
+	"github.com/dkolesni-prog/transformer/internal/buildinfo"
+	"github.com/dkolesni-prog/transformer/internal/cache"
+	"github.com/dkolesni-prog/transformer/internal/clicks"
 	"github.com/dkolesni-prog/transformer/internal/config"
+	"github.com/dkolesni-prog/transformer/internal/failover"
 	"github.com/dkolesni-prog/transformer/internal/helpers"
+	"github.com/dkolesni-prog/transformer/internal/jobs"
+	"github.com/dkolesni-prog/transformer/internal/logging"
+	"github.com/dkolesni-prog/transformer/internal/notify"
+	"github.com/dkolesni-prog/transformer/internal/org"
+	"github.com/dkolesni-prog/transformer/internal/ratelimit"
+	"github.com/dkolesni-prog/transformer/internal/retention"
+	"github.com/dkolesni-prog/transformer/internal/scheduler"
+	"github.com/dkolesni-prog/transformer/internal/shard"
+	"github.com/dkolesni-prog/transformer/internal/shortid"
+	"github.com/dkolesni-prog/transformer/internal/slowlog"
 	"github.com/dkolesni-prog/transformer/internal/store"
+	"github.com/dkolesni-prog/transformer/internal/storemetrics"
+	"github.com/dkolesni-prog/transformer/internal/upgrade"
+	"github.com/dkolesni-prog/transformer/internal/urlpolicy"
+	"github.com/dkolesni-prog/transformer/internal/webhook"
 )
 
-const version = "iter14"
-
 func main() {
-	middleware.Initialize("info", version)
-	if err := run(); err != nil {
-		middleware.Log.Info().Err(err).Msg("Failed to run server")
+	cfg := config.NewConfig()
+
+	name, args := "serve", flag.Args()
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	cmd, ok := findCommand(name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		printUsage(os.Stderr)
+		os.Exit(2)
+	}
+	logOut := os.Stdout
+	if name != "serve" {
+		// Сервисные команды могут писать результат в stdout.
+		logOut = os.Stderr
+	}
+	logger := logging.New(logOut, "info", buildinfo.Get().Version)
+	if err := cmd.run(cfg, logger, args); err != nil {
+		logger.Error("Failed to run command", "error", err, "command", name)
+		os.Exit(1)
 	}
 }
 
-func run() error {
+func run(cfg *config.Config, logger logging.Logger, _ []string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	cfg := config.NewConfig()
-	middleware.InitAuth(cfg.SecretKey)
+	if err := middleware.InitIPAnonymization(cfg.AnonymizeIPs, cfg.SecretKey); err != nil {
+		return err
+	}
+	if _, err := middleware.ParseTrustedProxies(cfg.TrustedProxies); err != nil {
+		return err
+	}
+	if _, err := middleware.ParseCookieOptions(cfg.CookieSecure, cfg.CookieSameSite,
+		cfg.BaseURL, cfg.CookieMaxAge, cfg.CookieRenewAfter); err != nil {
+		return err
+	}
+
+	// Битые списки слов и доменов должны останавливать запуск, а не всплывать на первом запросе.
+	idOpts := shortid.Options{
+		Reserved:        cfg.ReservedIDs,
+		WordlistPath:    cfg.ProfanityWordlist,
+		CaseInsensitive: cfg.CaseInsensitiveIDs,
+		HashIDs:         cfg.HashIDs,
+		GrowthThreshold: cfg.IDGrowthThreshold,
+		OnGrow: func(extra int) {
+			logger.Warn("Short ID keyspace is filling up, generating longer IDs", "extra_chars", extra)
+		},
+	}
+	if err := shortid.Init(idOpts); err != nil {
+		return err
+	}
+	if _, err := urlpolicy.New(cfg); err != nil {
+		return err
+	}
+	for _, expr := range []string{cfg.ScheduleCompaction, cfg.SchedulePurge, cfg.ScheduleRetention, cfg.SchedulePartitions} {
+		if expr == "" {
+			continue
+		}
+		if _, err := scheduler.Parse(expr); err != nil {
+			return err
+		}
+	}
+	if cfg.RateLimitBackend != "memory" && cfg.RateLimitBackend != "redis" {
+		return fmt.Errorf("rate limit backend %q: want memory or redis", cfg.RateLimitBackend)
+	}
+	if cfg.RateLimit > 0 && cfg.RateLimitBackend == "redis" && cfg.RedisAddr == "" {
+		return errors.New("rate limit backend redis needs a redis address")
+	}
+	if (cfg.TelegramBotToken == "") != (cfg.TelegramChatID == "") {
+		return errors.New("telegram notifications need both a bot token and a chat ID")
+	}
+	if cfg.SMTPAddr != "" && cfg.SMTPFrom == "" {
+		return errors.New("verification emails need a sender address")
+	}
+	// Снимки файла по расписанию заменяют периодические.
+	if cfg.ScheduleCompaction != "" {
+		cfg.FileCheckpointInterval = 0
+	}
 
-	storage, err := newStorage(ctx, cfg)
+	storage, err := newStorage(ctx, cfg, logger)
 	if err != nil {
-		middleware.Log.Error().Err(err).Msg("Could not connect to storage")
+		logger.Error("Could not connect to storage", "error", err)
 		return err
 	}
 
 	defer func() {
 		if closeErr := storage.Close(ctx); closeErr != nil {
-			middleware.Log.Error().Err(closeErr).Msg("Could not close context")
+			logger.Error("Could not close context", "error", closeErr)
+		}
+	}()
+	// backend — само хранилище под метриками, primary — его шард 0 (или оно само):
+	// по нему выбираются подсистемы.
+	backend := store.Unwrap(storage)
+	primary := primaryDB(backend)
+
+	auditLog, err := newAuditLog(ctx, cfg, primary, logger)
+	if err != nil {
+		logger.Error("Could not initialize audit log", "error", err)
+		return err
+	}
+
+	// Подсистемы с собственными таблицами работают прямо с *store.RDB, а не через обёртки.
+	orgs, err := newOrgDirectory(ctx, cfg, primary, logger)
+	if err != nil {
+		logger.Error("Could not initialize organizations", "error", err)
+		return err
+	}
+
+	tokens, err := newTokenStore(ctx, cfg, primary, logger)
+	if err != nil {
+		logger.Error("Could not initialize API tokens", "error", err)
+		return err
+	}
+
+	accounts, err := newAccountDirectory(ctx, cfg, primary, logger)
+	if err != nil {
+		logger.Error("Could not initialize accounts", "error", err)
+		return err
+	}
+
+	jobQueue, err := newJobQueue(ctx, cfg, primary, logger)
+	if err != nil {
+		logger.Error("Could not initialize job queue", "error", err)
+		return err
+	}
+	defer func() {
+		if closeErr := jobQueue.Close(); closeErr != nil {
+			logger.Error("Could not close job queue", "error", closeErr)
 		}
 	}()
+	runner := jobs.NewRunner(jobQueue, logger)
+	// Чистка идёт мимо обёрток: им нечего делать с уже удалёнными ссылками.
+	purger, _ := backend.(store.Purger)
+	fileStore, _ := backend.(*store.Storage)
 
-	router := endpoints.NewRouter(cfg, storage, version)
+	// Уведомления закрываются последними: трекер при остановке ещё может сообщить о пороге.
+	notifier := newNotifier(cfg, logger)
+	defer notifier.Close()
+
+	tracker, err := newClickTracker(ctx, cfg, primary, logger)
+	if err != nil {
+		logger.Error("Could not initialize click tracking", "error", err)
+		return err
+	}
+	defer func() {
+		if closeErr := tracker.Close(); closeErr != nil {
+			logger.Error("Could not flush clicks", "error", closeErr)
+		}
+	}()
+	if notifier != nil && cfg.NotifyClickThreshold > 0 {
+		tracker.OnThreshold(cfg.NotifyClickThreshold, func(shortID string, clicks int) {
+			notifier.Notify("Link %s reached %d clicks", strings.TrimSuffix(cfg.BaseURL, "/")+"/"+shortID, clicks)
+		})
+	}
+	if reconnecting, ok := backend.(*failover.Store); ok {
+		notifyFailover(reconnecting, notifier)
+	}
+
+	if _, isDB := primary.(*store.RDB); isDB {
+		storage = withBreaker(cfg, storage, logger)
+		if cfg.Failover {
+			local := newLocalStorage(cfg, logger)
+			fo := failover.NewStore(storage, local, max(cfg.BreakerThreshold, 1), cfg.FailoverInterval, logger)
+			notifyFailover(fo, notifier)
+			storage = fo
+		}
+	}
+	// Замеряем до кэша: попадания в него о хранилище ничего не говорят.
+	if cfg.SlowOpThreshold > 0 {
+		storage = slowlog.NewStore(storage, cfg.SlowOpThreshold, logger)
+	}
+	if cfg.CacheSize > 0 {
+		cached := newCache(ctx, cfg, storage, logger)
+		warmCache(ctx, cfg, cached, tracker.Log(), logger)
+		if cfg.CacheWarmup > 0 && cfg.CacheHotSetFile != "" {
+			defer func() {
+				if saveErr := cache.SaveHotSet(cfg.CacheHotSetFile, cached.HotIDs(cfg.CacheWarmup)); saveErr != nil {
+					logger.Error("Could not save cache hot set", "error", saveErr)
+				}
+			}()
+		}
+		storage = cached
+	}
+	if cfg.BloomRebuildInterval > 0 {
+		filtered := bloom.NewStore(storage, logger)
+		filtered.Start(cfg.BloomRebuildInterval)
+		storage = filtered
+	}
+	if auditLog != nil {
+		storage = audit.NewStore(storage, auditLog, logger)
+	}
+	if dispatcher := webhook.NewDispatcher(cfg.WebhookURLs, cfg.WebhookSecret,
+		jobs.Retry{MaxAttempts: cfg.WebhookMaxAttempts, Backoff: cfg.WebhookRetryBackoff}, runner, logger); dispatcher != nil {
+		// В Postgres события пишутся в outbox в транзакции изменения; записи, ушедшие при
+		// сбое в локальное хранилище (cfg.Failover), событий не порождают.
+		if outboxes := outboxDBs(backend); len(outboxes) > 0 {
+			relay := webhook.StartRelay(dispatcher, outboxes, logger)
+			defer relay.Stop()
+		} else {
+			storage = webhook.NewStore(storage, dispatcher)
+		}
+	}
+
+	if cfg.RetentionIdle > 0 && cfg.ScheduleRetention == "" {
+		policy := retentionPolicy(cfg, tracker.Log())
+		worker := retention.Start(storage, tracker.Log(), policy, cfg.RetentionInterval, logger)
+		defer worker.Stop()
+	}
+
+	sched, err := newScheduler(cfg, storage, fileStore, purger, tracker, logger)
+	if err != nil {
+		return err
+	}
+
+	limiter := newRateLimiter(ctx, cfg, logger)
+	if closer, ok := limiter.(io.Closer); ok {
+		defer func() {
+			if closeErr := closer.Close(); closeErr != nil {
+				logger.Error("Could not close rate limiter", "error", closeErr)
+			}
+		}()
+	}
+
+	handlers := endpoints.New(endpoints.Deps{
+		Store:       storage,
+		Config:      cfg,
+		Logger:      logger,
+		Audit:       auditLog,
+		Orgs:        orgs,
+		Tracker:     tracker,
+		Jobs:        runner,
+		Purger:      purger,
+		Scheduler:   sched,
+		RateLimiter: limiter,
+		Notifier:    notifier,
+		Accounts:    accounts,
+		Tokens:      tokens,
+		Mailer:      newMailer(cfg),
+	})
+	runner.Start(cfg.JobWorkers)
+	sched.Start()
 
 	srv := &http.Server{
 		Addr:    cfg.RunAddr,
-		Handler: router,
+		Handler: handlers.Router(),
+	}
+
+	// После SIGUSR2 сокет переходит к новому процессу, а этот дообслуживает начатые
+	// запросы и выходит. Файловое и in-memory хранилище не видят записей друг друга,
+	// пока работают оба процесса, так что без простоя выкладываются экземпляры на БД.
+	ln, err := upgrade.Listen(cfg.RunAddr)
+	if err != nil {
+		logger.Error("Could not listen", "error", err, "address", cfg.RunAddr)
+		return err
 	}
 
 	go func() {
-		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
-			middleware.Log.Error().Err(err).Msg("Server encountered an error")
+		if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Server encountered an error", "error", err)
 		}
 	}()
+	if err := upgrade.Ready(); err != nil {
+		logger.Error("Could not report readiness to the previous process", "error", err)
+	}
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	upgrades := make(chan os.Signal, 1)
+	upgrade.Notify(upgrades)
 
-	sig := <-stop
-	middleware.Log.Info().Msgf("Received signal %v. Shutting down the server...", sig)
+wait:
+	for {
+		select {
+		case sig := <-stop:
+			logger.Info(fmt.Sprintf("Received signal %v. Shutting down the server...", sig))
+			break wait
+		case <-upgrades:
+			logger.Info("Starting new process to take over the listener")
+			if err := upgrade.Upgrade(ln, cfg.UpgradeTimeout); err != nil {
+				logger.Error("Upgrade failed, keep serving", "error", err)
+				continue
+			}
+			logger.Info("New process is serving, shutting down the old one")
+			break wait
+		}
+	}
 
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer shutdownCancel()
 
 	if err := srv.Shutdown(shutdownCtx); err != nil {
-		middleware.Log.Error().Err(err).Msg("Server shutdown error")
+		logger.Error("Server shutdown error", "error", err)
 		return err
 	}
 
-	middleware.Log.Info().Msg("Server exited cleanly")
+	// Фоновые удаления и подгрузки заголовков получают ещё немного времени, потом отменяются.
+	backgroundCtx, backgroundCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer backgroundCancel()
+	if err := handlers.ShutdownBackground(backgroundCtx); err != nil {
+		logger.Warn("Cancelled unfinished background operations", "error", err)
+	}
+	if err := sched.Stop(backgroundCtx); err != nil {
+		logger.Warn("Cancelled running scheduled tasks", "error", err)
+	}
+	// Прерванные задания останутся в очереди и выполнятся после перезапуска.
+	if err := runner.Stop(backgroundCtx); err != nil {
+		logger.Warn("Left unfinished jobs in the queue", "error", err)
+	}
+
+	logger.Info("Server exited cleanly")
 	return nil
 
 }
 
-//nolint:unparam  // Retaining error return for bc if removed. the main is red.
-func newStorage(ctx context.Context, cfg *config.Config) (store.Store, error) {
+// newStorage opens the configured storage under per-backend storemetrics; store.Unwrap gets the storage itself.
+func newStorage(ctx context.Context, cfg *config.Config, logger logging.Logger) (store.Store, error) {
 
-	middleware.Log.Info().
-		Str("address", cfg.RunAddr).
-		Str("Running server on", cfg.BaseURL).
-		Str("file_storage", cfg.FileStoragePath).
-		Str("DB DSN is:", helpers.Classify(cfg.DatabaseDSN)).
-		Msg("Initializing storage")
+	logger.Info("Initializing storage",
+		"address", cfg.RunAddr,
+		"Running server on", cfg.BaseURL,
+		"file_storage", cfg.FileStoragePath,
+		"DB DSN is:", helpers.Classify(cfg.DatabaseDSN),
+	)
 
-	if cfg.DatabaseDSN != "" {
-		rdb, err := store.NewRDB(ctx, cfg.DatabaseDSN)
-		if err == nil {
-			bootErr := rdb.Bootstrap(ctx)
-			if bootErr == nil {
-				return rdb, nil
+	if cfg.DatabaseDSN == "" {
+		name := "memory"
+		if cfg.FileStoragePath != "" {
+			name = "file"
+		}
+		return storemetrics.NewStore(newLocalStorage(cfg, logger), name), nil
+	}
+
+	db, err := openDB(ctx, cfg, logger)
+	if err == nil {
+		name := "db"
+		if _, ok := db.(*shard.Store); ok {
+			name = "sharded"
+		}
+		return storemetrics.NewStore(db, name), nil
+	}
+	logger.Warn("Falling back from DB to file/memory storage, will keep reconnecting in background")
+
+	connect := func(ctx context.Context) (store.Store, error) {
+		db, err := openDB(ctx, cfg, logger)
+		if err != nil {
+			return nil, err
+		}
+		return withBreaker(cfg, db, logger), nil
+	}
+	reconnecting := failover.NewReconnecting(connect, newLocalStorage(cfg, logger), cfg.FailoverInterval, logger)
+	return storemetrics.NewStore(reconnecting, "reconnecting"), nil
+}
+
+// openDB connects to Postgres: a single *store.RDB, or a *shard.Store when cfg.DatabaseShards is set.
+func openDB(ctx context.Context, cfg *config.Config, logger logging.Logger) (store.Store, error) {
+	if cfg.DatabaseShards == "" {
+		return connectDB(ctx, cfg, logger)
+	}
+	primary, err := connectDB(ctx, cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	shards := []shard.Shard{primary}
+	for _, dsn := range strings.Split(cfg.DatabaseShards, ",") {
+		dsn = strings.TrimSpace(dsn)
+		if dsn == "" {
+			continue
+		}
+		rdb, err := connectShard(ctx, cfg, dsn, logger)
+		if err != nil {
+			for _, opened := range shards {
+				_ = opened.Close(ctx)
 			}
-			middleware.Log.Error().
-				Err(bootErr).
-				Msg("DB bootstrap error")
-		} else {
-			middleware.Log.Error().
-				Err(err).
-				Msg("NewRDB error")
+			return nil, fmt.Errorf("shard %d: %w", len(shards), err)
 		}
-		middleware.Log.Warn().
-			Msg("Falling back from DB to file/memory storage")
+		shards = append(shards, rdb)
 	}
+	logger.Info("Links are sharded across databases", "shards", len(shards))
+	return shard.New(shards), nil
+}
 
+// primaryDB returns shard 0 of a sharded storage: audit, jobs, orgs and clicks live only there.
+func primaryDB(s store.Store) store.Store {
+	if sharded, ok := s.(*shard.Store); ok {
+		return sharded.Primary()
+	}
+	return s
+}
+
+// newNotifier sends operator notifications to Slack and/or Telegram; nil if neither is configured.
+func newNotifier(cfg *config.Config, logger logging.Logger) *notify.Notifier {
+	var senders []notify.Sender
+	if cfg.SlackWebhookURL != "" {
+		senders = append(senders, &notify.Slack{WebhookURL: cfg.SlackWebhookURL})
+	}
+	if cfg.TelegramBotToken != "" {
+		senders = append(senders, &notify.Telegram{Token: cfg.TelegramBotToken, ChatID: cfg.TelegramChatID})
+	}
+	return notify.New(logger, senders...)
+}
+
+// notifyFailover reports switches between the database and local storage.
+func notifyFailover(fo *failover.Store, notifier *notify.Notifier) {
+	if notifier == nil {
+		return
+	}
+	fo.OnSwitch(func(failedOver bool, err error) {
+		if failedOver {
+			notifier.Notify("Database is unavailable (%v), serving from local storage", err)
+			return
+		}
+		notifier.Notify("Database is back, local changes were replayed to it")
+	})
+}
+
+// outboxDBs enables the outbox on every database behind backend and returns them; nil if backend is not Postgres.
+func outboxDBs(backend store.Store) []webhook.Outbox {
+	var dbs []store.Store
+	if sharded, ok := backend.(*shard.Store); ok {
+		for _, sh := range sharded.Shards() {
+			dbs = append(dbs, sh)
+		}
+	} else {
+		dbs = append(dbs, backend)
+	}
+	outboxes := make([]webhook.Outbox, 0, len(dbs))
+	for _, db := range dbs {
+		if _, ok := db.(*store.RDB); !ok {
+			return nil
+		}
+	}
+	for _, db := range dbs {
+		rdb := db.(*store.RDB)
+		rdb.EnableOutbox()
+		outboxes = append(outboxes, rdb)
+	}
+	return outboxes
+}
+
+// connectDB opens the pool, creates the schema and attaches the read replica if configured.
+func connectDB(ctx context.Context, cfg *config.Config, logger logging.Logger) (*store.RDB, error) {
+	rdb, err := connectShard(ctx, cfg, cfg.DatabaseDSN, logger)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ReplicaDSN != "" {
+		if replicaErr := rdb.ConnectReplica(ctx, cfg.ReplicaDSN); replicaErr != nil {
+			logger.Warn("Read replica unavailable, reading from primary", "error", replicaErr)
+		}
+	}
+	return rdb, nil
+}
+
+// connectShard opens the pool for dsn and creates the schema.
+func connectShard(ctx context.Context, cfg *config.Config, dsn string, logger logging.Logger) (*store.RDB, error) {
+	rdb, err := store.NewRDB(ctx, dsn, rdbOptions(cfg), logger)
+	if err != nil {
+		logger.Error("NewRDB error", "error", err)
+		return nil, err
+	}
+	if bootErr := rdb.Bootstrap(ctx); bootErr != nil {
+		logger.Error("DB bootstrap error", "error", bootErr)
+		_ = rdb.Close(ctx)
+		return nil, bootErr
+	}
+	return rdb, nil
+}
+
+func withBreaker(cfg *config.Config, s store.Store, logger logging.Logger) store.Store {
+	if cfg.BreakerThreshold <= 0 {
+		return s
+	}
+	return breaker.NewStore(s, breaker.New(cfg.BreakerThreshold, cfg.BreakerCooldown, logger))
+}
+
+// newLocalStorage returns the file store if a path is configured, otherwise the memory store.
+func newLocalStorage(cfg *config.Config, logger logging.Logger) store.Store {
 	if cfg.FileStoragePath != "" {
-		fileStore := store.NewStorage(cfg)
-		return fileStore, nil
+		fileStore := store.NewStorage(cfg, logger)
+		return fileStore
+	}
+
+	memoryStore := store.NewMemoryStorageWithLimits(store.MemoryLimits{
+		MaxEntries: cfg.MemoryMaxEntries,
+		TTL:        cfg.MemoryTTL,
+		LRU:        cfg.MemoryEviction == "lru",
+	})
+	if cfg.MemorySnapshotPath != "" {
+		if err := memoryStore.EnableSnapshots(cfg.MemorySnapshotPath, cfg.MemorySnapshotInterval, logger); err != nil {
+			logger.Error("Could not load memory store snapshot", "error", err)
+		}
+	}
+	return memoryStore
+}
+
+// newAuditLog picks the audit sink: a file if configured, otherwise the DB table when running on Postgres.
+func newAuditLog(ctx context.Context, cfg *config.Config, storage store.Store, logger logging.Logger) (audit.Log, error) {
+	if cfg.AuditFilePath != "" {
+		fileLog, err := audit.NewFileLog(cfg.AuditFilePath, logger)
+		if err != nil {
+			return nil, err
+		}
+		return fileLog, nil
+	}
+	if rdb, ok := storage.(*store.RDB); ok {
+		dbLog := audit.NewDBLog(rdb.Pool(), logger)
+		if err := dbLog.Bootstrap(ctx); err != nil {
+			return nil, err
+		}
+		return dbLog, nil
+	}
+	return nil, nil
+}
+
+// newJobQueue keeps background jobs in Postgres next to the links, otherwise in a file or in memory.
+func newJobQueue(ctx context.Context, cfg *config.Config, storage store.Store, logger logging.Logger) (jobs.Queue, error) {
+	if rdb, ok := storage.(*store.RDB); ok {
+		dbQueue := jobs.NewDBQueue(rdb.Pool(), logger)
+		if err := dbQueue.Bootstrap(ctx); err != nil {
+			return nil, err
+		}
+		return dbQueue, nil
+	}
+	if cfg.JobsFilePath != "" {
+		return jobs.NewFileQueue(cfg.JobsFilePath, logger)
+	}
+	return jobs.NewMemoryQueue(), nil
+}
+
+// newOrgDirectory keeps organizations in Postgres next to the links, otherwise in a file or in memory.
+func newOrgDirectory(ctx context.Context, cfg *config.Config, storage store.Store, logger logging.Logger) (org.Directory, error) {
+	if rdb, ok := storage.(*store.RDB); ok {
+		dbDir := org.NewDBDirectory(rdb.Pool(), logger)
+		if err := dbDir.Bootstrap(ctx); err != nil {
+			return nil, err
+		}
+		return dbDir, nil
+	}
+	if cfg.OrgsFilePath != "" {
+		return org.NewFileDirectory(cfg.OrgsFilePath)
+	}
+	return org.NewMemoryDirectory(), nil
+}
+
+// newTokenStore keeps API tokens in Postgres next to the links, otherwise in a file or in memory.
+func newTokenStore(ctx context.Context, cfg *config.Config, storage store.Store, logger logging.Logger) (apitoken.Store, error) {
+	if rdb, ok := storage.(*store.RDB); ok {
+		dbStore := apitoken.NewDBStore(rdb.Pool(), logger)
+		if err := dbStore.Bootstrap(ctx); err != nil {
+			return nil, err
+		}
+		return dbStore, nil
+	}
+	if cfg.TokensFilePath != "" {
+		return apitoken.NewFileStore(cfg.TokensFilePath)
+	}
+	return apitoken.NewMemoryStore(), nil
+}
+
+// newAccountDirectory keeps accounts next to the organizations: in Postgres, otherwise in a file
+// or in memory. Nil when accounts are disabled.
+func newAccountDirectory(ctx context.Context, cfg *config.Config, storage store.Store, logger logging.Logger) (account.Directory, error) {
+	if !cfg.Accounts {
+		return nil, nil
+	}
+	if rdb, ok := storage.(*store.RDB); ok {
+		dbDir := account.NewDBDirectory(rdb.Pool(), logger)
+		if err := dbDir.Bootstrap(ctx); err != nil {
+			return nil, err
+		}
+		return dbDir, nil
+	}
+	if cfg.AccountsFilePath != "" {
+		return account.NewFileDirectory(cfg.AccountsFilePath)
+	}
+	return account.NewMemoryDirectory(), nil
+}
+
+// newMailer sends verification emails over SMTP; nil without an SMTP server.
+func newMailer(cfg *config.Config) account.Mailer {
+	if cfg.SMTPAddr == "" {
+		return nil
+	}
+	return &account.SMTP{Addr: cfg.SMTPAddr, From: cfg.SMTPFrom, Username: cfg.SMTPUsername, Password: cfg.SMTPPassword}
+}
+
+// newClickTracker records clicks into Postgres when running on it, otherwise in memory.
+// With cfg.GeoIPDBPath set clicks are enriched with country and region.
+func newClickTracker(ctx context.Context, cfg *config.Config, storage store.Store, logger logging.Logger) (*clicks.Tracker, error) {
+	var enrichers []clicks.Enricher
+	if cfg.GeoIPDBPath != "" {
+		geo, err := clicks.OpenGeoIP(cfg.GeoIPDBPath)
+		if err != nil {
+			return nil, err
+		}
+		enrichers = append(enrichers, geo)
+	}
+
+	if rdb, ok := storage.(*store.RDB); ok {
+		dbLog, err := openClickLog(ctx, cfg, rdb, logger)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.SchedulePartitions == "" {
+			dbLog.MaintainPartitions(partitionCheckInterval)
+		}
+		return clicks.NewTracker(dbLog, logger, enrichers...), nil
+	}
+	return clicks.NewTracker(clicks.NewMemoryLog(), logger, enrichers...), nil
+}
+
+// partitionCheckInterval — how often upcoming clicks partitions are created.
+const partitionCheckInterval = 12 * time.Hour
+
+// openClickLog bootstraps the clicks table, partitioned by month when cfg.ClicksPartitionMonths is set.
+func openClickLog(ctx context.Context, cfg *config.Config, rdb *store.RDB, logger logging.Logger) (*clicks.DBLog, error) {
+	dbLog := clicks.NewDBLog(rdb.Pool(), logger).WithPartitions(cfg.ClicksPartitionMonths)
+	if err := dbLog.Bootstrap(ctx); err != nil {
+		return nil, err
+	}
+	return dbLog, nil
+}
+
+// newScheduler registers the maintenance tasks that have a schedule in cfg. Tasks the
+// storage cannot do (snapshots without a file store, partitions without Postgres) are skipped.
+func newScheduler(cfg *config.Config, storage store.Store, fileStore *store.Storage, purger store.Purger,
+	tracker *clicks.Tracker, logger logging.Logger) (*scheduler.Scheduler, error) {
+	sched := scheduler.New(logger)
+	add := func(name, expr string, supported bool, run scheduler.Func) error {
+		if expr != "" && !supported {
+			logger.Warn("Scheduled task cannot run with this configuration, skipping", "task", name)
+			return nil
+		}
+		return sched.Add(name, expr, run)
 	}
 
-	memoryStore := store.NewMemoryStorage()
-	return memoryStore, nil
+	if err := add("compaction", cfg.ScheduleCompaction, fileStore != nil, func(context.Context) (any, error) {
+		return nil, fileStore.Checkpoint()
+	}); err != nil {
+		return nil, err
+	}
+	if err := add("purge", cfg.SchedulePurge, purger != nil, func(ctx context.Context) (any, error) {
+		purged, err := purger.PurgeDeleted(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]int{"purged": purged}, nil
+	}); err != nil {
+		return nil, err
+	}
+	policy := retentionPolicy(cfg, tracker.Log())
+	if err := add("retention", cfg.ScheduleRetention, cfg.RetentionIdle > 0, func(ctx context.Context) (any, error) {
+		rep, err := retention.Run(ctx, storage, tracker.Log(), policy)
+		if errors.Is(err, retention.ErrShortHistory) {
+			return rep, nil
+		}
+		return rep, err
+	}); err != nil {
+		return nil, err
+	}
+	dbLog, _ := tracker.Log().(*clicks.DBLog)
+	if err := add("partitions", cfg.SchedulePartitions, dbLog != nil && cfg.ClicksPartitionMonths > 0, func(ctx context.Context) (any, error) {
+		return nil, dbLog.EnsurePartitions(ctx)
+	}); err != nil {
+		return nil, err
+	}
+	return sched, nil
+}
+
+// retentionPolicy builds the retention policy from cfg. An in-memory click log only knows
+// about clicks since startup, so links are judged only once it covers the whole idle period.
+func retentionPolicy(cfg *config.Config, log clicks.Log) retention.Policy {
+	p := retention.Policy{Idle: cfg.RetentionIdle, MinAge: cfg.RetentionMinAge, DryRun: cfg.RetentionDryRun}
+	if _, inMemory := log.(*clicks.MemoryLog); inMemory {
+		p.HistorySince = time.Now().UTC()
+	}
+	return p
+}
+
+// newCache wraps the storage into the LRU cache, invalidated across instances via Redis when configured.
+func newCache(ctx context.Context, cfg *config.Config, storage store.Store, logger logging.Logger) *cache.Store {
+	var invalidator cache.Invalidator
+	if cfg.RedisAddr != "" {
+		redisInvalidator, err := cache.NewRedisInvalidator(ctx, cfg.RedisAddr, logger)
+		if err != nil {
+			logger.Error("Redis unavailable, cache invalidation stays local", "error", err)
+		} else {
+			invalidator = redisInvalidator
+		}
+	}
+	return cache.NewStore(storage, cfg.CacheSize, cfg.CacheTTL, invalidator, logger)
+}
+
+// newRateLimiter builds the per-IP limiter: shared through Redis when configured, otherwise
+// in memory. An unreachable Redis falls back to per-instance counting rather than no limit.
+func newRateLimiter(ctx context.Context, cfg *config.Config, logger logging.Logger) ratelimit.Limiter {
+	if cfg.RateLimit <= 0 {
+		return nil
+	}
+	limit := ratelimit.Limit{PerMinute: cfg.RateLimit, Burst: cfg.RateLimitBurst}
+	if cfg.RateLimitBackend == "redis" {
+		limiter, err := ratelimit.NewRedis(ctx, cfg.RedisAddr, limit)
+		if err == nil {
+			return limiter
+		}
+		logger.Error("Redis unavailable, rate limits are counted per instance", "error", err)
+	}
+	return ratelimit.NewMemory(limit)
+}
+
+// warmCache preloads hot links so a restart doesn't send every popular redirect to storage at once:
+// the hot set saved on the previous shutdown, or else the most clicked links of the last day.
+func warmCache(ctx context.Context, cfg *config.Config, cached *cache.Store, log clicks.Log, logger logging.Logger) {
+	if cfg.CacheWarmup <= 0 {
+		return
+	}
+	start := time.Now()
+	var ids []string
+	source := "hot set"
+	if cfg.CacheHotSetFile != "" {
+		loaded, err := cache.LoadHotSet(cfg.CacheHotSetFile)
+		if err != nil {
+			logger.Warn("Could not read cache hot set, warming from clicks", "error", err)
+		}
+		ids = loaded
+	}
+	if len(ids) == 0 {
+		source = "clicks"
+		top, err := log.Top(ctx, cfg.CacheWarmup, time.Now().Add(-24*time.Hour))
+		if err != nil {
+			logger.Error("Could not load hot links for cache warm-up", "error", err)
+			return
+		}
+		ids = top
+	}
+	if len(ids) > cfg.CacheWarmup {
+		ids = ids[:cfg.CacheWarmup]
+	}
+	warmed := cached.Warm(ctx, ids)
+	logger.Info("Cache warmed up", "links", warmed, "source", source, "duration", time.Since(start))
+}
+
+func rdbOptions(cfg *config.Config) store.RDBOptions {
+	return store.RDBOptions{
+		MaxConns:          int32(cfg.DBMaxConns),
+		MinConns:          int32(cfg.DBMinConns),
+		MaxConnLifetime:   cfg.DBMaxConnLifetime,
+		HealthCheckPeriod: cfg.DBHealthCheckPeriod,
+		MaxRetries:        cfg.DBMaxRetries,
+		RetryBackoff:      cfg.DBRetryBackoff,
+	}
 }
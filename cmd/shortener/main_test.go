@@ -2,15 +2,29 @@
 package main
 
 import (
+	"archive/zip"
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-resty/resty/v2"
@@ -18,14 +32,36 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/dkolesni-prog/transformer/internal/app/endpoints"
+	"github.com/dkolesni-prog/transformer/internal/app/middleware"
+	"github.com/dkolesni-prog/transformer/internal/app/negotiate"
+	"github.com/dkolesni-prog/transformer/internal/audit"
+	"github.com/dkolesni-prog/transformer/internal/bloom"
+	"github.com/dkolesni-prog/transformer/internal/breaker"
+	"github.com/dkolesni-prog/transformer/internal/buildinfo"
+	"github.com/dkolesni-prog/transformer/internal/cache"
+	"github.com/dkolesni-prog/transformer/internal/clicks"
 	"github.com/dkolesni-prog/transformer/internal/config"
+	"github.com/dkolesni-prog/transformer/internal/failover"
+	"github.com/dkolesni-prog/transformer/internal/jobs"
+	"github.com/dkolesni-prog/transformer/internal/logging"
+	"github.com/dkolesni-prog/transformer/internal/notify"
+	"github.com/dkolesni-prog/transformer/internal/ratelimit"
+	"github.com/dkolesni-prog/transformer/internal/retention"
+	"github.com/dkolesni-prog/transformer/internal/scheduler"
+	"github.com/dkolesni-prog/transformer/internal/shard"
+	"github.com/dkolesni-prog/transformer/internal/shortid"
+	"github.com/dkolesni-prog/transformer/internal/slowlog"
 	"github.com/dkolesni-prog/transformer/internal/store"
+	"github.com/dkolesni-prog/transformer/internal/storemetrics"
+	"github.com/dkolesni-prog/transformer/internal/upgrade"
+	"github.com/dkolesni-prog/transformer/internal/webhook"
 )
 
 // TestEndpoints tests the main endpoints of the URL shortening service.
 func TestEndpoints(t *testing.T) {
 	cfg := config.NewConfig()
-	storage := store.NewStorage(cfg)
+	cfg.FileStoragePath = filepath.Join(t.TempDir(), "data.json")
+	storage := store.NewStorage(cfg, logging.Nop())
 
 	tests := []struct {
 		name       string
@@ -100,16 +136,11 @@ func TestEndpoints(t *testing.T) {
 				req = httptest.NewRequest(tt.method, tt.url, http.NoBody)
 			}
 			rec := httptest.NewRecorder()
+			h := endpoints.New(endpoints.Deps{Store: storage, Config: cfg})
 			r := chi.NewRouter()
-			r.Post("/", func(w http.ResponseWriter, r *http.Request) {
-				endpoints.ShortenURL(w, r, storage, cfg)
-			})
-			r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
-				endpoints.GetFullURL(w, r, storage)
-			})
-			r.Post("/api/shorten/batch", func(w http.ResponseWriter, r *http.Request) {
-				endpoints.ShortenBatch(w, r, storage, cfg)
-			})
+			r.Post("/", h.ShortenURL)
+			r.Get("/{id}", h.GetFullURL)
+			r.Post("/api/shorten/batch", h.ShortenBatch)
 			r.ServeHTTP(rec, req)
 			if rec.Code != tt.wantCode {
 				t.Errorf("got status code %d, want %d", rec.Code, tt.wantCode)
@@ -134,10 +165,806 @@ func TestEndpoints(t *testing.T) {
 	}
 }
 
+// TestFileStorageSoftDelete checks that deleted links answer 410 and the tombstone survives a reload.
+func TestFileStorageSoftDelete(t *testing.T) {
+	cfg := *config.NewConfig()
+	cfg.FileStoragePath = filepath.Join(t.TempDir(), "data.json")
+	cfg.FileSync = store.SyncAlways
+
+	storage := store.NewStorage(&cfg, logging.Nop())
+	storage.SetIfAbsent("gone1234", "https://example.com/gone")
+	_, err := storage.DeleteBatch(context.Background(), "", []string{"gone1234"})
+	require.NoError(t, err)
+	require.NoError(t, storage.Close(context.Background()))
+
+	reloaded := store.NewStorage(&cfg, logging.Nop())
+	defer func() { _ = reloaded.Close(context.Background()) }()
+
+	r := chi.NewRouter()
+	r.Get("/{id}", endpoints.New(endpoints.Deps{Store: reloaded, Config: &cfg}).GetFullURL)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/gone1234", http.NoBody))
+	assert.Equal(t, http.StatusGone, rec.Code)
+}
+
+// TestShortenNormalizesURL checks that case, default ports and dot-segments don't create distinct links.
+// exportAll собирает записи хранилища по shortID.
+func exportAll(t *testing.T, s store.Store) map[string]store.Record {
+	t.Helper()
+	out := make(map[string]store.Record)
+	require.NoError(t, s.ExportRecords(context.Background(), func(rec store.Record) error {
+		out[rec.ShortURL] = rec
+		return nil
+	}))
+	return out
+}
+
+func TestFileStorageCrashRecovery(t *testing.T) {
+	ctx := context.Background()
+	cfg := *config.NewConfig()
+	cfg.FileStoragePath = filepath.Join(t.TempDir(), "data.json")
+	cfg.FileSync = store.SyncAlways
+	walPath := cfg.FileStoragePath + ".wal"
+
+	storage := store.NewStorage(&cfg, logging.Nop())
+	storage.SetIfAbsent("kept1234", "https://example.com/kept")
+	storage.SetIfAbsent("gone1234", "https://example.com/gone")
+	_, err := storage.DeleteBatch(ctx, "", []string{"gone1234"})
+	require.NoError(t, err)
+	wal, err := os.ReadFile(walPath)
+	require.NoError(t, err)
+	require.NotEmpty(t, wal)
+	require.NoError(t, storage.Close(ctx))
+
+	// Падение между записью снимка и обнулением журнала: журнал проигрывается поверх
+	// снимка, в котором его записи уже есть, и после него дописана ещё одна.
+	late, err := json.Marshal(store.Record{ShortURL: "late1234", OriginalURL: "https://example.com/late", UserID: "u1"})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(walPath, append(wal, append(late, '\n')...), 0o600))
+
+	reloaded := store.NewStorage(&cfg, logging.Nop())
+	records := exportAll(t, reloaded)
+	require.NoError(t, reloaded.Close(ctx))
+	require.Len(t, records, 3)
+	assert.False(t, records["kept1234"].IsDeleted)
+	assert.True(t, records["gone1234"].IsDeleted)
+	assert.Equal(t, "https://example.com/late", records["late1234"].OriginalURL)
+	assert.Equal(t, "u1", records["late1234"].UserID)
+
+	// Оборванная последняя строка журнала пропускается, остальное восстанавливается,
+	// а при старте делается новый снимок с пустым журналом.
+	next, err := json.Marshal(store.Record{ShortURL: "next1234", OriginalURL: "https://example.com/next"})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(walPath, append(append(next, '\n'), `{"short_url":"torn1234","original_u`...), 0o600))
+
+	reloaded = store.NewStorage(&cfg, logging.Nop())
+	records = exportAll(t, reloaded)
+	info, err := os.Stat(walPath)
+	require.NoError(t, err)
+	assert.Zero(t, info.Size(), "the torn journal is checkpointed away")
+	require.NoError(t, reloaded.Close(ctx))
+	assert.Len(t, records, 4)
+	assert.Contains(t, records, "next1234")
+	assert.NotContains(t, records, "torn1234")
+
+	reloaded = store.NewStorage(&cfg, logging.Nop())
+	defer func() { _ = reloaded.Close(ctx) }()
+	assert.Equal(t, records, exportAll(t, reloaded))
+}
+
+func TestFileStorageV1Migration(t *testing.T) {
+	ctx := context.Background()
+	cfg := *config.NewConfig()
+	cfg.FileStoragePath = filepath.Join(t.TempDir(), "data.json")
+
+	// Файл v1: строки Record без заголовка и без created_at.
+	const v1 = `{"uuid":"1","short_url":"abc12345","original_url":"https://example.com/a","user_id":"alice","is_deleted":false}
+{"uuid":"2","short_url":"def12345","original_url":"https://example.com/d","user_id":"bob","is_deleted":true}
+{"uuid":"3","short_url":"anon1234","original_url":"https://example.com/anon","user_id":"","is_deleted":false}
+`
+	require.NoError(t, os.WriteFile(cfg.FileStoragePath, []byte(v1), 0o600))
+	written := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(cfg.FileStoragePath, written, written))
+
+	storage := store.NewStorage(&cfg, logging.Nop())
+	records := exportAll(t, storage)
+	require.NoError(t, storage.Close(ctx))
+	require.Len(t, records, 3)
+	assert.Equal(t, store.Record{UUID: "1", ShortURL: "abc12345", OriginalURL: "https://example.com/a", UserID: "alice", CreatedAt: written}, records["abc12345"])
+	assert.Equal(t, store.Record{UUID: "2", ShortURL: "def12345", OriginalURL: "https://example.com/d", UserID: "bob", IsDeleted: true, CreatedAt: written}, records["def12345"])
+	assert.Equal(t, store.Record{UUID: "3", ShortURL: "anon1234", OriginalURL: "https://example.com/anon", CreatedAt: written}, records["anon1234"])
+
+	// Файл переписан в v2 и читается без потерь.
+	data, err := os.ReadFile(cfg.FileStoragePath)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), `{"format":"shortener","version":2}`), string(data))
+	storage = store.NewStorage(&cfg, logging.Nop())
+	defer func() { _ = storage.Close(ctx) }()
+	assert.Equal(t, records, exportAll(t, storage))
+}
+
+func TestBackupRestoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	source := *config.NewConfig()
+	source.FileStoragePath = filepath.Join(dir, "source.json")
+	target := *config.NewConfig()
+	target.FileStoragePath = filepath.Join(dir, "target.json")
+	dump := filepath.Join(dir, "dump.jsonl")
+
+	created := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	storage := store.NewStorage(&source, logging.Nop())
+	require.NoError(t, storage.ImportRecords(ctx, []store.Record{
+		{UUID: "1", ShortURL: "abc12345", OriginalURL: "https://example.com/a", UserID: "alice", CreatedAt: created},
+		{UUID: "2", ShortURL: "def12345", OriginalURL: "https://example.com/d", UserID: "bob", IsDeleted: true, CreatedAt: created},
+		{UUID: "3", ShortURL: "tag12345", OriginalURL: "https://example.com/t", UserID: "alice", CreatedAt: created,
+			Meta: &store.LinkMeta{Tags: []string{"docs"}, Title: "Tagged"}},
+	}))
+	want := exportAll(t, storage)
+	require.NoError(t, storage.Close(ctx))
+
+	require.NoError(t, backup(&source, logging.Nop(), []string{"-o", dump}))
+	require.NoError(t, restore(&target, logging.Nop(), []string{"-i", dump}))
+
+	restored := store.NewStorage(&target, logging.Nop())
+	defer func() { _ = restored.Close(ctx) }()
+	assert.Equal(t, want, exportAll(t, restored))
+}
+
+func TestShortenNormalizesURL(t *testing.T) {
+	cfg := config.NewConfig()
+	storage := store.NewMemoryStorage()
+
+	h := endpoints.New(endpoints.Deps{Store: storage, Config: cfg})
+	r := chi.NewRouter()
+	r.Post("/", h.ShortenURL)
+	r.Get("/{id}", h.GetFullURL)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("HTTPS://Example.COM:443/a/./b/../c")))
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	shortID := store.ShortIDFromURL(rec.Body.String(), cfg.BaseURL)
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+shortID, http.NoBody))
+	assert.Equal(t, "https://example.com/a/c", rec.Header().Get("Location"))
+}
+
+// TestShortenBlockedDomain checks that blocked destinations and their subdomains get 403.
+func TestShortenBlockedDomain(t *testing.T) {
+	cfg := *config.NewConfig()
+	cfg.BlockedDomains = "evil.example, *.phish.*"
+	storage := store.NewMemoryStorage()
+	router := endpoints.New(endpoints.Deps{Store: storage, Config: &cfg, Version: "testversion"}).Router()
+
+	for _, target := range []string{"https://evil.example/x", "https://cdn.Evil.Example", "http://login.phish.io/"} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(target)))
+		assert.Equal(t, http.StatusForbidden, rec.Code, target)
+		assert.Contains(t, rec.Body.String(), `"destination_blocked"`, target)
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://notevil.example")))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+}
+
+// TestShortenAllowlist checks that with an allowlist only listed domains can be shortened, batch included.
+func TestShortenAllowlist(t *testing.T) {
+	cfg := *config.NewConfig()
+	cfg.AllowedDomains = "corp.example"
+	storage := store.NewMemoryStorage()
+	router := endpoints.New(endpoints.Deps{Store: storage, Config: &cfg, Version: "testversion"}).Router()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://wiki.corp.example/page"}`)))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = httptest.NewRecorder()
+	body := `[{"correlation_id":"1","original_url":"https://corp.example"},{"correlation_id":"2","original_url":"https://example.com"}]`
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(body)))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"destination_not_allowed"`)
+}
+
+// TestReservedShortIDs checks the built-in and operator-supplied reserved words and the profanity filter.
+func TestReservedShortIDs(t *testing.T) {
+	require.NoError(t, shortid.Init(shortid.Options{Reserved: "promo, Sale"}))
+	defer func() { _ = shortid.Init(shortid.Options{}) }()
+
+	for _, id := range []string{"api", "PING", "version", "promo", "sale"} {
+		assert.True(t, shortid.IsReserved(id), id)
+	}
+	assert.False(t, shortid.IsReserved("abcd1234"))
+	assert.ErrorIs(t, shortid.Validate("xxShiTxx"), shortid.ErrOffensive)
+	assert.NoError(t, shortid.Validate("abcd1234"))
+
+	id, err := shortid.Generate(8)
+	require.NoError(t, err)
+	assert.Len(t, id, 8)
+}
+
+// TestCaseInsensitiveIDs checks lowercase generation and case folding on redirect.
+func TestCaseInsensitiveIDs(t *testing.T) {
+	require.NoError(t, shortid.Init(shortid.Options{CaseInsensitive: true}))
+	defer func() { _ = shortid.Init(shortid.Options{}) }()
+
+	cfg := config.NewConfig()
+	storage := store.NewMemoryStorage()
+	router := endpoints.New(endpoints.Deps{Store: storage, Config: cfg, Version: "testversion"}).Router()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://example.com/print")))
+	require.Equal(t, http.StatusCreated, rec.Code)
+	shortID := store.ShortIDFromURL(rec.Body.String(), cfg.BaseURL)
+	require.Equal(t, strings.ToLower(shortID), shortID)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+strings.ToUpper(shortID), http.NoBody))
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+}
+
+// TestHashIDs checks that hash-based IDs agree across instances and grow on collision.
+func TestHashIDs(t *testing.T) {
+	require.NoError(t, shortid.Init(shortid.Options{HashIDs: true}))
+	defer func() { _ = shortid.Init(shortid.Options{}) }()
+
+	cfg := *config.NewConfig()
+	cfg.BaseURL = "http://localhost:8080/"
+	shorten := func(router http.Handler, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(target)))
+		return rec
+	}
+	first := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: &cfg}).Router()
+	second := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: &cfg}).Router()
+
+	rec := shorten(first, "https://example.com/hashed")
+	require.Equal(t, http.StatusCreated, rec.Code)
+	link := rec.Body.String()
+	// Другой экземпляр со своим хранилищем выдаёт ту же ссылку, нормализация тоже учитывается.
+	rec = shorten(second, "https://EXAMPLE.com:443/hashed")
+	require.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, link, rec.Body.String())
+	rec = shorten(first, "https://example.com/hashed")
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Equal(t, link, rec.Body.String())
+
+	id := store.ShortIDFromURL(link, cfg.BaseURL)
+	assert.Len(t, id, 8)
+	longer, err := shortid.ForURL("https://example.com/hashed", 8, 1)
+	require.NoError(t, err)
+	assert.Equal(t, id, longer[:8])
+	assert.Len(t, longer, 9)
+}
+
+// TestIDGrowth checks that random IDs get longer once too many candidates collide.
+func TestIDGrowth(t *testing.T) {
+	var grownTo []int
+	require.NoError(t, shortid.Init(shortid.Options{
+		GrowthThreshold: 0.5,
+		OnGrow:          func(extra int) { grownTo = append(grownTo, extra) },
+	}))
+	defer func() { _ = shortid.Init(shortid.Options{}) }()
+
+	id, err := shortid.ForURL("https://example.com/", 8, 0)
+	require.NoError(t, err)
+	assert.Len(t, id, 8)
+
+	// Редкие коллизии длину не меняют.
+	for i := range 400 {
+		shortid.Observe(i%10 == 0)
+	}
+	assert.Zero(t, shortid.Growth())
+
+	for range 200 {
+		shortid.Observe(true)
+	}
+	assert.Equal(t, 1, shortid.Growth())
+	assert.Equal(t, []int{1}, grownTo)
+	id, err = shortid.ForURL("https://example.com/", 8, 0)
+	require.NoError(t, err)
+	assert.Len(t, id, 9)
+	// Поздние попытки одного сохранения удлиняются ещё.
+	id, err = shortid.ForURL("https://example.com/", 8, 2)
+	require.NoError(t, err)
+	assert.Len(t, id, 10)
+
+	// Сколько бы длина ни росла, id помещается в колонку short_id.
+	for range 20 * 200 {
+		shortid.Observe(true)
+	}
+	id, err = shortid.ForURL("https://example.com/", 8, 4)
+	require.NoError(t, err)
+	assert.Len(t, id, shortid.MaxLen)
+}
+
+// TestShortenIDN checks punycode storage of internationalized and mixed-script domains
+// and the Unicode form in the user's listing.
+func TestShortenIDN(t *testing.T) {
+	cfg := *config.NewConfig()
+	cfg.BlockedDomains = "раураl.com"
+	storage := store.NewMemoryStorage()
+	router := endpoints.New(endpoints.Deps{Store: storage, Config: &cfg, Version: "testversion"}).Router()
+
+	tests := []struct {
+		name     string
+		target   string
+		wantCode int
+		location string
+		display  string
+	}{
+		{
+			name:     "cyrillic domain",
+			target:   "https://Пример.РФ/путь",
+			wantCode: http.StatusCreated,
+			location: "https://xn--e1afmkfd.xn--p1ai/%D0%BF%D1%83%D1%82%D1%8C",
+			display:  "https://пример.рф/%D0%BF%D1%83%D1%82%D1%8C",
+		},
+		{
+			name:     "mixed cyrillic and latin label",
+			target:   "https://раypal.com/login",
+			wantCode: http.StatusCreated,
+			location: "https://xn--ypal-43d9g.com/login",
+			display:  "https://раypal.com/login",
+		},
+		{
+			name:     "blocked homograph",
+			target:   "https://РАУРАL.com/",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "invalid IDNA label",
+			target:   "https://xn--a.com/",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.target)))
+			require.Equal(t, tt.wantCode, rec.Code, rec.Body.String())
+			if tt.wantCode != http.StatusCreated {
+				return
+			}
+			cookies := rec.Result().Cookies()
+			_ = rec.Result().Body.Close()
+
+			shortID := store.ShortIDFromURL(rec.Body.String(), cfg.BaseURL)
+			rec = httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+shortID, http.NoBody))
+			assert.Equal(t, tt.location, rec.Header().Get("Location"))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/user/urls", http.NoBody)
+			for _, c := range cookies {
+				req.AddCookie(c)
+			}
+			rec = httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			require.Equal(t, http.StatusOK, rec.Code)
+			var list []store.UserURL
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+			require.Len(t, list, 1)
+			assert.Equal(t, tt.display, list[0].OriginalURL)
+		})
+	}
+}
+
+// TestShortenURLTooLong checks the configurable URL length limit.
+func TestShortenStripsTracking(t *testing.T) {
+	require.NoError(t, shortid.Init(shortid.Options{HashIDs: true}))
+	defer func() { _ = shortid.Init(shortid.Options{}) }()
+
+	cfg := *config.NewConfig()
+	cfg.StripTrackingParams = true
+	cfg.MaxURLLength = 64
+	router := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: &cfg, Version: "testversion"}).Router()
+	shorten := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"`+target+`"}`)))
+		return rec
+	}
+	var created struct {
+		Result string `json:"result"`
+	}
+
+	// Метки убираются до проверки длины: с ними адрес длиннее лимита, без них — нет.
+	rec := shorten("https://example.com/page?utm_source=newsletter&id=1&utm_campaign=spring&gclid=abc")
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	link := created.Result
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+store.ShortIDFromURL(link, cfg.BaseURL), http.NoBody))
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	assert.Equal(t, "https://example.com/page?id=1", rec.Header().Get("Location"))
+
+	// Тот же адрес с другими метками сводится к той же ссылке.
+	rec = shorten("https://example.com/page?id=1&fbclid=xyz")
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	assert.Equal(t, link, created.Result)
+
+	// Без меток адрес всё ещё длиннее лимита — отказ.
+	rec = shorten("https://example.com/" + strings.Repeat("a", 64) + "?utm_source=x")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"url_too_long"`)
+}
+
+func TestShortenURLTooLong(t *testing.T) {
+	cfg := *config.NewConfig()
+	cfg.MaxURLLength = 64
+	router := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: &cfg, Version: "testversion"}).Router()
+
+	long := "https://example.com/" + strings.Repeat("a", 64)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"`+long+`"}`)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"url_too_long"`)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://example.com/ok")))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+}
+
+// TestPasswordProtectedLink checks that a link created with a password redirects only after the password is given.
+func TestPasswordProtectedLink(t *testing.T) {
+	cfg := config.NewConfig()
+	router := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: cfg, Version: "testversion"}).Router()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/shorten",
+		strings.NewReader(`{"url":"https://example.com/secret","password":"hunter2"}`)))
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var created map[string]string
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	path := "/" + store.ShortIDFromURL(created["result"], cfg.BaseURL)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, http.NoBody))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), "<form")
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path+"?pw=wrong", http.NoBody))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req := httptest.NewRequest(http.MethodGet, path, http.NoBody)
+	req.Header.Set("X-Link-Password", "hunter2")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	assert.Equal(t, "https://example.com/secret", rec.Header().Get("Location"))
+
+	req = httptest.NewRequest(http.MethodPost, path, strings.NewReader("pw=hunter2"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+}
+
+// TestUpdateUserURL checks that only the owner can change a link's destination.
+func TestUpdateUserURL(t *testing.T) {
+	cfg := config.NewConfig()
+	router := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: cfg, Version: "testversion"}).Router()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://example.com/tpyo")))
+	require.Equal(t, http.StatusCreated, rec.Code)
+	owner := rec.Result().Cookies()
+	_ = rec.Result().Body.Close()
+	path := "/api/user/urls/" + store.ShortIDFromURL(rec.Body.String(), cfg.BaseURL)
+
+	update := func(cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, path, strings.NewReader(`{"url":"https://example.com/typo"}`))
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusNotFound, update(nil).Code)
+	rec = update(owner)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "https://example.com/typo")
+}
+
+func TestTransferUserURL(t *testing.T) {
+	cfg := config.NewConfig()
+	router := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: cfg, Version: "testversion"}).Router()
+
+	do := func(method, path, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/", "https://example.com/handover", nil)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	owner := rec.Result().Cookies()
+	_ = rec.Result().Body.Close()
+	id := store.ShortIDFromURL(rec.Body.String(), cfg.BaseURL)
+
+	rec = do(http.MethodPost, "/", "https://example.com/claimant", nil)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	claimant := rec.Result().Cookies()
+	_ = rec.Result().Body.Close()
+
+	// Чужой пользователь не может ни передать ссылку, ни получить на неё токен.
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/api/user/urls/"+id+"/transfer", `{}`, claimant).Code)
+	// Кука с userID владельца без верной подписи ссылку не передаёт.
+	userID, _, _ := strings.Cut(owner[0].Value, ":")
+	forged := []*http.Cookie{{Name: "UserID", Value: userID + ":forged-signature"}}
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/api/user/urls/"+id+"/transfer", `{"user_id":"thief"}`, forged).Code)
+
+	rec = do(http.MethodPost, "/api/user/urls/"+id+"/transfer", `{}`, owner)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var issued struct {
+		Token string `json:"claim_token"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &issued))
+
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/api/user/urls/claim", `{"token":"x.y"}`, claimant).Code)
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/api/user/urls/claim", `{"token":"`+issued.Token+`"}`, nil).Code)
+
+	rec = do(http.MethodPost, "/api/user/urls/claim", `{"token":"`+issued.Token+`"}`, claimant)
+	require.Equal(t, http.StatusOK, rec.Code)
+	newOwner := claimant
+
+	rec = do(http.MethodGet, "/api/user/urls", "", newOwner)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "https://example.com/handover")
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, "/api/user/urls", "", owner).Code)
+
+	// Повторно тем же токеном ссылку не забрать: у прежнего владельца её уже нет.
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/api/user/urls/claim", `{"token":"`+issued.Token+`"}`, claimant).Code)
+}
+
+func TestOrgURLs(t *testing.T) {
+	cfg := config.NewConfig()
+	router := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: cfg, Version: "testversion"}).Router()
+
+	do := func(method, path, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	userCookie := func(id string) []*http.Cookie {
+		return []*http.Cookie{{Name: "UserID", Value: id + ":sig"}}
+	}
+	admin, viewer := userCookie("alice"), userCookie("bob")
+
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/org/acme", "", admin).Code)
+	assert.Equal(t, http.StatusConflict, do(http.MethodPost, "/api/org/acme", "", viewer).Code)
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/api/org/acme/urls", "", viewer).Code)
+
+	require.Equal(t, http.StatusOK, do(http.MethodPut, "/api/org/acme/members/bob", `{"role":"viewer"}`, admin).Code)
+	rec := do(http.MethodPost, "/api/org/acme/urls", `{"url":"https://example.com/org-link"}`, admin)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = do(http.MethodGet, "/api/org/acme/urls", "", viewer)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "https://example.com/org-link")
+
+	// Участник с ролью viewer не может создавать ссылки, а личный список админа остаётся пустым.
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/api/org/acme/urls", `{"url":"https://example.com/x"}`, viewer).Code)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, "/api/user/urls", "", admin).Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/org/nope/urls", "", admin).Code)
+}
+
+func TestTenantDomains(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.TenantBaseURLs = "https://go.acme.test/"
+	router := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: cfg, Version: "testversion"}).Router()
+
+	do := func(method, host, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Host = host
+		req.AddCookie(&http.Cookie{Name: "UserID", Value: "tenant-user:sig"})
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "go.acme.test", "/", "https://example.com/tenant")
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.True(t, strings.HasPrefix(rec.Body.String(), "https://go.acme.test/"), rec.Body.String())
+	path := "/" + store.ShortIDFromURL(rec.Body.String(), "https://go.acme.test/")
+
+	assert.Equal(t, http.StatusTemporaryRedirect, do(http.MethodGet, "go.acme.test", path, "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "localhost:8080", path, "").Code)
+
+	rec = do(http.MethodGet, "go.acme.test", "/api/user/urls", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "https://go.acme.test/")
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, "localhost:8080", "/api/user/urls", "").Code)
+
+	// Повторное сокращение на другом домене отдаёт адрес в домене, где ссылка живёт.
+	// MemoryStorage узнаёт повторы только по хеш-id.
+	require.NoError(t, shortid.Init(shortid.Options{HashIDs: true}))
+	defer func() { _ = shortid.Init(shortid.Options{}) }()
+	rec = do(http.MethodPost, "go.acme.test", "/", "https://example.com/tenant-hashed")
+	require.Equal(t, http.StatusCreated, rec.Code)
+	path = "/" + store.ShortIDFromURL(rec.Body.String(), "https://go.acme.test/")
+	rec = do(http.MethodPost, "localhost:8080", "/", "https://example.com/tenant-hashed")
+	require.Equal(t, http.StatusConflict, rec.Code)
+	assert.Equal(t, "https://go.acme.test"+path, rec.Body.String())
+	rec = do(http.MethodPost, "localhost:8080", "/api/shorten/batch", `[{"correlation_id":"1","original_url":"https://example.com/tenant-hashed"}]`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	assert.Contains(t, rec.Body.String(), "https://go.acme.test"+path)
+
+	rec = do(http.MethodPost, "localhost:8080", "/", "https://example.com/main")
+	require.Equal(t, http.StatusCreated, rec.Code)
+	mainURL := rec.Body.String()
+	rec = do(http.MethodPost, "go.acme.test", "/api/shorten", `{"url":"https://example.com/main"}`)
+	require.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), mainURL)
+	assert.Equal(t, http.StatusTemporaryRedirect, do(http.MethodGet, "localhost:8080", "/"+store.ShortIDFromURL(mainURL, cfg.BaseURL), "").Code)
+}
+
+func TestTaggedLinks(t *testing.T) {
+	cfg := config.NewConfig()
+	router := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: cfg, Version: "testversion"}).Router()
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.AddCookie(&http.Cookie{Name: "UserID", Value: "tagger:sig"})
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/shorten", `{"url":"https://example.com/a","tags":["Marketing","q3"]}`).Code)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/shorten", `{"url":"https://example.com/b","tags":["q3"]}`).Code)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/shorten/batch",
+		`[{"correlation_id":"1","original_url":"https://example.com/c","tags":["marketing"]}]`).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/shorten", `{"url":"https://example.com/d","tags":["no spaces"]}`).Code)
+
+	rec := do(http.MethodGet, "/api/user/urls?tag=marketing", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var list []store.UserURL
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	assert.Len(t, list, 2)
+
+	rec = do(http.MethodGet, "/api/user/urls?tag=marketing&tag=q3", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	list = nil
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Len(t, list, 1)
+	assert.Equal(t, "https://example.com/a", list[0].OriginalURL)
+	assert.Equal(t, []string{"marketing", "q3"}, list[0].Tags)
+
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/shorten",
+		`{"url":"https://example.com/titled","title":" Launch page ","note":"for the Q3 launch","tags":["titled"]}`).Code)
+	rec = do(http.MethodGet, "/api/user/urls?tag=titled", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	list = nil
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Len(t, list, 1)
+	assert.Equal(t, "Launch page", list[0].Title)
+	assert.Equal(t, "for the Q3 launch", list[0].Note)
+}
+
+func TestTopUserURLs(t *testing.T) {
+	cfg := config.NewConfig()
+	router := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: cfg, Version: "testversion"}).Router()
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.AddCookie(&http.Cookie{Name: "UserID", Value: "top-user:sig"})
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	shorten := func(u string) string {
+		rec := do(http.MethodPost, "/", u)
+		require.Equal(t, http.StatusCreated, rec.Code)
+		return "/" + store.ShortIDFromURL(rec.Body.String(), cfg.BaseURL)
+	}
+
+	popular, quiet := shorten("https://example.com/popular"), shorten("https://example.com/quiet")
+	shorten("https://example.com/never")
+	// Краулер и HEAD-проверка учитываются отдельно от людей.
+	assert.Equal(t, http.StatusTemporaryRedirect, do(http.MethodHead, popular, "").Code)
+	crawler := httptest.NewRequest(http.MethodGet, popular, http.NoBody)
+	crawler.Header.Set("User-Agent", "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)")
+	router.ServeHTTP(httptest.NewRecorder(), crawler)
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, popular, http.NoBody)
+		req.Header.Set("Referer", "https://www.news.example/today")
+		req.Header.Set("User-Agent", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 "+
+			"(KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	do(http.MethodGet, quiet, "")
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/api/user/urls/top?window=soon", "").Code)
+
+	// Переходы пишутся в фоне, поэтому ждём, пока трекер их сбросит.
+	var top []struct {
+		OriginalURL string `json:"original_url"`
+		Clicks      int    `json:"clicks"`
+	}
+	require.Eventually(t, func() bool {
+		rec := do(http.MethodGet, "/api/user/urls/top?window=1d", "")
+		top = nil
+		return rec.Code == http.StatusOK && json.Unmarshal(rec.Body.Bytes(), &top) == nil && len(top) == 2
+	}, 3*time.Second, 50*time.Millisecond)
+	assert.Equal(t, "https://example.com/popular", top[0].OriginalURL)
+	assert.Equal(t, 3, top[0].Clicks)
+	assert.Equal(t, 1, top[1].Clicks)
+
+	rec := do(http.MethodGet, "/api/user/urls"+popular+"/stats?window=30d", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var stats struct {
+		Clicks    int            `json:"clicks"`
+		Bots      int            `json:"bots"`
+		Countries map[string]int `json:"countries"`
+		Referrers map[string]int `json:"referrers"`
+		Browsers  map[string]int `json:"browsers"`
+		Devices   map[string]int `json:"devices"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stats))
+	assert.Equal(t, 3, stats.Clicks)
+	assert.Equal(t, 2, stats.Bots)
+	assert.Empty(t, stats.Countries, "no GeoIP database configured")
+	assert.Equal(t, map[string]int{"news.example": 3}, stats.Referrers)
+	assert.Equal(t, map[string]int{"Safari": 3}, stats.Browsers)
+	assert.Equal(t, map[string]int{"mobile": 3}, stats.Devices)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/user/urls/nosuchid/stats", "").Code)
+}
+
+func TestClickEventsStream(t *testing.T) {
+	cfg := config.NewConfig()
+	ts := httptest.NewServer(endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: cfg, Version: "testversion"}).Router())
+	defer ts.Close()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	send := func(method, path, body string) *http.Response {
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.AddCookie(&http.Cookie{Name: "UserID", Value: "live-user:sig"})
+		req.Header.Set("Referer", "https://news.example/")
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := send(http.MethodPost, "/", "https://example.com/launch")
+	shortURL, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	path := "/" + store.ShortIDFromURL(string(shortURL), cfg.BaseURL)
+
+	stream := send(http.MethodGet, "/api/user/urls"+path+"/events", "")
+	defer stream.Body.Close()
+	require.Equal(t, http.StatusOK, stream.StatusCode)
+	assert.Equal(t, "text/event-stream", stream.Header.Get("Content-Type"))
+
+	resp = send(http.MethodGet, path, "")
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+
+	buf := make([]byte, 512)
+	n, err := stream.Body.Read(buf)
+	require.NoError(t, err)
+	assert.Contains(t, string(buf[:n]), "event: click")
+	assert.Contains(t, string(buf[:n]), "https://news.example/")
+}
+
 func TestGzipHandling(t *testing.T) {
 	cfg := config.NewConfig()
 	storeNotImported := store.NewMemoryStorage()
-	router := endpoints.NewRouter(cfg, storeNotImported, "testversion")
+	router := endpoints.New(endpoints.Deps{Store: storeNotImported, Config: cfg, Version: "testversion"}).Router()
 	ts := httptest.NewServer(router)
 	defer ts.Close()
 
@@ -204,8 +1031,2375 @@ func TestGzipHandling(t *testing.T) {
 		require.NoError(t, err, "Failed to unmarshal response JSON")
 		require.Contains(t, respData["result"], cfg.BaseURL)
 	})
+
+	t.Run("Redirect_NotCompressed", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://example.com/gzip-redirect")))
+		require.Equal(t, http.StatusCreated, rec.Code)
+		path := strings.TrimPrefix(rec.Body.String(), strings.TrimSuffix(cfg.BaseURL, "/"))
+
+		req := httptest.NewRequest(http.MethodGet, path, http.NoBody)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+		assert.Empty(t, rec.Header().Get("Content-Encoding"))
+		assert.False(t, isGzipData(rec.Body.Bytes()))
+		assert.Equal(t, "https://example.com/gzip-redirect", rec.Header().Get("Location"))
+	})
 }
 
 func isGzipData(data []byte) bool {
 	return len(data) >= 2 && data[0] == 0x1F && data[1] == 0x8B
 }
+
+func TestSplitVariants(t *testing.T) {
+	cfg := config.NewConfig()
+	router := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: cfg, Version: "testversion"}).Router()
+
+	shorten := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+		req.AddCookie(&http.Cookie{Name: "UserID", Value: "splitter:sig"})
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	assert.Equal(t, http.StatusBadRequest, shorten(`{"url":"https://example.com/","variants":[{"url":"https://a.example/","weight":1}]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, shorten(`{"url":"https://example.com/",`+
+		`"variants":[{"url":"https://a.example/","weight":0},{"url":"https://b.example/","weight":1}]}`).Code)
+
+	rec := shorten(`{"url":"https://example.com/landing","sticky":true,` +
+		`"variants":[{"url":"https://a.example/","weight":1},{"url":"https://b.example/","weight":3}]}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var created struct {
+		Result string `json:"result"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	path := "/" + store.ShortIDFromURL(created.Result, cfg.BaseURL)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, http.NoBody))
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	first := rec.Header().Get("Location")
+	assert.Contains(t, []string{"https://a.example/", "https://b.example/"}, first)
+	var variantCookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if strings.HasPrefix(c.Name, "ab_") {
+			variantCookie = c
+		}
+	}
+	require.NotNil(t, variantCookie)
+
+	// Липкий тест: с cookie посетитель всегда попадает на тот же вариант.
+	for i := 0; i < 5; i++ {
+		req := httptest.NewRequest(http.MethodGet, path, http.NoBody)
+		req.AddCookie(variantCookie)
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, first, rec.Header().Get("Location"))
+	}
+}
+
+func TestUTMTemplate(t *testing.T) {
+	cfg := config.NewConfig()
+	router := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: cfg, Version: "testversion"}).Router()
+
+	shorten := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+		req.AddCookie(&http.Cookie{Name: "UserID", Value: "marketer:sig"})
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	assert.Equal(t, http.StatusBadRequest, shorten(`{"url":"https://example.com/","utm":"utm_source=%zz"}`).Code)
+
+	rec := shorten(`{"url":"https://example.com/p?x=1&utm_source=newsletter","tags":["spring"],` +
+		`"utm":"utm_source=shortener&utm_campaign={tag}&utm_content={id}"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var created struct {
+		Result string `json:"result"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	id := store.ShortIDFromURL(created.Result, cfg.BaseURL)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+id, http.NoBody))
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	// Заданный в адресе utm_source не перезаписывается, остальное дописывается в конец.
+	assert.Equal(t, "https://example.com/p?x=1&utm_source=newsletter&utm_campaign=spring&utm_content="+id,
+		rec.Header().Get("Location"))
+}
+
+func TestDeviceTargets(t *testing.T) {
+	cfg := config.NewConfig()
+	router := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: cfg, Version: "testversion"}).Router()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://example.com/app",`+
+		`"devices":{"mobile":"https://m.example.com/app","ios":"https://apps.apple.com/app/id1"}}`))
+	req.AddCookie(&http.Cookie{Name: "UserID", Value: "app-owner:sig"})
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var created struct {
+		Result string `json:"result"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	path := "/" + store.ShortIDFromURL(created.Result, cfg.BaseURL)
+
+	tests := []struct {
+		name, userAgent, want string
+	}{
+		{"iPhone goes to the App Store", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) Mobile/15E148", "https://apps.apple.com/app/id1"},
+		{"Android falls back to mobile", "Mozilla/5.0 (Linux; Android 14; Pixel 8) Chrome/124.0 Mobile Safari/537.36", "https://m.example.com/app"},
+		{"desktop keeps the main URL", "Mozilla/5.0 (X11; Linux x86_64) Chrome/124.0 Safari/537.36", "https://example.com/app"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, path, http.NoBody)
+			req.Header.Set("User-Agent", tt.userAgent)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+			assert.Equal(t, tt.want, rec.Header().Get("Location"))
+			assert.Equal(t, "User-Agent", rec.Header().Get("Vary"))
+		})
+	}
+}
+
+func TestGeoTargets(t *testing.T) {
+	cfg := config.NewConfig()
+	router := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: cfg, Version: "testversion"}).Router()
+
+	shorten := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+		req.AddCookie(&http.Cookie{Name: "UserID", Value: "geo-owner:sig"})
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	assert.Equal(t, http.StatusBadRequest, shorten(`{"url":"https://example.com/","geo":{"Europe":"https://example.eu/"}}`).Code)
+
+	rec := shorten(`{"url":"https://example.com/shop","geo":{"eu":"https://example.eu/shop","de-by":"https://example.de/bayern"}}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var created struct {
+		Result string `json:"result"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+
+	// Без базы GeoIP страна посетителя неизвестна — переход идёт на основной адрес.
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+store.ShortIDFromURL(created.Result, cfg.BaseURL), http.NoBody))
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	assert.Equal(t, "https://example.com/shop", rec.Header().Get("Location"))
+}
+
+func TestRobotsAndFavicon(t *testing.T) {
+	cfg := config.NewConfig()
+	router := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: cfg, Version: "testversion"}).Router()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/robots.txt", http.NoBody))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Disallow: /\n")
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/favicon.ico", http.NoBody))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "image/x-icon", rec.Header().Get("Content-Type"))
+	assert.NotEmpty(t, rec.Body.Bytes())
+}
+
+func TestDashboard(t *testing.T) {
+	cfg := config.NewConfig()
+	router := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: cfg, Version: "testversion"}).Router()
+
+	do := func(user, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.AddCookie(&http.Cookie{Name: "UserID", Value: user + ":sig"})
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := do("ui-user", http.MethodGet, "/", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `<form id="shorten">`)
+
+	rec = do("ui-user", http.MethodPost, "/", "https://example.com/dashboard")
+	require.Equal(t, http.StatusCreated, rec.Code)
+	shortURL := rec.Body.String()
+	id := store.ShortIDFromURL(shortURL, cfg.BaseURL)
+
+	rec = do("ui-user", http.MethodGet, "/ui", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), shortURL)
+	assert.Contains(t, rec.Body.String(), "/ui/links/"+id)
+
+	rec = do("ui-user", http.MethodGet, "/ui/links/"+id+"?window=30d", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "clicks")
+	assert.Equal(t, http.StatusNotFound, do("ui-stranger", http.MethodGet, "/ui/links/"+id, "").Code)
+}
+
+func TestFlaggedInterstitial(t *testing.T) {
+	cfg := *config.NewConfig()
+	cfg.AdminToken = "admin-secret"
+	router := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: &cfg, Version: "testversion"}).Router()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://login.example.net/")))
+	require.Equal(t, http.StatusCreated, rec.Code)
+	path := "/" + store.ShortIDFromURL(rec.Body.String(), cfg.BaseURL)
+
+	flag := httptest.NewRequest(http.MethodPut, "/api/admin/urls"+path+"/flag", strings.NewReader(`{"reason":"phishing"}`))
+	flag.Header.Set("Authorization", "Bearer admin-secret")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, flag)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, http.NoBody))
+	require.Equal(t, http.StatusOK, rec.Code, "flagged link must not redirect automatically")
+	assert.Contains(t, rec.Body.String(), "https://login.example.net/")
+	assert.Contains(t, rec.Body.String(), "phishing")
+	assert.Contains(t, rec.Body.String(), path+"?confirm=1")
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path+"?confirm=1", http.NoBody))
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	assert.Equal(t, "https://login.example.net/", rec.Header().Get("Location"))
+}
+
+// signedCookie — кука userID, подписанная auth, как её выдал бы сервис.
+func signedCookie(auth *middleware.Auth, userID string) *http.Cookie {
+	rec := httptest.NewRecorder()
+	auth.SetUserID(rec, userID)
+	return rec.Result().Cookies()[0]
+}
+
+func TestDeleteAccount(t *testing.T) {
+	cfg := config.NewConfig()
+	auditLog, err := audit.NewFileLog(filepath.Join(t.TempDir(), "audit.log"), logging.Nop())
+	require.NoError(t, err)
+	defer func() { _ = auditLog.Close() }()
+	storage := audit.NewStore(store.NewMemoryStorage(), auditLog, logging.Nop())
+	auth := middleware.NewAuth("erase-secret", nil, middleware.CookieOptions{})
+	router := endpoints.New(endpoints.Deps{Store: storage, Config: cfg, Audit: auditLog, Auth: auth, Version: "testversion"}).Router()
+
+	send := func(cookie *http.Cookie, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	do := func(user, method, path, body string) *httptest.ResponseRecorder {
+		return send(signedCookie(auth, user), method, path, body)
+	}
+	var paths []string
+	for _, u := range []string{"https://example.com/erase-1", "https://example.com/erase-2"} {
+		rec := do("leaving-user", http.MethodPost, "/", u)
+		require.Equal(t, http.StatusCreated, rec.Code)
+		paths = append(paths, "/"+store.ShortIDFromURL(rec.Body.String(), cfg.BaseURL))
+	}
+	rec := do("staying-user", http.MethodPost, "/", "https://example.com/stays")
+	require.Equal(t, http.StatusCreated, rec.Code)
+	stayingID := store.ShortIDFromURL(rec.Body.String(), cfg.BaseURL)
+	require.NoError(t, storage.SetMeta(context.Background(), "staying-user", stayingID, store.LinkMeta{Title: "Stays"}))
+	do("visitor", http.MethodGet, paths[0], "")
+
+	// Переход пишется в фоне: ждём его, чтобы отчёт был предсказуемым.
+	require.Eventually(t, func() bool {
+		rec := do("leaving-user", http.MethodGet, "/api/user/urls"+paths[0]+"/stats", "")
+		return rec.Code == http.StatusOK && strings.Contains(rec.Body.String(), `"clicks":1`)
+	}, 3*time.Second, 50*time.Millisecond)
+
+	// Кука с чужим userID без верной подписи ничего не стирает.
+	forged := &http.Cookie{Name: "UserID", Value: "leaving-user:forged-signature"}
+	assert.Equal(t, http.StatusUnauthorized, send(forged, http.MethodDelete, "/api/user/account", "").Code)
+	assert.Equal(t, http.StatusOK, do("leaving-user", http.MethodGet, "/api/user/urls", "").Code)
+
+	rec = do("leaving-user", http.MethodDelete, "/api/user/account", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var report endpoints.ErasureReport
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	assert.Equal(t, endpoints.ErasureReport{Links: 2, Clicks: 1, AuditEvents: 2}, report)
+
+	assert.Equal(t, http.StatusNotFound, do("visitor", http.MethodGet, paths[1], "").Code)
+	events, err := auditLog.Query(context.Background(), audit.Filter{})
+	require.NoError(t, err)
+	actions := make(map[audit.Action]int)
+	for _, e := range events {
+		actions[e.Action]++
+		if e.Action == audit.ActionErase {
+			assert.Empty(t, e.UserID, "erasure events do not keep the erased user")
+		} else {
+			assert.Equal(t, "staying-user", e.UserID)
+		}
+	}
+	assert.Equal(t, map[audit.Action]int{audit.ActionCreate: 1, audit.ActionMeta: 1, audit.ActionErase: 2}, actions)
+}
+
+func TestExportUserData(t *testing.T) {
+	cfg := config.NewConfig()
+	auth := middleware.NewAuth("export-secret", nil, middleware.CookieOptions{})
+	router := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: cfg, Auth: auth, Version: "testversion"}).Router()
+
+	send := func(cookie *http.Cookie, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	do := func(user, method, path, body string) *httptest.ResponseRecorder {
+		return send(signedCookie(auth, user), method, path, body)
+	}
+	require.Equal(t, http.StatusCreated, do("exporter", http.MethodPost, "/api/shorten",
+		`{"url":"https://example.com/mine","tags":["keep"],"password":"s3cret"}`).Code)
+	require.Equal(t, http.StatusCreated, do("someone-else", http.MethodPost, "/", "https://example.com/theirs").Code)
+
+	// Выгрузка по куке без верной подписи не отдаётся.
+	forged := &http.Cookie{Name: "UserID", Value: "exporter:forged-signature"}
+	assert.Equal(t, http.StatusUnauthorized, send(forged, http.MethodGet, "/api/user/export", "").Code)
+
+	rec := do("exporter", http.MethodGet, "/api/user/export", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get("Content-Disposition"), "attachment")
+	assert.NotContains(t, rec.Body.String(), "password_hash")
+	var export endpoints.UserExport
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &export))
+	require.Len(t, export.Links, 1)
+	assert.Equal(t, "https://example.com/mine", export.Links[0].OriginalURL)
+	assert.Equal(t, []string{"keep"}, export.Links[0].Tags)
+	assert.True(t, export.Links[0].PasswordProtected)
+
+	rec = do("exporter", http.MethodGet, "/api/user/export?format=zip", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	require.NoError(t, err)
+	require.Len(t, zr.File, 1)
+	assert.Equal(t, "export.json", zr.File[0].Name)
+}
+
+func TestAnonymizeIP(t *testing.T) {
+	defer func() { require.NoError(t, middleware.InitIPAnonymization(middleware.AnonymizeOff, "")) }()
+
+	assert.Equal(t, "203.0.113.77", middleware.AnonymizeIP("203.0.113.77"))
+
+	require.NoError(t, middleware.InitIPAnonymization(middleware.AnonymizeTruncate, ""))
+	assert.Equal(t, "203.0.113.0", middleware.AnonymizeIP("203.0.113.77"))
+	assert.Equal(t, "2001:db8:abcd::", middleware.AnonymizeIP("2001:db8:abcd:12::1"))
+
+	require.NoError(t, middleware.InitIPAnonymization(middleware.AnonymizeHash, "secret"))
+	hashed := middleware.AnonymizeIP("203.0.113.77")
+	assert.Len(t, hashed, 16)
+	assert.Equal(t, hashed, middleware.AnonymizeIP("203.0.113.77"))
+	assert.NotEqual(t, hashed, middleware.AnonymizeIP("203.0.113.78"))
+
+	assert.Error(t, middleware.InitIPAnonymization("scramble", ""))
+}
+
+func TestRetention(t *testing.T) {
+	ctx := context.Background()
+	storage := store.NewMemoryStorage()
+	log := clicks.NewMemoryLog()
+	now := time.Now().UTC()
+	require.NoError(t, storage.ImportRecords(ctx, []store.Record{
+		{ShortURL: "stale", OriginalURL: "https://example.com/stale", UserID: "u1", CreatedAt: now.AddDate(-1, 0, 0)},
+		{ShortURL: "clicked", OriginalURL: "https://example.com/clicked", UserID: "u1", CreatedAt: now.AddDate(-1, 0, 0)},
+		{ShortURL: "young", OriginalURL: "https://example.com/young", UserID: "u2", CreatedAt: now.AddDate(0, 0, -1)},
+	}))
+	require.NoError(t, log.Record(ctx, clicks.Click{Time: now.AddDate(0, 0, -3), ShortID: "clicked"}))
+
+	policy := retention.Policy{Idle: 90 * 24 * time.Hour, MinAge: 30 * 24 * time.Hour, DryRun: true}
+	rep, err := retention.Run(ctx, storage, log, policy)
+	require.NoError(t, err)
+	assert.Equal(t, 3, rep.Checked)
+	assert.Equal(t, []string{"stale"}, rep.Links)
+	assert.Zero(t, rep.Deleted)
+	_, deleted, err := storage.LoadFull(ctx, "stale")
+	require.NoError(t, err)
+	assert.False(t, deleted)
+
+	// Удаление чисткой уходит подписчикам как link.expired, а не link.deleted.
+	events := make(chan store.Event, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e store.Event
+		_ = json.NewDecoder(r.Body).Decode(&e)
+		events <- e
+	}))
+	defer srv.Close()
+	runner := jobs.NewRunner(jobs.NewMemoryQueue(), logging.Nop())
+	dispatcher := webhook.NewDispatcher(srv.URL, "secret", jobs.Retry{}, runner, logging.Nop())
+	runner.Start(1)
+	defer func() { _ = runner.Stop(context.Background()) }()
+
+	policy.DryRun = false
+	rep, err = retention.Run(ctx, webhook.NewStore(storage, dispatcher), log, policy)
+	require.NoError(t, err)
+	assert.Equal(t, 1, rep.Deleted)
+	_, deleted, err = storage.LoadFull(ctx, "stale")
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	select {
+	case e := <-events:
+		assert.Equal(t, store.EventExpired, e.Type)
+		assert.Equal(t, "stale", e.ShortID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no webhook for the expired link")
+	}
+
+	// Журнал в памяти, поднятый минуту назад, ничего не знает о переходах за 90 дней.
+	policy.HistorySince = now.Add(-time.Minute)
+	_, err = retention.Run(ctx, storage, log, policy)
+	assert.ErrorIs(t, err, retention.ErrShortHistory)
+}
+
+// TestRDBImportTagsOnlyInserted проверяет, что метки пропущенной при импорте строки не
+// попадают чужой ссылке и не обрывают пакет. Нужна тестовая БД: TEST_DATABASE_DSN.
+func TestRDBImportTagsOnlyInserted(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	ctx := context.Background()
+	rdb, err := store.NewRDB(ctx, dsn, store.RDBOptions{}, logging.Nop())
+	require.NoError(t, err)
+	defer func() { _ = rdb.Close(ctx) }()
+	require.NoError(t, rdb.Bootstrap(ctx))
+
+	suffix := strconv.FormatInt(time.Now().UnixNano()%1e9, 36)
+	owner := "import-tags-" + suffix
+	defer func() { _, _ = rdb.EraseUser(ctx, owner) }()
+	existing, fresh, clash := "it"+suffix+"a", "it"+suffix+"b", "it"+suffix+"c"
+	existingURL := "https://example.com/import-tags/" + suffix
+
+	require.NoError(t, rdb.ImportRecords(ctx, []store.Record{
+		{ShortURL: existing, OriginalURL: existingURL, UserID: owner, Meta: &store.LinkMeta{Tags: []string{"kept"}}},
+	}))
+	require.NoError(t, rdb.ImportRecords(ctx, []store.Record{
+		// Тот же shortID с другим адресом и тот же адрес под другим shortID — обе строки пропускаются.
+		{ShortURL: existing, OriginalURL: existingURL + "/other", UserID: owner, Meta: &store.LinkMeta{Tags: []string{"stray"}}},
+		{ShortURL: clash, OriginalURL: existingURL, UserID: owner, Meta: &store.LinkMeta{Tags: []string{"ghost"}}},
+		{ShortURL: fresh, OriginalURL: existingURL + "/fresh", UserID: owner, Meta: &store.LinkMeta{Tags: []string{"new"}}},
+	}))
+
+	meta, err := rdb.LoadMeta(ctx, existing)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"kept"}, meta.Tags, "the skipped row's tags do not attach to the existing link")
+	meta, err = rdb.LoadMeta(ctx, fresh)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"new"}, meta.Tags)
+	_, err = rdb.LoadMeta(ctx, clash)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+// BenchmarkRDBUserQueries сравнивает LoadUserURLs и DeleteBatch с индексом (user_id, is_deleted)
+// и без него. Нужна отдельная тестовая БД: go test -bench RDB с TEST_DATABASE_DSN.
+func BenchmarkRDBUserQueries(b *testing.B) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		b.Skip("TEST_DATABASE_DSN is not set")
+	}
+	const users, linksPerUser = 500, 40
+
+	ctx := context.Background()
+	rdb, err := store.NewRDB(ctx, dsn, store.RDBOptions{}, logging.Nop())
+	require.NoError(b, err)
+	defer func() { _ = rdb.Close(ctx) }()
+	require.NoError(b, rdb.Bootstrap(ctx))
+
+	records := make([]store.Record, 0, users*linksPerUser)
+	for u := range users {
+		for l := range linksPerUser {
+			records = append(records, store.Record{
+				ShortURL:    fmt.Sprintf("bu%dl%d", u, l),
+				OriginalURL: fmt.Sprintf("https://example.com/bench/%d/%d", u, l),
+				UserID:      fmt.Sprintf("bench-user-%d", u),
+			})
+		}
+	}
+	require.NoError(b, rdb.ImportRecords(ctx, records))
+	defer func() {
+		for u := range users {
+			_, _ = rdb.EraseUser(ctx, fmt.Sprintf("bench-user-%d", u))
+		}
+	}()
+	ids := make([]string, linksPerUser)
+	for l := range ids {
+		ids[l] = fmt.Sprintf("bu7l%d", l)
+	}
+
+	run := func(b *testing.B) {
+		b.Run("LoadUserURLs", func(b *testing.B) {
+			for range b.N {
+				_, loadErr := rdb.LoadUserURLs(ctx, "bench-user-3", "http://localhost:8080/")
+				require.NoError(b, loadErr)
+			}
+		})
+		b.Run("DeleteBatch", func(b *testing.B) {
+			for range b.N {
+				_, err := rdb.DeleteBatch(ctx, "bench-user-7", ids)
+				require.NoError(b, err)
+			}
+		})
+	}
+
+	_, err = rdb.Pool().Exec(ctx, `DROP INDEX short_urls_user_id_is_deleted_idx; ANALYZE short_urls;`)
+	require.NoError(b, err)
+	b.Run("without_index", run)
+
+	require.NoError(b, rdb.Bootstrap(ctx))
+	_, err = rdb.Pool().Exec(ctx, `ANALYZE short_urls;`)
+	require.NoError(b, err)
+	b.Run("with_index", run)
+}
+
+func TestConflictError(t *testing.T) {
+	var err error = &store.ConflictError{OwnerID: "first-user"}
+	assert.ErrorIs(t, err, store.ErrConflict)
+	assert.False(t, store.IsFailure(err))
+
+	var conflict *store.ConflictError
+	require.ErrorAs(t, fmt.Errorf("save: %w", err), &conflict)
+	assert.Equal(t, "first-user", conflict.OwnerID)
+}
+
+func TestShortenBatchDuplicates(t *testing.T) {
+	cfg := config.NewConfig()
+	storage := store.NewMemoryStorage()
+	router := endpoints.New(endpoints.Deps{Store: storage, Config: cfg, Version: "testversion"}).Router()
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.AddCookie(&http.Cookie{Name: "UserID", Value: "batch-user:sig"})
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := do(http.MethodPost, "/api/shorten/batch", `[
+		{"correlation_id":"c3","original_url":"https://example.com/dup","tags":["one"]},
+		{"correlation_id":"c1","original_url":"https://example.com/single"},
+		{"correlation_id":"c2","original_url":"https://example.com/dup","tags":["two"]}
+	]`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var resp []struct {
+		CorrelationID string `json:"correlation_id"`
+		ShortURL      string `json:"short_url"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Len(t, resp, 3)
+	assert.Equal(t, []string{"c3", "c1", "c2"}, []string{resp[0].CorrelationID, resp[1].CorrelationID, resp[2].CorrelationID})
+	assert.Equal(t, resp[0].ShortURL, resp[2].ShortURL)
+	assert.NotEqual(t, resp[0].ShortURL, resp[1].ShortURL)
+
+	rec = do(http.MethodGet, "/api/user/urls", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var list []store.UserURL
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Len(t, list, 2)
+	for _, item := range list {
+		if item.OriginalURL == "https://example.com/dup" {
+			assert.Equal(t, []string{"one", "two"}, item.Tags)
+		}
+	}
+}
+
+// TestSaveBatchDuplicates checks that a URL repeated in one batch is stored once by every backend.
+func TestSaveBatchDuplicates(t *testing.T) {
+	cfg := *config.NewConfig()
+	cfg.FileStoragePath = filepath.Join(t.TempDir(), "data.json")
+	fileStorage := store.NewStorage(&cfg, logging.Nop())
+	defer func() { _ = fileStorage.Close(context.Background()) }()
+
+	dup, err := url.Parse("https://example.com/same")
+	require.NoError(t, err)
+	other, err := url.Parse("https://example.com/other")
+	require.NoError(t, err)
+
+	for name, s := range map[string]store.Store{"memory": store.NewMemoryStorage(), "file": fileStorage} {
+		t.Run(name, func(t *testing.T) {
+			saved, saveErr := s.SaveBatch(context.Background(), "u1", []*url.URL{dup, other, dup}, nil, &cfg)
+			require.NoError(t, saveErr)
+			require.Len(t, saved, 3)
+			assert.Equal(t, saved[0], saved[2])
+			assert.NotEqual(t, saved[0].ShortURL, saved[1].ShortURL)
+
+			list, loadErr := s.LoadUserURLs(context.Background(), "u1", cfg.BaseURL)
+			require.NoError(t, loadErr)
+			assert.Len(t, list, 2)
+		})
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	released := make(chan struct{})
+	slow := middleware.Timeout(50 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		w.Header().Set("X-Late", "1")
+		_, err := w.Write([]byte("too late"))
+		assert.ErrorIs(t, err, http.ErrHandlerTimeout)
+		close(released)
+	}))
+	rec := httptest.NewRecorder()
+	slow.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", http.NoBody))
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+	<-released
+	assert.Empty(t, rec.Header().Get("X-Late"))
+	assert.NotContains(t, rec.Body.String(), "too late")
+
+	fast := middleware.Timeout(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusTeapot)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	rec = httptest.NewRecorder()
+	fast.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fast", http.NoBody))
+	assert.Equal(t, http.StatusTeapot, rec.Code)
+	assert.Equal(t, "text/plain", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "ok", rec.Body.String())
+}
+
+func TestLimitConcurrency(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	handler := middleware.LimitConcurrency(0, 1)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			entered <- struct{}{}
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	first := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", http.NoBody))
+		first <- rec.Code
+	}()
+	<-entered
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", http.NoBody))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/abc", http.NoBody))
+	assert.Equal(t, http.StatusOK, rec.Code, "redirects have their own limit")
+
+	close(release)
+	assert.Equal(t, http.StatusOK, <-first)
+}
+
+func TestMiddlewareMetrics(t *testing.T) {
+	cfg := *config.NewConfig()
+	cfg.AdminToken = "metrics-token"
+	router := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: &cfg, Version: "testversion"}).Router()
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://example.com/metrics-"+strings.Repeat("long", 64)))
+	req.Header.Set("Accept-Encoding", "gzip")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, http.StatusUnauthorized, func() int {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", http.NoBody))
+		return rec.Code
+	}())
+
+	req = httptest.NewRequest(http.MethodGet, "/metrics", http.NoBody)
+	req.Header.Set("Authorization", "Bearer metrics-token")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var vars struct {
+		Gzip map[string]any   `json:"gzip"`
+		Auth map[string]int64 `json:"auth"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &vars))
+	assert.GreaterOrEqual(t, vars.Gzip["negotiated_gzip"], float64(1))
+	assert.Greater(t, vars.Gzip["bytes_in"], float64(0))
+	assert.Greater(t, vars.Gzip["bytes_out"], float64(0))
+	assert.Contains(t, vars.Gzip, "ratio")
+	assert.GreaterOrEqual(t, vars.Auth["cookies_issued_missing"], int64(1))
+}
+
+// TestHandlersIsolated checks that two Handlers in one process keep their own keys, IDs and logs.
+func TestHandlersIsolated(t *testing.T) {
+	cfg := *config.NewConfig()
+	cfg.AdminToken = "isolated-token"
+	storage := store.NewMemoryStorage()
+
+	var logs bytes.Buffer
+	first := endpoints.New(endpoints.Deps{
+		Store:  storage,
+		Config: &cfg,
+		Logger: logging.New(&logs, "info", "test"),
+		IDGen:  func() string { return "first-user" },
+		Auth:   middleware.NewAuth("first-secret", func() string { return "first-user" }, middleware.CookieOptions{}),
+	}).Router()
+	second := endpoints.New(endpoints.Deps{
+		Store:  storage,
+		Config: &cfg,
+		Auth:   middleware.NewAuth("second-secret", nil, middleware.CookieOptions{}),
+	}).Router()
+
+	do := func(router http.Handler, method, path, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(first, http.MethodPost, "/", "https://example.com/isolated", nil)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	owner := rec.Result().Cookies()
+	_ = rec.Result().Body.Close()
+	require.NotEmpty(t, owner)
+	assert.True(t, strings.HasPrefix(owner[0].Value, "first-user:"))
+	id := store.ShortIDFromURL(rec.Body.String(), cfg.BaseURL)
+
+	rec = do(first, http.MethodPost, "/api/user/urls/"+id+"/transfer", `{}`, owner)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var issued struct {
+		Token string `json:"claim_token"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &issued))
+
+	// Токен подписан ключом первого экземпляра, второй его не принимает.
+	rec = do(second, http.MethodPost, "/", "https://example.com/second-visitor", nil)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	visitor := rec.Result().Cookies()
+	_ = rec.Result().Body.Close()
+	assert.Equal(t, http.StatusForbidden, do(second, http.MethodPost, "/api/user/urls/claim", `{"token":"`+issued.Token+`"}`, visitor).Code)
+
+	// Смена ключа первого не трогает второй, а сообщение о ней попадает только в лог первого.
+	rotate := httptest.NewRequest(http.MethodPost, "/api/admin/keys/rotate", http.NoBody)
+	rotate.Header.Set("Authorization", "Bearer isolated-token")
+	rec = httptest.NewRecorder()
+	first.ServeHTTP(rec, rotate)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Contains(t, logs.String(), "Cookie signing key rotated")
+	// Кука тоже подписана прежним ключом, поэтому берём новую.
+	rec = do(first, http.MethodPost, "/", "https://example.com/after-rotation", nil)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	rotated := rec.Result().Cookies()
+	_ = rec.Result().Body.Close()
+	assert.Equal(t, http.StatusForbidden, do(first, http.MethodPost, "/api/user/urls/claim", `{"token":"`+issued.Token+`"}`, rotated).Code)
+}
+
+func TestVersionEndpoint(t *testing.T) {
+	cfg := config.NewConfig()
+	router := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: cfg, Version: "testversion"}).Router()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/version", http.NoBody))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get("Content-Type"), "application/json")
+	var info buildinfo.Info
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &info))
+	assert.Equal(t, "testversion", info.Version)
+	assert.Equal(t, runtime.Version(), info.GoVersion)
+
+	// Старые клиенты по-прежнему получают версию строкой.
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/version/", http.NoBody))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "testversion", rec.Body.String())
+}
+
+func TestUpgradeInheritsListener(t *testing.T) {
+	parent, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer parent.Close()
+	lnFile, err := parent.(*net.TCPListener).File()
+	require.NoError(t, err)
+	defer lnFile.Close()
+	readyR, readyW, err := os.Pipe()
+	require.NoError(t, err)
+	defer readyR.Close()
+	defer readyW.Close()
+
+	// Так окружение выглядит в процессе, запущенном upgrade.Upgrade. Listen и Ready
+	// закрывают переданные дескрипторы, поэтому отдаём им копии.
+	lnFD, err := syscall.Dup(int(lnFile.Fd()))
+	require.NoError(t, err)
+	readyFD, err := syscall.Dup(int(readyW.Fd()))
+	require.NoError(t, err)
+	t.Setenv("SHORTENER_LISTEN_FD", strconv.Itoa(lnFD))
+	t.Setenv("SHORTENER_READY_FD", strconv.Itoa(readyFD))
+
+	ln, err := upgrade.Listen("127.0.0.1:1")
+	require.NoError(t, err)
+	assert.Equal(t, parent.Addr().String(), ln.Addr().String())
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("new"))
+	})}
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Close()
+
+	require.NoError(t, upgrade.Ready())
+	b := make([]byte, 1)
+	_, err = readyR.Read(b)
+	require.NoError(t, err)
+
+	resp, err := http.Get("http://" + ln.Addr().String())
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(body))
+}
+
+func BenchmarkRedirect(b *testing.B) {
+	cfg := config.NewConfig()
+	storage := store.NewMemoryStorage()
+	router := endpoints.New(endpoints.Deps{Store: storage, Config: cfg, Version: "testversion"}).Router()
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://example.com/benchmark?q=1"))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(b, http.StatusCreated, rec.Code)
+	path := strings.TrimPrefix(rec.Body.String(), strings.TrimSuffix(cfg.BaseURL, "/"))
+
+	for _, enc := range []string{"identity", "gzip"} {
+		b.Run(enc, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				req := httptest.NewRequest(http.MethodGet, path, http.NoBody)
+				req.Header.Set("Accept-Encoding", enc)
+				rec := httptest.NewRecorder()
+				router.ServeHTTP(rec, req)
+				if rec.Code != http.StatusTemporaryRedirect {
+					b.Fatalf("status %d", rec.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestLoggingBodyCapture(t *testing.T) {
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(w, r.Body)
+	})
+	serve := func(maxBody int) string {
+		var logs bytes.Buffer
+		handler := middleware.WithLogging(logging.New(&logs, "info", "test"), maxBody)(echo)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("0123456789abcdef")))
+		require.Equal(t, "0123456789abcdef", rec.Body.String())
+		return logs.String()
+	}
+
+	logs := serve(8)
+	assert.Contains(t, logs, "01234567…")
+	assert.NotContains(t, logs, "89abcdef")
+
+	logs = serve(0)
+	assert.NotContains(t, logs, "request_body")
+	assert.NotContains(t, logs, "response_body")
+	assert.Contains(t, logs, "Ответ отправлен")
+}
+
+func TestTrustedProxies(t *testing.T) {
+	trusted, err := middleware.ParseTrustedProxies("10.0.0.0/8, 192.0.2.1")
+	require.NoError(t, err)
+	_, err = middleware.ParseTrustedProxies("10.0.0.0/8,not-a-cidr")
+	assert.Error(t, err)
+
+	handler := middleware.ClientIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(middleware.GetClientIP(r.Context())))
+	}))
+	resolve := func(remoteAddr string, headers map[string]string) string {
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.RemoteAddr = remoteAddr
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+
+	// Чужой клиент не может подставить себе адрес.
+	assert.Equal(t, "198.51.100.7", resolve("198.51.100.7:1234", map[string]string{"X-Forwarded-For": "203.0.113.5"}))
+	// Через цепочку доверенных прокси берётся первый недоверенный адрес справа.
+	assert.Equal(t, "203.0.113.5", resolve("10.1.2.3:1234", map[string]string{"X-Forwarded-For": "1.1.1.1, 203.0.113.5, 10.0.0.2"}))
+	assert.Equal(t, "203.0.113.9", resolve("192.0.2.1:1234", map[string]string{"X-Real-IP": "203.0.113.9"}))
+	assert.Equal(t, "10.1.2.3", resolve("10.1.2.3:1234", nil))
+}
+
+func TestCSRF(t *testing.T) {
+	cfg := *config.NewConfig()
+	cfg.CSRFProtection = true
+	router := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: &cfg, Version: "testversion"}).Router()
+
+	// Страница кабинета выдаёт куку с токеном и кладёт тот же токен в разметку.
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+	require.Equal(t, http.StatusOK, rec.Code)
+	cookies := rec.Result().Cookies()
+	_ = rec.Result().Body.Close()
+	var token string
+	for _, c := range cookies {
+		if c.Name == "csrf_token" {
+			token = c.Value
+			assert.Equal(t, http.SameSiteStrictMode, c.SameSite)
+		}
+	}
+	require.NotEmpty(t, token)
+	assert.Contains(t, rec.Body.String(), token)
+
+	shorten := func(headers map[string]string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://example.com/csrf"}`))
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Запрос со страницы чужого сайта без токена отклоняется, со страницы сервиса — проходит.
+	assert.Equal(t, http.StatusForbidden, shorten(map[string]string{"Origin": "https://evil.example"}))
+	assert.Equal(t, http.StatusForbidden, shorten(map[string]string{"Origin": "https://evil.example", middleware.CSRFHeader: "forged"}))
+	assert.Equal(t, http.StatusCreated, shorten(map[string]string{"Sec-Fetch-Site": "same-origin", middleware.CSRFHeader: token}))
+	// API-клиенты: bearer-токен и запросы без браузерных заголовков.
+	assert.NotEqual(t, http.StatusForbidden, shorten(map[string]string{"Origin": "https://evil.example", "Authorization": "Bearer api-key"}))
+	assert.NotEqual(t, http.StatusForbidden, shorten(nil))
+}
+
+func TestAuthCookieAttributes(t *testing.T) {
+	_, err := middleware.ParseCookieOptions("false", "none", "https://short.example/", 0, 0)
+	assert.Error(t, err, "SameSite=None without Secure")
+	opts, err := middleware.ParseCookieOptions("auto", "strict", "https://short.example/", time.Hour, time.Minute)
+	require.NoError(t, err)
+	assert.True(t, opts.Secure)
+
+	auth := middleware.NewAuth("secret", func() string { return "cookie-user" }, opts)
+	handler := auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	issue := func(cookie *http.Cookie) *http.Cookie {
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		for _, c := range rec.Result().Cookies() {
+			if c.Name == "UserID" {
+				return c
+			}
+		}
+		return nil
+	}
+
+	fresh := issue(nil)
+	require.NotNil(t, fresh)
+	assert.True(t, fresh.Secure)
+	assert.True(t, fresh.HttpOnly)
+	assert.Equal(t, http.SameSiteStrictMode, fresh.SameSite)
+	assert.Equal(t, 3600, fresh.MaxAge)
+
+	// Свежая кука не переиздаётся, старая и кука без времени выдачи — продлеваются.
+	assert.Nil(t, issue(fresh))
+	userID, sig, _ := strings.Cut(fresh.Value, ":")
+	sig, _, _ = strings.Cut(sig, ":")
+	stale := &http.Cookie{Name: "UserID", Value: fmt.Sprintf("%s:%s:%d", userID, sig, time.Now().Add(-2*time.Minute).Unix())}
+	renewed := issue(stale)
+	require.NotNil(t, renewed)
+	assert.True(t, strings.HasPrefix(renewed.Value, "cookie-user:"))
+	assert.NotNil(t, issue(&http.Cookie{Name: "UserID", Value: userID + ":" + sig}))
+}
+
+func TestRestoreUserURLs(t *testing.T) {
+	cfg := *config.NewConfig()
+	storage := store.NewMemoryStorage()
+	router := endpoints.New(endpoints.Deps{Store: storage, Config: &cfg, Version: "testversion"}).Router()
+	ctx := context.Background()
+
+	mineURL, err := url.Parse("https://example.com/restore/mine")
+	require.NoError(t, err)
+	theirsURL, err := url.Parse("https://example.com/restore/theirs")
+	require.NoError(t, err)
+	mine, err := storage.Save(ctx, "restorer", mineURL, store.LinkMeta{}, &cfg)
+	require.NoError(t, err)
+	theirs, err := storage.Save(ctx, "someone-else", theirsURL, store.LinkMeta{}, &cfg)
+	require.NoError(t, err)
+	mineID := store.ShortIDFromURL(mine, cfg.BaseURL)
+	theirsID := store.ShortIDFromURL(theirs, cfg.BaseURL)
+	_, err = storage.DeleteBatch(ctx, "restorer", []string{mineID})
+	require.NoError(t, err)
+	_, err = storage.DeleteBatch(ctx, "someone-else", []string{theirsID})
+	require.NoError(t, err)
+
+	restore := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/user/urls/restore", strings.NewReader(body))
+		req.AddCookie(&http.Cookie{Name: "UserID", Value: "restorer:sig"})
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusBadRequest, restore(`[]`).Code)
+	// Чужая удалённая ссылка остаётся удалённой.
+	rec := restore(`["` + mineID + `","` + theirsID + `"]`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `["`+mineID+`"]`, rec.Body.String())
+	_, deleted, err := storage.LoadFull(ctx, mineID)
+	require.NoError(t, err)
+	assert.False(t, deleted)
+	_, deleted, err = storage.LoadFull(ctx, theirsID)
+	require.NoError(t, err)
+	assert.True(t, deleted)
+
+	// Повтор ничего не меняет, вычищенную ссылку не вернуть.
+	assert.JSONEq(t, `[]`, restore(`["`+mineID+`"]`).Body.String())
+	_, err = storage.DeleteBatch(ctx, "restorer", []string{mineID})
+	require.NoError(t, err)
+	_, err = storage.PurgeDeleted(ctx)
+	require.NoError(t, err)
+	assert.JSONEq(t, `[]`, restore(`["`+mineID+`"]`).Body.String())
+}
+
+func TestDeleteUserURLsSync(t *testing.T) {
+	cfg := *config.NewConfig()
+	storage := store.NewMemoryStorage()
+	router := endpoints.New(endpoints.Deps{Store: storage, Config: &cfg, Version: "testversion"}).Router()
+	ctx := context.Background()
+
+	mineURL, err := url.Parse("https://example.com/delete/mine")
+	require.NoError(t, err)
+	theirsURL, err := url.Parse("https://example.com/delete/theirs")
+	require.NoError(t, err)
+	mine, err := storage.Save(ctx, "deleter", mineURL, store.LinkMeta{}, &cfg)
+	require.NoError(t, err)
+	theirs, err := storage.Save(ctx, "someone-else", theirsURL, store.LinkMeta{}, &cfg)
+	require.NoError(t, err)
+	mineID := store.ShortIDFromURL(mine, cfg.BaseURL)
+	theirsID := store.ShortIDFromURL(theirs, cfg.BaseURL)
+
+	body := `["` + mineID + `","` + theirsID + `","missing1"]`
+	req := httptest.NewRequest(http.MethodDelete, "/api/user/urls?sync=true", strings.NewReader(body))
+	req.AddCookie(&http.Cookie{Name: "UserID", Value: "deleter:sig"})
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[
+		{"id":"`+mineID+`","status":"deleted"},
+		{"id":"`+theirsID+`","status":"forbidden"},
+		{"id":"missing1","status":"not_found"}
+	]`, rec.Body.String())
+
+	_, deleted, err := storage.LoadFull(ctx, mineID)
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	_, deleted, err = storage.LoadFull(ctx, theirsID)
+	require.NoError(t, err)
+	assert.False(t, deleted)
+}
+
+func TestDeleteJobStatus(t *testing.T) {
+	cfg := *config.NewConfig()
+	storage := store.NewMemoryStorage()
+	router := endpoints.New(endpoints.Deps{Store: storage, Config: &cfg, Version: "testversion"}).Router()
+
+	u, err := url.Parse("https://example.com/job/mine")
+	require.NoError(t, err)
+	short, err := storage.Save(context.Background(), "job-owner", u, store.LinkMeta{}, &cfg)
+	require.NoError(t, err)
+	id := store.ShortIDFromURL(short, cfg.BaseURL)
+
+	do := func(method, path, body, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.AddCookie(&http.Cookie{Name: "UserID", Value: user + ":sig"})
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodDelete, "/api/user/urls", `["`+id+`","missing1"]`, "job-owner")
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	var accepted struct {
+		JobID string `json:"job_id"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &accepted))
+	require.NotEmpty(t, accepted.JobID)
+	assert.Equal(t, "/api/user/jobs/"+accepted.JobID, rec.Header().Get("Location"))
+
+	var job struct {
+		Status    string `json:"status"`
+		Requested int    `json:"requested"`
+		Deleted   int    `json:"deleted"`
+		NotFound  int    `json:"not_found"`
+	}
+	require.Eventually(t, func() bool {
+		rec := do(http.MethodGet, "/api/user/jobs/"+accepted.JobID, "", "job-owner")
+		return rec.Code == http.StatusOK && json.Unmarshal(rec.Body.Bytes(), &job) == nil && job.Status == "completed"
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, job.Requested)
+	assert.Equal(t, 1, job.Deleted)
+	assert.Equal(t, 1, job.NotFound)
+
+	// Чужое задание не видно.
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/user/jobs/"+accepted.JobID, "", "someone-else").Code)
+}
+
+func TestJobQueueSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.jsonl")
+	queue, err := jobs.NewFileQueue(path, logging.Nop())
+	require.NoError(t, err)
+	// Процесс поставил задание и упал, не успев его выполнить.
+	id, err := jobs.NewRunner(queue, logging.Nop()).Enqueue(context.Background(), "echo", "u1", map[string]string{"say": "hi"})
+	require.NoError(t, err)
+	require.NoError(t, queue.Close())
+
+	queue, err = jobs.NewFileQueue(path, logging.Nop())
+	require.NoError(t, err)
+	defer queue.Close()
+	runner := jobs.NewRunner(queue, logging.Nop())
+	attempts := 0
+	runner.Handle("echo", 3, func(ctx context.Context, job jobs.Job) (any, error) {
+		attempts++
+		if attempts == 1 {
+			return nil, errors.New("transient")
+		}
+		return json.RawMessage(job.Payload), nil
+	})
+	runner.Start(1)
+	defer func() { _ = runner.Stop(context.Background()) }()
+
+	var job jobs.Job
+	require.Eventually(t, func() bool {
+		job, err = queue.Get(context.Background(), id)
+		return err == nil && job.Finished()
+	}, 5*time.Second, 20*time.Millisecond)
+	assert.Equal(t, jobs.StatusCompleted, job.Status)
+	assert.Equal(t, 2, job.Attempts)
+	assert.Equal(t, "u1", job.UserID)
+	assert.JSONEq(t, `{"say":"hi"}`, string(job.Result))
+}
+
+func TestMaintenanceScheduler(t *testing.T) {
+	at := func(s string) time.Time {
+		ts, err := time.Parse("2006-01-02 15:04", s)
+		require.NoError(t, err)
+		return ts
+	}
+	for _, tc := range []struct{ expr, from, want string }{
+		{"30 3 * * *", "2026-10-16 03:30", "2026-10-17 03:30"},
+		{"*/15 * * * *", "2026-10-16 10:07", "2026-10-16 10:15"},
+		{"0 0 1 * *", "2026-12-15 00:00", "2027-01-01 00:00"},
+		{"0 4 * * 1-5", "2026-10-16 05:00", "2026-10-19 04:00"}, // пятница -> понедельник
+		{"@every 90m", "2026-10-16 10:00", "2026-10-16 11:30"},
+	} {
+		s, err := scheduler.Parse(tc.expr)
+		require.NoError(t, err, tc.expr)
+		assert.Equal(t, at(tc.want), s.Next(at(tc.from)), tc.expr)
+	}
+	for _, bad := range []string{"* * *", "60 * * * *", "0 0 * * mon", "*/0 * * * *", "@every 1ms"} {
+		_, err := scheduler.Parse(bad)
+		assert.Error(t, err, bad)
+	}
+
+	sched := scheduler.New(logging.Nop())
+	require.NoError(t, sched.Add("purge", "0 3 * * *", func(context.Context) (any, error) {
+		return map[string]int{"purged": 2}, nil
+	}))
+	require.NoError(t, sched.Add("disabled", "", nil))
+	sched.Start()
+	defer func() { _ = sched.Stop(context.Background()) }()
+
+	cfg := *config.NewConfig()
+	cfg.AdminToken = "sched-token"
+	router := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: &cfg, Scheduler: sched}).Router()
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, http.NoBody)
+		req.Header.Set("Authorization", "Bearer sched-token")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/api/admin/scheduler/purge/run")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/api/admin/scheduler/disabled/run").Code)
+
+	rec = do(http.MethodGet, "/api/admin/scheduler")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var statuses []struct {
+		Name       string         `json:"name"`
+		Schedule   string         `json:"schedule"`
+		NextRun    *time.Time     `json:"next_run"`
+		LastEnd    *time.Time     `json:"last_end"`
+		LastResult map[string]int `json:"last_result"`
+		Runs       int            `json:"runs"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &statuses))
+	require.Len(t, statuses, 1)
+	assert.Equal(t, "purge", statuses[0].Name)
+	assert.Equal(t, "0 3 * * *", statuses[0].Schedule)
+	assert.Equal(t, 1, statuses[0].Runs)
+	assert.NotNil(t, statuses[0].LastEnd)
+	assert.Equal(t, 2, statuses[0].LastResult["purged"])
+}
+
+func TestRoutePrefix(t *testing.T) {
+	cfg := *config.NewConfig()
+	cfg.BaseURL = "http://proxy.example.com/"
+	cfg.RoutePrefix = "s/"
+	router := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: &cfg}).Router()
+	assert.Equal(t, "/s", cfg.RoutePrefix)
+	assert.Equal(t, "http://proxy.example.com/s/", cfg.BaseURL)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.AddCookie(&http.Cookie{Name: "UserID", Value: "prefixed-user:sig"})
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/s/", "https://example.com/behind-proxy")
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.True(t, strings.HasPrefix(rec.Body.String(), "http://proxy.example.com/s/"), rec.Body.String())
+	id := store.ShortIDFromURL(rec.Body.String(), cfg.BaseURL)
+
+	rec = do(http.MethodGet, "/s/"+id, "")
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	assert.Equal(t, "https://example.com/behind-proxy", rec.Header().Get("Location"))
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/"+id, "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/s"+id, "").Code)
+
+	rec = do(http.MethodGet, "/s", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `\/s/api/shorten`)
+
+	rec = do(http.MethodDelete, "/s/api/user/urls", `["`+id+`"]`)
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	assert.True(t, strings.HasPrefix(rec.Header().Get("Location"), "/s/api/user/jobs/"), rec.Header().Get("Location"))
+
+	// Cookie липкого варианта должна приходить браузеру и на путь с префиксом.
+	rec = do(http.MethodPost, "/s/api/shorten", `{"url":"https://example.com/landing","sticky":true,`+
+		`"variants":[{"url":"https://a.example/","weight":1},{"url":"https://b.example/","weight":1}]}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var created struct {
+		Result string `json:"result"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	splitID := store.ShortIDFromURL(created.Result, cfg.BaseURL)
+	rec = do(http.MethodGet, "/s/"+splitID, "")
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	var variantCookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if strings.HasPrefix(c.Name, "ab_") {
+			variantCookie = c
+		}
+	}
+	require.NotNil(t, variantCookie)
+	assert.Equal(t, "/s/"+splitID, variantCookie.Path)
+}
+
+func TestBaseURLFromHost(t *testing.T) {
+	cfg := *config.NewConfig()
+	cfg.BaseURLFromHost = true
+	cfg.TrustedProxies = "10.0.0.0/8"
+	router := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: &cfg}).Router()
+
+	shorten := func(target, remote string, header http.Header) string {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader("https://example.com/multi-host"))
+		req.RemoteAddr = remote
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		require.Contains(t, []int{http.StatusCreated, http.StatusConflict}, rec.Code)
+		return rec.Body.String()
+	}
+
+	assert.True(t, strings.HasPrefix(shorten("http://one.example/", "192.0.2.1:5000", nil), "http://one.example/"))
+	assert.True(t, strings.HasPrefix(shorten("https://two.example:8443/", "192.0.2.1:5000", nil), "https://two.example:8443/"))
+
+	viaProxy := http.Header{"Forwarded": {`for=192.0.2.7;proto=https;host="sho.rt", for=10.0.0.2`}}
+	assert.True(t, strings.HasPrefix(shorten("http://internal:8080/", "10.0.0.1:5000", viaProxy), "https://sho.rt/"))
+	legacy := http.Header{"X-Forwarded-Proto": {"https"}, "X-Forwarded-Host": {"legacy.example"}}
+	assert.True(t, strings.HasPrefix(shorten("http://internal:8080/", "10.0.0.1:5000", legacy), "https://legacy.example/"))
+
+	// Заголовки от недоверенного клиента и мусорные хосты не учитываются.
+	assert.True(t, strings.HasPrefix(shorten("http://one.example/", "192.0.2.1:5000", legacy), "http://one.example/"))
+	bad := http.Header{"X-Forwarded-Host": {"evil.example/phish"}}
+	assert.True(t, strings.HasPrefix(shorten("http://internal:8080/", "10.0.0.1:5000", bad), "http://internal:8080/"))
+}
+
+func TestShortenOnSelectedDomain(t *testing.T) {
+	cfg := *config.NewConfig()
+	cfg.BaseURL = "http://localhost:8080/"
+	cfg.TenantBaseURLs = "https://go.example/"
+	router := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: &cfg}).Router()
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.AddCookie(&http.Cookie{Name: "UserID", Value: "domains-user:sig"})
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/api/shorten", `{"url":"https://example.com/on-tenant","domain":"GO.example"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var created struct {
+		Result string `json:"result"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	require.True(t, strings.HasPrefix(created.Result, "https://go.example/"), created.Result)
+	id := store.ShortIDFromURL(created.Result, "https://go.example/")
+
+	// Ссылка живёт только на выбранном домене.
+	assert.Equal(t, http.StatusTemporaryRedirect, do(http.MethodGet, "https://go.example/"+id, "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/"+id, "").Code)
+
+	rec = do(http.MethodPost, "https://go.example/?domain=localhost:8080", "https://example.com/on-main")
+	require.Equal(t, http.StatusCreated, rec.Code)
+	assert.True(t, strings.HasPrefix(rec.Body.String(), cfg.BaseURL), rec.Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/shorten", `{"url":"https://example.com/x","domain":"evil.example"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/shorten/batch?domain=evil.example", `[{"correlation_id":"1","original_url":"https://example.com/y"}]`).Code)
+}
+
+func TestContentNegotiation(t *testing.T) {
+	cfg := *config.NewConfig()
+	cfg.BaseURL = "http://localhost:8080/"
+	router := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: &cfg}).Router()
+	shorten := func(accept, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"`+target+`"}`))
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusCreated, rec.Code)
+		return rec
+	}
+
+	rec := shorten("", "https://example.com/neg-json")
+	assert.Equal(t, "application/json; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Header().Values("Vary"), "Accept")
+
+	rec = shorten("application/json;q=0.5, application/xml", "https://example.com/neg-xml")
+	assert.Equal(t, "application/xml; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Regexp(t, `^<\?xml .*\?>\s*<response><result>http://localhost:8080/\w+</result></response>\s*$`, rec.Body.String())
+
+	rec = shorten("application/msgpack", "https://example.com/neg-msgpack")
+	assert.Equal(t, "application/msgpack", rec.Header().Get("Content-Type"))
+	body := rec.Body.Bytes()
+	// fixmap из одной пары, ключ — fixstr "result", значение — fixstr или str8 с адресом.
+	require.Greater(t, len(body), 10)
+	assert.Equal(t, []byte{0x81, 0xa6}, body[:2])
+	assert.Equal(t, "result", string(body[2:8]))
+	assert.Contains(t, string(body[8:]), "http://localhost:8080/")
+
+	// Незнакомый формат не ломает клиента: отвечаем JSON.
+	rec = shorten("text/csv", "https://example.com/neg-unknown")
+	assert.Equal(t, "application/json; charset=utf-8", rec.Header().Get("Content-Type"))
+
+	nop := negotiate.EncoderFunc(func(io.Writer, any) error { return nil })
+	registry := negotiate.NewRegistry()
+	registry.Register("application/json", negotiate.Format{Encoder: nop})
+	registry.Register("text/csv", negotiate.Format{ContentType: "text/csv; header=present", Encoder: nop})
+	assert.Equal(t, "text/csv; header=present", registry.Negotiate("text/*;q=0.9, application/json;q=0.1").ContentType)
+	assert.Equal(t, "application/json", registry.Negotiate("image/png").ContentType)
+}
+
+func TestProblemDetails(t *testing.T) {
+	cfg := *config.NewConfig()
+	cfg.BaseURL = "http://localhost:8080/"
+	cfg.AdminToken = "problem-token"
+	cfg.BlockedDomains = "blocked.example"
+	router := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: &cfg}).Router()
+	type problem struct {
+		Type      string `json:"type"`
+		Title     string `json:"title"`
+		Status    int    `json:"status"`
+		Detail    string `json:"detail"`
+		Instance  string `json:"instance"`
+		RequestID string `json:"request_id"`
+		Code      string `json:"code"`
+		URL       string `json:"url"`
+	}
+	do := func(req *http.Request) (*httptest.ResponseRecorder, problem) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		require.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+		var p problem
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &p), rec.Body.String())
+		assert.Equal(t, rec.Code, p.Status)
+		assert.Equal(t, rec.Header().Get("X-Request-ID"), p.RequestID)
+		return rec, p
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader("{not json"))
+	req.Header.Set("X-Request-ID", "lb-42")
+	rec, p := do(req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, problem{Type: "about:blank", Title: "Bad Request", Status: 400, Detail: "Failed to parse JSON",
+		Instance: "/api/shorten", RequestID: "lb-42"}, p)
+
+	rec, p = do(httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://blocked.example/x"}`)))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Equal(t, "destination_blocked", p.Code)
+	assert.Equal(t, "https://blocked.example/x", p.URL)
+	assert.NotEmpty(t, p.RequestID)
+
+	// Ошибки middleware тоже в problem+json.
+	rec, p = do(httptest.NewRequest(http.MethodGet, "/api/admin/stats", http.NoBody))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "Unauthorized", p.Title)
+
+	// Короткие ссылки открывают браузеры: там ошибка остаётся текстом.
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/missing-id", http.NoBody))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "Short URL not found\n", rec.Body.String())
+}
+
+func TestRateLimit(t *testing.T) {
+	cfg := *config.NewConfig()
+	cfg.BaseURL = "http://localhost:8080/"
+	cfg.RateLimit = 60
+	cfg.RateLimitBurst = 2
+	router := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: &cfg}).Router()
+	ping := func(h http.Handler, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/ping", http.NoBody)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for want := 1; want >= 0; want-- {
+		rec := ping(router, "192.0.2.1")
+		require.NotEqual(t, http.StatusTooManyRequests, rec.Code)
+		assert.Equal(t, "2", rec.Header().Get("X-RateLimit-Limit"))
+		assert.Equal(t, strconv.Itoa(want), rec.Header().Get("X-RateLimit-Remaining"))
+	}
+	rec := ping(router, "192.0.2.1")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+	// Лимит у каждого IP свой.
+	assert.NotEqual(t, http.StatusTooManyRequests, ping(router, "192.0.2.2").Code)
+
+	// Экземпляры с общим лимитером (как с Redis) делят счёт.
+	cfg.RateLimit = 0
+	shared := ratelimit.NewMemory(ratelimit.Limit{PerMinute: 60, Burst: 1})
+	first := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: &cfg, RateLimiter: shared}).Router()
+	second := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: &cfg, RateLimiter: shared}).Router()
+	assert.NotEqual(t, http.StatusTooManyRequests, ping(first, "192.0.2.3").Code)
+	assert.Equal(t, http.StatusTooManyRequests, ping(second, "192.0.2.3").Code)
+}
+
+// sluggishStore замедляет сохранение и чтение ссылки.
+type sluggishStore struct {
+	*store.MemoryStorage
+}
+
+func (s sluggishStore) Save(ctx context.Context, userID string, u *url.URL, meta store.LinkMeta, cfg *config.Config) (string, error) {
+	time.Sleep(5 * time.Millisecond)
+	return s.MemoryStorage.Save(ctx, userID, u, meta, cfg)
+}
+
+func (s sluggishStore) LoadFull(ctx context.Context, shortID string) (*url.URL, bool, error) {
+	time.Sleep(5 * time.Millisecond)
+	return s.MemoryStorage.LoadFull(ctx, shortID)
+}
+
+// hangingStore ждёт, пока у вызывающего истечёт срок, и ломается на LoadMeta.
+type hangingStore struct {
+	*store.MemoryStorage
+}
+
+func (s hangingStore) LoadFull(ctx context.Context, _ string) (*url.URL, bool, error) {
+	<-ctx.Done()
+	return nil, false, ctx.Err()
+}
+
+func (s hangingStore) LoadMeta(context.Context, string) (store.LinkMeta, error) {
+	return store.LinkMeta{}, errors.New("connection reset")
+}
+
+func TestBreakerCallerDeadline(t *testing.T) {
+	s := breaker.NewStore(hangingStore{store.NewMemoryStorage()}, breaker.New(1, time.Hour, logging.Nop()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	_, _, err := s.LoadFull(ctx, "slow")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	_, err = s.LoadOwner(context.Background(), "missing")
+	require.ErrorIs(t, err, store.ErrNotFound, "the caller's own deadline does not open the breaker")
+
+	_, err = s.LoadMeta(context.Background(), "missing")
+	require.Error(t, err)
+	var unavailable *store.UnavailableError
+	_, err = s.LoadOwner(context.Background(), "missing")
+	assert.ErrorAs(t, err, &unavailable, "a backend failure does")
+}
+
+func TestSlowStoreOperations(t *testing.T) {
+	cfg := *config.NewConfig()
+	cfg.BaseURL = "http://localhost:8080/"
+	cfg.AdminToken = "slow-token"
+	var logs bytes.Buffer
+	logger := logging.New(&logs, "info", "test")
+	storage := slowlog.NewStore(sluggishStore{store.NewMemoryStorage()}, 2*time.Millisecond, logger)
+	router := endpoints.New(endpoints.Deps{Store: storage, Config: &cfg}).Router()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://example.com/reset?token=secret-token")))
+	require.Equal(t, http.StatusCreated, rec.Code)
+	id := store.ShortIDFromURL(rec.Body.String(), cfg.BaseURL)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+id, http.NoBody))
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+
+	out := logs.String()
+	assert.Contains(t, out, "Slow store operation")
+	assert.Equal(t, 2, strings.Count(out, "Slow store operation"))
+	assert.Contains(t, out, "LoadFull")
+	assert.Contains(t, out, "https://example.com")
+	// Путь и запрос адреса в лог не попадают.
+	assert.NotContains(t, out, "secret-token")
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", http.NoBody)
+	req.Header.Set("Authorization", "Bearer slow-token")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var vars struct {
+		SlowOps map[string]int64 `json:"store_slow_ops"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &vars))
+	assert.GreaterOrEqual(t, vars.SlowOps["LoadFull"], int64(1))
+	assert.GreaterOrEqual(t, vars.SlowOps["total"], int64(2))
+}
+
+func TestStoreMetrics(t *testing.T) {
+	cfg := *config.NewConfig()
+	cfg.BaseURL = "http://localhost:8080/"
+	cfg.AdminToken = "store-metrics-token"
+	memory := store.NewMemoryStorage()
+	storage := storemetrics.NewStore(memory, "memory")
+	assert.Same(t, memory, store.Unwrap(storage))
+	router := endpoints.New(endpoints.Deps{Store: storage, Config: &cfg}).Router()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://example.com/measured")))
+	require.Equal(t, http.StatusCreated, rec.Code)
+	id := store.ShortIDFromURL(rec.Body.String(), cfg.BaseURL)
+	for _, path := range []string{"/" + id, "/missing-id"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, http.NoBody))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", http.NoBody)
+	req.Header.Set("Authorization", "Bearer store-metrics-token")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var vars struct {
+		Store map[string]map[string]float64 `json:"store"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &vars))
+	m := vars.Store["memory"]
+	assert.GreaterOrEqual(t, m["Save_calls"], float64(1))
+	assert.GreaterOrEqual(t, m["LoadFull_calls"], float64(2))
+	assert.Contains(t, m, "LoadFull_seconds")
+	// Ненайденная ссылка — не сбой хранилища.
+	assert.Zero(t, m["LoadFull_errors"])
+}
+
+func TestCacheWarmup(t *testing.T) {
+	ctx := context.Background()
+	cfg := *config.NewConfig()
+	cfg.BaseURL = "http://localhost:8080/"
+	memory := store.NewMemoryStorage()
+	var ids []string
+	for _, target := range []string{"https://example.com/hot", "https://example.com/warm", "https://example.com/cold"} {
+		u, err := url.Parse(target)
+		require.NoError(t, err)
+		link, err := memory.Save(ctx, "user", u, store.LinkMeta{}, &cfg)
+		require.NoError(t, err)
+		ids = append(ids, store.ShortIDFromURL(link, cfg.BaseURL))
+	}
+
+	log := clicks.NewMemoryLog()
+	now := time.Now()
+	require.NoError(t, log.Record(ctx,
+		clicks.Click{ShortID: ids[1], Time: now}, clicks.Click{ShortID: ids[0], Time: now},
+		clicks.Click{ShortID: ids[0], Time: now}, clicks.Click{ShortID: ids[2], Time: now.Add(-48 * time.Hour)},
+		clicks.Click{ShortID: ids[2], Time: now, Bot: true}))
+	top, err := log.Top(ctx, 10, now.Add(-24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, ids[:2], top)
+
+	cached := cache.NewStore(memory, 2, 0, nil, logging.Nop())
+	assert.Equal(t, 2, cached.Warm(ctx, append(top, "missing")))
+	assert.Equal(t, top, cached.HotIDs(10))
+
+	// Горячий список переживает перезапуск через файл.
+	path := filepath.Join(t.TempDir(), "hotset")
+	loaded, err := cache.LoadHotSet(path)
+	require.NoError(t, err)
+	assert.Empty(t, loaded)
+	require.NoError(t, cache.SaveHotSet(path, cached.HotIDs(10)))
+	loaded, err = cache.LoadHotSet(path)
+	require.NoError(t, err)
+	assert.Equal(t, top, loaded)
+}
+
+// countingStore считает обращения к LoadFull и LoadMeta.
+type countingStore struct {
+	*store.MemoryStorage
+	loads     atomic.Int64
+	metaLoads atomic.Int64
+}
+
+func (s *countingStore) LoadFull(ctx context.Context, shortID string) (*url.URL, bool, error) {
+	s.loads.Add(1)
+	return s.MemoryStorage.LoadFull(ctx, shortID)
+}
+
+func (s *countingStore) LoadMeta(ctx context.Context, shortID string) (store.LinkMeta, error) {
+	s.metaLoads.Add(1)
+	return s.MemoryStorage.LoadMeta(ctx, shortID)
+}
+
+func TestCacheLinkMeta(t *testing.T) {
+	ctx := context.Background()
+	cfg := *config.NewConfig()
+	cfg.BaseURL = "http://localhost:8080/"
+	backend := &countingStore{MemoryStorage: store.NewMemoryStorage()}
+	u, err := url.Parse("https://example.com/meta")
+	require.NoError(t, err)
+	// Настройки сохраняются вместе со ссылкой, без отдельного SetMeta.
+	link, err := backend.Save(ctx, "user", u, store.LinkMeta{Title: "first", Domain: "go.example"}, &cfg)
+	require.NoError(t, err)
+	id := store.ShortIDFromURL(link, cfg.BaseURL)
+
+	cached := cache.NewStore(backend, 8, 0, nil, logging.Nop())
+	for range 3 {
+		meta, metaErr := cached.LoadMeta(ctx, id)
+		require.NoError(t, metaErr)
+		assert.Equal(t, "first", meta.Title)
+		assert.Equal(t, "go.example", meta.Domain)
+	}
+	assert.Equal(t, int64(1), backend.metaLoads.Load())
+
+	require.NoError(t, cached.SetMeta(ctx, "user", id, store.LinkMeta{Title: "second"}))
+	meta, err := cached.LoadMeta(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "second", meta.Title)
+
+	_, err = cached.LoadMeta(ctx, "missing")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+// gatedStore задерживает LoadFull до сигнала в release, отдав прочитанное значение в loaded.
+type gatedStore struct {
+	*store.MemoryStorage
+	loaded  chan struct{}
+	release chan struct{}
+}
+
+func (s *gatedStore) LoadFull(ctx context.Context, shortID string) (*url.URL, bool, error) {
+	u, isDeleted, err := s.MemoryStorage.LoadFull(ctx, shortID)
+	s.loaded <- struct{}{}
+	<-s.release
+	return u, isDeleted, err
+}
+
+func TestCacheStalePut(t *testing.T) {
+	ctx := context.Background()
+	cfg := *config.NewConfig()
+	cfg.BaseURL = "http://localhost:8080/"
+	backend := &gatedStore{MemoryStorage: store.NewMemoryStorage(), loaded: make(chan struct{}), release: make(chan struct{})}
+	old, err := url.Parse("https://example.com/old")
+	require.NoError(t, err)
+	link, err := backend.MemoryStorage.Save(ctx, "user", old, store.LinkMeta{}, &cfg)
+	require.NoError(t, err)
+	id := store.ShortIDFromURL(link, cfg.BaseURL)
+	cached := cache.NewStore(backend, 8, 0, nil, logging.Nop())
+
+	// Чтение прочитало старый адрес, но положить его в кэш успевает только после UpdateURL.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _, _ = cached.LoadFull(ctx, id)
+	}()
+	<-backend.loaded
+	updated, err := url.Parse("https://example.com/new")
+	require.NoError(t, err)
+	require.NoError(t, cached.UpdateURL(ctx, "user", id, updated))
+	close(backend.release)
+	<-done
+
+	go func() { <-backend.loaded }()
+	u, _, err := cached.LoadFull(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, updated.String(), u.String())
+}
+
+func TestCacheTTL(t *testing.T) {
+	ctx := context.Background()
+	cfg := *config.NewConfig()
+	cfg.BaseURL = "http://localhost:8080/"
+	backend := &countingStore{MemoryStorage: store.NewMemoryStorage()}
+	u, err := url.Parse("https://example.com/ttl")
+	require.NoError(t, err)
+	link, err := backend.Save(ctx, "user", u, store.LinkMeta{}, &cfg)
+	require.NoError(t, err)
+	id := store.ShortIDFromURL(link, cfg.BaseURL)
+
+	cached := cache.NewStore(backend, 8, 20*time.Millisecond, nil, logging.Nop())
+	for range 2 {
+		_, _, err = cached.LoadFull(ctx, id)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int64(1), backend.loads.Load())
+	time.Sleep(30 * time.Millisecond)
+	_, _, err = cached.LoadFull(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), backend.loads.Load())
+}
+
+func TestBloomFilter(t *testing.T) {
+	ctx := context.Background()
+	cfg := *config.NewConfig()
+	cfg.BaseURL = "http://localhost:8080/"
+	backend := &countingStore{MemoryStorage: store.NewMemoryStorage()}
+	u, err := url.Parse("https://example.com/existing")
+	require.NoError(t, err)
+	existing, err := backend.Save(ctx, "user", u, store.LinkMeta{}, &cfg)
+	require.NoError(t, err)
+
+	filtered := bloom.NewStore(backend, logging.Nop())
+	require.NoError(t, filtered.Rebuild(ctx))
+	router := endpoints.New(endpoints.Deps{Store: filtered, Config: &cfg}).Router()
+	get := func(id string) int {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+id, http.NoBody))
+		return rec.Code
+	}
+
+	// Перебор несуществующих id почти не доходит до хранилища.
+	for i := range 100 {
+		assert.Equal(t, http.StatusNotFound, get(fmt.Sprintf("probe%03d", i)))
+	}
+	assert.LessOrEqual(t, backend.loads.Load(), int64(5))
+
+	assert.Equal(t, http.StatusTemporaryRedirect, get(store.ShortIDFromURL(existing, cfg.BaseURL)))
+	// Новые ссылки попадают в фильтр сразу.
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://example.com/fresh")))
+	require.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, http.StatusTemporaryRedirect, get(store.ShortIDFromURL(rec.Body.String(), cfg.BaseURL)))
+}
+
+// memoryShard — шард поверх памяти: MemoryStorage сам не выбирает id, поэтому AcceptIDs пустой.
+type memoryShard struct {
+	*store.MemoryStorage
+}
+
+func (memoryShard) AcceptIDs(func(string) bool) {}
+
+func TestShardedStore(t *testing.T) {
+	ctx := context.Background()
+	cfg := *config.NewConfig()
+	cfg.BaseURL = "http://localhost:8080/"
+
+	ring := shard.NewRing(3)
+	counts := make([]int, 3)
+	for i := range 3000 {
+		counts[ring.Owner(fmt.Sprintf("id%05d", i))]++
+	}
+	for i, n := range counts {
+		assert.InDelta(t, 1000, n, 300, "shard %d", i)
+	}
+	// Четвёртый шард забирает ключи только у старых, остальные остаются на месте.
+	grown := shard.NewRing(4)
+	for i := range 3000 {
+		id := fmt.Sprintf("id%05d", i)
+		if owner := grown.Owner(id); owner != 3 {
+			assert.Equal(t, ring.Owner(id), owner)
+		}
+	}
+
+	shards := []shard.Shard{
+		memoryShard{store.NewMemoryStorage()},
+		memoryShard{store.NewMemoryStorage()},
+		memoryShard{store.NewMemoryStorage()},
+	}
+	sharded := shard.New(shards)
+	var records []store.Record
+	var ids []string
+	for i := range 30 {
+		id := fmt.Sprintf("link%03d", i)
+		ids = append(ids, id)
+		records = append(records, store.Record{ShortURL: id, OriginalURL: "https://example.com/" + id, UserID: "owner"})
+	}
+	require.NoError(t, sharded.ImportRecords(ctx, records))
+
+	// Каждая запись лежит ровно в своём шарде.
+	for _, id := range ids {
+		for i, sh := range shards {
+			_, _, err := sh.LoadFull(ctx, id)
+			if i == ring.Owner(id) {
+				assert.NoError(t, err, id)
+			} else {
+				assert.ErrorIs(t, err, store.ErrNotFound, id)
+			}
+		}
+		u, _, err := sharded.LoadFull(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com/"+id, u.String())
+	}
+
+	list, err := sharded.LoadUserURLs(ctx, "owner", cfg.BaseURL)
+	require.NoError(t, err)
+	assert.Len(t, list, len(ids))
+
+	// Итог DeleteBatch идёт в порядке запроса, хотя id разошлись по разным шардам.
+	toDelete := []string{ids[5], "missing", ids[0], ids[17]}
+	results, err := sharded.DeleteBatch(ctx, "owner", toDelete)
+	require.NoError(t, err)
+	require.Len(t, results, len(toDelete))
+	for i, res := range results {
+		assert.Equal(t, toDelete[i], res.ShortID)
+	}
+	assert.Equal(t, store.DeleteNotFound, results[1].Status)
+	assert.Equal(t, 3, store.CountDeleted(results))
+
+	var exported int
+	require.NoError(t, sharded.ExportRecords(ctx, func(store.Record) error {
+		exported++
+		return nil
+	}))
+	assert.Equal(t, len(ids), exported)
+}
+
+// memoryOutbox — outbox в памяти: события уходят из него, только если fn не вернула ошибку.
+type memoryOutbox struct {
+	mu     sync.Mutex
+	events []store.Event
+}
+
+func (o *memoryOutbox) DrainOutbox(_ context.Context, limit int, fn func([]store.Event) error) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n := min(limit, len(o.events))
+	if n == 0 {
+		return 0, nil
+	}
+	if err := fn(o.events[:n]); err != nil {
+		return 0, err
+	}
+	o.events = o.events[n:]
+	return n, nil
+}
+
+func (o *memoryOutbox) len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.events)
+}
+
+func TestWebhookOutboxRelay(t *testing.T) {
+	received := make(chan webhook.Event, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e webhook.Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err == nil {
+			received <- e
+		}
+	}))
+	defer srv.Close()
+
+	runner := jobs.NewRunner(jobs.NewMemoryQueue(), logging.Nop())
+	dispatcher := webhook.NewDispatcher(srv.URL, "secret", jobs.Retry{}, runner, logging.Nop())
+	require.NotNil(t, dispatcher)
+	runner.Start(1)
+	defer func() { _ = runner.Stop(context.Background()) }()
+
+	outbox := &memoryOutbox{events: []store.Event{
+		store.NewEvent(store.EventCreated, "u1", "abc", "https://example.com/a"),
+		store.NewEvent(store.EventUpdated, "u1", "abc", "https://example.com/b"),
+		store.NewEvent(store.EventDeleted, "u1", "abc", ""),
+	}}
+	relay := webhook.StartRelay(dispatcher, []webhook.Outbox{outbox}, logging.Nop())
+	defer relay.Stop()
+
+	var got []string
+	for range 3 {
+		select {
+		case e := <-received:
+			assert.Equal(t, "abc", e.ShortID)
+			got = append(got, e.Type)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("webhook deliveries: got %v", got)
+		}
+	}
+	assert.ElementsMatch(t, []string{webhook.EventCreated, webhook.EventUpdated, webhook.EventDeleted}, got)
+	assert.Zero(t, outbox.len())
+}
+
+func TestWebhookDeadLetters(t *testing.T) {
+	var healthy atomic.Bool
+	var delivered atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		delivered.Add(1)
+	}))
+	defer srv.Close()
+
+	runner := jobs.NewRunner(jobs.NewMemoryQueue(), logging.Nop())
+	dispatcher := webhook.NewDispatcher(srv.URL, "secret", jobs.Retry{MaxAttempts: 2, Backoff: 10 * time.Millisecond}, runner, logging.Nop())
+
+	cfg := *config.NewConfig()
+	cfg.AdminToken = "dlq-token"
+	router := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: &cfg, Jobs: runner}).Router()
+	// Воркеры запускаются после того, как New зарегистрировал свои обработчики.
+	runner.Start(1)
+	defer func() { _ = runner.Stop(context.Background()) }()
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, http.NoBody)
+		req.Header.Set("Authorization", "Bearer dlq-token")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	failed := func() []jobs.Job {
+		rec := do(http.MethodGet, "/api/admin/jobs/failed?kind="+webhook.JobKind)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var list []jobs.Job
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+		return list
+	}
+
+	dispatcher.Publish(store.NewEvent(store.EventCreated, "u1", "abc", "https://example.com"))
+	// Обе попытки провалились — доставка осталась в dead letter, а не пропала.
+	require.Eventually(t, func() bool { return len(failed()) == 1 }, 5*time.Second, 20*time.Millisecond)
+	dead := failed()[0]
+	assert.Equal(t, 2, dead.Attempts)
+	assert.Contains(t, dead.Error, "502")
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/api/admin/jobs/failed").Code)
+
+	healthy.Store(true)
+	rec := do(http.MethodPost, "/api/admin/jobs/"+dead.ID+"/retry")
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	require.Eventually(t, func() bool { return delivered.Load() == 1 }, 5*time.Second, 20*time.Millisecond)
+	assert.Empty(t, failed())
+	// Повторять можно только проваленное задание.
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/api/admin/jobs/"+dead.ID+"/retry").Code)
+}
+
+func TestWebhookSignature(t *testing.T) {
+	type signed struct {
+		header http.Header
+		body   []byte
+	}
+	hits := make(chan signed, 4)
+	receiver := func(secret string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			// Каждый получатель проверяет подпись своим секретом.
+			if err := webhook.Verify([]byte(secret), r.Header, body, 0, time.Now()); err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			hits <- signed{header: r.Header.Clone(), body: body}
+		}))
+	}
+	own, shared := receiver("endpoint-secret"), receiver("default-secret")
+	defer own.Close()
+	defer shared.Close()
+
+	runner := jobs.NewRunner(jobs.NewMemoryQueue(), logging.Nop())
+	dispatcher := webhook.NewDispatcher(own.URL+"|endpoint-secret, "+shared.URL, "default-secret", jobs.Retry{MaxAttempts: 1}, runner, logging.Nop())
+	runner.Start(1)
+	defer func() { _ = runner.Stop(context.Background()) }()
+	dispatcher.Publish(store.NewEvent(store.EventCreated, "u1", "abc", "https://example.com"))
+
+	var hit signed
+	for range 2 {
+		select {
+		case hit = <-hits:
+			assert.NotContains(t, string(hit.body), "secret")
+		case <-time.After(5 * time.Second):
+			t.Fatal("webhook was not delivered with a valid signature")
+		}
+	}
+
+	// Подменённое тело, чужой секрет и перехваченный запрос, повторённый позже, отвергаются.
+	now := time.Now()
+	secret := []byte("default-secret")
+	if webhook.Verify(secret, hit.header, hit.body, 0, now) != nil {
+		secret = []byte("endpoint-secret")
+	}
+	require.NoError(t, webhook.Verify(secret, hit.header, hit.body, 0, now))
+	assert.ErrorIs(t, webhook.Verify(secret, hit.header, append(hit.body, ' '), 0, now), webhook.ErrBadSignature)
+	assert.ErrorIs(t, webhook.Verify([]byte("other"), hit.header, hit.body, 0, now), webhook.ErrBadSignature)
+	assert.ErrorIs(t, webhook.Verify(secret, hit.header, hit.body, time.Minute, now.Add(10*time.Minute)), webhook.ErrStale)
+	assert.ErrorIs(t, webhook.Verify(secret, http.Header{}, hit.body, 0, now), webhook.ErrNoSignature)
+}
+
+func TestOperatorNotifications(t *testing.T) {
+	var mu sync.Mutex
+	var slackTexts, telegramTexts []string
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg struct {
+			Text string `json:"text"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&msg)
+		mu.Lock()
+		slackTexts = append(slackTexts, msg.Text)
+		mu.Unlock()
+	}))
+	defer slack.Close()
+	telegram := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg struct {
+			ChatID string `json:"chat_id"`
+			Text   string `json:"text"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&msg)
+		assert.Equal(t, "/botbot-token/sendMessage", r.URL.Path)
+		assert.Equal(t, "42", msg.ChatID)
+		mu.Lock()
+		telegramTexts = append(telegramTexts, msg.Text)
+		mu.Unlock()
+	}))
+	defer telegram.Close()
+
+	notifier := notify.New(logging.Nop(),
+		&notify.Slack{WebhookURL: slack.URL},
+		&notify.Telegram{Token: "bot-token", ChatID: "42", APIURL: telegram.URL})
+
+	// Ссылка набрала порог переходов: одно уведомление, сколько бы переходов ни было дальше.
+	tracker := clicks.NewTracker(clicks.NewMemoryLog(), logging.Nop())
+	tracker.OnThreshold(3, func(shortID string, n int) { notifier.Notify("Link %s reached %d clicks", shortID, n) })
+	for range 5 {
+		tracker.Track(clicks.Click{Time: time.Now(), ShortID: "hot"})
+	}
+	tracker.Track(clicks.Click{Time: time.Now(), ShortID: "cold"})
+	require.NoError(t, tracker.Close())
+
+	// Помеченный адрес.
+	cfg := *config.NewConfig()
+	cfg.BaseURL = "http://localhost:8080/"
+	cfg.AdminToken = "notify-token"
+	storage := store.NewMemoryStorage()
+	u, err := url.Parse("https://phish.example.com/login")
+	require.NoError(t, err)
+	short, err := storage.Save(context.Background(), "owner", u, store.LinkMeta{}, &cfg)
+	require.NoError(t, err)
+	router := endpoints.New(endpoints.Deps{Store: storage, Config: &cfg, Notifier: notifier}).Router()
+	req := httptest.NewRequest(http.MethodPut, "/api/admin/urls/"+store.ShortIDFromURL(short, cfg.BaseURL)+"/flag", strings.NewReader(`{"reason":"phishing"}`))
+	req.Header.Set("Authorization", "Bearer notify-token")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	// Хранилище работает на резервном: БД недоступна с самого старта.
+	fo := failover.NewReconnecting(func(context.Context) (store.Store, error) {
+		return nil, errors.New("connection refused")
+	}, store.NewMemoryStorage(), time.Hour, logging.Nop())
+	defer func() { _ = fo.Close(context.Background()) }()
+	notifyFailover(fo, notifier)
+
+	notifier.Close()
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, slackTexts, telegramTexts)
+	require.Len(t, slackTexts, 3)
+	assert.Equal(t, "Link hot reached 5 clicks", slackTexts[0], "all clicks came in one batch")
+	assert.Contains(t, slackTexts[1], "flagged as phishing")
+	assert.Contains(t, slackTexts[1], "https://phish.example.com/login")
+	assert.Contains(t, slackTexts[2], "Database is unavailable")
+}
+
+func TestFailoverReplay(t *testing.T) {
+	ctx := context.Background()
+	cfg := *config.NewConfig()
+	cfg.BaseURL = "http://localhost:8080/"
+
+	primary := store.NewMemoryStorage()
+	require.NoError(t, primary.ImportRecords(ctx, []store.Record{
+		{ShortURL: "taken", OriginalURL: "https://example.com/primary", UserID: "alice"},
+	}))
+
+	var up atomic.Bool
+	fo := failover.NewReconnecting(func(context.Context) (store.Store, error) {
+		if !up.Load() {
+			return nil, errors.New("connection refused")
+		}
+		return primary, nil
+	}, store.NewMemoryStorage(), 10*time.Millisecond, logging.Nop())
+	defer func() { _ = fo.Close(context.Background()) }()
+	switched := make(chan bool, 2)
+	fo.OnSwitch(func(failedOver bool, _ error) { switched <- failedOver })
+	require.True(t, <-switched)
+
+	fresh, err := url.Parse("https://example.com/fresh")
+	require.NoError(t, err)
+	short, err := fo.Save(ctx, "bob", fresh, store.LinkMeta{}, &cfg)
+	require.NoError(t, err)
+	freshID := store.ShortIDFromURL(short, cfg.BaseURL)
+	// Тот же shortID, что уже занят в primary: ImportRecords его пропустит.
+	require.NoError(t, fo.ImportRecords(ctx, []store.Record{
+		{ShortURL: "taken", OriginalURL: "https://example.com/secondary", UserID: "bob"},
+	}))
+
+	up.Store(true)
+	select {
+	case failedOver := <-switched:
+		require.False(t, failedOver)
+	case <-time.After(5 * time.Second):
+		t.Fatal("primary was not recovered")
+	}
+
+	u, _, err := primary.LoadFull(ctx, freshID)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/fresh", u.String())
+	owner, err := primary.LoadOwner(ctx, freshID)
+	require.NoError(t, err)
+	assert.Equal(t, "bob", owner)
+
+	u, _, err = primary.LoadFull(ctx, "taken")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/primary", u.String(), "primary keeps its own record")
+	conflicts := fo.Conflicts()
+	require.Len(t, conflicts, 1, "the skipped record is reported, not counted as replayed")
+	assert.Equal(t, "taken", conflicts[0].ShortURL)
+	assert.Equal(t, "https://example.com/secondary", conflicts[0].OriginalURL)
+}
+
+// unwritableStore — primary, который читает, но не пишет.
+type unwritableStore struct {
+	*store.MemoryStorage
+}
+
+func (s unwritableStore) Save(context.Context, string, *url.URL, store.LinkMeta, *config.Config) (string, error) {
+	return "", errors.New("read-only transaction")
+}
+
+func TestFailoverReadsPrimary(t *testing.T) {
+	ctx := context.Background()
+	cfg := *config.NewConfig()
+	cfg.BaseURL = "http://localhost:8080/"
+
+	primary := store.NewMemoryStorage()
+	require.NoError(t, primary.ImportRecords(ctx, []store.Record{
+		{ShortURL: "old", OriginalURL: "https://example.com/old", UserID: "alice"},
+	}))
+	fo := failover.NewStore(unwritableStore{primary}, store.NewMemoryStorage(), 1, time.Hour, logging.Nop())
+	defer func() { _ = fo.Close(context.Background()) }()
+
+	u, err := url.Parse("https://example.com/new")
+	require.NoError(t, err)
+	short, err := fo.Save(ctx, "bob", u, store.LinkMeta{}, &cfg)
+	require.NoError(t, err, "the write goes to the secondary")
+
+	got, _, err := fo.LoadFull(ctx, store.ShortIDFromURL(short, cfg.BaseURL))
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/new", got.String())
+	got, _, err = fo.LoadFull(ctx, "old")
+	require.NoError(t, err, "links created before the switch are read from the primary")
+	assert.Equal(t, "https://example.com/old", got.String())
+	_, _, err = fo.LoadFull(ctx, "missing")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+// chanMailer отдаёт тела писем в канал.
+type chanMailer chan string
+
+func (m chanMailer) Send(_ context.Context, _, _, body string) error {
+	m <- body
+	return nil
+}
+
+func TestAccounts(t *testing.T) {
+	cfg := *config.NewConfig()
+	cfg.BaseURL = "http://localhost:8080/"
+	cfg.Accounts = true
+	cfg.AccountsRequireVerified = true
+	mails := make(chanMailer, 1)
+	router := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: &cfg, Mailer: mails}).Router()
+
+	do := func(method, path, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Ссылка создана анонимно, потом тот же посетитель регистрируется.
+	rec := do(http.MethodPost, "/", "https://example.com/before-signup", nil)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	browser := rec.Result().Cookies()
+	_ = rec.Result().Body.Close()
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/user/register", `{"email":"not-an-email","password":"long enough"}`, browser).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/user/register", `{"email":"a@example.com","password":"short"}`, browser).Code)
+	rec = do(http.MethodPost, "/api/user/register", `{"email":"Alice@Example.com","password":"correct horse"}`, browser)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"email":"alice@example.com"`)
+	assert.NotContains(t, rec.Body.String(), "password")
+	assert.Equal(t, http.StatusConflict, do(http.MethodPost, "/api/user/register", `{"email":"alice@example.com","password":"correct horse"}`, nil).Code)
+
+	var mail string
+	select {
+	case mail = <-mails:
+	case <-time.After(5 * time.Second):
+		t.Fatal("verification email was not sent")
+	}
+	link := mail[strings.Index(mail, "http://localhost:8080/api/user/verify?token="):]
+	link = strings.TrimSpace(strings.TrimPrefix(link, "http://localhost:8080"))
+
+	// Без подтверждения email вход закрыт, с неверным паролем — тем более.
+	login := func(body string) *httptest.ResponseRecorder {
+		return do(http.MethodPost, "/api/user/login", body, nil)
+	}
+	assert.Equal(t, http.StatusForbidden, login(`{"email":"alice@example.com","password":"correct horse"}`).Code)
+	assert.Equal(t, http.StatusUnauthorized, login(`{"email":"alice@example.com","password":"wrong horse"}`).Code)
+	assert.Equal(t, http.StatusUnauthorized, login(`{"email":"bob@example.com","password":"correct horse"}`).Code)
+
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/api/user/verify?token=x.y", "", nil).Code)
+	require.Equal(t, http.StatusOK, do(http.MethodGet, link, "", nil).Code)
+
+	// Вход с другого устройства получает куку с тем же userID и видит прежние ссылки.
+	rec = login(`{"email":"alice@example.com","password":"correct horse"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	device := rec.Result().Cookies()
+	_ = rec.Result().Body.Close()
+	rec = do(http.MethodGet, "/api/user/urls", "", device)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "https://example.com/before-signup")
+	rec = do(http.MethodGet, "/api/user/account", "", device)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"verified":true`)
+
+	// С учётными записями кука с чужим userID без верной подписи не проходит.
+	var userCookie *http.Cookie
+	for _, c := range device {
+		if c.Name == "UserID" {
+			userCookie = c
+		}
+	}
+	require.NotNil(t, userCookie)
+	userID, _, _ := strings.Cut(userCookie.Value, ":")
+	forged := []*http.Cookie{{Name: "UserID", Value: userID + ":forged-signature"}}
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/api/user/urls", "", forged).Code)
+
+	// Удаление данных удаляет и учётную запись.
+	require.Equal(t, http.StatusOK, do(http.MethodDelete, "/api/user/account", "", device).Code)
+	assert.Equal(t, http.StatusUnauthorized, login(`{"email":"alice@example.com","password":"correct horse"}`).Code)
+}
+
+func TestAPITokens(t *testing.T) {
+	cfg := *config.NewConfig()
+	cfg.BaseURL = "http://localhost:8080/"
+	router := endpoints.New(endpoints.Deps{Store: store.NewMemoryStorage(), Config: &cfg}).Router()
+
+	do := func(method, path, body, token string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/", "https://example.com/owned", "", nil)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	owner := rec.Result().Cookies()
+	_ = rec.Result().Body.Close()
+	id := store.ShortIDFromURL(rec.Body.String(), cfg.BaseURL)
+
+	issue := func(scope string) string {
+		rec := do(http.MethodPost, "/api/user/tokens", `{"name":"ci","scope":"`+scope+`"}`, "", owner)
+		require.Equal(t, http.StatusCreated, rec.Code)
+		var issued struct {
+			ID    string `json:"id"`
+			Token string `json:"token"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &issued))
+		require.NotEmpty(t, issued.Token)
+		return issued.Token
+	}
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/user/tokens", `{"name":"ci","scope":"admin"}`, "", owner).Code)
+	// Кука с userID владельца, но без верной подписи, токенами не управляет.
+	for _, c := range owner {
+		if c.Name == "UserID" {
+			userID, _, _ := strings.Cut(c.Value, ":")
+			forged := []*http.Cookie{{Name: "UserID", Value: userID + ":forged-signature"}}
+			assert.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/api/user/tokens", `{"name":"ci","scope":"full"}`, "", forged).Code)
+			assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/api/user/tokens", "", "", forged).Code)
+		}
+	}
+	create, read, full := issue("create-only"), issue("read-stats"), issue("full")
+
+	// create-only: сокращает от имени владельца, но не читает и не удаляет.
+	rec = do(http.MethodPost, "/api/shorten", `{"url":"https://example.com/from-ci"}`, create, nil)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	for _, c := range rec.Result().Cookies() {
+		assert.NotEqual(t, "UserID", c.Name, "token requests get no user cookie")
+	}
+	_ = rec.Result().Body.Close()
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/api/user/urls", "", create, nil).Code)
+	assert.Equal(t, http.StatusForbidden, do(http.MethodDelete, "/api/user/urls", `["`+id+`"]`, create, nil).Code)
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/api/user/tokens", "", create, nil).Code)
+
+	// read-stats видит обе ссылки, но ничего не меняет.
+	rec = do(http.MethodGet, "/api/user/urls", "", read, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "https://example.com/owned")
+	assert.Contains(t, rec.Body.String(), "https://example.com/from-ci")
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/user/urls/"+id+"/stats", "", read, nil).Code)
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/api/shorten", `{"url":"https://example.com/x"}`, read, nil).Code)
+
+	// full управляет токенами; список без секретов.
+	rec = do(http.MethodGet, "/api/user/tokens", "", full, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var list []struct {
+		ID    string `json:"id"`
+		Scope string `json:"scope"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Len(t, list, 3)
+	assert.NotContains(t, rec.Body.String(), create)
+	assert.Equal(t, "create-only", list[0].Scope)
+
+	// Другой пользователь не видит и не отзывает чужие токены.
+	rec = do(http.MethodPost, "/", "https://example.com/stranger", "", nil)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	stranger := rec.Result().Cookies()
+	_ = rec.Result().Body.Close()
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/api/user/tokens/"+list[0].ID, "", "", stranger).Code)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/api/user/tokens/"+list[0].ID, "", full, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/api/shorten", `{"url":"https://example.com/y"}`, create, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/api/user/urls", "", read+"x", nil).Code)
+	assert.Equal(t, http.StatusAccepted, do(http.MethodDelete, "/api/user/urls", `["`+id+`"]`, full, nil).Code)
+}
@@ -0,0 +1,103 @@
+package main
+
+// Cmd/shortener/migrate.go.
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/dkolesni-prog/transformer/internal/config"
+	"github.com/dkolesni-prog/transformer/internal/logging"
+	"github.com/dkolesni-prog/transformer/internal/store"
+)
+
+const migrateProgressEvery = 10000
+
+// migrateStore копирует все записи (владельцев и пометки удаления тоже) из одного
+// хранилища в другое: shortener migrate-store --from file://data.json --to postgres://...
+func migrateStore(cfg *config.Config, logger logging.Logger, args []string) error {
+	fs := flag.NewFlagSet("migrate-store", flag.ContinueOnError)
+	from := fs.String("from", "", "source storage: file://path, memory:// or postgres://dsn")
+	to := fs.String("to", "", "target storage: file://path or postgres://dsn")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *to == "" {
+		return fmt.Errorf("migrate-store: both --from and --to are required")
+	}
+
+	ctx := context.Background()
+	src, err := openStorageURL(ctx, cfg, *from, logger)
+	if err != nil {
+		return fmt.Errorf("open source: %w", err)
+	}
+	defer func() { _ = src.Close(ctx) }()
+
+	dst, err := openStorageURL(ctx, cfg, *to, logger)
+	if err != nil {
+		return fmt.Errorf("open target: %w", err)
+	}
+	defer func() {
+		if closeErr := dst.Close(ctx); closeErr != nil {
+			logger.Error("Could not close target storage", "error", closeErr)
+		}
+	}()
+
+	count := 0
+	batch := make([]store.Record, 0, restoreBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if importErr := dst.ImportRecords(ctx, batch); importErr != nil {
+			return fmt.Errorf("import records: %w", importErr)
+		}
+		before := count
+		count += len(batch)
+		batch = batch[:0]
+		if count/migrateProgressEvery != before/migrateProgressEvery {
+			logger.Info("Migration in progress", "records", count)
+		}
+		return nil
+	}
+
+	exportErr := src.ExportRecords(ctx, func(rec store.Record) error {
+		batch = append(batch, rec)
+		if len(batch) < restoreBatchSize {
+			return nil
+		}
+		return flush()
+	})
+	if exportErr != nil {
+		return fmt.Errorf("export records: %w", exportErr)
+	}
+	if flushErr := flush(); flushErr != nil {
+		return flushErr
+	}
+	logger.Info("Migration finished", "records", count, "from", *from, "to", *to)
+	return nil
+}
+
+// openStorageURL открывает хранилище по адресу вида file://path, memory:// или postgres://dsn.
+func openStorageURL(ctx context.Context, cfg *config.Config, rawURL string, logger logging.Logger) (store.Store, error) {
+	target := *cfg
+	target.DatabaseDSN = ""
+	target.FileStoragePath = ""
+	target.MemorySnapshotPath = ""
+
+	switch {
+	case strings.HasPrefix(rawURL, "file://"):
+		target.FileStoragePath = strings.TrimPrefix(rawURL, "file://")
+		if target.FileStoragePath == "" {
+			return nil, fmt.Errorf("empty file path in %q", rawURL)
+		}
+	case strings.HasPrefix(rawURL, "memory://"):
+	case strings.HasPrefix(rawURL, "postgres://"), strings.HasPrefix(rawURL, "postgresql://"):
+		target.DatabaseDSN = rawURL
+	default:
+		return nil, fmt.Errorf("unsupported storage URL %q", rawURL)
+	}
+	return openStorage(ctx, &target, logger)
+}
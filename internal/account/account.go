@@ -0,0 +1,53 @@
+// Internal/account/account.go.
+
+package account
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound — учётной записи нет.
+	ErrNotFound = errors.New("account not found")
+	// ErrExists — email или userID уже заняты другой учётной записью.
+	ErrExists = errors.New("account already exists")
+	// ErrInvalidEmail — строка не похожа на адрес почты.
+	ErrInvalidEmail = errors.New("invalid email")
+)
+
+// Account — учётная запись с паролем. Ссылки по-прежнему принадлежат UserID: при
+// регистрации запись привязывается к userID из куки, вход выдаёт куку с ним же.
+type Account struct {
+	Email        string    `json:"email"`
+	UserID       string    `json:"user_id"`
+	PasswordHash string    `json:"password_hash"`
+	Verified     bool      `json:"verified"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Directory хранит учётные записи.
+type Directory interface {
+	// Create заводит учётную запись; ErrExists, если email или userID уже заняты.
+	Create(ctx context.Context, a Account) error
+	ByEmail(ctx context.Context, email string) (Account, error)
+	ByUserID(ctx context.Context, userID string) (Account, error)
+	// MarkVerified отмечает email подтверждённым; ErrNotFound, если записи нет.
+	MarkVerified(ctx context.Context, email string) error
+	// Delete удаляет учётную запись userID; отсутствие записи не ошибка.
+	Delete(ctx context.Context, userID string) error
+}
+
+// NormalizeEmail проверяет адрес и приводит его к нижнему регистру, чтобы
+// один ящик нельзя было зарегистрировать дважды.
+func NormalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return "", ErrInvalidEmail
+	}
+	return strings.ToLower(email), nil
+}
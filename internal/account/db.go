@@ -0,0 +1,97 @@
+// Internal/account/db.go.
+
+package account
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/dkolesni-prog/transformer/internal/logging"
+)
+
+// DBDirectory хранит учётные записи в таблице accounts.
+type DBDirectory struct {
+	pool   *pgxpool.Pool
+	logger logging.Logger
+}
+
+func NewDBDirectory(pool *pgxpool.Pool, logger logging.Logger) *DBDirectory {
+	return &DBDirectory{pool: pool, logger: logger}
+}
+
+// Bootstrap creates the accounts table if it doesn't exist.
+func (d *DBDirectory) Bootstrap(ctx context.Context) error {
+	const schema = `
+CREATE TABLE IF NOT EXISTS accounts (
+    email VARCHAR(254) PRIMARY KEY,
+    user_id VARCHAR(64) NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL,
+    verified BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+`
+	if _, execErr := d.pool.Exec(ctx, schema); execErr != nil {
+		d.logger.Error("Could not create accounts table", "error", execErr)
+		return errors.New("cannot create accounts table: " + execErr.Error())
+	}
+	return nil
+}
+
+func (d *DBDirectory) Create(ctx context.Context, a Account) error {
+	const sqlInsert = `
+INSERT INTO accounts (email, user_id, password_hash, verified, created_at)
+VALUES ($1, $2, $3, $4, $5);
+`
+	if _, err := d.pool.Exec(ctx, sqlInsert, a.Email, a.UserID, a.PasswordHash, a.Verified, a.CreatedAt); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrExists
+		}
+		return errors.New("insert account: " + err.Error())
+	}
+	return nil
+}
+
+func (d *DBDirectory) ByEmail(ctx context.Context, email string) (Account, error) {
+	return d.selectOne(ctx, `WHERE email = $1`, email)
+}
+
+func (d *DBDirectory) ByUserID(ctx context.Context, userID string) (Account, error) {
+	return d.selectOne(ctx, `WHERE user_id = $1`, userID)
+}
+
+func (d *DBDirectory) MarkVerified(ctx context.Context, email string) error {
+	tag, err := d.pool.Exec(ctx, `UPDATE accounts SET verified = TRUE WHERE email = $1;`, email)
+	if err != nil {
+		return errors.New("update account: " + err.Error())
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (d *DBDirectory) Delete(ctx context.Context, userID string) error {
+	if _, err := d.pool.Exec(ctx, `DELETE FROM accounts WHERE user_id = $1;`, userID); err != nil {
+		return errors.New("delete account: " + err.Error())
+	}
+	return nil
+}
+
+func (d *DBDirectory) selectOne(ctx context.Context, where string, arg string) (Account, error) {
+	var a Account
+	err := d.pool.QueryRow(ctx,
+		`SELECT email, user_id, password_hash, verified, created_at FROM accounts `+where+`;`, arg).
+		Scan(&a.Email, &a.UserID, &a.PasswordHash, &a.Verified, &a.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Account{}, ErrNotFound
+	}
+	if err != nil {
+		return Account{}, errors.New("select account: " + err.Error())
+	}
+	return a, nil
+}
@@ -0,0 +1,49 @@
+// Internal/account/mail.go.
+
+package account
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// Mailer отправляет письма пользователям, например ссылку подтверждения email.
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// SMTP отправляет письма через SMTP-сервер Addr ("host:port"). С Username
+// авторизуется по PLAIN, что net/smtp разрешает только по TLS или на localhost.
+type SMTP struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+func (s *SMTP) Send(ctx context.Context, to, subject, body string) error {
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return fmt.Errorf("smtp: header contains a line break")
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return fmt.Errorf("smtp address: %w", err)
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	msg := "From: " + s.From + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + body
+	// net/smtp не принимает контекст: письмо уходит за время одного соединения.
+	if err := smtp.SendMail(s.Addr, auth, s.From, []string{to}, []byte(msg)); err != nil {
+		return fmt.Errorf("smtp send: %w", err)
+	}
+	return nil
+}
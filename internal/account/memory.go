@@ -0,0 +1,132 @@
+// Internal/account/memory.go.
+
+package account
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// MemoryDirectory держит учётные записи в памяти и, если задан path,
+// целиком переписывает их в JSON-файл после каждого изменения.
+type MemoryDirectory struct {
+	mu       sync.RWMutex
+	accounts map[string]Account
+	path     string
+}
+
+func NewMemoryDirectory() *MemoryDirectory {
+	return &MemoryDirectory{accounts: make(map[string]Account)}
+}
+
+// NewFileDirectory загружает учётные записи из path (если файл есть) и сохраняет изменения туда же.
+func NewFileDirectory(path string) (*MemoryDirectory, error) {
+	d := NewMemoryDirectory()
+	d.path = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read accounts file: %w", err)
+	}
+	if unmarshalErr := json.Unmarshal(data, &d.accounts); unmarshalErr != nil {
+		return nil, fmt.Errorf("parse accounts file: %w", unmarshalErr)
+	}
+	return d, nil
+}
+
+func (d *MemoryDirectory) Create(ctx context.Context, a Account) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.accounts[a.Email]; ok {
+		return ErrExists
+	}
+	if _, ok := d.byUserID(a.UserID); ok {
+		return ErrExists
+	}
+	d.accounts[a.Email] = a
+	return d.persist()
+}
+
+func (d *MemoryDirectory) ByEmail(ctx context.Context, email string) (Account, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	a, ok := d.accounts[email]
+	if !ok {
+		return Account{}, ErrNotFound
+	}
+	return a, nil
+}
+
+func (d *MemoryDirectory) ByUserID(ctx context.Context, userID string) (Account, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	a, ok := d.byUserID(userID)
+	if !ok {
+		return Account{}, ErrNotFound
+	}
+	return a, nil
+}
+
+func (d *MemoryDirectory) MarkVerified(ctx context.Context, email string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	a, ok := d.accounts[email]
+	if !ok {
+		return ErrNotFound
+	}
+	a.Verified = true
+	d.accounts[email] = a
+	return d.persist()
+}
+
+func (d *MemoryDirectory) Delete(ctx context.Context, userID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	a, ok := d.byUserID(userID)
+	if !ok {
+		return nil
+	}
+	delete(d.accounts, a.Email)
+	return d.persist()
+}
+
+// byUserID вызывается под d.mu.
+func (d *MemoryDirectory) byUserID(userID string) (Account, bool) {
+	for _, a := range d.accounts {
+		if a.UserID == userID {
+			return a, true
+		}
+	}
+	return Account{}, false
+}
+
+// persist вызывается под d.mu.
+func (d *MemoryDirectory) persist() error {
+	if d.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(d.accounts)
+	if err != nil {
+		return fmt.Errorf("marshal accounts: %w", err)
+	}
+	tmp := d.path + ".tmp"
+	if wErr := os.WriteFile(tmp, data, 0o600); wErr != nil {
+		return fmt.Errorf("write accounts file: %w", wErr)
+	}
+	if rErr := os.Rename(tmp, d.path); rErr != nil {
+		return fmt.Errorf("replace accounts file: %w", rErr)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+// Internal/apitoken/apitoken.go.
+
+package apitoken
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Scope — что разрешено токену.
+type Scope string
+
+const (
+	// ScopeCreate — только сокращать ссылки: POST /, /api/shorten и /api/shorten/batch.
+	ScopeCreate Scope = "create-only"
+	// ScopeReadStats — только читать: GET и HEAD, включая списки ссылок и статистику.
+	ScopeReadStats Scope = "read-stats"
+	// ScopeFull — всё, что может владелец с кукой, в том числе удаление и управление токенами.
+	ScopeFull Scope = "full"
+)
+
+// prefix отличает API-токены от других Bearer-токенов, например админского.
+const prefix = "tk_"
+
+var (
+	// ErrNotFound — токена нет или он отозван.
+	ErrNotFound = errors.New("api token not found")
+	// ErrInvalid — строка не является API-токеном или секрет не совпал.
+	ErrInvalid = errors.New("invalid api token")
+)
+
+// Token — выданный токен. Сам секрет не хранится, только его SHA-256.
+type Token struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"user_id"`
+	Name       string    `json:"name"`
+	Scope      Scope     `json:"scope"`
+	SecretHash string    `json:"secret_hash"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Store хранит токены пользователей.
+type Store interface {
+	Create(ctx context.Context, t Token) error
+	// Get возвращает токен по ID; ErrNotFound, если его нет.
+	Get(ctx context.Context, id string) (Token, error)
+	// List возвращает токены userID, старые первыми.
+	List(ctx context.Context, userID string) ([]Token, error)
+	// Delete отзывает токен userID; ErrNotFound, если такого у пользователя нет.
+	Delete(ctx context.Context, userID, id string) error
+}
+
+// ValidScope сообщает, известна ли область.
+func ValidScope(s Scope) bool {
+	return s == ScopeCreate || s == ScopeReadStats || s == ScopeFull
+}
+
+// Allows сообщает, можно ли токену с областью s выполнить запрос method path.
+func (s Scope) Allows(method, path string) bool {
+	if s == ScopeFull {
+		return true
+	}
+	return s == required(method, path)
+}
+
+// required — область, которой достаточно для запроса; ScopeFull для всего, что меняет
+// данные, кроме создания ссылок, и для управления токенами.
+func required(method, path string) Scope {
+	switch {
+	case path == "/api/user/tokens" || strings.HasPrefix(path, "/api/user/tokens/"):
+		return ScopeFull
+	case method == http.MethodPost && (path == "/" || path == "/api/shorten" || path == "/api/shorten/batch"):
+		return ScopeCreate
+	case method == http.MethodGet || method == http.MethodHead:
+		return ScopeReadStats
+	}
+	return ScopeFull
+}
+
+// New готовит токен userID и возвращает его вместе со строкой, которую предъявляет
+// клиент: "tk_<id>_<секрет>". Строка показывается один раз и нигде не сохраняется.
+func New(userID, name string, scope Scope) (Token, string) {
+	id, secret := randomHex(8), randomHex(24)
+	t := Token{
+		ID:         id,
+		UserID:     userID,
+		Name:       name,
+		Scope:      scope,
+		SecretHash: hashSecret(secret),
+		CreatedAt:  time.Now().UTC(),
+	}
+	return t, prefix + id + "_" + secret
+}
+
+// IsToken сообщает, похожа ли строка из заголовка Authorization на API-токен.
+func IsToken(raw string) bool {
+	return strings.HasPrefix(raw, prefix)
+}
+
+// Verify находит токен по строке клиента и сверяет секрет.
+func Verify(ctx context.Context, s Store, raw string) (Token, error) {
+	id, secret, ok := strings.Cut(strings.TrimPrefix(raw, prefix), "_")
+	if !IsToken(raw) || !ok || id == "" || secret == "" {
+		return Token{}, ErrInvalid
+	}
+	t, err := s.Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return Token{}, ErrInvalid
+	}
+	if err != nil {
+		return Token{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(t.SecretHash)) != 1 {
+		return Token{}, ErrInvalid
+	}
+	return t, nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,113 @@
+// Internal/apitoken/db.go.
+
+package apitoken
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/dkolesni-prog/transformer/internal/logging"
+)
+
+// DBStore хранит токены в таблице api_tokens.
+type DBStore struct {
+	pool   *pgxpool.Pool
+	logger logging.Logger
+}
+
+func NewDBStore(pool *pgxpool.Pool, logger logging.Logger) *DBStore {
+	return &DBStore{pool: pool, logger: logger}
+}
+
+// Bootstrap creates the api_tokens table if it doesn't exist.
+func (s *DBStore) Bootstrap(ctx context.Context) error {
+	const schema = `
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id VARCHAR(32) PRIMARY KEY,
+    user_id VARCHAR(64) NOT NULL,
+    name VARCHAR(128) NOT NULL,
+    scope VARCHAR(16) NOT NULL,
+    secret_hash CHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS api_tokens_user_id_idx ON api_tokens (user_id);
+`
+	if _, execErr := s.pool.Exec(ctx, schema); execErr != nil {
+		s.logger.Error("Could not create api_tokens table", "error", execErr)
+		return errors.New("cannot create api_tokens table: " + execErr.Error())
+	}
+	return nil
+}
+
+func (s *DBStore) Create(ctx context.Context, t Token) error {
+	const sqlInsert = `
+INSERT INTO api_tokens (id, user_id, name, scope, secret_hash, created_at)
+VALUES ($1, $2, $3, $4, $5, $6);
+`
+	if _, err := s.pool.Exec(ctx, sqlInsert, t.ID, t.UserID, t.Name, string(t.Scope), t.SecretHash, t.CreatedAt); err != nil {
+		return errors.New("insert api token: " + err.Error())
+	}
+	return nil
+}
+
+func (s *DBStore) Get(ctx context.Context, id string) (Token, error) {
+	const sqlSelect = `
+SELECT id, user_id, name, scope, secret_hash, created_at
+FROM api_tokens
+WHERE id = $1;
+`
+	var t Token
+	var scope string
+	err := s.pool.QueryRow(ctx, sqlSelect, id).Scan(&t.ID, &t.UserID, &t.Name, &scope, &t.SecretHash, &t.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Token{}, ErrNotFound
+	}
+	if err != nil {
+		return Token{}, errors.New("select api token: " + err.Error())
+	}
+	t.Scope = Scope(scope)
+	return t, nil
+}
+
+func (s *DBStore) List(ctx context.Context, userID string) ([]Token, error) {
+	const sqlSelect = `
+SELECT id, user_id, name, scope, secret_hash, created_at
+FROM api_tokens
+WHERE user_id = $1
+ORDER BY created_at;
+`
+	rows, err := s.pool.Query(ctx, sqlSelect, userID)
+	if err != nil {
+		return nil, errors.New("select api tokens: " + err.Error())
+	}
+	defer rows.Close()
+
+	var out []Token
+	for rows.Next() {
+		var t Token
+		var scope string
+		if scanErr := rows.Scan(&t.ID, &t.UserID, &t.Name, &scope, &t.SecretHash, &t.CreatedAt); scanErr != nil {
+			return nil, errors.New("rows.Scan: " + scanErr.Error())
+		}
+		t.Scope = Scope(scope)
+		out = append(out, t)
+	}
+	if rowsErr := rows.Err(); rowsErr != nil {
+		return nil, errors.New("rows.Err: " + rowsErr.Error())
+	}
+	return out, nil
+}
+
+func (s *DBStore) Delete(ctx context.Context, userID, id string) error {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM api_tokens WHERE id = $1 AND user_id = $2;`, id, userID)
+	if err != nil {
+		return errors.New("delete api token: " + err.Error())
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
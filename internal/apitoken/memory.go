@@ -0,0 +1,107 @@
+// Internal/apitoken/memory.go.
+
+package apitoken
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// MemoryStore держит токены в памяти и, если задан path,
+// целиком переписывает их в JSON-файл после каждого изменения.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	tokens map[string]Token
+	path   string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{tokens: make(map[string]Token)}
+}
+
+// NewFileStore загружает токены из path (если файл есть) и сохраняет изменения туда же.
+func NewFileStore(path string) (*MemoryStore, error) {
+	s := NewMemoryStore()
+	s.path = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read api tokens file: %w", err)
+	}
+	if unmarshalErr := json.Unmarshal(data, &s.tokens); unmarshalErr != nil {
+		return nil, fmt.Errorf("parse api tokens file: %w", unmarshalErr)
+	}
+	return s, nil
+}
+
+func (s *MemoryStore) Create(ctx context.Context, t Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[t.ID] = t
+	return s.persist()
+}
+
+func (s *MemoryStore) Get(ctx context.Context, id string) (Token, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.tokens[id]
+	if !ok {
+		return Token{}, ErrNotFound
+	}
+	return t, nil
+}
+
+func (s *MemoryStore) List(ctx context.Context, userID string) ([]Token, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var out []Token
+	for _, t := range s.tokens {
+		if t.UserID == userID {
+			out = append(out, t)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, userID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[id]
+	if !ok || t.UserID != userID {
+		return ErrNotFound
+	}
+	delete(s.tokens, id)
+	return s.persist()
+}
+
+// persist вызывается под s.mu.
+func (s *MemoryStore) persist() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(s.tokens)
+	if err != nil {
+		return fmt.Errorf("marshal api tokens: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if wErr := os.WriteFile(tmp, data, 0o600); wErr != nil {
+		return fmt.Errorf("write api tokens file: %w", wErr)
+	}
+	if rErr := os.Rename(tmp, s.path); rErr != nil {
+		return fmt.Errorf("replace api tokens file: %w", rErr)
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+// Internal/app/endpoints/account.go.
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/dkolesni-prog/transformer/internal/account"
+	"github.com/dkolesni-prog/transformer/internal/app/middleware"
+)
+
+const (
+	// verifyTokenTTL — сколько живёт ссылка подтверждения email.
+	verifyTokenTTL = 48 * time.Hour
+	// minPasswordLen и maxPasswordLen — bcrypt учитывает только первые 72 байта пароля.
+	minPasswordLen = 8
+	maxPasswordLen = 72
+)
+
+// dummyPasswordHash сравнивается с паролем при входе на неизвестный email, чтобы по
+// времени ответа нельзя было узнать, зарегистрирован ли адрес.
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	return hash
+})
+
+// ErasureReport — ответ DELETE /api/user/account: сколько чего удалено.
+type ErasureReport struct {
+	Links       int `json:"links"`
+	Clicks      int `json:"clicks"`
+	AuditEvents int `json:"audit_events"`
+}
+
+// accountView — учётная запись в ответах API, без хеша пароля.
+type accountView struct {
+	Email     string    `json:"email"`
+	UserID    string    `json:"user_id"`
+	Verified  bool      `json:"verified"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newAccountView(a account.Account) accountView {
+	return accountView{Email: a.Email, UserID: a.UserID, Verified: a.Verified, CreatedAt: a.CreatedAt}
+}
+
+type credentials struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// DeleteAccount irreversibly erases the caller's links (deleted ones included), their clicks
+// and the audit events mentioning the caller, and revokes their API tokens: DELETE /api/user/account.
+// Needs a signed cookie or an API token.
+func (h *Handlers) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	userID, ok := verifiedUser(w, r)
+	if !ok {
+		return
+	}
+
+	erased, err := h.store.EraseUser(r.Context(), userID)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	report := ErasureReport{Links: len(erased)}
+	if len(erased) > 0 {
+		if report.Clicks, err = h.tracker.Log().Delete(r.Context(), erased); err != nil {
+			storeError(w, r, err)
+			return
+		}
+	}
+	if h.audit != nil {
+		if report.AuditEvents, err = h.audit.Erase(r.Context(), userID); err != nil {
+			storeError(w, r, err)
+			return
+		}
+	}
+	if err = h.revokeAllTokens(r, userID); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	if h.accounts != nil {
+		if err = h.accounts.Delete(r.Context(), userID); err != nil {
+			storeError(w, r, err)
+			return
+		}
+	}
+	h.logger.Info("User account erased", "links", report.Links, "clicks", report.Clicks, "audit_events", report.AuditEvents)
+
+	middleware.ClearUserIDCookie(w)
+	writeData(w, r, http.StatusOK, report)
+}
+
+// Register creates an email/password account for the caller's current userID, so the links
+// made with this cookie stay with the account: POST /api/user/register {"email", "password"}.
+// A verification link is mailed to the address.
+func (h *Handlers) Register(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok || userID == "" {
+		reject(w, r, http.StatusUnauthorized, "unauthorized", nil)
+		return
+	}
+	creds, ok := decodeCredentials(w, r)
+	if !ok {
+		return
+	}
+	if len(creds.Password) < minPasswordLen || len(creds.Password) > maxPasswordLen {
+		middleware.Problem(w, r, "password must be 8 to 72 bytes long", http.StatusBadRequest)
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(creds.Password), bcrypt.DefaultCost)
+	if err != nil {
+		h.logger.Error("Could not hash account password", "error", err)
+		middleware.Problem(w, r, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	acc := account.Account{
+		Email:        creds.Email,
+		UserID:       userID,
+		PasswordHash: string(hash),
+		CreatedAt:    time.Now().UTC(),
+	}
+	err = h.accounts.Create(r.Context(), acc)
+	if errors.Is(err, account.ErrExists) {
+		reject(w, r, http.StatusConflict, "account_exists", nil)
+		return
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	h.sendVerification(r, acc.Email)
+	writeData(w, r, http.StatusCreated, newAccountView(acc))
+}
+
+// Login checks the password and reissues the user cookie with the account's userID:
+// POST /api/user/login {"email", "password"}.
+func (h *Handlers) Login(w http.ResponseWriter, r *http.Request) {
+	creds, ok := decodeCredentials(w, r)
+	if !ok {
+		return
+	}
+	acc, err := h.accounts.ByEmail(r.Context(), creds.Email)
+	if err != nil && !errors.Is(err, account.ErrNotFound) {
+		storeError(w, r, err)
+		return
+	}
+	hash := []byte(acc.PasswordHash)
+	if err != nil {
+		hash = dummyPasswordHash()
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(creds.Password)) != nil || err != nil {
+		reject(w, r, http.StatusUnauthorized, "invalid_credentials", nil)
+		return
+	}
+	if h.cfg.AccountsRequireVerified && !acc.Verified {
+		reject(w, r, http.StatusForbidden, "email_not_verified", nil)
+		return
+	}
+	h.auth.SetUserID(w, acc.UserID)
+	writeData(w, r, http.StatusOK, newAccountView(acc))
+}
+
+// Logout drops the user cookie; the next request gets a fresh anonymous userID:
+// POST /api/user/logout.
+func (h *Handlers) Logout(w http.ResponseWriter, _ *http.Request) {
+	middleware.ClearUserIDCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetAccount shows the account bound to the caller's userID: GET /api/user/account.
+func (h *Handlers) GetAccount(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok || userID == "" {
+		reject(w, r, http.StatusUnauthorized, "unauthorized", nil)
+		return
+	}
+	acc, err := h.accounts.ByUserID(r.Context(), userID)
+	if errors.Is(err, account.ErrNotFound) {
+		middleware.Problem(w, r, "Account not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	writeData(w, r, http.StatusOK, newAccountView(acc))
+}
+
+// VerifyEmail confirms the address with the token from the verification email:
+// GET /api/user/verify?token=...
+func (h *Handlers) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	email, ok := h.parseVerifyToken(r.URL.Query().Get("token"), time.Now())
+	if !ok {
+		reject(w, r, http.StatusForbidden, "invalid_verification_token", nil)
+		return
+	}
+	err := h.accounts.MarkVerified(r.Context(), email)
+	if errors.Is(err, account.ErrNotFound) {
+		reject(w, r, http.StatusForbidden, "invalid_verification_token", nil)
+		return
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	writeData(w, r, http.StatusOK, map[string]any{"email": email, "verified": true})
+}
+
+// decodeCredentials разбирает тело с email и паролем; email приводится к account.NormalizeEmail.
+func decodeCredentials(w http.ResponseWriter, r *http.Request) (credentials, bool) {
+	defer func() { _ = r.Body.Close() }()
+	var creds credentials
+	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+		middleware.Problem(w, r, "Failed to parse JSON", http.StatusBadRequest)
+		return creds, false
+	}
+	email, err := account.NormalizeEmail(creds.Email)
+	if err != nil {
+		middleware.Problem(w, r, "invalid email", http.StatusBadRequest)
+		return creds, false
+	}
+	creds.Email = email
+	return creds, true
+}
+
+// sendVerification отправляет ссылку подтверждения после ответа. Без почты ссылка
+// пишется в лог, чтобы оператор мог передать её пользователю.
+func (h *Handlers) sendVerification(r *http.Request, email string) {
+	expires := time.Now().Add(verifyTokenTTL)
+	token := h.auth.SignToken("verify|" + strconv.FormatInt(expires.Unix(), 10) + "|" + email)
+	link := tenantConfig(r, h.cfg).BaseURL + "api/user/verify?token=" + url.QueryEscape(token)
+	if h.mailer == nil {
+		h.logger.Warn("No mailer configured, verification link not sent", "email", email, "link", link)
+		return
+	}
+	h.goBackground(r, func(ctx context.Context) {
+		body := "Confirm your email by opening this link within 48 hours:\n\n" + link + "\n"
+		if err := h.mailer.Send(ctx, email, "Confirm your email", body); err != nil {
+			h.logger.Error("Could not send verification email", "error", err)
+		}
+	})
+}
+
+func (h *Handlers) parseVerifyToken(token string, now time.Time) (string, bool) {
+	payload, ok := h.auth.VerifyToken(token)
+	if !ok {
+		return "", false
+	}
+	parts := strings.SplitN(payload, "|", 3)
+	if len(parts) != 3 || parts[0] != "verify" {
+		return "", false
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || now.Unix() > expires {
+		return "", false
+	}
+	return parts[2], true
+}
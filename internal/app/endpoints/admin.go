@@ -0,0 +1,98 @@
+// Internal/app/endpoints/admin.go.
+package endpoints
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/dkolesni-prog/transformer/internal/app/middleware"
+	"github.com/dkolesni-prog/transformer/internal/store"
+)
+
+// adminRoutes — операторские эндпоинты под /api/admin, закрытые AdminAuth.
+func (h *Handlers) adminRoutes(r chi.Router) {
+	r.Get("/users/{userID}/urls", h.AdminGetUserURLs)
+	r.Delete("/urls/{id}", h.AdminDeleteURL)
+	r.Post("/urls/{id}/transfer", h.AdminTransferURL)
+	r.Put("/urls/{id}/flag", h.AdminFlagURL)
+	r.Delete("/urls/{id}/flag", h.AdminUnflagURL)
+	r.Get("/stats", h.AdminStats)
+	r.Post("/keys/rotate", h.AdminRotateKey)
+	r.Post("/jobs/purge", h.AdminPurge)
+	r.Get("/jobs/failed", h.AdminFailedJobs)
+	r.Get("/jobs/{id}", h.AdminGetJob)
+	r.Post("/jobs/{id}/retry", h.AdminRetryJob)
+	r.Get("/scheduler", h.AdminSchedule)
+	r.Post("/scheduler/{task}/run", h.AdminRunScheduled)
+}
+
+// AdminGetUserURLs lists the links of any user.
+func (h *Handlers) AdminGetUserURLs(w http.ResponseWriter, r *http.Request) {
+	list, err := h.store.LoadUserURLs(r.Context(), chi.URLParam(r, "userID"), h.cfg.BaseURL)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	if list == nil {
+		list = []store.UserURL{}
+	}
+	writeData(w, r, http.StatusOK, list)
+}
+
+// AdminDeleteURL soft-deletes a short ID on behalf of its owner.
+func (h *Handlers) AdminDeleteURL(w http.ResponseWriter, r *http.Request) {
+	rec, err := store.FindRecord(r.Context(), h.store, chi.URLParam(r, "id"))
+	if errors.Is(err, store.ErrNotFound) {
+		middleware.Problem(w, r, "Short URL not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	if _, delErr := h.store.DeleteBatch(r.Context(), rec.UserID, []string{rec.ShortURL}); delErr != nil {
+		storeError(w, r, delErr)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminStats returns record counts.
+func (h *Handlers) AdminStats(w http.ResponseWriter, r *http.Request) {
+	st, err := store.CollectStats(r.Context(), h.store)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	writeData(w, r, http.StatusOK, st)
+}
+
+// AdminRotateKey switches the cookie signing key to {"secret": "..."} or to a random one.
+// The new key lives in memory only: after a restart SECRET_KEY applies again.
+func (h *Handlers) AdminRotateKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Secret string `json:"secret"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			middleware.Problem(w, r, "Failed to parse JSON", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Secret == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			middleware.Problem(w, r, internalServerError, http.StatusInternalServerError)
+			return
+		}
+		req.Secret = hex.EncodeToString(buf)
+	}
+	h.auth.RotateSecret(req.Secret)
+	h.logger.Info("Cookie signing key rotated")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,172 @@
+// Internal/app/endpoints/analytics.go.
+package endpoints
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/dkolesni-prog/transformer/internal/app/middleware"
+	"github.com/dkolesni-prog/transformer/internal/clicks"
+	"github.com/dkolesni-prog/transformer/internal/store"
+	"github.com/dkolesni-prog/transformer/internal/urlpolicy"
+)
+
+const (
+	defaultTopWindow = 7 * 24 * time.Hour
+	defaultTopLimit  = 10
+	maxTopLimit      = 100
+)
+
+var errInvalidWindow = errors.New("invalid window")
+
+// TopLink — элемент ответа GET /api/user/urls/top.
+type TopLink struct {
+	ShortURL    string `json:"short_url"`
+	OriginalURL string `json:"original_url"`
+	Title       string `json:"title,omitempty"`
+	Clicks      int    `json:"clicks"`
+}
+
+// LinkStatsResponse — ответ GET /api/user/urls/{id}/stats.
+type LinkStatsResponse struct {
+	ShortURL string `json:"short_url"`
+	clicks.LinkStats
+}
+
+// recordClick ставит переход в очередь трекера; сама запись идёт в фоне.
+// В c уже заполнено то, что известно после выбора адреса: ShortID, вариант и гео.
+func (h *Handlers) recordClick(r *http.Request, c clicks.Click) {
+	c.Time = time.Now().UTC()
+	c.IP = middleware.GetClientIP(r.Context())
+	c.Referrer = r.Referer()
+	c.UserAgent = r.UserAgent()
+	// HEAD шлют проверщики ссылок и превью, а не люди.
+	c.Bot = r.Method == http.MethodHead
+	h.tracker.Track(c)
+}
+
+// TopUserURLs returns the caller's most clicked links: GET /api/user/urls/top?window=7d&limit=10.
+func (h *Handlers) TopUserURLs(w http.ResponseWriter, r *http.Request) {
+	cfg := tenantConfig(r, h.cfg)
+	userID, ok := middleware.GetUserID(r)
+	if !ok || userID == "" {
+		reject(w, r, http.StatusUnauthorized, "unauthorized", nil)
+		return
+	}
+	window, err := parseWindow(r.URL.Query().Get("window"))
+	if err != nil {
+		middleware.Problem(w, r, "Invalid window", http.StatusBadRequest)
+		return
+	}
+	limit := defaultTopLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		if limit, err = strconv.Atoi(raw); err != nil || limit < 1 || limit > maxTopLimit {
+			middleware.Problem(w, r, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	list, err := h.store.LoadUserURLs(r.Context(), userID, cfg.BaseURL)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	domain := tenantDomain(r)
+	byID := make(map[string]store.UserURL, len(list))
+	ids := make([]string, 0, len(list))
+	for _, item := range list {
+		if item.Domain != domain {
+			continue
+		}
+		id := store.ShortIDFromURL(item.ShortURL, cfg.BaseURL)
+		byID[id] = item
+		ids = append(ids, id)
+	}
+
+	top := []TopLink{}
+	if len(ids) > 0 {
+		counts, countErr := h.tracker.Log().Counts(r.Context(), ids, time.Now().Add(-window))
+		if countErr != nil {
+			storeError(w, r, countErr)
+			return
+		}
+		for id, n := range counts {
+			item := byID[id]
+			top = append(top, TopLink{
+				ShortURL:    item.ShortURL,
+				OriginalURL: urlpolicy.DisplayURL(item.OriginalURL),
+				Title:       item.Title,
+				Clicks:      n,
+			})
+		}
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Clicks != top[j].Clicks {
+			return top[i].Clicks > top[j].Clicks
+		}
+		return top[i].ShortURL < top[j].ShortURL
+	})
+	if len(top) > limit {
+		top = top[:limit]
+	}
+	writeData(w, r, http.StatusOK, top)
+}
+
+// LinkStats returns click stats of the caller's link: GET /api/user/urls/{id}/stats?window=30d.
+// Страны и регионы есть только при настроенной базе GeoIP.
+func (h *Handlers) LinkStats(w http.ResponseWriter, r *http.Request) {
+	cfg := tenantConfig(r, h.cfg)
+	userID, ok := middleware.GetUserID(r)
+	if !ok || userID == "" {
+		reject(w, r, http.StatusUnauthorized, "unauthorized", nil)
+		return
+	}
+	window, err := parseWindow(r.URL.Query().Get("window"))
+	if err != nil {
+		middleware.Problem(w, r, "Invalid window", http.StatusBadRequest)
+		return
+	}
+	id := chi.URLParam(r, "id")
+	owned, err := h.ownsLink(r, cfg, userID, id)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	if !owned {
+		middleware.Problem(w, r, "Short URL not found", http.StatusNotFound)
+		return
+	}
+
+	stats, err := h.tracker.Log().Stats(r.Context(), id, time.Now().Add(-window))
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	writeData(w, r, http.StatusOK, LinkStatsResponse{ShortURL: cfg.BaseURL + id, LinkStats: stats})
+}
+
+// parseWindow понимает time.ParseDuration и дни вида "7d"; пусто — неделя.
+func parseWindow(raw string) (time.Duration, error) {
+	if raw == "" {
+		return defaultTopWindow, nil
+	}
+	var d time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(raw, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(raw)
+	}
+	if err != nil || d <= 0 {
+		return 0, errInvalidWindow
+	}
+	return d, nil
+}
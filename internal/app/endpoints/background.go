@@ -0,0 +1,71 @@
+// Internal/app/endpoints/background.go.
+package endpoints
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// backgroundGroup — операции, которые обработчики оставляют доработать после ответа:
+// подгрузка заголовков. У каждого Handlers своя группа; удаления идут через очередь jobs.
+type backgroundGroup struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newBackgroundGroup() *backgroundGroup {
+	bg := &backgroundGroup{}
+	bg.ctx, bg.cancel = context.WithCancel(context.Background())
+	return bg
+}
+
+// goBackground запускает fn с контекстом, который хранит значения запроса (пользователя,
+// IP для аудита), но не его отмену. Контекст ограничен cfg.BackgroundTimeout (<= 0 — без
+// срока) и отменяется ShutdownBackground.
+func (h *Handlers) goBackground(r *http.Request, fn func(ctx context.Context)) {
+	base := context.WithoutCancel(r.Context())
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if timeout := h.cfg.BackgroundTimeout; timeout > 0 {
+		ctx, cancel = context.WithTimeout(base, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(base)
+	}
+	stop := context.AfterFunc(h.bg.ctx, cancel)
+
+	h.bg.wg.Add(1)
+	go func() {
+		defer h.bg.wg.Done()
+		defer stop()
+		defer cancel()
+		fn(ctx)
+	}()
+}
+
+// ShutdownBackground ждёт фоновые операции до истечения ctx, затем отменяет оставшиеся
+// и возвращает ctx.Err(), если дождаться не удалось. Очередь из Deps.Jobs останавливает
+// её владелец, собственную очередь Handlers — этот метод.
+func (h *Handlers) ShutdownBackground(ctx context.Context) error {
+	if h.ownJobs {
+		if err := h.jobs.Stop(ctx); err != nil {
+			return err
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		h.bg.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		h.bg.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
@@ -4,17 +4,34 @@ package endpoints
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/go-chi/chi/v5"
 
+	"github.com/dkolesni-prog/transformer/internal/account"
+	"github.com/dkolesni-prog/transformer/internal/apitoken"
 	"github.com/dkolesni-prog/transformer/internal/app/middleware"
+	"github.com/dkolesni-prog/transformer/internal/app/negotiate"
+	"github.com/dkolesni-prog/transformer/internal/audit"
+	"github.com/dkolesni-prog/transformer/internal/buildinfo"
+	"github.com/dkolesni-prog/transformer/internal/clicks"
 	"github.com/dkolesni-prog/transformer/internal/config"
+	"github.com/dkolesni-prog/transformer/internal/jobs"
+	"github.com/dkolesni-prog/transformer/internal/logging"
+	"github.com/dkolesni-prog/transformer/internal/notify"
+	"github.com/dkolesni-prog/transformer/internal/org"
+	"github.com/dkolesni-prog/transformer/internal/ratelimit"
+	"github.com/dkolesni-prog/transformer/internal/scheduler"
+	"github.com/dkolesni-prog/transformer/internal/shortid"
 	"github.com/dkolesni-prog/transformer/internal/store"
+	"github.com/dkolesni-prog/transformer/internal/urlpolicy"
 )
 
 const (
@@ -22,96 +39,434 @@ const (
 	contentType         = "Content-Type"
 	contentTypeJSON     = "application/json; charset=utf-8"
 	contentTypeText     = "text/plain; charset=utf-8"
+	// headerOwnedByOther помечает ответ 409, если существующая ссылка принадлежит другому пользователю.
+	headerOwnedByOther = "X-Owned-By-Other"
 )
 
-// NewRouter creates and returns the main chi.Router.
-func NewRouter(cfg *config.Config, s store.Store, version string) http.Handler {
+// Handlers — HTTP-обработчики сервиса вместе с их зависимостями. Лог и ключ подписи
+// кук не глобальные, поэтому в одном процессе (и в тестах) можно держать несколько
+// независимых экземпляров.
+type Handlers struct {
+	store   store.Store
+	cfg     *config.Config
+	logger  logging.Logger
+	auth    *middleware.Auth
+	audit   audit.Log
+	orgs    org.Directory
+	tracker *clicks.Tracker
+	build   buildinfo.Info
+	bg      *backgroundGroup
+	jobs    *jobs.Runner
+	// ownJobs — очередь создана в New и останавливается в ShutdownBackground.
+	ownJobs   bool
+	purger    store.Purger
+	scheduler *scheduler.Scheduler
+	limiter   ratelimit.Limiter
+	notifier  *notify.Notifier
+	// accounts — nil, если учётные записи выключены (Config.Accounts).
+	accounts account.Directory
+	mailer   account.Mailer
+	tokens   apitoken.Store
+}
+
+// Deps — зависимости для New. Обязательны только Store и Config.
+type Deps struct {
+	Store  store.Store
+	Config *config.Config
+	// Logger — лог запросов и обработчиков; nil — logging.Nop.
+	Logger logging.Logger
+	// IDGen выдаёт userID новым посетителям; nil — middleware.NewUserID.
+	IDGen func() string
+	// Auth подписывает куки и токены; nil — новый Auth с Config.SecretKey и IDGen.
+	Auth *middleware.Auth
+	// Audit может быть nil, тогда журнал действий недоступен.
+	Audit audit.Log
+	// Orgs и Tracker могут быть nil, тогда организации и переходы хранятся только в памяти.
+	Orgs    org.Directory
+	Tracker *clicks.Tracker
+	// Version подменяет версию из buildinfo.Get() в ответе /version.
+	Version string
+	// Jobs — очередь фоновых заданий. New регистрирует в ней свои обработчики, а запускает
+	// её вызывающий после New. nil — очередь в памяти, которая запускается сразу.
+	Jobs *jobs.Runner
+	// Purger — хранилище без обёрток для POST /api/admin/jobs/purge; nil — чистка недоступна.
+	Purger store.Purger
+	// Scheduler — обслуживающие задачи по расписанию для /api/admin/scheduler; nil — их нет.
+	Scheduler *scheduler.Scheduler
+	// RateLimiter считает запросы с одного IP; nil при Config.RateLimit > 0 — счёт в памяти.
+	RateLimiter ratelimit.Limiter
+	// Notifier сообщает операторам о помеченных ссылках; nil — не сообщает.
+	Notifier *notify.Notifier
+	// Accounts хранит учётные записи; nil при Config.Accounts — в памяти.
+	Accounts account.Directory
+	// Mailer отправляет письма подтверждения email; nil — ссылка только пишется в лог.
+	Mailer account.Mailer
+	// Tokens хранит API-токены пользователей; nil — в памяти.
+	Tokens apitoken.Store
+}
+
+// New собирает Handlers, подставляя значения по умолчанию для незаданных зависимостей.
+func New(d Deps) *Handlers {
+	if d.Logger == nil {
+		d.Logger = logging.Nop()
+	}
+	if d.IDGen == nil {
+		d.IDGen = middleware.NewUserID
+	}
+	// Конфиг, собранный вручную (в тестах), мог получить RoutePrefix после NewConfig.
+	d.Config.ApplyRoutePrefix()
+	if d.Auth == nil {
+		// Неверные настройки куки останавливают запуск в main.
+		cookie, _ := middleware.ParseCookieOptions(d.Config.CookieSecure, d.Config.CookieSameSite,
+			d.Config.BaseURL, d.Config.CookieMaxAge, d.Config.CookieRenewAfter)
+		d.Auth = middleware.NewAuth(d.Config.SecretKey, d.IDGen, cookie)
+	}
+	if d.Tokens == nil {
+		d.Tokens = apitoken.NewMemoryStore()
+	}
+	d.Auth.UseTokens(d.Tokens)
+	if d.Orgs == nil {
+		d.Orgs = org.NewMemoryDirectory()
+	}
+	if d.Tracker == nil {
+		d.Tracker = clicks.NewTracker(clicks.NewMemoryLog(), d.Logger)
+	}
+	if d.Accounts == nil && d.Config.Accounts {
+		d.Accounts = account.NewMemoryDirectory()
+	}
+	if d.Accounts != nil {
+		// За userID стоит учётная запись: куку с чужим userID подделать нельзя.
+		d.Auth.RequireSignature()
+	}
+	if d.RateLimiter == nil && d.Config.RateLimit > 0 {
+		d.RateLimiter = ratelimit.NewMemory(ratelimit.Limit{PerMinute: d.Config.RateLimit, Burst: d.Config.RateLimitBurst})
+	}
+	build := buildinfo.Get()
+	if d.Version != "" {
+		build.Version = d.Version
+	}
+	h := &Handlers{
+		store:     d.Store,
+		cfg:       d.Config,
+		logger:    d.Logger,
+		auth:      d.Auth,
+		audit:     d.Audit,
+		orgs:      d.Orgs,
+		tracker:   d.Tracker,
+		build:     build,
+		bg:        newBackgroundGroup(),
+		jobs:      d.Jobs,
+		scheduler: d.Scheduler,
+		limiter:   d.RateLimiter,
+		notifier:  d.Notifier,
+		accounts:  d.Accounts,
+		mailer:    d.Mailer,
+		tokens:    d.Tokens,
+	}
+	if h.jobs == nil {
+		h.jobs = jobs.NewRunner(jobs.NewMemoryQueue(), d.Logger)
+		h.ownJobs = true
+	}
+	h.registerJobs(d.Purger)
+	if h.ownJobs {
+		h.jobs.Start(1)
+	}
+	return h
+}
+
+// Router регистрирует обработчики на новом chi.Router.
+func (h *Handlers) Router() http.Handler {
+	cfg := h.cfg
 	r := chi.NewRouter()
-	r.Use(middleware.WithLogging, middleware.GzipMiddleware)
-	r.Use(middleware.AuthMiddleware)
+	// Неверные сети останавливают запуск в main, здесь берутся только разобранные.
+	trusted, _ := middleware.ParseTrustedProxies(cfg.TrustedProxies)
+	r.Use(middleware.RequestID)
+	r.Use(middleware.ClientIP(trusted))
+	r.Use(middleware.WithLogging(h.logger, cfg.LogBodyLimit))
+	r.Use(middleware.RateLimit(h.limiter))
+	r.Use(middleware.LimitConcurrency(cfg.MaxConcurrentReads, cfg.MaxConcurrentWrites))
+	r.Use(middleware.GzipMiddleware)
+	r.Use(h.auth.Middleware)
+	r.Use(middleware.CSRF(cfg.CSRFProtection))
+	r.Use(h.withTenant(trusted))
+	r.Use(requestTimeout(cfg))
 
-	r.Post("/", func(w http.ResponseWriter, r *http.Request) {
-		ShortenURL(w, r, s, cfg)
-	})
-	r.Post("/api/shorten", func(w http.ResponseWriter, r *http.Request) {
-		ShortenURLJSON(w, r, s, cfg)
-	})
-	r.Post("/api/shorten/batch", func(w http.ResponseWriter, r *http.Request) {
-		ShortenBatch(w, r, s, cfg)
-	})
-	r.Delete("/api/user/urls", func(w http.ResponseWriter, r *http.Request) {
-		DeleteUserURLs(w, r, s)
-	})
-	r.Get("/api/user/urls", func(w http.ResponseWriter, r *http.Request) {
-		GetUserURLs(w, r, s, cfg)
-	})
-	r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
-		GetFullURL(w, r, s)
-	})
-	r.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
-		Ping(w, r, s)
-	})
-	r.Get("/version/", func(w http.ResponseWriter, r *http.Request) {
-		GetVersion(w, r, version)
+	r.Get("/", h.Home)
+	r.Post("/", h.ShortenURL)
+	r.Post("/api/shorten", h.ShortenURLJSON)
+	r.Post("/api/shorten/batch", h.ShortenBatch)
+	r.Delete("/api/user/urls", h.DeleteUserURLs)
+	r.Get("/api/user/urls", h.GetUserURLs)
+	r.Get("/api/user/jobs/{id}", h.GetUserJob)
+	r.Delete("/api/user/account", h.DeleteAccount)
+	if h.accounts != nil {
+		r.Post("/api/user/register", h.Register)
+		r.Post("/api/user/login", h.Login)
+		r.Post("/api/user/logout", h.Logout)
+		r.Get("/api/user/verify", h.VerifyEmail)
+		r.Get("/api/user/account", h.GetAccount)
+	}
+	r.Get("/api/user/export", h.ExportUserData)
+	r.Post("/api/user/tokens", h.CreateToken)
+	r.Get("/api/user/tokens", h.ListTokens)
+	r.Get("/api/user/tokens/{id}", h.GetToken)
+	r.Delete("/api/user/tokens/{id}", h.RevokeToken)
+	r.Get("/api/user/urls/top", h.TopUserURLs)
+	r.Get("/api/user/urls/{id}/stats", h.LinkStats)
+	r.Get("/api/user/urls/{id}/events", h.ClickEvents)
+	r.Post("/api/user/urls/{id}/transfer", h.TransferUserURL)
+	r.Post("/api/user/urls/claim", h.ClaimUserURL)
+	r.Post("/api/user/urls/restore", h.RestoreUserURLs)
+	r.Put("/api/user/urls/{id}", h.UpdateUserURL)
+	r.Get("/{id}", h.GetFullURL)
+	r.Post("/{id}", h.GetFullURL)
+	r.Head("/{id}", h.GetFullURL)
+	r.Get("/ui", h.UILinks)
+	r.Get("/ui/links/{id}", h.UILinkStats)
+	r.Get("/robots.txt", h.GetRobots)
+	r.Get("/favicon.ico", GetFavicon)
+	r.Get("/ping", h.Ping)
+	r.Get("/version", h.GetBuildInfo)
+	r.Get("/version/", h.GetVersion)
+	r.Route("/api/org/{org}", h.orgRoutes)
+	r.With(middleware.AdminAuth(cfg.AdminToken)).Get("/metrics", middleware.MetricsHandler().ServeHTTP)
+	r.Route("/api/admin", func(r chi.Router) {
+		r.Use(middleware.AdminAuth(cfg.AdminToken))
+		r.Get("/audit", h.GetAuditLog)
+		h.adminRoutes(r)
 	})
+	if cfg.RoutePrefix != "" {
+		return stripRoutePrefix(cfg.RoutePrefix, r)
+	}
 	return r
 }
 
-// DeleteUserURLs removes user’s short URLs asynchronously.
-func DeleteUserURLs(w http.ResponseWriter, r *http.Request, s store.Store) {
+// stripRoutePrefix отдаёт next запросы под prefix с путём без него, остальным отвечает 404.
+func stripRoutePrefix(prefix string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest, ok := strings.CutPrefix(r.URL.Path, prefix)
+		if !ok || (rest != "" && rest[0] != '/') {
+			http.NotFound(w, r)
+			return
+		}
+		if rest == "" {
+			rest = "/"
+		}
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = rest
+		r2.URL.RawPath = ""
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// requestTimeout ограничивает время обработки: пачкам и выгрузке даётся больше,
+// поток событий живёт, пока клиент не отключится.
+func requestTimeout(cfg *config.Config) func(http.Handler) http.Handler {
+	regular := middleware.Timeout(cfg.RequestTimeout)
+	long := middleware.Timeout(cfg.BatchRequestTimeout)
+	return func(next http.Handler) http.Handler {
+		regularNext, longNext := regular(next), long(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch path := r.URL.Path; {
+			case strings.HasSuffix(path, "/events"):
+				next.ServeHTTP(w, r)
+			case path == "/api/shorten/batch", path == "/api/user/export":
+				longNext.ServeHTTP(w, r)
+			default:
+				regularNext.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// DeleteUserURLs queues removal of user’s short URLs and replies 202 with a job ID
+// to poll at GET /api/user/jobs/{id}.
+// With ?sync=true it waits for the store and replies with the outcome for every ID.
+func (h *Handlers) DeleteUserURLs(w http.ResponseWriter, r *http.Request) {
 	userID, ok := middleware.GetUserID(r)
-	fmt.Printf("[DEBUG DeleteUserURLs] => got userID=%q ok=%v\n", userID, ok)
 	if !ok || userID == "" {
-		w.Header().Set(contentType, contentTypeJSON)
-		w.WriteHeader(http.StatusUnauthorized)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "unauthorized"})
+		middleware.Problem(w, r, "unauthorized", http.StatusUnauthorized)
 		return
 	}
 	var toDelete []string
 	if err := json.NewDecoder(r.Body).Decode(&toDelete); err != nil {
-		http.Error(w, "invalid request", http.StatusBadRequest)
+		middleware.Problem(w, r, "invalid request", http.StatusBadRequest)
+		return
+	}
+	defer func() { _ = r.Body.Close() }()
+	requested := len(toDelete)
+	for _, id := range toDelete[:requested] {
+		if folded, changed := shortid.Fold(id); changed {
+			toDelete = append(toDelete, folded)
+		}
+	}
+
+	if sync, _ := strconv.ParseBool(r.URL.Query().Get("sync")); sync {
+		results, errDel := h.store.DeleteBatch(r.Context(), userID, toDelete)
+		if errDel != nil {
+			storeError(w, r, errDel)
+			return
+		}
+		writeData(w, r, http.StatusOK, foldDeleteResults(results, requested))
+		return
+	}
+
+	payload := deletePayload{IDs: toDelete, Requested: requested, IP: middleware.GetClientIP(r.Context())}
+	jobID, err := h.jobs.Enqueue(r.Context(), jobDeleteURLs, userID, payload)
+	if err != nil {
+		h.logger.Error("Failed to queue URL deletion", "error", err)
+		storeError(w, r, err)
+		return
+	}
+	writeJobAccepted(w, r, h.cfg.RoutePrefix+"/api/user/jobs/"+jobID, jobID)
+}
+
+// foldDeleteResults оставляет по итогу на каждый из первых requested ID запроса:
+// для ID с заглавными буквами в регистронезависимом режиме берётся лучший из итогов
+// самого ID и его свёрнутого вида, которые DeleteBatch получил в хвосте списка.
+func foldDeleteResults(results []store.DeleteResult, requested int) []store.DeleteResult {
+	rank := map[store.DeleteStatus]int{store.DeleteNotFound: 0, store.DeleteForbidden: 1, store.Deleted: 2}
+	folded := make(map[string]store.DeleteStatus, len(results)-requested)
+	for _, res := range results[requested:] {
+		folded[res.ShortID] = res.Status
+	}
+	out := results[:requested]
+	for i, res := range out {
+		id, changed := shortid.Fold(res.ShortID)
+		if !changed {
+			continue
+		}
+		if status, ok := folded[id]; ok && rank[status] > rank[res.Status] {
+			out[i].Status = status
+		}
+	}
+	return out
+}
+
+// RestoreUserURLs undoes a deletion: POST /api/user/urls/restore ["id1","id2"].
+// Replies with the IDs actually restored; links of other users, live links and links
+// already purged by retention are skipped.
+func (h *Handlers) RestoreUserURLs(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok || userID == "" {
+		middleware.Problem(w, r, "unauthorized", http.StatusUnauthorized)
 		return
 	}
 	defer func() { _ = r.Body.Close() }()
-	go func() {
-		bg := context.Background()
-		if errDel := s.DeleteBatch(bg, userID, toDelete); errDel != nil {
-			middleware.Log.Error().Err(errDel).Msg("Failed to mark URLs as deleted")
+	var ids []string
+	if err := json.NewDecoder(r.Body).Decode(&ids); err != nil || len(ids) == 0 {
+		middleware.Problem(w, r, "invalid request", http.StatusBadRequest)
+		return
+	}
+	for _, id := range ids {
+		if folded, changed := shortid.Fold(id); changed {
+			ids = append(ids, folded)
 		}
-	}()
-	w.WriteHeader(http.StatusAccepted)
+	}
+	restored, err := h.store.RestoreBatch(r.Context(), userID, ids)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	if restored == nil {
+		restored = []string{}
+	}
+	writeData(w, r, http.StatusOK, restored)
+}
+
+// UpdateUserURL changes the destination of the caller's link: PUT /api/user/urls/{id} {"url": "..."}.
+func (h *Handlers) UpdateUserURL(w http.ResponseWriter, r *http.Request) {
+	cfg := tenantConfig(r, h.cfg)
+	userID, ok := middleware.GetUserID(r)
+	if !ok || userID == "" {
+		middleware.Problem(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	defer func() { _ = r.Body.Close() }()
+	var req struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.URL == "" {
+		middleware.Problem(w, r, "Failed to parse JSON", http.StatusBadRequest)
+		return
+	}
+	parsed, pErr := url.ParseRequestURI(req.URL)
+	if pErr != nil || parsed.Scheme == "" || parsed.Host == "" {
+		middleware.Problem(w, r, "Invalid URL", http.StatusBadRequest)
+		return
+	}
+	parsed, ok = h.applyPolicy(w, r, cfg, parsed)
+	if !ok {
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	err := h.store.UpdateURL(r.Context(), userID, id, parsed)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		middleware.Problem(w, r, "Short URL not found", http.StatusNotFound)
+		return
+	case errors.Is(err, store.ErrConflict):
+		middleware.Problem(w, r, "URL is already shortened", http.StatusConflict)
+		return
+	case err != nil:
+		storeError(w, r, err)
+		return
+	}
+	writeData(w, r, http.StatusOK, map[string]string{
+		"short_url":    cfg.BaseURL + id,
+		"original_url": parsed.String(),
+	})
 }
 
 // GetUserURLs lists user’s short URLs.
-func GetUserURLs(w http.ResponseWriter, r *http.Request, s store.Store, cfg *config.Config) {
+func (h *Handlers) GetUserURLs(w http.ResponseWriter, r *http.Request) {
+	cfg := tenantConfig(r, h.cfg)
 	userID, ok := middleware.GetUserID(r)
 	if !ok || userID == "" {
-		w.Header().Set(contentType, contentTypeJSON)
-		w.WriteHeader(http.StatusUnauthorized)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "unauthorized"})
+		middleware.Problem(w, r, "unauthorized", http.StatusUnauthorized)
 		return
 	}
-	list, err := s.LoadUserURLs(r.Context(), userID, cfg.BaseURL)
+	list, err := h.store.LoadUserURLs(r.Context(), userID, cfg.BaseURL)
 	if err != nil {
-		http.Error(w, internalServerError, http.StatusInternalServerError)
+		storeError(w, r, err)
 		return
 	}
+	// У каждого домена своё пространство ссылок.
+	// ?tag=a&tag=b оставляет ссылки со всеми указанными метками.
+	domain, wantTags := tenantDomain(r), r.URL.Query()["tag"]
+	filtered := list[:0]
+	for _, item := range list {
+		if item.Domain == domain && hasTags(item, wantTags) {
+			filtered = append(filtered, item)
+		}
+	}
+	list = filtered
 	if len(list) == 0 {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
-	w.Header().Set(contentType, contentTypeJSON)
-	w.WriteHeader(http.StatusOK)
-	if encErr := json.NewEncoder(w).Encode(list); encErr != nil {
-		http.Error(w, internalServerError, http.StatusInternalServerError)
+	for i := range list {
+		list[i].OriginalURL = urlpolicy.DisplayURL(list[i].OriginalURL)
 	}
+	writeData(w, r, http.StatusOK, list)
 }
 
 // GetFullURL redirects to the original URL if it’s not deleted; otherwise returns 410 Gone.
-func GetFullURL(w http.ResponseWriter, r *http.Request, s store.Store) {
+// Errors stay plain text: short links are opened by browsers rather than API clients.
+func (h *Handlers) GetFullURL(w http.ResponseWriter, r *http.Request) {
+	cfg := tenantConfig(r, h.cfg)
 	id := chi.URLParam(r, "id")
-	longURL, isDeleted, err := s.LoadFull(r.Context(), id)
+	longURL, isDeleted, err := h.store.LoadFull(r.Context(), id)
+	if folded, changed := shortid.Fold(id); changed && errors.Is(err, store.ErrNotFound) {
+		// Регистронезависимый режим: id могли перепечатать заглавными.
+		id = folded
+		longURL, isDeleted, err = h.store.LoadFull(r.Context(), id)
+	}
 	if err != nil {
+		if isUnavailable(w, r, err) {
+			return
+		}
 		http.Error(w, "Short URL not found", http.StatusNotFound)
 		return
 	}
@@ -119,158 +474,414 @@ func GetFullURL(w http.ResponseWriter, r *http.Request, s store.Store) {
 		http.Error(w, "URL is gone", http.StatusGone)
 		return
 	}
-	http.Redirect(w, r, longURL.String(), http.StatusTemporaryRedirect)
+	meta, err := h.store.LoadMeta(r.Context(), id)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		storeError(w, r, err)
+		return
+	}
+	if meta.Domain != tenantDomain(r) {
+		http.Error(w, "Short URL not found", http.StatusNotFound)
+		return
+	}
+	if !checkLinkPassword(w, r, meta) {
+		return
+	}
+	status := http.StatusTemporaryRedirect
+	if r.Method == http.MethodPost {
+		// Ответ на форму пароля: браузер должен перейти по ссылке GET-запросом.
+		status = http.StatusSeeOther
+	}
+	dest, click := h.destination(w, r, id, longURL.String(), meta)
+	utm := cfg.UTMTemplate
+	if meta.UTM != "" {
+		utm = meta.UTM
+	}
+	dest = appendUTM(dest, utm, r.Host, id, meta)
+	if !h.confirmFlagged(w, r, meta, dest) {
+		return
+	}
+	h.recordClick(r, click)
+	http.Redirect(w, r, dest, status)
 }
 
-// ShortenBatch handles bulk shortening requests.
-func ShortenBatch(w http.ResponseWriter, r *http.Request, s store.Store, cfg *config.Config) {
+// ShortenBatch handles bulk shortening requests. ?domain= picks the short domain for the whole batch.
+func (h *Handlers) ShortenBatch(w http.ResponseWriter, r *http.Request) {
 	defer func() { _ = r.Body.Close() }()
+	r, ok := h.selectDomain(w, r, r.URL.Query().Get("domain"))
+	if !ok {
+		return
+	}
+	cfg := tenantConfig(r, h.cfg)
 	type BatchRequestItem struct {
-		CorrelationID string `json:"correlation_id"`
-		OriginalURL   string `json:"original_url"`
+		CorrelationID string   `json:"correlation_id"`
+		OriginalURL   string   `json:"original_url"`
+		Tags          []string `json:"tags"`
 	}
 	type BatchResponseItem struct {
 		CorrelationID string `json:"correlation_id"`
 		ShortURL      string `json:"short_url"`
+		// Existing — адрес уже был сокращён; OwnedByOther — и ссылка принадлежит другому пользователю.
+		Existing     bool `json:"existing,omitempty"`
+		OwnedByOther bool `json:"owned_by_other,omitempty"`
 	}
 	var reqs []BatchRequestItem
 	if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
-		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		middleware.Problem(w, r, "Invalid request format", http.StatusBadRequest)
 		return
 	}
 	if len(reqs) == 0 {
-		http.Error(w, "Empty batch", http.StatusBadRequest)
+		middleware.Problem(w, r, "Empty batch", http.StatusBadRequest)
 		return
 	}
+	// Одинаковые адреса сохраняются один раз: slot[i] — индекс адреса i-го элемента в urls.
 	urls := make([]*url.URL, 0, len(reqs))
-	corrMap := make(map[*url.URL]string)
-	for _, rItem := range reqs {
+	tags := make([][]string, 0, len(reqs))
+	slot := make([]int, len(reqs))
+	seen := make(map[string]int, len(reqs))
+	for i, rItem := range reqs {
 		parsed, pErr := url.ParseRequestURI(rItem.OriginalURL)
 		if pErr != nil {
-			http.Error(w, "Invalid URL in batch", http.StatusBadRequest)
+			middleware.Problem(w, r, "Invalid URL in batch", http.StatusBadRequest)
 			return
 		}
+		itemTags, tagErr := normalizeTags(rItem.Tags)
+		if tagErr != nil {
+			middleware.Problem(w, r, "Invalid tags in batch: "+tagErr.Error(), http.StatusBadRequest)
+			return
+		}
+		parsed, ok := h.applyPolicy(w, r, cfg, parsed)
+		if !ok {
+			return
+		}
+		if j, dup := seen[parsed.String()]; dup {
+			slot[i] = j
+			if tags[j], tagErr = normalizeTags(append(tags[j], itemTags...)); tagErr != nil {
+				middleware.Problem(w, r, "Invalid tags in batch: "+tagErr.Error(), http.StatusBadRequest)
+				return
+			}
+			continue
+		}
+		seen[parsed.String()] = len(urls)
+		slot[i] = len(urls)
 		urls = append(urls, parsed)
-		corrMap[parsed] = rItem.CorrelationID
+		tags = append(tags, itemTags)
+	}
+	metas := make([]store.LinkMeta, len(urls))
+	for i := range urls {
+		metas[i] = store.LinkMeta{Tags: tags[i], Domain: tenantDomain(r)}
 	}
 	userID, _ := middleware.GetUserID(r)
-	shorts, err := s.SaveBatch(r.Context(), userID, urls, cfg)
+	shorts, err := h.store.SaveBatch(r.Context(), userID, urls, metas, cfg)
 	if err != nil {
-		http.Error(w, `{"error":"internal error"}`, http.StatusInternalServerError)
+		if isUnavailable(w, r, err) {
+			return
+		}
+		middleware.Problem(w, r, internalServerError, http.StatusInternalServerError)
+		return
+	}
+	if len(shorts) != len(urls) {
+		h.logger.Error("SaveBatch returned a wrong number of results", "want", len(urls), "got", len(shorts))
+		middleware.Problem(w, r, internalServerError, http.StatusInternalServerError)
 		return
 	}
-	resp := make([]BatchResponseItem, 0, len(shorts))
-	for i, shortU := range shorts {
+	for i, saved := range shorts {
+		if saved.Existing {
+			shorts[i].ShortURL = h.ownerShortURL(r, cfg, saved.ShortURL)
+			continue
+		}
+		h.fetchTitleLater(r, cfg, userID, store.ShortIDFromURL(saved.ShortURL, cfg.BaseURL), urls[i], metas[i])
+	}
+	// Ответ идёт в порядке запроса, по элементу на каждый correlation_id.
+	resp := make([]BatchResponseItem, 0, len(reqs))
+	for i, rItem := range reqs {
+		saved := shorts[slot[i]]
 		resp = append(resp, BatchResponseItem{
-			CorrelationID: corrMap[urls[i]],
-			ShortURL:      shortU,
+			CorrelationID: rItem.CorrelationID,
+			ShortURL:      saved.ShortURL,
+			Existing:      saved.Existing,
+			OwnedByOther:  saved.Existing && saved.OwnerID != userID,
 		})
 	}
-	w.Header().Set(contentType, contentTypeJSON)
-	w.WriteHeader(http.StatusCreated)
-	_ = json.NewEncoder(w).Encode(resp)
+	writeData(w, r, http.StatusCreated, resp)
 }
 
-// ShortenURL handles the plain-text URL shortening endpoint.
-func ShortenURL(w http.ResponseWriter, r *http.Request, s store.Store, cfg *config.Config) {
+// ShortenURL handles the plain-text URL shortening endpoint. ?domain= picks the short domain.
+func (h *Handlers) ShortenURL(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		middleware.Problem(w, r, "Only POST method is allowed", http.StatusMethodNotAllowed)
 		return
 	}
 	defer func() { _ = r.Body.Close() }()
+	r, ok := h.selectDomain(w, r, r.URL.Query().Get("domain"))
+	if !ok {
+		return
+	}
+	cfg := tenantConfig(r, h.cfg)
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, internalServerError, http.StatusInternalServerError)
+		middleware.Problem(w, r, internalServerError, http.StatusInternalServerError)
 		return
 	}
 	longURL := string(body)
 	if longURL == "" {
-		http.Error(w, "Empty body", http.StatusBadRequest)
+		middleware.Problem(w, r, "Empty body", http.StatusBadRequest)
 		return
 	}
 	parsed, pErr := url.ParseRequestURI(longURL)
 	if pErr != nil || parsed.Scheme == "" || parsed.Host == "" {
-		http.Error(w, "Invalid URL", http.StatusBadRequest)
+		middleware.Problem(w, r, "Invalid URL", http.StatusBadRequest)
+		return
+	}
+	parsed, ok = h.applyPolicy(w, r, cfg, parsed)
+	if !ok {
 		return
 	}
 	userID, _ := middleware.GetUserID(r)
-	res, saveErr := s.Save(r.Context(), userID, parsed, cfg)
+	meta := store.LinkMeta{Domain: tenantDomain(r)}
+	res, saveErr := h.store.Save(r.Context(), userID, parsed, meta, cfg)
 	if saveErr != nil {
-		if strings.Contains(saveErr.Error(), "conflict") {
+		if errors.Is(saveErr, store.ErrConflict) {
+			res = h.ownerShortURL(r, cfg, res)
+			if ownedByOther(saveErr, userID) {
+				w.Header().Set(headerOwnedByOther, "true")
+			}
 			w.Header().Set(contentType, contentTypeText)
 			w.WriteHeader(http.StatusConflict)
 			_, _ = w.Write([]byte(res))
 			return
 		}
-		http.Error(w, internalServerError, http.StatusInternalServerError)
+		storeError(w, r, saveErr)
 		return
 	}
+	h.fetchTitleLater(r, cfg, userID, store.ShortIDFromURL(res, cfg.BaseURL), parsed, meta)
 	w.Header().Set(contentType, contentTypeText)
 	w.WriteHeader(http.StatusCreated)
 	_, _ = w.Write([]byte(res))
 }
 
 // ShortenURLJSON handles the JSON-based URL shortening endpoint.
-func ShortenURLJSON(w http.ResponseWriter, r *http.Request, s store.Store, cfg *config.Config) {
+func (h *Handlers) ShortenURLJSON(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		middleware.Problem(w, r, "Only POST method is allowed", http.StatusMethodNotAllowed)
 		return
 	}
 	defer func() { _ = r.Body.Close() }()
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, internalServerError, http.StatusInternalServerError)
+		middleware.Problem(w, r, internalServerError, http.StatusInternalServerError)
 		return
 	}
 	var req struct {
-		URL string `json:"url"`
+		URL      string   `json:"url"`
+		Password string   `json:"password"`
+		Tags     []string `json:"tags"`
+		Title    string   `json:"title"`
+		Note     string   `json:"note"`
+		// Variants делят трафик ссылки между несколькими адресами, url остаётся основным.
+		Variants []store.Variant      `json:"variants"`
+		Sticky   bool                 `json:"sticky"`
+		UTM      string               `json:"utm"`
+		Devices  *store.DeviceTargets `json:"devices"`
+		Geo      map[string]string    `json:"geo"`
+		// Domain — хост короткой ссылки: основной или один из TenantBaseURLs; пусто — по Host.
+		Domain string `json:"domain"`
 	}
 	if errJSON := json.Unmarshal(body, &req); errJSON != nil {
-		http.Error(w, "Failed to parse JSON", http.StatusBadRequest)
+		middleware.Problem(w, r, "Failed to parse JSON", http.StatusBadRequest)
 		return
 	}
+	r, ok := h.selectDomain(w, r, req.Domain)
+	if !ok {
+		return
+	}
+	cfg := tenantConfig(r, h.cfg)
 	if req.URL == "" {
-		http.Error(w, "Empty url field", http.StatusBadRequest)
+		middleware.Problem(w, r, "Empty url field", http.StatusBadRequest)
 		return
 	}
 	parsed, pErr := url.ParseRequestURI(req.URL)
 	if pErr != nil || parsed.Scheme == "" || parsed.Host == "" {
-		http.Error(w, "Invalid URL", http.StatusBadRequest)
+		middleware.Problem(w, r, "Invalid URL", http.StatusBadRequest)
+		return
+	}
+	meta := store.LinkMeta{Title: strings.TrimSpace(req.Title), Note: strings.TrimSpace(req.Note)}
+	if utf8.RuneCountInString(meta.Title) > maxTitleLen || utf8.RuneCountInString(meta.Note) > maxNoteLen {
+		middleware.Problem(w, r, "Title or note is too long", http.StatusBadRequest)
+		return
+	}
+	if meta.Tags, err = normalizeTags(req.Tags); err != nil {
+		middleware.Problem(w, r, "Invalid tags: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Password != "" {
+		if meta.PasswordHash, err = hashLinkPassword(req.Password); err != nil {
+			middleware.Problem(w, r, internalServerError, http.StatusInternalServerError)
+			return
+		}
+	}
+	parsed, ok = h.applyPolicy(w, r, cfg, parsed)
+	if !ok {
+		return
+	}
+	if meta.Variants, ok = h.parseVariants(w, r, cfg, req.Variants); !ok {
+		return
+	}
+	meta.StickyVariants = req.Sticky && len(meta.Variants) > 0
+	if meta.Devices, ok = h.parseDevices(w, r, cfg, req.Devices); !ok {
+		return
+	}
+	if meta.Geo, ok = h.parseGeo(w, r, cfg, req.Geo); !ok {
+		return
+	}
+	if meta.UTM = strings.TrimSpace(req.UTM); !validUTM(meta.UTM) {
+		middleware.Problem(w, r, "Invalid UTM template", http.StatusBadRequest)
 		return
 	}
+	meta.Domain = tenantDomain(r)
 	userID, _ := middleware.GetUserID(r)
-	shortU, saveErr := s.Save(r.Context(), userID, parsed, cfg)
+	shortU, saveErr := h.store.Save(r.Context(), userID, parsed, meta, cfg)
 	if saveErr != nil {
-		if strings.Contains(saveErr.Error(), "conflict") {
-			w.Header().Set(contentType, contentTypeJSON)
-			w.WriteHeader(http.StatusConflict)
-			_ = json.NewEncoder(w).Encode(map[string]string{"result": shortU})
+		if errors.Is(saveErr, store.ErrConflict) {
+			shortU = h.ownerShortURL(r, cfg, shortU)
+			writeData(w, r, http.StatusConflict, struct {
+				Result       string `json:"result"`
+				OwnedByOther bool   `json:"owned_by_other,omitempty"`
+			}{shortU, ownedByOther(saveErr, userID)})
 			return
 		}
-		http.Error(w, internalServerError, http.StatusInternalServerError)
+		storeError(w, r, saveErr)
 		return
 	}
-	w.Header().Set(contentType, contentTypeJSON)
-	w.WriteHeader(http.StatusCreated)
-	_ = json.NewEncoder(w).Encode(map[string]string{"result": shortU})
+	h.fetchTitleLater(r, cfg, userID, store.ShortIDFromURL(shortU, cfg.BaseURL), parsed, meta)
+	writeData(w, r, http.StatusCreated, map[string]string{"result": shortU})
 }
 
 // Ping checks database connectivity.
-func Ping(w http.ResponseWriter, r *http.Request, s store.Store) {
-	if err := s.Ping(r.Context()); err != nil {
-		http.Error(w, "DB connection failed", http.StatusInternalServerError)
+func (h *Handlers) Ping(w http.ResponseWriter, r *http.Request) {
+	if err := h.store.Ping(r.Context()); err != nil {
+		if isUnavailable(w, r, err) {
+			return
+		}
+		middleware.Problem(w, r, "DB connection failed", http.StatusInternalServerError)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
 
-// GetVersion prints the server version.
-func GetVersion(w http.ResponseWriter, r *http.Request, version string) {
+// GetVersion prints the server version as plain text; kept at /version/ for old clients.
+func (h *Handlers) GetVersion(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Only use GET!", http.StatusMethodNotAllowed)
+		middleware.Problem(w, r, "Only use GET!", http.StatusMethodNotAllowed)
 		return
 	}
 	w.Header().Set(contentType, "text/plain")
 	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte(version))
+	_, _ = w.Write([]byte(h.build.Version))
+}
+
+// GetBuildInfo returns version, commit, build time and Go version as JSON.
+func (h *Handlers) GetBuildInfo(w http.ResponseWriter, r *http.Request) {
+	writeData(w, r, http.StatusOK, h.build)
+}
+
+// GetAuditLog returns audit events filtered by user_id, short_id, action, since (RFC 3339) and limit.
+func (h *Handlers) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	if h.audit == nil {
+		middleware.Problem(w, r, "Audit log is not configured", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	f := audit.Filter{
+		UserID:  q.Get("user_id"),
+		ShortID: q.Get("short_id"),
+		Action:  audit.Action(q.Get("action")),
+		Limit:   100,
+	}
+	if rawSince := q.Get("since"); rawSince != "" {
+		since, err := time.Parse(time.RFC3339, rawSince)
+		if err != nil {
+			middleware.Problem(w, r, "Invalid since", http.StatusBadRequest)
+			return
+		}
+		f.Since = since
+	}
+	if rawLimit := q.Get("limit"); rawLimit != "" {
+		limit, err := strconv.Atoi(rawLimit)
+		if err != nil || limit <= 0 {
+			middleware.Problem(w, r, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		f.Limit = limit
+	}
+	events, err := h.audit.Query(r.Context(), f)
+	if err != nil {
+		middleware.Problem(w, r, internalServerError, http.StatusInternalServerError)
+		return
+	}
+	if events == nil {
+		events = []audit.Event{}
+	}
+	writeData(w, r, http.StatusOK, events)
+}
+
+// storeError answers 503 with Retry-After while storage is unavailable, 500 otherwise.
+func storeError(w http.ResponseWriter, r *http.Request, err error) {
+	if isUnavailable(w, r, err) {
+		return
+	}
+	middleware.Problem(w, r, internalServerError, http.StatusInternalServerError)
+}
+
+// fetchTitleLater при cfg.FetchTitles запускает фоновую подгрузку заголовка страницы
+// для только что созданной ссылки, если заголовок не задан явно.
+func (h *Handlers) fetchTitleLater(r *http.Request, cfg *config.Config, userID, shortID string, u *url.URL, meta store.LinkMeta) {
+	if cfg.FetchTitles && meta.Title == "" {
+		h.goBackground(r, func(ctx context.Context) {
+			h.fetchTitle(ctx, cfg, userID, shortID, u)
+		})
+	}
+}
+
+// writeData отвечает v в формате, который клиент просит в Accept (см. negotiate.Default);
+// без Accept и для незнакомых форматов — в JSON.
+func writeData(w http.ResponseWriter, r *http.Request, status int, v any) {
+	format := negotiate.Default.Negotiate(r.Header.Get("Accept"))
+	w.Header().Set(contentType, format.ContentType)
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(status)
+	_ = format.Encoder.Encode(w, v)
+}
+
+// verifiedUser — userID запроса для необратимых и чувствительных операций: токены, удаление
+// и выгрузка данных, передача ссылок. Годится только подтверждённый userID (подписанная кука
+// или API-токен), иначе любой, кто знает чужой userID, действовал бы от его имени.
+// На неподтверждённый отвечает 401 и возвращает false.
+func verifiedUser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok || userID == "" || !middleware.Verified(r) {
+		reject(w, r, http.StatusUnauthorized, "unauthorized", nil)
+		return "", false
+	}
+	return userID, true
+}
+
+// isUnavailable writes 503 and reports true if err is a store.UnavailableError.
+func isUnavailable(w http.ResponseWriter, r *http.Request, err error) bool {
+	var unavailable *store.UnavailableError
+	if !errors.As(err, &unavailable) {
+		return false
+	}
+	retryAfter := int(unavailable.RetryAfter.Round(time.Second).Seconds())
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	middleware.Problem(w, r, "Service temporarily unavailable", http.StatusServiceUnavailable)
+	return true
+}
+
+// ownedByOther reports whether a conflict points at a link created by another user.
+func ownedByOther(err error, userID string) bool {
+	var conflict *store.ConflictError
+	return errors.As(err, &conflict) && conflict.OwnerID != userID
 }
@@ -0,0 +1,79 @@
+// Internal/app/endpoints/events.go.
+package endpoints
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/dkolesni-prog/transformer/internal/app/middleware"
+)
+
+// sseKeepAlive — период комментариев-пингов, чтобы прокси не рвали простаивающий поток.
+const sseKeepAlive = 15 * time.Second
+
+// ClickEvents streams clicks on the caller's link as Server-Sent Events: GET /api/user/urls/{id}/events.
+// Поток содержит переходы, обслуженные этим экземпляром сервиса.
+func (h *Handlers) ClickEvents(w http.ResponseWriter, r *http.Request) {
+	cfg := tenantConfig(r, h.cfg)
+	userID, ok := middleware.GetUserID(r)
+	if !ok || userID == "" {
+		reject(w, r, http.StatusUnauthorized, "unauthorized", nil)
+		return
+	}
+	id := chi.URLParam(r, "id")
+	owned, err := h.ownsLink(r, cfg, userID, id)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	if !owned {
+		middleware.Problem(w, r, "Short URL not found", http.StatusNotFound)
+		return
+	}
+
+	events, unsubscribe := h.tracker.Subscribe(id)
+	defer unsubscribe()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set(contentType, "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		h.logger.Error("Streaming is not supported by the response writer", "error", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case c := <-events:
+			// IP посетителя владельцу ссылки не отдаём.
+			data, err := json.Marshal(struct {
+				Time      time.Time `json:"time"`
+				Referrer  string    `json:"referrer,omitempty"`
+				UserAgent string    `json:"user_agent,omitempty"`
+			}{c.Time, c.Referrer, c.UserAgent})
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: click\ndata: %s\n\n", data); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
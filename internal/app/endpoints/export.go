@@ -0,0 +1,129 @@
+// Internal/app/endpoints/export.go.
+package endpoints
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/dkolesni-prog/transformer/internal/app/middleware"
+	"github.com/dkolesni-prog/transformer/internal/clicks"
+	"github.com/dkolesni-prog/transformer/internal/store"
+)
+
+// UserExport — архив данных пользователя, GET /api/user/export.
+type UserExport struct {
+	UserID     string       `json:"user_id"`
+	ExportedAt time.Time    `json:"exported_at"`
+	Links      []ExportLink `json:"links"`
+}
+
+// ExportLink — ссылка со всеми её настройками и сводкой переходов за всё время.
+// Хеш пароля не выгружается, только признак его наличия.
+type ExportLink struct {
+	ShortID           string               `json:"short_id"`
+	ShortURL          string               `json:"short_url"`
+	OriginalURL       string               `json:"original_url"`
+	CreatedAt         time.Time            `json:"created_at,omitempty"`
+	Deleted           bool                 `json:"deleted"`
+	Title             string               `json:"title,omitempty"`
+	Note              string               `json:"note,omitempty"`
+	Tags              []string             `json:"tags,omitempty"`
+	PasswordProtected bool                 `json:"password_protected,omitempty"`
+	Variants          []store.Variant      `json:"variants,omitempty"`
+	UTM               string               `json:"utm,omitempty"`
+	Devices           *store.DeviceTargets `json:"devices,omitempty"`
+	Geo               map[string]string    `json:"geo,omitempty"`
+	Clicks            clicks.LinkStats     `json:"clicks"`
+}
+
+// ExportUserData returns everything stored about the caller as a JSON download,
+// or as a ZIP archive with export.json for ?format=zip. Needs a signed cookie or an API token.
+func (h *Handlers) ExportUserData(w http.ResponseWriter, r *http.Request) {
+	userID, ok := verifiedUser(w, r)
+	if !ok {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "zip" {
+		middleware.Problem(w, r, "Unknown format", http.StatusBadRequest)
+		return
+	}
+
+	export := UserExport{UserID: userID, ExportedAt: time.Now().UTC(), Links: []ExportLink{}}
+	err := h.store.ExportRecords(r.Context(), func(rec store.Record) error {
+		if rec.UserID != userID {
+			return nil
+		}
+		export.Links = append(export.Links, h.exportLink(rec))
+		return nil
+	})
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	for i := range export.Links {
+		stats, statsErr := h.tracker.Log().Stats(r.Context(), export.Links[i].ShortID, time.Time{})
+		if statsErr != nil {
+			storeError(w, r, statsErr)
+			return
+		}
+		export.Links[i].Clicks = stats
+	}
+	sort.Slice(export.Links, func(i, j int) bool {
+		return export.Links[i].CreatedAt.Before(export.Links[j].CreatedAt)
+	})
+
+	name := "export-" + export.ExportedAt.Format("20060102")
+	w.Header().Set("Cache-Control", "no-store")
+	if format != "zip" {
+		w.Header().Set(contentType, contentTypeJSON)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".json"))
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(export)
+		return
+	}
+
+	w.Header().Set(contentType, "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".zip"))
+	w.WriteHeader(http.StatusOK)
+	zw := zip.NewWriter(w)
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: "export.json", Method: zip.Deflate, Modified: export.ExportedAt})
+	if err == nil {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(export)
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		h.logger.Error("Could not write export archive", "error", err)
+	}
+}
+
+// exportLink собирает ссылку для выгрузки; короткий адрес строится от домена, под которым её создали.
+func (h *Handlers) exportLink(rec store.Record) ExportLink {
+	baseURL := h.cfg.BaseURL
+	link := ExportLink{
+		ShortID:     rec.ShortURL,
+		OriginalURL: rec.OriginalURL,
+		CreatedAt:   rec.CreatedAt,
+		Deleted:     rec.IsDeleted,
+	}
+	if meta := rec.Meta; meta != nil {
+		if t, ok := h.tenantsFor(h.cfg)[meta.Domain]; ok {
+			baseURL = t.cfg.BaseURL
+		}
+		link.Title, link.Note, link.Tags = meta.Title, meta.Note, meta.Tags
+		link.PasswordProtected = meta.PasswordHash != ""
+		link.Variants, link.UTM, link.Devices, link.Geo = meta.Variants, meta.UTM, meta.Devices, meta.Geo
+	}
+	link.ShortURL = baseURL + rec.ShortURL
+	return link
+}
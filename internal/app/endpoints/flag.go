@@ -0,0 +1,94 @@
+// Internal/app/endpoints/flag.go.
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/dkolesni-prog/transformer/internal/app/middleware"
+	"github.com/dkolesni-prog/transformer/internal/store"
+)
+
+// confirmParam — параметр, которым посетитель подтверждает переход по помеченной ссылке.
+const confirmParam = "confirm"
+
+const maxFlagReasonLen = 200
+
+// confirmFlagged пропускает непомеченные ссылки и подтверждённые переходы.
+// Для остальных показывает предупреждение с адресом назначения и отвечает false.
+func (h *Handlers) confirmFlagged(w http.ResponseWriter, r *http.Request, meta store.LinkMeta, dest string) bool {
+	if meta.Flagged == "" || r.URL.Query().Get(confirmParam) == "1" {
+		return true
+	}
+	next := *r.URL
+	q := next.Query()
+	q.Set(confirmParam, "1")
+	next.RawQuery = q.Encode()
+	h.renderUI(w, r, "warning", map[string]any{
+		"Title":       "Warning: suspicious link",
+		"Public":      true,
+		"Reason":      meta.Flagged,
+		"Destination": dest,
+		"Continue":    h.cfg.RoutePrefix + next.RequestURI(),
+	})
+	return false
+}
+
+// AdminFlagURL marks a link as suspicious: PUT /api/admin/urls/{id}/flag {"reason": "phishing"}.
+// Помечать ссылки может и сканер, через этот же эндпоинт.
+func (h *Handlers) AdminFlagURL(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			middleware.Problem(w, r, "Failed to parse JSON", http.StatusBadRequest)
+			return
+		}
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		req.Reason = "suspicious"
+	}
+	if len(req.Reason) > maxFlagReasonLen {
+		middleware.Problem(w, r, "Reason is too long", http.StatusBadRequest)
+		return
+	}
+	h.setFlag(w, r, req.Reason)
+}
+
+// AdminUnflagURL removes the mark: DELETE /api/admin/urls/{id}/flag.
+func (h *Handlers) AdminUnflagURL(w http.ResponseWriter, r *http.Request) {
+	h.setFlag(w, r, "")
+}
+
+func (h *Handlers) setFlag(w http.ResponseWriter, r *http.Request, reason string) {
+	rec, err := store.FindRecord(r.Context(), h.store, chi.URLParam(r, "id"))
+	if errors.Is(err, store.ErrNotFound) {
+		middleware.Problem(w, r, "Short URL not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	meta, err := h.store.LoadMeta(r.Context(), rec.ShortURL)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		storeError(w, r, err)
+		return
+	}
+	wasFlagged := meta.Flagged != ""
+	meta.Flagged = reason
+	if err := h.store.SetMeta(r.Context(), rec.UserID, rec.ShortURL, meta); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	if reason != "" && !wasFlagged {
+		h.notifier.Notify("Link %s was flagged as %s, destination %s", rec.ShortURL, reason, rec.OriginalURL)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,209 @@
+// Internal/app/endpoints/jobs.go.
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/dkolesni-prog/transformer/internal/app/middleware"
+	"github.com/dkolesni-prog/transformer/internal/jobs"
+	"github.com/dkolesni-prog/transformer/internal/store"
+)
+
+// Виды заданий, которые выполняют Handlers.
+const (
+	jobDeleteURLs = "delete_urls"
+	jobPurge      = "purge"
+)
+
+// deletePayload — задание jobDeleteURLs. IP нужен аудиту: задание выполняется без запроса.
+type deletePayload struct {
+	IDs []string `json:"ids"`
+	// Requested — сколько ID прислал клиент; дальше в IDs свёрнутые варианты (shortid.Fold).
+	Requested int    `json:"requested"`
+	IP        string `json:"ip,omitempty"`
+}
+
+// deleteCounts — результат jobDeleteURLs.
+type deleteCounts struct {
+	Deleted   int `json:"deleted"`
+	NotFound  int `json:"not_found"`
+	Forbidden int `json:"forbidden"`
+}
+
+// deleteJobView — ответ GET /api/user/jobs/{id}.
+type deleteJobView struct {
+	ID        string `json:"id"`
+	Status    string `json:"status"`
+	Requested int    `json:"requested"`
+	deleteCounts
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// registerJobs подключает обработчики заданий к очереди.
+func (h *Handlers) registerJobs(purger store.Purger) {
+	h.jobs.Handle(jobDeleteURLs, 0, h.runDeleteJob)
+	if purger != nil {
+		h.jobs.Handle(jobPurge, 1, func(ctx context.Context, _ jobs.Job) (any, error) {
+			purged, err := purger.PurgeDeleted(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("purge: %w", err)
+			}
+			h.logger.Info("Purge finished", "purged", purged)
+			return map[string]int{"purged": purged}, nil
+		})
+	}
+	h.purger = purger
+}
+
+// runDeleteJob помечает ссылки удалёнными. Повтор после сбоя безопасен: уже удалённые
+// ссылки владельца снова дают store.Deleted.
+func (h *Handlers) runDeleteJob(ctx context.Context, job jobs.Job) (any, error) {
+	var p deletePayload
+	if err := json.Unmarshal(job.Payload, &p); err != nil {
+		return nil, fmt.Errorf("decode delete job: %w", err)
+	}
+	ctx = middleware.WithClientIP(ctx, p.IP)
+	results, err := h.store.DeleteBatch(ctx, job.UserID, p.IDs)
+	if err != nil {
+		return nil, err
+	}
+	var counts deleteCounts
+	for _, res := range foldDeleteResults(results, p.Requested) {
+		switch res.Status {
+		case store.Deleted:
+			counts.Deleted++
+		case store.DeleteNotFound:
+			counts.NotFound++
+		case store.DeleteForbidden:
+			counts.Forbidden++
+		}
+	}
+	return counts, nil
+}
+
+// GetUserJob reports the state of a deletion started by DELETE /api/user/urls: GET /api/user/jobs/{id}.
+// Finished jobs are kept for jobs.Retention.
+func (h *Handlers) GetUserJob(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok || userID == "" {
+		middleware.Problem(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	job, err := h.jobs.Get(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, jobs.ErrNotFound) || (err == nil && (job.UserID != userID || job.Kind != jobDeleteURLs)) {
+		middleware.Problem(w, r, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+
+	var p deletePayload
+	_ = json.Unmarshal(job.Payload, &p)
+	view := deleteJobView{ID: job.ID, Status: string(jobs.StatusPending), Requested: p.Requested, CreatedAt: job.CreatedAt}
+	switch job.Status {
+	case jobs.StatusCompleted:
+		view.Status = string(job.Status)
+		_ = json.Unmarshal(job.Result, &view.deleteCounts)
+	case jobs.StatusFailed:
+		// Текст ошибки хранилища наружу не отдаётся.
+		view.Status = string(job.Status)
+		view.Error = "could not delete links, retry the request"
+	}
+	if job.Finished() {
+		view.FinishedAt = &job.UpdatedAt
+	}
+	writeData(w, r, http.StatusOK, view)
+}
+
+// AdminPurge queues a hard delete of soft-deleted links: POST /api/admin/jobs/purge.
+func (h *Handlers) AdminPurge(w http.ResponseWriter, r *http.Request) {
+	if h.purger == nil {
+		middleware.Problem(w, r, "storage does not support purge", http.StatusNotImplemented)
+		return
+	}
+	jobID, err := h.jobs.Enqueue(r.Context(), jobPurge, "", nil)
+	if err != nil {
+		h.logger.Error("Could not queue purge", "error", err)
+		storeError(w, r, err)
+		return
+	}
+	writeJobAccepted(w, r, h.cfg.RoutePrefix+"/api/admin/jobs/"+jobID, jobID)
+}
+
+// AdminGetJob shows any job with its payload and result: GET /api/admin/jobs/{id}.
+func (h *Handlers) AdminGetJob(w http.ResponseWriter, r *http.Request) {
+	job, err := h.jobs.Get(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, jobs.ErrNotFound) {
+		middleware.Problem(w, r, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	writeData(w, r, http.StatusOK, job)
+}
+
+// failedJobsLimit — сколько проваленных заданий отдаёт AdminFailedJobs по умолчанию.
+const failedJobsLimit = 100
+
+// AdminFailedJobs lists dead-lettered jobs of one kind, most recent first:
+// GET /api/admin/jobs/failed?kind=webhook[&limit=N]. Failed jobs are kept for jobs.Retention.
+func (h *Handlers) AdminFailedJobs(w http.ResponseWriter, r *http.Request) {
+	kind := r.URL.Query().Get("kind")
+	if kind == "" {
+		middleware.Problem(w, r, "kind is required", http.StatusBadRequest)
+		return
+	}
+	limit := failedJobsLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			middleware.Problem(w, r, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, 1000)
+	}
+	list, err := h.jobs.Failed(r.Context(), kind, limit)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	if list == nil {
+		list = []jobs.Job{}
+	}
+	writeData(w, r, http.StatusOK, list)
+}
+
+// AdminRetryJob puts a failed job back into the queue with a fresh set of attempts:
+// POST /api/admin/jobs/{id}/retry.
+func (h *Handlers) AdminRetryJob(w http.ResponseWriter, r *http.Request) {
+	job, err := h.jobs.Requeue(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, jobs.ErrNotFound) {
+		middleware.Problem(w, r, "Failed job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.logger.Error("Could not requeue job", "error", err)
+		storeError(w, r, err)
+		return
+	}
+	writeJobAccepted(w, r, h.cfg.RoutePrefix+"/api/admin/jobs/"+job.ID, job.ID)
+}
+
+func writeJobAccepted(w http.ResponseWriter, r *http.Request, location, jobID string) {
+	w.Header().Set("Location", location)
+	writeData(w, r, http.StatusAccepted, map[string]string{"job_id": jobID})
+}
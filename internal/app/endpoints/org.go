@@ -0,0 +1,136 @@
+// Internal/app/endpoints/org.go.
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/dkolesni-prog/transformer/internal/app/middleware"
+	"github.com/dkolesni-prog/transformer/internal/org"
+)
+
+// orgRoutes — эндпоинты /api/org/{org}. Ссылки организации живут в store под org.OwnerID,
+// поэтому для /urls переиспользуются обычные пользовательские обработчики.
+func (h *Handlers) orgRoutes(r chi.Router) {
+	r.Post("/", h.CreateOrg)
+	r.With(h.orgAccess(org.RoleViewer)).Get("/members", h.GetOrgMembers)
+	r.With(h.orgAccess(org.RoleAdmin)).Put("/members/{userID}", h.SetOrgMember)
+	r.With(h.orgAccess(org.RoleAdmin)).Delete("/members/{userID}", h.RemoveOrgMember)
+
+	r.Group(func(r chi.Router) {
+		r.Use(h.orgAccess(org.RoleViewer), actAsOrg)
+		r.Get("/urls", h.GetUserURLs)
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(h.orgAccess(org.RoleEditor), actAsOrg)
+		r.Post("/urls", h.ShortenURLJSON)
+		r.Put("/urls/{id}", h.UpdateUserURL)
+		r.Delete("/urls", h.DeleteUserURLs)
+	})
+}
+
+// orgAccess пропускает только участников организации с ролью не ниже need.
+func (h *Handlers) orgAccess(need org.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, ok := middleware.GetUserID(r)
+			if !ok || userID == "" {
+				reject(w, r, http.StatusUnauthorized, "unauthorized", nil)
+				return
+			}
+			role, err := h.orgs.Role(r.Context(), chi.URLParam(r, "org"), userID)
+			switch {
+			case errors.Is(err, org.ErrNotFound):
+				middleware.Problem(w, r, "Organization not found", http.StatusNotFound)
+				return
+			case errors.Is(err, org.ErrNotMember):
+				reject(w, r, http.StatusForbidden, "not_a_member", nil)
+				return
+			case err != nil:
+				storeError(w, r, err)
+				return
+			}
+			if !role.Allows(need) {
+				reject(w, r, http.StatusForbidden, "insufficient_role", nil)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// actAsOrg подменяет владельца запроса на организацию из пути.
+func actAsOrg(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := middleware.WithUserID(r.Context(), org.OwnerID(chi.URLParam(r, "org")))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// CreateOrg заводит организацию: POST /api/org/{org}. Создатель становится администратором.
+func (h *Handlers) CreateOrg(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok || userID == "" {
+		reject(w, r, http.StatusUnauthorized, "unauthorized", nil)
+		return
+	}
+	name := chi.URLParam(r, "org")
+	err := h.orgs.Create(r.Context(), name, userID)
+	switch {
+	case errors.Is(err, org.ErrInvalidName):
+		middleware.Problem(w, r, "Invalid organization name", http.StatusBadRequest)
+		return
+	case errors.Is(err, org.ErrExists):
+		middleware.Problem(w, r, "Organization already exists", http.StatusConflict)
+		return
+	case err != nil:
+		storeError(w, r, err)
+		return
+	}
+	writeData(w, r, http.StatusCreated, org.Member{UserID: userID, Role: org.RoleAdmin})
+}
+
+// GetOrgMembers lists organization members: GET /api/org/{org}/members.
+func (h *Handlers) GetOrgMembers(w http.ResponseWriter, r *http.Request) {
+	members, err := h.orgs.Members(r.Context(), chi.URLParam(r, "org"))
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	writeData(w, r, http.StatusOK, members)
+}
+
+// SetOrgMember adds a member or changes their role: PUT /api/org/{org}/members/{userID} {"role": "editor"}.
+func (h *Handlers) SetOrgMember(w http.ResponseWriter, r *http.Request) {
+	defer func() { _ = r.Body.Close() }()
+	var req struct {
+		Role org.Role `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !org.ValidRole(req.Role) {
+		middleware.Problem(w, r, "Invalid role", http.StatusBadRequest)
+		return
+	}
+	member := org.Member{UserID: chi.URLParam(r, "userID"), Role: req.Role}
+	if err := h.orgs.SetMember(r.Context(), chi.URLParam(r, "org"), member.UserID, member.Role); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	writeData(w, r, http.StatusOK, member)
+}
+
+// RemoveOrgMember: DELETE /api/org/{org}/members/{userID}.
+func (h *Handlers) RemoveOrgMember(w http.ResponseWriter, r *http.Request) {
+	err := h.orgs.RemoveMember(r.Context(), chi.URLParam(r, "org"), chi.URLParam(r, "userID"))
+	if errors.Is(err, org.ErrNotMember) {
+		middleware.Problem(w, r, "Member not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,68 @@
+// Internal/app/endpoints/password.go.
+package endpoints
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/dkolesni-prog/transformer/internal/app/middleware"
+	"github.com/dkolesni-prog/transformer/internal/store"
+)
+
+const linkPasswordHeader = "X-Link-Password"
+
+var passwordForm = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Protected link</title></head>
+<body>
+<form method="post">
+<p>This link is password protected.</p>
+{{if .Wrong}}<p style="color:#b00">Wrong password.</p>{{end}}
+<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<input type="password" name="pw" autofocus>
+<button type="submit">Open</button>
+</form>
+</body>
+</html>
+`))
+
+// hashLinkPassword готовит bcrypt-хеш для LinkMeta.PasswordHash.
+func hashLinkPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("hash link password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// checkLinkPassword пропускает запрос к открытой ссылке или с верным паролем
+// (?pw=, заголовок X-Link-Password или поле pw формы). Иначе отвечает 401 с формой.
+func checkLinkPassword(w http.ResponseWriter, r *http.Request, meta store.LinkMeta) bool {
+	if meta.PasswordHash == "" {
+		return true
+	}
+	password := r.URL.Query().Get("pw")
+	if h := r.Header.Get(linkPasswordHeader); h != "" {
+		password = h
+	}
+	if r.Method == http.MethodPost {
+		if pw := r.PostFormValue("pw"); pw != "" {
+			password = pw
+		}
+	}
+	if password != "" && bcrypt.CompareHashAndPassword([]byte(meta.PasswordHash), []byte(password)) == nil {
+		return true
+	}
+
+	w.Header().Set(contentType, "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusUnauthorized)
+	_ = passwordForm.Execute(w, map[string]any{
+		"Wrong":     password != "",
+		"CSRFToken": middleware.CSRFToken(r.Context()),
+	})
+	return false
+}
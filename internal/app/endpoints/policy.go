@@ -0,0 +1,75 @@
+// Internal/app/endpoints/policy.go.
+package endpoints
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/dkolesni-prog/transformer/internal/app/middleware"
+	"github.com/dkolesni-prog/transformer/internal/config"
+	"github.com/dkolesni-prog/transformer/internal/urlpolicy"
+)
+
+// policies — по одной urlpolicy.Policy на конфиг, чтобы не собирать её на каждый запрос.
+var policies sync.Map
+
+func policyFor(cfg *config.Config) (*urlpolicy.Policy, error) {
+	if p, ok := policies.Load(cfg); ok {
+		return p.(*urlpolicy.Policy), nil
+	}
+	p, err := urlpolicy.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	stored, _ := policies.LoadOrStore(cfg, p)
+	return stored.(*urlpolicy.Policy), nil
+}
+
+// applyPolicy нормализует и проверяет ссылку перед сохранением.
+// Если ссылка отклонена, ответ уже записан и ok == false.
+func (h *Handlers) applyPolicy(w http.ResponseWriter, r *http.Request, cfg *config.Config, u *url.URL) (*url.URL, bool) {
+	policy, err := policyFor(cfg)
+	if err != nil {
+		h.logger.Error("Could not load URL policy", "error", err)
+		middleware.Problem(w, r, internalServerError, http.StatusInternalServerError)
+		return nil, false
+	}
+	applied, err := policy.Apply(r.Context(), u)
+	switch {
+	case errors.Is(err, urlpolicy.ErrTooLong):
+		reject(w, r, http.StatusRequestEntityTooLarge, "url_too_long", nil)
+		return nil, false
+	case errors.Is(err, urlpolicy.ErrBlocked):
+		forbidden(w, r, "destination_blocked", u)
+		return nil, false
+	case errors.Is(err, urlpolicy.ErrNotAllowed):
+		forbidden(w, r, "destination_not_allowed", u)
+		return nil, false
+	case errors.Is(err, urlpolicy.ErrPrivateAddress):
+		forbidden(w, r, "destination_private", u)
+		return nil, false
+	case errors.Is(err, urlpolicy.ErrUnreachable):
+		h.logger.Info("Destination check failed", "error", err, "url", u.String())
+		reject(w, r, http.StatusUnprocessableEntity, "destination_unreachable", u)
+		return nil, false
+	case err != nil:
+		middleware.Problem(w, r, "Invalid URL", http.StatusBadRequest)
+		return nil, false
+	}
+	return applied, true
+}
+
+func forbidden(w http.ResponseWriter, r *http.Request, code string, u *url.URL) {
+	reject(w, r, http.StatusForbidden, code, u)
+}
+
+// reject отвечает ошибкой с машиночитаемой причиной code и, если есть, отклонённым адресом.
+func reject(w http.ResponseWriter, r *http.Request, status int, code string, u *url.URL) {
+	p := middleware.ProblemDetails{Status: status, Code: code}
+	if u != nil {
+		p.Extensions = map[string]any{"url": u.String()}
+	}
+	middleware.WriteProblem(w, r, p)
+}
@@ -0,0 +1,273 @@
+// Internal/app/endpoints/redirect.go.
+package endpoints
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dkolesni-prog/transformer/internal/app/middleware"
+	"github.com/dkolesni-prog/transformer/internal/clicks"
+	"github.com/dkolesni-prog/transformer/internal/config"
+	"github.com/dkolesni-prog/transformer/internal/store"
+)
+
+const (
+	maxVariants      = 10
+	maxVariantWeight = 1000
+	// variantCookieTTL — сколько помнится вариант, показанный посетителю липкого сплит-теста.
+	variantCookieTTL = 30 * 24 * time.Hour
+	maxUTMLen        = 512
+	maxGeoRules      = 50
+)
+
+var (
+	geoKeyRe = regexp.MustCompile(`^(EU|[A-Z]{2}(-[A-Z0-9]{1,3})?)$`)
+
+	euCountries = map[string]struct{}{
+		"AT": {}, "BE": {}, "BG": {}, "CY": {}, "CZ": {}, "DE": {}, "DK": {}, "EE": {}, "ES": {},
+		"FI": {}, "FR": {}, "GR": {}, "HR": {}, "HU": {}, "IE": {}, "IT": {}, "LT": {}, "LU": {},
+		"LV": {}, "MT": {}, "NL": {}, "PL": {}, "PT": {}, "RO": {}, "SE": {}, "SI": {}, "SK": {},
+	}
+)
+
+// destination выбирает адрес перехода: по географии посетителя, по его платформе, затем
+// вариант сплит-теста, иначе основной адрес ссылки. В click — заготовка перехода для статистики.
+func (h *Handlers) destination(w http.ResponseWriter, r *http.Request, id, longURL string,
+	meta store.LinkMeta) (dest string, click clicks.Click) {
+	click.ShortID = id
+	if len(meta.Geo) > 0 {
+		// Гео-правила требуют поиска по базе прямо в запросе; трекер его уже не повторит.
+		click.Country, click.Region = h.tracker.Locate(middleware.GetClientIP(r.Context()))
+		if target := pickGeo(meta.Geo, click.Country, click.Region); target != "" {
+			return target, click
+		}
+	}
+	if meta.Devices != nil {
+		w.Header().Add("Vary", "User-Agent")
+	}
+	if target := pickDevice(r, meta.Devices); target != "" {
+		return target, click
+	}
+	if v, ok := h.pickVariant(w, r, id, meta); ok {
+		click.Variant = v.URL
+		return v.URL, click
+	}
+	return longURL, click
+}
+
+// checkDestination разбирает дополнительный адрес ссылки и прогоняет его через политику URL.
+// При ошибке ответ уже записан.
+func (h *Handlers) checkDestination(w http.ResponseWriter, r *http.Request, cfg *config.Config, raw string) (string, bool) {
+	parsed, err := url.ParseRequestURI(raw)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		middleware.Problem(w, r, "Invalid destination URL", http.StatusBadRequest)
+		return "", false
+	}
+	parsed, ok := h.applyPolicy(w, r, cfg, parsed)
+	if !ok {
+		return "", false
+	}
+	return parsed.String(), true
+}
+
+// parseDevices проверяет адреса для платформ; пустые поля остаются пустыми.
+func (h *Handlers) parseDevices(w http.ResponseWriter, r *http.Request, cfg *config.Config, in *store.DeviceTargets) (*store.DeviceTargets, bool) {
+	if in == nil || *in == (store.DeviceTargets{}) {
+		return nil, true
+	}
+	out := *in
+	for _, field := range []*string{&out.Mobile, &out.Desktop, &out.IOS, &out.Android} {
+		if *field == "" {
+			continue
+		}
+		checked, ok := h.checkDestination(w, r, cfg, *field)
+		if !ok {
+			return nil, false
+		}
+		*field = checked
+	}
+	return &out, true
+}
+
+// parseGeo проверяет гео-правила: ключ — ISO-код страны, регион ISO 3166-2 или "EU".
+func (h *Handlers) parseGeo(w http.ResponseWriter, r *http.Request, cfg *config.Config, in map[string]string) (map[string]string, bool) {
+	if len(in) == 0 {
+		return nil, true
+	}
+	if len(in) > maxGeoRules {
+		middleware.Problem(w, r, "Too many geo rules", http.StatusBadRequest)
+		return nil, false
+	}
+	out := make(map[string]string, len(in))
+	for key, raw := range in {
+		key = strings.ToUpper(strings.TrimSpace(key))
+		if !geoKeyRe.MatchString(key) {
+			middleware.Problem(w, r, "Invalid geo rule "+key, http.StatusBadRequest)
+			return nil, false
+		}
+		checked, ok := h.checkDestination(w, r, cfg, raw)
+		if !ok {
+			return nil, false
+		}
+		out[key] = checked
+	}
+	return out, true
+}
+
+// pickGeo ищет правило сначала для региона, потом для страны, потом для ЕС.
+func pickGeo(rules map[string]string, country, region string) string {
+	if country == "" {
+		return ""
+	}
+	if target, ok := rules[region]; ok && region != "" {
+		return target
+	}
+	if target, ok := rules[country]; ok {
+		return target
+	}
+	if _, member := euCountries[country]; member {
+		return rules["EU"]
+	}
+	return ""
+}
+
+// pickDevice возвращает адрес для платформы посетителя или пусто, если её не переопределяли.
+func pickDevice(r *http.Request, targets *store.DeviceTargets) string {
+	if targets == nil {
+		return ""
+	}
+	ua := r.UserAgent()
+	switch clicks.MobileOS(ua) {
+	case "ios":
+		if targets.IOS != "" {
+			return targets.IOS
+		}
+	case "android":
+		if targets.Android != "" {
+			return targets.Android
+		}
+	}
+	switch clicks.DeviceType(ua) {
+	case clicks.DeviceMobile, clicks.DeviceTablet:
+		return targets.Mobile
+	case clicks.DeviceDesktop:
+		return targets.Desktop
+	}
+	return ""
+}
+
+// parseVariants проверяет варианты сплит-теста и прогоняет их адреса через политику URL.
+// При ошибке ответ уже записан.
+func (h *Handlers) parseVariants(w http.ResponseWriter, r *http.Request, cfg *config.Config, in []store.Variant) ([]store.Variant, bool) {
+	if len(in) == 0 {
+		return nil, true
+	}
+	if len(in) == 1 || len(in) > maxVariants {
+		middleware.Problem(w, r, "A split needs from 2 to "+strconv.Itoa(maxVariants)+" variants", http.StatusBadRequest)
+		return nil, false
+	}
+	out := make([]store.Variant, 0, len(in))
+	for _, v := range in {
+		if v.Weight < 1 || v.Weight > maxVariantWeight {
+			middleware.Problem(w, r, "Variant weight must be from 1 to "+strconv.Itoa(maxVariantWeight), http.StatusBadRequest)
+			return nil, false
+		}
+		checked, ok := h.checkDestination(w, r, cfg, v.URL)
+		if !ok {
+			return nil, false
+		}
+		out = append(out, store.Variant{URL: checked, Weight: v.Weight})
+	}
+	return out, true
+}
+
+// pickVariant выбирает вариант сплит-теста по весам; false — у ссылки нет вариантов.
+// Для липкого теста выбор запоминается в cookie, и посетитель видит одну и ту же версию.
+func (h *Handlers) pickVariant(w http.ResponseWriter, r *http.Request, id string, meta store.LinkMeta) (store.Variant, bool) {
+	if len(meta.Variants) == 0 {
+		return store.Variant{}, false
+	}
+	cookieName := "ab_" + id
+	if meta.StickyVariants {
+		if c, err := r.Cookie(cookieName); err == nil {
+			if i, err := strconv.Atoi(c.Value); err == nil && i >= 0 && i < len(meta.Variants) {
+				return meta.Variants[i], true
+			}
+		}
+	}
+
+	total := 0
+	for _, v := range meta.Variants {
+		total += v.Weight
+	}
+	i, n := 0, rand.IntN(total)
+	for ; n >= meta.Variants[i].Weight; i++ {
+		n -= meta.Variants[i].Weight
+	}
+	if meta.StickyVariants {
+		http.SetCookie(w, &http.Cookie{
+			Name:     cookieName,
+			Value:    strconv.Itoa(i),
+			Path:     h.cfg.RoutePrefix + "/" + id,
+			MaxAge:   int(variantCookieTTL / time.Second),
+			HttpOnly: true,
+		})
+	}
+	return meta.Variants[i], true
+}
+
+// validUTM проверяет шаблон UTM-параметров ссылки: это строка запроса с плейсхолдерами.
+func validUTM(tmpl string) bool {
+	if tmpl == "" {
+		return true
+	}
+	params, err := url.ParseQuery(tmpl)
+	return err == nil && len(params) > 0 && len(tmpl) <= maxUTMLen
+}
+
+// appendUTM дописывает к адресу параметры шаблона. Плейсхолдеры: {id} — короткий ID,
+// {tag} — первая метка ссылки, {domain} — хост короткой ссылки. Параметры, которые
+// уже есть в адресе, и пустые после подстановки не добавляются.
+func appendUTM(dest, tmpl, host, id string, meta store.LinkMeta) string {
+	if tmpl == "" {
+		return dest
+	}
+	params, err := url.ParseQuery(tmpl)
+	if err != nil {
+		return dest
+	}
+	u, err := url.Parse(dest)
+	if err != nil {
+		return dest
+	}
+	var tag string
+	if len(meta.Tags) > 0 {
+		tag = meta.Tags[0]
+	}
+	expand := strings.NewReplacer("{id}", id, "{tag}", tag, "{domain}", host)
+
+	existing := u.Query()
+	extra := url.Values{}
+	for key, values := range params {
+		if existing.Has(key) || len(values) == 0 {
+			continue
+		}
+		if v := expand.Replace(values[0]); v != "" {
+			extra.Set(key, v)
+		}
+	}
+	if len(extra) == 0 {
+		return dest
+	}
+	// Дописываем в конец, не пересобирая исходную строку запроса: порядок и кодировка её параметров сохраняются.
+	if u.RawQuery != "" {
+		u.RawQuery += "&"
+	}
+	u.RawQuery += extra.Encode()
+	return u.String()
+}
@@ -0,0 +1,34 @@
+// Internal/app/endpoints/scheduler.go.
+package endpoints
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/dkolesni-prog/transformer/internal/app/middleware"
+	"github.com/dkolesni-prog/transformer/internal/scheduler"
+)
+
+// AdminSchedule lists scheduled maintenance tasks with their last run: GET /api/admin/scheduler.
+func (h *Handlers) AdminSchedule(w http.ResponseWriter, r *http.Request) {
+	statuses := []scheduler.Status{}
+	if h.scheduler != nil {
+		statuses = h.scheduler.Statuses()
+	}
+	writeData(w, r, http.StatusOK, statuses)
+}
+
+// AdminRunScheduled runs a scheduled task now and waits for it: POST /api/admin/scheduler/{task}/run.
+func (h *Handlers) AdminRunScheduled(w http.ResponseWriter, r *http.Request) {
+	if h.scheduler == nil {
+		middleware.Problem(w, r, "Task not found", http.StatusNotFound)
+		return
+	}
+	status, ok := h.scheduler.Run(r.Context(), chi.URLParam(r, "task"))
+	if !ok {
+		middleware.Problem(w, r, "Task not found", http.StatusNotFound)
+		return
+	}
+	writeData(w, r, http.StatusOK, status)
+}
@@ -0,0 +1,59 @@
+// Internal/app/endpoints/static.go.
+package endpoints
+
+import (
+	"bytes"
+	_ "embed"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dkolesni-prog/transformer/internal/config"
+)
+
+// defaultRobots запрещает обход коротких ссылок: краулер, прошедший по ним, накручивает переходы.
+// Открыта только главная страница.
+const defaultRobots = `User-agent: *
+Allow: /$
+Disallow: /
+`
+
+//go:embed static/favicon.ico
+var favicon []byte
+
+// robotsBodies — содержимое robots.txt, прочитанное один раз на конфиг.
+var robotsBodies sync.Map
+
+// robotsFor читает cfg.RobotsFile; без файла или при ошибке чтения отдаётся defaultRobots.
+func (h *Handlers) robotsFor(cfg *config.Config) []byte {
+	if body, ok := robotsBodies.Load(cfg); ok {
+		return body.([]byte)
+	}
+	body := []byte(defaultRobots)
+	if cfg.RobotsFile != "" {
+		custom, err := os.ReadFile(cfg.RobotsFile)
+		if err != nil {
+			h.logger.Error("Could not read robots.txt, serving the default", "error", err, "path", cfg.RobotsFile)
+		} else {
+			body = custom
+		}
+	}
+	stored, _ := robotsBodies.LoadOrStore(cfg, body)
+	return stored.([]byte)
+}
+
+// GetRobots serves /robots.txt.
+func (h *Handlers) GetRobots(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(contentType, contentTypeText)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(h.robotsFor(h.cfg))
+}
+
+// GetFavicon serves /favicon.ico, so browsers opening short links don't produce 404s.
+func GetFavicon(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(contentType, "image/x-icon")
+	w.Header().Set("Cache-Control", "public, max-age=604800")
+	http.ServeContent(w, r, "favicon.ico", time.Time{}, bytes.NewReader(favicon))
+}
@@ -0,0 +1,51 @@
+// Internal/app/endpoints/tags.go.
+package endpoints
+
+import (
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/dkolesni-prog/transformer/internal/store"
+)
+
+const maxTags = 10
+
+var (
+	tagRe = regexp.MustCompile(`^[\p{L}\p{N}][\p{L}\p{N}_.-]{0,31}$`)
+
+	errInvalidTag  = errors.New("invalid tag")
+	errTooManyTags = errors.New("too many tags")
+)
+
+// normalizeTags приводит метки к нижнему регистру, убирает повторы и сортирует.
+func normalizeTags(tags []string) ([]string, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	out := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !tagRe.MatchString(tag) {
+			return nil, errInvalidTag
+		}
+		out = append(out, tag)
+	}
+	slices.Sort(out)
+	out = slices.Compact(out)
+	if len(out) > maxTags {
+		return nil, errTooManyTags
+	}
+	return out, nil
+}
+
+// hasTags сообщает, помечена ли ссылка всеми метками из want.
+func hasTags(item store.UserURL, want []string) bool {
+	for _, tag := range want {
+		if !slices.Contains(item.Tags, strings.ToLower(tag)) {
+			return false
+		}
+	}
+	return true
+}
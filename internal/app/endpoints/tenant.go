@@ -0,0 +1,122 @@
+// Internal/app/endpoints/tenant.go.
+package endpoints
+
+import (
+	"context"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/dkolesni-prog/transformer/internal/app/middleware"
+	"github.com/dkolesni-prog/transformer/internal/config"
+	"github.com/dkolesni-prog/transformer/internal/store"
+)
+
+type tenantCtxKey struct{}
+
+// tenant — дополнительный домен и конфиг с его BaseURL.
+type tenant struct {
+	domain string
+	cfg    *config.Config
+}
+
+// tenantSets — арендаторы по хосту, собранные из cfg.TenantBaseURLs один раз на конфиг.
+var tenantSets sync.Map
+
+func (h *Handlers) tenantsFor(cfg *config.Config) map[string]tenant {
+	if set, ok := tenantSets.Load(cfg); ok {
+		return set.(map[string]tenant)
+	}
+	set := make(map[string]tenant)
+	for _, raw := range strings.Split(cfg.TenantBaseURLs, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			h.logger.Error("Skipping invalid tenant base URL", "base_url", raw)
+			continue
+		}
+		domain := strings.ToLower(u.Host)
+		tenantCfg := *cfg
+		tenantCfg.BaseURL = config.JoinRoutePrefix(raw, cfg.RoutePrefix)
+		set[domain] = tenant{domain: domain, cfg: &tenantCfg}
+	}
+	stored, _ := tenantSets.LoadOrStore(cfg, set)
+	return stored.(map[string]tenant)
+}
+
+// withTenant выбирает арендатора по заголовку Host. Запросы на основной домен
+// и на неизвестные хосты обслуживаются как раньше, с cfg.BaseURL, а с
+// cfg.BaseURLFromHost — с базовым URL, собранным из адреса запроса.
+func (h *Handlers) withTenant(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if t, ok := h.tenantsFor(h.cfg)[strings.ToLower(r.Host)]; ok {
+				r = r.WithContext(context.WithValue(r.Context(), tenantCtxKey{}, t))
+			} else if h.cfg.BaseURLFromHost {
+				scheme, host := middleware.RequestOrigin(r, trusted)
+				hostCfg := *h.cfg
+				hostCfg.BaseURL = config.JoinRoutePrefix(scheme+"://"+host, h.cfg.RoutePrefix)
+				// Пустой domain: ссылки остаются в пространстве имён основного домена.
+				r = r.WithContext(context.WithValue(r.Context(), tenantCtxKey{}, tenant{cfg: &hostCfg}))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// tenantConfig возвращает конфиг арендатора запроса или cfg для основного домена.
+func tenantConfig(r *http.Request, cfg *config.Config) *config.Config {
+	if t, ok := r.Context().Value(tenantCtxKey{}).(tenant); ok {
+		return t.cfg
+	}
+	return cfg
+}
+
+// tenantDomain — пространство имён ссылок запроса; пусто для основного домена.
+func tenantDomain(r *http.Request) string {
+	t, _ := r.Context().Value(tenantCtxKey{}).(tenant)
+	return t.domain
+}
+
+// selectDomain переключает запрос на домен, выбранный клиентом при создании ссылки:
+// хост BaseURL или один из TenantBaseURLs. Пустой domain оставляет домен по Host;
+// на незнакомый отвечает 400 и возвращает false.
+func (h *Handlers) selectDomain(w http.ResponseWriter, r *http.Request, domain string) (*http.Request, bool) {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if domain == "" {
+		return r, true
+	}
+	if t, ok := h.tenantsFor(h.cfg)[domain]; ok {
+		return r.WithContext(context.WithValue(r.Context(), tenantCtxKey{}, t)), true
+	}
+	if u, err := url.Parse(h.cfg.BaseURL); err == nil && strings.ToLower(u.Host) == domain {
+		return r.WithContext(context.WithValue(r.Context(), tenantCtxKey{}, tenant{cfg: h.cfg})), true
+	}
+	middleware.Problem(w, r, "Unknown domain", http.StatusBadRequest)
+	return r, false
+}
+
+// ownerShortURL переписывает короткий адрес уже существующей ссылки на домен, в котором
+// её создали: адрес остаётся рабочим, даже если тот же URL повторно сокращают на другом
+// домене. Если домен ссылки не удалось узнать или он больше не настроен, short не меняется.
+func (h *Handlers) ownerShortURL(r *http.Request, cfg *config.Config, short string) string {
+	shortID := store.ShortIDFromURL(short, cfg.BaseURL)
+	meta, err := h.store.LoadMeta(r.Context(), shortID)
+	if err != nil || meta.Domain == tenantDomain(r) {
+		return short
+	}
+	base := h.cfg.BaseURL
+	if meta.Domain != "" {
+		t, ok := h.tenantsFor(h.cfg)[meta.Domain]
+		if !ok {
+			return short
+		}
+		base = t.cfg.BaseURL
+	}
+	return strings.TrimSuffix(base, "/") + "/" + shortID
+}
@@ -0,0 +1,54 @@
+// Internal/app/endpoints/title.go.
+package endpoints
+
+import (
+	"context"
+	"net/url"
+	"sync"
+
+	"github.com/dkolesni-prog/transformer/internal/config"
+	"github.com/dkolesni-prog/transformer/internal/urlpolicy"
+)
+
+const (
+	maxTitleLen = 200
+	maxNoteLen  = 1000
+	// maxTitleFetches ограничивает число одновременных запросов за заголовками, например при больших батчах.
+	maxTitleFetches = 8
+)
+
+var (
+	titleFetchers sync.Map
+	titleSlots    = make(chan struct{}, maxTitleFetches)
+)
+
+func titleFetcherFor(cfg *config.Config) *urlpolicy.TitleFetcher {
+	if f, ok := titleFetchers.Load(cfg); ok {
+		return f.(*urlpolicy.TitleFetcher)
+	}
+	stored, _ := titleFetchers.LoadOrStore(cfg, urlpolicy.NewTitleFetcher(cfg.ProbeTimeout))
+	return stored.(*urlpolicy.TitleFetcher)
+}
+
+// fetchTitle подставляет <title> страницы, если владелец не успел задать заголовок сам.
+func (h *Handlers) fetchTitle(ctx context.Context, cfg *config.Config, userID, shortID string, u *url.URL) {
+	titleSlots <- struct{}{}
+	defer func() { <-titleSlots }()
+
+	ctx, cancel := context.WithTimeout(ctx, 2*cfg.ProbeTimeout)
+	defer cancel()
+
+	title, err := titleFetcherFor(cfg).Fetch(ctx, u)
+	if err != nil || title == "" {
+		h.logger.Debug("No page title fetched", "error", err, "short_id", shortID)
+		return
+	}
+	meta, err := h.store.LoadMeta(ctx, shortID)
+	if err != nil || meta.Title != "" {
+		return
+	}
+	meta.Title = title
+	if setErr := h.store.SetMeta(ctx, userID, shortID, meta); setErr != nil {
+		h.logger.Warn("Could not save page title", "error", setErr, "short_id", shortID)
+	}
+}
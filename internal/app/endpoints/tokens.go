@@ -0,0 +1,141 @@
+// Internal/app/endpoints/tokens.go.
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/dkolesni-prog/transformer/internal/apitoken"
+	"github.com/dkolesni-prog/transformer/internal/app/middleware"
+)
+
+// maxTokenName — длина названия токена, которое видит владелец в списке.
+const maxTokenName = 128
+
+// tokenView — токен в ответах API. Token, сама строка для заголовка Authorization,
+// есть только в ответе на создание.
+type tokenView struct {
+	ID        string         `json:"id"`
+	Name      string         `json:"name"`
+	Scope     apitoken.Scope `json:"scope"`
+	CreatedAt time.Time      `json:"created_at"`
+	Token     string         `json:"token,omitempty"`
+}
+
+func newTokenView(t apitoken.Token) tokenView {
+	return tokenView{ID: t.ID, Name: t.Name, Scope: t.Scope, CreatedAt: t.CreatedAt}
+}
+
+// CreateToken issues a long-lived API token for the caller:
+// POST /api/user/tokens {"name": "ci", "scope": "create-only"|"read-stats"|"full"}.
+// The token is sent as "Authorization: Bearer <token>" and is shown only in this response.
+func (h *Handlers) CreateToken(w http.ResponseWriter, r *http.Request) {
+	userID, ok := verifiedUser(w, r)
+	if !ok {
+		return
+	}
+	defer func() { _ = r.Body.Close() }()
+	var req struct {
+		Name  string         `json:"name"`
+		Scope apitoken.Scope `json:"scope"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.Problem(w, r, "Failed to parse JSON", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxTokenName {
+		middleware.Problem(w, r, "name must be 1 to 128 bytes long", http.StatusBadRequest)
+		return
+	}
+	if !apitoken.ValidScope(req.Scope) {
+		middleware.Problem(w, r, "scope must be create-only, read-stats or full", http.StatusBadRequest)
+		return
+	}
+
+	token, raw := apitoken.New(userID, req.Name, req.Scope)
+	if err := h.tokens.Create(r.Context(), token); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	h.logger.Info("API token issued", "token", token.ID, "scope", string(token.Scope))
+	view := newTokenView(token)
+	view.Token = raw
+	w.Header().Set("Location", h.cfg.RoutePrefix+"/api/user/tokens/"+token.ID)
+	writeData(w, r, http.StatusCreated, view)
+}
+
+// ListTokens lists the caller's API tokens without their secrets: GET /api/user/tokens.
+func (h *Handlers) ListTokens(w http.ResponseWriter, r *http.Request) {
+	userID, ok := verifiedUser(w, r)
+	if !ok {
+		return
+	}
+	list, err := h.tokens.List(r.Context(), userID)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	out := make([]tokenView, 0, len(list))
+	for _, t := range list {
+		out = append(out, newTokenView(t))
+	}
+	writeData(w, r, http.StatusOK, out)
+}
+
+// GetToken shows one of the caller's API tokens: GET /api/user/tokens/{id}.
+func (h *Handlers) GetToken(w http.ResponseWriter, r *http.Request) {
+	userID, ok := verifiedUser(w, r)
+	if !ok {
+		return
+	}
+	token, err := h.tokens.Get(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, apitoken.ErrNotFound) || (err == nil && token.UserID != userID) {
+		middleware.Problem(w, r, "Token not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	writeData(w, r, http.StatusOK, newTokenView(token))
+}
+
+// RevokeToken deletes one of the caller's API tokens; it stops working immediately:
+// DELETE /api/user/tokens/{id}.
+func (h *Handlers) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	userID, ok := verifiedUser(w, r)
+	if !ok {
+		return
+	}
+	err := h.tokens.Delete(r.Context(), userID, chi.URLParam(r, "id"))
+	if errors.Is(err, apitoken.ErrNotFound) {
+		middleware.Problem(w, r, "Token not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	h.logger.Info("API token revoked", "token", chi.URLParam(r, "id"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeAllTokens отзывает все токены userID, например при удалении аккаунта.
+func (h *Handlers) revokeAllTokens(r *http.Request, userID string) error {
+	list, err := h.tokens.List(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+	for _, t := range list {
+		if err := h.tokens.Delete(r.Context(), userID, t.ID); err != nil && !errors.Is(err, apitoken.ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+// Internal/app/endpoints/transfer.go.
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/dkolesni-prog/transformer/internal/app/middleware"
+	"github.com/dkolesni-prog/transformer/internal/config"
+	"github.com/dkolesni-prog/transformer/internal/store"
+)
+
+// claimTokenTTL — сколько живёт токен передачи ссылки.
+const claimTokenTTL = 24 * time.Hour
+
+// TransferUserURL передаёт ссылку другому пользователю: POST /api/user/urls/{id}/transfer.
+// С {"user_id": "..."} ссылка сразу переходит к нему; с пустым user_id
+// в ответ отдаётся claim_token, который получатель предъявляет в ClaimUserURL.
+// Как и ClaimUserURL, требует подписанной куки или API-токена.
+func (h *Handlers) TransferUserURL(w http.ResponseWriter, r *http.Request) {
+	cfg := tenantConfig(r, h.cfg)
+	userID, ok := verifiedUser(w, r)
+	if !ok {
+		return
+	}
+	defer func() { _ = r.Body.Close() }()
+	var req struct {
+		UserID string `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.Problem(w, r, "Failed to parse JSON", http.StatusBadRequest)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if req.UserID == "" {
+		h.issueClaimToken(w, r, cfg, userID, id)
+		return
+	}
+	h.transfer(w, r, cfg, userID, id, req.UserID)
+}
+
+// ClaimUserURL забирает ссылку по токену из TransferUserURL: POST /api/user/urls/claim {"token": "..."}.
+func (h *Handlers) ClaimUserURL(w http.ResponseWriter, r *http.Request) {
+	cfg := tenantConfig(r, h.cfg)
+	userID, ok := verifiedUser(w, r)
+	if !ok {
+		return
+	}
+	defer func() { _ = r.Body.Close() }()
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		middleware.Problem(w, r, "Failed to parse JSON", http.StatusBadRequest)
+		return
+	}
+	fromUserID, shortID, ok := h.parseClaimToken(req.Token, time.Now())
+	if !ok {
+		reject(w, r, http.StatusForbidden, "invalid_claim_token", nil)
+		return
+	}
+	h.transfer(w, r, cfg, fromUserID, shortID, userID)
+}
+
+// AdminTransferURL переназначает ссылку без участия владельца: POST /api/admin/urls/{id}/transfer.
+func (h *Handlers) AdminTransferURL(w http.ResponseWriter, r *http.Request) {
+	defer func() { _ = r.Body.Close() }()
+	var req struct {
+		UserID string `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+		middleware.Problem(w, r, "Failed to parse JSON", http.StatusBadRequest)
+		return
+	}
+	id := chi.URLParam(r, "id")
+	rec, err := store.FindRecord(r.Context(), h.store, id)
+	if errors.Is(err, store.ErrNotFound) {
+		middleware.Problem(w, r, "Short URL not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	h.transfer(w, r, h.cfg, rec.UserID, id, req.UserID)
+}
+
+func (h *Handlers) transfer(w http.ResponseWriter, r *http.Request, cfg *config.Config, fromUserID, shortID, toUserID string) {
+	err := h.store.TransferOwner(r.Context(), fromUserID, shortID, toUserID)
+	if errors.Is(err, store.ErrNotFound) {
+		middleware.Problem(w, r, "Short URL not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	writeData(w, r, http.StatusOK, map[string]string{
+		"short_url": cfg.BaseURL + shortID,
+		"user_id":   toUserID,
+	})
+}
+
+// issueClaimToken проверяет, что ссылка принадлежит владельцу, и выдаёт подписанный токен на неё.
+func (h *Handlers) issueClaimToken(w http.ResponseWriter, r *http.Request, cfg *config.Config, userID, shortID string) {
+	owned, err := h.ownsLink(r, cfg, userID, shortID)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	if !owned {
+		middleware.Problem(w, r, "Short URL not found", http.StatusNotFound)
+		return
+	}
+
+	expires := time.Now().Add(claimTokenTTL)
+	token := h.auth.SignToken(strings.Join([]string{shortID, userID, strconv.FormatInt(expires.Unix(), 10)}, "|"))
+	writeData(w, r, http.StatusOK, map[string]string{
+		"claim_token": token,
+		"expires_at":  expires.UTC().Format(time.RFC3339),
+	})
+}
+
+func (h *Handlers) parseClaimToken(token string, now time.Time) (fromUserID, shortID string, ok bool) {
+	payload, ok := h.auth.VerifyToken(token)
+	if !ok {
+		return "", "", false
+	}
+	parts := strings.Split(payload, "|")
+	if len(parts) != 3 {
+		return "", "", false
+	}
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || now.Unix() > expires {
+		return "", "", false
+	}
+	return parts[1], parts[0], true
+}
+
+// ownsLink сообщает, есть ли живая ссылка shortID среди ссылок userID.
+func (h *Handlers) ownsLink(r *http.Request, cfg *config.Config, userID, shortID string) (bool, error) {
+	list, err := h.store.LoadUserURLs(r.Context(), userID, cfg.BaseURL)
+	if err != nil {
+		return false, err
+	}
+	for _, item := range list {
+		if !item.Alias && store.ShortIDFromURL(item.ShortURL, cfg.BaseURL) == shortID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,151 @@
+// Internal/app/endpoints/ui.go.
+package endpoints
+
+import (
+	"embed"
+	"html/template"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/dkolesni-prog/transformer/internal/app/middleware"
+	"github.com/dkolesni-prog/transformer/internal/store"
+	"github.com/dkolesni-prog/transformer/internal/urlpolicy"
+)
+
+// Дашборд /ui: страницы рендерятся на сервере, а создание и удаление ссылок
+// идут из браузера через тот же JSON API, что и у остальных клиентов.
+
+//go:embed ui/*.html
+var uiFS embed.FS
+
+var uiTemplates = template.Must(template.ParseFS(uiFS, "ui/*.html"))
+
+// uiWindows — окна статистики, между которыми переключается страница ссылки.
+var uiWindows = []string{"1d", "7d", "30d", "365d"}
+
+type uiLink struct {
+	store.UserURL
+	ID string
+}
+
+type uiBreakdown struct {
+	Name string
+	Rows []uiCount
+}
+
+type uiCount struct {
+	Key   string
+	Count int
+}
+
+// UILinks renders the dashboard with the caller's links: GET /ui.
+func (h *Handlers) UILinks(w http.ResponseWriter, r *http.Request) {
+	cfg := tenantConfig(r, h.cfg)
+	userID, ok := middleware.GetUserID(r)
+	if !ok || userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	list, err := h.store.LoadUserURLs(r.Context(), userID, cfg.BaseURL)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	domain := tenantDomain(r)
+	links := make([]uiLink, 0, len(list))
+	for _, item := range list {
+		if item.Domain != domain {
+			continue
+		}
+		item.OriginalURL = urlpolicy.DisplayURL(item.OriginalURL)
+		links = append(links, uiLink{UserURL: item, ID: store.ShortIDFromURL(item.ShortURL, cfg.BaseURL)})
+	}
+	h.renderUI(w, r, "links", map[string]any{"Title": "My links", "Links": links})
+}
+
+// Home renders the public homepage with a shorten form: GET /.
+func (h *Handlers) Home(w http.ResponseWriter, r *http.Request) {
+	brand := tenantConfig(r, h.cfg).Branding
+	if brand == "" {
+		brand = "URL shortener"
+	}
+	h.renderUI(w, r, "home", map[string]any{"Title": brand, "Public": true})
+}
+
+// UILinkStats renders click stats of the caller's link: GET /ui/links/{id}?window=7d.
+func (h *Handlers) UILinkStats(w http.ResponseWriter, r *http.Request) {
+	cfg := tenantConfig(r, h.cfg)
+	userID, ok := middleware.GetUserID(r)
+	if !ok || userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id := chi.URLParam(r, "id")
+	owned, err := h.ownsLink(r, cfg, userID, id)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	if !owned {
+		http.Error(w, "Short URL not found", http.StatusNotFound)
+		return
+	}
+	windowName := r.URL.Query().Get("window")
+	if windowName == "" {
+		windowName = "7d"
+	}
+	window, err := parseWindow(windowName)
+	if err != nil {
+		http.Error(w, "Invalid window", http.StatusBadRequest)
+		return
+	}
+	stats, err := h.tracker.Log().Stats(r.Context(), id, time.Now().Add(-window))
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	h.renderUI(w, r, "stats", map[string]any{
+		"Title":    "Link stats",
+		"ShortURL": cfg.BaseURL + id,
+		"Window":   windowName,
+		"Windows":  uiWindows,
+		"Stats":    stats,
+		"Breakdowns": []uiBreakdown{
+			{"Referrer", sortedCounts(stats.Referrers)},
+			{"Country", sortedCounts(stats.Countries)},
+			{"Region", sortedCounts(stats.Regions)},
+			{"Browser", sortedCounts(stats.Browsers)},
+			{"Device", sortedCounts(stats.Devices)},
+			{"Variant", sortedCounts(stats.Variants)},
+		},
+	})
+}
+
+func (h *Handlers) renderUI(w http.ResponseWriter, r *http.Request, name string, data map[string]any) {
+	data["CSRFToken"] = middleware.CSRFToken(r.Context())
+	data["Prefix"] = h.cfg.RoutePrefix
+	w.Header().Set(contentType, "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if err := uiTemplates.ExecuteTemplate(w, name, data); err != nil {
+		h.logger.Error("Could not render dashboard page", "error", err, "template", name)
+	}
+}
+
+// sortedCounts раскладывает разбивку по убыванию числа переходов.
+func sortedCounts(m map[string]int) []uiCount {
+	rows := make([]uiCount, 0, len(m))
+	for key, n := range m {
+		rows = append(rows, uiCount{Key: key, Count: n})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Count != rows[j].Count {
+			return rows[i].Count > rows[j].Count
+		}
+		return rows[i].Key < rows[j].Key
+	})
+	return rows
+}
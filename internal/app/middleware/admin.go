@@ -0,0 +1,28 @@
+// Internal/app/middleware/admin.go.
+
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// AdminAuth пропускает только запросы с заголовком "Authorization: Bearer <token>".
+// Пустой token выключает админские эндпоинты целиком.
+func AdminAuth(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				Problem(w, r, "admin API is disabled", http.StatusForbidden)
+				return
+			}
+			got, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !found || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				Problem(w, r, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,79 @@
+// Internal/app/middleware/anonymize.go.
+
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Режимы обезличивания IP клиентов в логах, аудите и статистике переходов.
+const (
+	AnonymizeOff = ""
+	// AnonymizeTruncate обнуляет хвост адреса: у IPv4 остаётся /24, у IPv6 — /48.
+	// Страну и регион по такому адресу определить всё ещё можно.
+	AnonymizeTruncate = "truncate"
+	// AnonymizeHash заменяет адрес HMAC-хешем: разные посетители различимы, но адрес не восстановить.
+	AnonymizeHash = "hash"
+)
+
+var (
+	anonMu      sync.RWMutex
+	anonMode    string
+	anonHashKey []byte
+)
+
+// InitIPAnonymization включает обезличивание IP. Ключ хеша — secret; без него ключ
+// случайный, и хеши одного адреса после перезапуска не совпадут.
+func InitIPAnonymization(mode, secret string) error {
+	key := []byte(secret)
+	switch mode {
+	case AnonymizeOff, AnonymizeTruncate:
+	case AnonymizeHash:
+		if len(key) == 0 {
+			key = make([]byte, 32)
+			if _, err := rand.Read(key); err != nil {
+				return fmt.Errorf("generate IP hash key: %w", err)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown IP anonymization mode %q", mode)
+	}
+	anonMu.Lock()
+	defer anonMu.Unlock()
+	anonMode, anonHashKey = mode, key
+	return nil
+}
+
+// AnonymizeIP приводит IP к виду, который можно записывать: как есть, усечённым или хешем.
+func AnonymizeIP(ip string) string {
+	anonMu.RLock()
+	mode, key := anonMode, anonHashKey
+	anonMu.RUnlock()
+
+	switch mode {
+	case AnonymizeTruncate:
+		addr := net.ParseIP(ip)
+		if addr == nil {
+			return ""
+		}
+		if v4 := addr.To4(); v4 != nil {
+			return v4.Mask(net.CIDRMask(24, 32)).String()
+		}
+		return addr.Mask(net.CIDRMask(48, 128)).String()
+	case AnonymizeHash:
+		if ip == "" {
+			return ""
+		}
+		mac := hmac.New(sha256.New, key)
+		_, _ = mac.Write([]byte(ip))
+		return hex.EncodeToString(mac.Sum(nil))[:16]
+	default:
+		return ip
+	}
+}
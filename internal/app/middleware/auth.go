@@ -4,13 +4,19 @@ import (
 	"context"
 	"crypto/hmac"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/dkolesni-prog/transformer/internal/apitoken"
 )
 
 // ctxKey и iota — «типизированный» ключ для контекста.
@@ -18,21 +24,115 @@ type ctxKey int
 
 const (
 	keyUserID ctxKey = iota
+	keyClientIP
+	keyVerified
+)
+
+const (
+	cookieName          = "UserID"
+	defaultCookieMaxAge = 365 * 24 * time.Hour
 )
 
-const cookieName = "UserID"
+// CookieOptions — атрибуты куки пользователя.
+type CookieOptions struct {
+	Secure   bool
+	SameSite http.SameSite
+	// MaxAge — срок жизни куки; 0 — год.
+	MaxAge time.Duration
+	// RenewAfter — кука старше этого срока выдаётся заново с полным MaxAge, так что активный
+	// пользователь не теряет ссылки (скользящий срок). 0 — не продлевать.
+	RenewAfter time.Duration
+}
+
+// ParseCookieOptions разбирает настройки куки. secure: "auto" (Secure, если baseURL на
+// https), "true" или "false"; sameSite: "lax", "strict" или "none" (требует Secure).
+func ParseCookieOptions(secure, sameSite, baseURL string, maxAge, renewAfter time.Duration) (CookieOptions, error) {
+	opts := CookieOptions{MaxAge: maxAge, RenewAfter: renewAfter}
+	switch strings.ToLower(secure) {
+	case "", "auto":
+		opts.Secure = strings.HasPrefix(strings.ToLower(baseURL), "https://")
+	default:
+		b, err := strconv.ParseBool(secure)
+		if err != nil {
+			return opts, fmt.Errorf("cookie secure %q: want auto, true or false", secure)
+		}
+		opts.Secure = b
+	}
+	switch strings.ToLower(sameSite) {
+	case "", "lax":
+		opts.SameSite = http.SameSiteLaxMode
+	case "strict":
+		opts.SameSite = http.SameSiteStrictMode
+	case "none":
+		if !opts.Secure {
+			return opts, fmt.Errorf("cookie SameSite=None requires a secure cookie")
+		}
+		opts.SameSite = http.SameSiteNoneMode
+	default:
+		return opts, fmt.Errorf("cookie samesite %q: want lax, strict or none", sameSite)
+	}
+	if maxAge < 0 || renewAfter < 0 {
+		return opts, fmt.Errorf("cookie lifetime must not be negative")
+	}
+	return opts, nil
+}
+
+// Auth выдаёт и разбирает куки пользователя и подписывает токены. Ключ подписи
+// хранится в самом Auth, поэтому у каждого роутера может быть свой.
+type Auth struct {
+	mu     sync.RWMutex
+	secret []byte
+	newID  func() string
+	cookie CookieOptions
+	// tokens — выданные API-токены; nil — запросы с ними отклоняются.
+	tokens apitoken.Store
+	// strict — куки без верной подписи считаются битыми (см. RequireSignature).
+	strict bool
+}
+
+// NewAuth создаёт Auth с ключом secret. newID выдаёт userID новым посетителям,
+// nil — NewUserID.
+func NewAuth(secret string, newID func() string, cookie CookieOptions) *Auth {
+	if newID == nil {
+		newID = NewUserID
+	}
+	if cookie.MaxAge == 0 {
+		cookie.MaxAge = defaultCookieMaxAge
+	}
+	return &Auth{secret: []byte(secret), newID: newID, cookie: cookie}
+}
+
+// RotateSecret меняет ключ подписи кук на лету. Без RequireSignature ранее выданные
+// куки продолжают работать, но перестают считаться подтверждёнными (см. Verified).
+func (a *Auth) RotateSecret(secret string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.secret = []byte(secret)
+}
 
-var secretKey []byte
+// UseTokens включает вход по API-токенам из s. Вызывается до обслуживания запросов.
+func (a *Auth) UseTokens(s apitoken.Store) {
+	a.tokens = s
+}
 
-func InitAuth(secret string) {
-	secretKey = []byte(secret)
+// RequireSignature отклоняет куки с неверной подписью: посетитель получает новый userID.
+// Нужна, когда за userID стоит учётная запись, иначе куку чужого аккаунта можно подделать.
+// Вызывается до обслуживания запросов.
+func (a *Auth) RequireSignature() {
+	a.strict = true
 }
 
-// AuthMiddleware обрабатывает cookie:
+// Middleware обрабатывает cookie:
 // - При GET/DELETE /api/user/urls (protected): если нет куки или она «битая» — ставим новую куку и возвращаем 401.
 // - При других запросах (unprotected): если нет куки или она «битая» — ставим новую куку, но пропускаем дальше.
-func AuthMiddleware(next http.Handler) http.Handler {
+func (a *Auth) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// API-токен заменяет куку: другой Bearer (например, админский) сюда не относится.
+		if raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && apitoken.IsToken(raw) {
+			a.serveToken(w, r, next, raw)
+			return
+		}
+
 		c, err := r.Cookie(cookieName)
 
 		isUserUrls := (r.URL.Path == "/api/user/urls")
@@ -42,12 +142,13 @@ func AuthMiddleware(next http.Handler) http.Handler {
 
 		if err != nil {
 			// Куки нет вообще => генерируем новую и ставим
-			userID = generateNewUserID()
-			setUserIDCookie(w, userID)
+			authMetrics.Add(cookieIssuedMissing, 1)
+			userID = a.newID()
+			a.setUserIDCookie(w, userID)
 
 			if isProtected {
 				// Защищённый эндпоинт без куки => 401
-				http.Error(w, "unauthorized (no cookie)", http.StatusUnauthorized)
+				Problem(w, r, "unauthorized (no cookie)", http.StatusUnauthorized)
 				return
 			}
 			// Иначе пропускаем дальше
@@ -57,14 +158,15 @@ func AuthMiddleware(next http.Handler) http.Handler {
 		}
 
 		// Кука есть => разбираем
-		parsedID, pErr := parseSignedValue(c.Value)
+		parsedID, issued, signed, pErr := a.parseSignedValue(c.Value)
 		if pErr != nil || parsedID == "" {
 			// «Битая» кука => генерируем новую
-			userID = generateNewUserID()
-			setUserIDCookie(w, userID)
+			authMetrics.Add(cookieIssuedInvalid, 1)
+			userID = a.newID()
+			a.setUserIDCookie(w, userID)
 
 			if isProtected {
-				http.Error(w, "unauthorized (bad cookie)", http.StatusUnauthorized)
+				Problem(w, r, "unauthorized (bad cookie)", http.StatusUnauthorized)
 				return
 			}
 			// Не защищённый эндпоинт
@@ -73,13 +175,47 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		// Кука валидна
+		// Кука валидна; старую продлеваем. Куки без времени выдачи — из прежних версий.
 		userID = parsedID
+		if a.cookie.RenewAfter > 0 && time.Since(issued) > a.cookie.RenewAfter {
+			authMetrics.Add(cookieRenewed, 1)
+			a.setUserIDCookie(w, userID)
+		}
 		ctx := context.WithValue(r.Context(), keyUserID, userID)
+		ctx = context.WithValue(ctx, keyVerified, signed)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// serveToken пропускает запрос от имени владельца токена, если область токена его разрешает.
+// Кука при этом не выдаётся.
+func (a *Auth) serveToken(w http.ResponseWriter, r *http.Request, next http.Handler, raw string) {
+	if a.tokens == nil {
+		authMetrics.Add(tokenRejected, 1)
+		Problem(w, r, "invalid API token", http.StatusUnauthorized)
+		return
+	}
+	tok, err := apitoken.Verify(r.Context(), a.tokens, raw)
+	if errors.Is(err, apitoken.ErrInvalid) {
+		authMetrics.Add(tokenRejected, 1)
+		Problem(w, r, "invalid API token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		Problem(w, r, "could not check API token", http.StatusServiceUnavailable)
+		return
+	}
+	if !tok.Scope.Allows(r.Method, r.URL.Path) {
+		authMetrics.Add(tokenForbidden, 1)
+		Problem(w, r, "API token scope "+string(tok.Scope)+" does not allow this request", http.StatusForbidden)
+		return
+	}
+	authMetrics.Add(tokenAccepted, 1)
+	ctx := context.WithValue(r.Context(), keyUserID, tok.UserID)
+	ctx = context.WithValue(ctx, keyVerified, true)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
 // GetUserID достаёт userID из контекста для дальнейших операций.
 func GetUserID(r *http.Request) (string, bool) {
 	val := r.Context().Value(keyUserID)
@@ -87,48 +223,124 @@ func GetUserID(r *http.Request) (string, bool) {
 	return id, ok
 }
 
-func generateNewUserID() string {
+// Verified сообщает, что userID запроса подтверждён: кука с верной подписью или API-токен.
+// Новым посетителям и кукам без подписи userID не подтверждён.
+func Verified(r *http.Request) bool {
+	ok, _ := r.Context().Value(keyVerified).(bool)
+	return ok
+}
+
+// WithUserID подменяет владельца запроса, например на организацию, от имени которой действует пользователь.
+func WithUserID(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, keyUserID, userID)
+}
+
+// NewUserID — userID по умолчанию для новых посетителей.
+func NewUserID() string {
 	return fmt.Sprintf("U%d_%d", rand.Intn(9999999), time.Now().UnixNano())
 }
 
-// setUserIDCookie формирует "userID:signature" и устанавливает cookie.
-func setUserIDCookie(w http.ResponseWriter, userID string) {
-	signed := makeSignedValue(userID)
+// setUserIDCookie формирует "userID:signature:issued" и устанавливает cookie.
+func (a *Auth) setUserIDCookie(w http.ResponseWriter, userID string) {
+	now := time.Now()
+	signed := a.makeSignedValue(userID) + ":" + strconv.FormatInt(now.Unix(), 10)
 	http.SetCookie(w, &http.Cookie{
 		Name:     cookieName,
 		Value:    signed,
 		Path:     "/",
-		Expires:  time.Now().AddDate(1, 0, 0), // 1 год
+		Expires:  now.Add(a.cookie.MaxAge),
+		MaxAge:   int(a.cookie.MaxAge / time.Second),
+		HttpOnly: true,
+		Secure:   a.cookie.Secure,
+		SameSite: a.cookie.SameSite,
+	})
+}
+
+// SetUserID выдаёт куку с userID, например после входа по паролю в учётную запись.
+// Кука, которую Middleware уже выдал в этом ответе новому посетителю, убирается.
+func (a *Auth) SetUserID(w http.ResponseWriter, userID string) {
+	var kept []string
+	for _, c := range w.Header().Values("Set-Cookie") {
+		if !strings.HasPrefix(c, cookieName+"=") {
+			kept = append(kept, c)
+		}
+	}
+	w.Header()["Set-Cookie"] = kept
+	a.setUserIDCookie(w, userID)
+}
+
+// ClearUserIDCookie просит браузер забыть cookie пользователя, например после удаления аккаунта.
+func ClearUserIDCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
 		HttpOnly: true,
 	})
 }
 
 // makeSignedValue формирует строку "userID:signature",
-func makeSignedValue(userID string) string {
-	mac := hmac.New(sha256.New, secretKey)
-	_, _ = io.WriteString(mac, userID)
-	signature := hex.EncodeToString(mac.Sum(nil))
-	return userID + ":" + signature
+func (a *Auth) makeSignedValue(userID string) string {
+	return userID + ":" + a.sign(userID)
+}
+
+// SignToken подписывает payload тем же ключом, что и куки: "base64(payload).signature".
+func (a *Auth) SignToken(payload string) string {
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return encoded + "." + a.sign(encoded)
 }
 
-// parseSignedValue вытаскивает userID и проверяет формат
-func parseSignedValue(value string) (string, error) {
-	parts := strings.SplitN(value, ":", 2)
-	if len(parts) != 2 {
-		return "", fmt.Errorf("invalid cookie format")
+// VerifyToken проверяет подпись токена из SignToken и возвращает payload.
+// После RotateSecret ранее выданные токены перестают проходить проверку.
+func (a *Auth) VerifyToken(token string) (string, bool) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(a.sign(encoded))) {
+		return "", false
 	}
-	userID := parts[0]
-	if userID == "" {
-		return "", fmt.Errorf("empty userID")
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", false
 	}
+	return string(payload), true
+}
 
-	// ВНИМFНИЕ!!!ATTENTION
-	// -- Для полноценной проверки подписи в проде раскомментируйте строки ниже: --
-	//
-	// expected := makeSignedValue(userID)
-	// if value != expected {
-	// 	return "", fmt.Errorf("signature mismatch")
-	// }
+// sign — HMAC-SHA256 от data текущим ключом, в hex.
+func (a *Auth) sign(data string) string {
+	a.mu.RLock()
+	mac := hmac.New(sha256.New, a.secret)
+	a.mu.RUnlock()
+	_, _ = io.WriteString(mac, data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
 
-	return userID, nil
+// parseSignedValue вытаскивает userID и время выдачи (нулевое у кук без него), проверяет формат
+// и подпись. signed — подпись верна; неверная подпись — ошибка только при RequireSignature.
+func (a *Auth) parseSignedValue(value string) (userID string, issued time.Time, signed bool, err error) {
+	parts := strings.SplitN(value, ":", 3)
+	if len(parts) < 2 {
+		return "", time.Time{}, false, fmt.Errorf("invalid cookie format")
+	}
+	userID = parts[0]
+	if userID == "" {
+		return "", time.Time{}, false, fmt.Errorf("empty userID")
+	}
+	if len(parts) == 3 {
+		sec, parseErr := strconv.ParseInt(parts[2], 10, 64)
+		if parseErr != nil {
+			return "", time.Time{}, false, fmt.Errorf("invalid cookie issue time")
+		}
+		issued = time.Unix(sec, 0)
+	}
+
+	// Несовпадения считаем и без RequireSignature, чтобы видеть, сколько кук отвалится
+	// при включении проверки.
+	signed = hmac.Equal([]byte(parts[1]), []byte(a.sign(userID)))
+	if !signed {
+		authMetrics.Add(signatureMismatch, 1)
+		if a.strict {
+			return "", time.Time{}, false, fmt.Errorf("signature mismatch")
+		}
+	}
+	return userID, issued, signed, nil
 }
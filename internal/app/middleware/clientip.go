@@ -0,0 +1,119 @@
+// Internal/app/middleware/clientip.go.
+
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ParseTrustedProxies разбирает список сетей через запятую: CIDR или отдельные адреса.
+// Неверные записи пропускаются и перечисляются в ошибке.
+func ParseTrustedProxies(raw string) ([]netip.Prefix, error) {
+	var (
+		prefixes []netip.Prefix
+		errs     []error
+	)
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("trusted proxy %q: %w", item, err))
+				continue
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("trusted proxy %q: %w", item, err))
+			continue
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, errors.Join(errs...)
+}
+
+// ClientIP запоминает IP клиента в контексте запроса. Если соединение пришло от
+// доверенного прокси, IP берётся из X-Forwarded-For (справа налево, первый адрес не из
+// trusted) или из X-Real-IP; от остальных эти заголовки игнорируются, их легко подделать.
+func ClientIP(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := resolveClientIP(r, trusted)
+			ctx := context.WithValue(r.Context(), keyClientIP, ip)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func resolveClientIP(r *http.Request, trusted []netip.Prefix) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	if !isTrusted(peer, trusted) {
+		return peer
+	}
+
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		client := ""
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, parseErr := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if parseErr != nil {
+				// Мусор в цепочке: дальше левее доверять нечему.
+				break
+			}
+			client = addr.Unmap().String()
+			if !isTrusted(client, trusted) {
+				return client
+			}
+		}
+		if client != "" {
+			return client
+		}
+	}
+	if realIP, parseErr := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); parseErr == nil {
+		return realIP.Unmap().String()
+	}
+	return peer
+}
+
+func isTrusted(ip string, trusted []netip.Prefix) bool {
+	if len(trusted) == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithClientIP кладёт IP клиента в контекст, например для задания, выполняемого после ответа.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, keyClientIP, ip)
+}
+
+// GetClientIP достаёт IP клиента из контекста.
+func GetClientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(keyClientIP).(string)
+	return ip
+}
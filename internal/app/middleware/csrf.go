@@ -0,0 +1,96 @@
+// Internal/app/middleware/csrf.go.
+
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+const (
+	csrfCookieName = "csrf_token"
+	// CSRFHeader и CSRFField — где браузер возвращает токен: заголовок для fetch, поле для форм.
+	CSRFHeader = "X-CSRF-Token"
+	CSRFField  = "csrf_token"
+)
+
+type csrfKey struct{}
+
+// CSRF защищает запросы с кукой пользователя от подделки с чужих сайтов по схеме
+// double-submit: токен лежит в куке csrf_token (SameSite=Strict, доступна скрипту),
+// и изменяющий запрос должен повторить его в заголовке X-CSRF-Token или поле csrf_token.
+//
+// Без проверки проходят безопасные методы, запросы с "Authorization: Bearer" и запросы
+// без Origin и Sec-Fetch-Site: браузеры шлют хотя бы один из них на каждый POST/DELETE,
+// значит, это API-клиент, а не страница, которую открыл пользователь.
+// enabled=false выключает проверку, токен при этом всё равно выдаётся.
+func CSRF(enabled bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := ""
+			if c, err := r.Cookie(csrfCookieName); err == nil && validCSRFToken(c.Value) {
+				token = c.Value
+			} else {
+				token = newCSRFToken()
+				http.SetCookie(w, &http.Cookie{
+					Name:     csrfCookieName,
+					Value:    token,
+					Path:     "/",
+					MaxAge:   365 * 24 * 60 * 60,
+					SameSite: http.SameSiteStrictMode,
+				})
+			}
+
+			if enabled && needsCSRFCheck(r) && !csrfTokenMatches(r, token) {
+				Problem(w, r, "invalid CSRF token", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfKey{}, token)))
+		})
+	}
+}
+
+// CSRFToken — токен текущего запроса для страниц и форм; пусто вне CSRF.
+func CSRFToken(ctx context.Context) string {
+	token, _ := ctx.Value(csrfKey{}).(string)
+	return token
+}
+
+func needsCSRFCheck(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		return false
+	}
+	return r.Header.Get("Origin") != "" || r.Header.Get("Sec-Fetch-Site") != ""
+}
+
+// csrfTokenMatches сравнивает токен из заголовка или формы с кукой. Кука, выданная
+// в этом же ответе, не совпадёт ни с чем: браузер её ещё не видел.
+func csrfTokenMatches(r *http.Request, token string) bool {
+	got := r.Header.Get(CSRFHeader)
+	if got == "" {
+		got = r.PostFormValue(CSRFField)
+	}
+	return got != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+func newCSRFToken() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func validCSRFToken(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -6,9 +6,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"strings"
+	"sync"
+
+	"github.com/dkolesni-prog/transformer/internal/logging"
 )
 
 const (
@@ -17,18 +19,41 @@ const (
 	gzipEncoding          = "gzip"
 )
 
+// gzipWriters переиспользует gzip.Writer: каждый новый выделяет сотни килобайт под словарь.
+var gzipWriters = sync.Pool{
+	New: func() any {
+		zw, _ := gzip.NewWriterLevel(io.Discard, gzip.BestCompression)
+		return zw
+	},
+}
+
+// compressWriter сжимает только успешные ответы: редиректы и ошибки уходят как есть,
+// и на самом частом запросе, GET /{id}, gzip.Writer даже не берётся из пула.
 type compressWriter struct {
 	w  http.ResponseWriter
 	zw *gzip.Writer
+	// wroteHeader — статус уже отправлен; zw != nil — тело сжимается.
+	wroteHeader bool
+	// in — сколько отдал обработчик, out — сколько ушло клиенту после сжатия.
+	in     int64
+	out    countingWriter
+	logger logging.Logger
 }
 
-func newCompressWriter(w http.ResponseWriter) *compressWriter {
-	zw, err := gzip.NewWriterLevel(w, gzip.BestCompression)
-	if err != nil {
-		Log.Error().Err(err).Msg("Failed to create gzip writer")
-		return nil
-	}
-	return &compressWriter{w: w, zw: zw}
+func newCompressWriter(w http.ResponseWriter, logger logging.Logger) *compressWriter {
+	return &compressWriter{w: w, out: countingWriter{w: w}, logger: logger}
+}
+
+// countingWriter считает байты, записанные в w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
 }
 
 func (c *compressWriter) Header() http.Header {
@@ -36,52 +61,80 @@ func (c *compressWriter) Header() http.Header {
 }
 
 func (c *compressWriter) Write(p []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.zw == nil {
+		return c.w.Write(p)
+	}
 	n, err := c.zw.Write(p)
+	c.in += int64(n)
 	if err != nil {
-		Log.Error().Err(err).Msg("Failed to write to gzip writer")
+		c.logger.Error("Failed to write to gzip writer", "error", err)
 		return n, fmt.Errorf("compressWriter write: %w", err)
 	}
-	log.Printf("[compressWriter] wrote %d bytes to gzip (err=%v)\n", n, err)
 	return n, nil
 }
 
 func (c *compressWriter) WriteHeader(statusCode int) {
-	c.w.Header().Del("Content-Length")
-	if statusCode < http.StatusMultipleChoices {
-		log.Println("Check if entered WriteHeader")
+	if c.wroteHeader {
+		return
+	}
+	c.wroteHeader = true
+	if statusCode >= http.StatusOK && statusCode < http.StatusMultipleChoices && statusCode != http.StatusNoContent {
+		c.w.Header().Del("Content-Length")
 		c.w.Header().Set(contentEncodingHeader, gzipEncoding)
+		c.zw = gzipWriters.Get().(*gzip.Writer)
+		c.zw.Reset(&c.out)
 	}
 	c.w.WriteHeader(statusCode)
 }
 
+// FlushError выталкивает сжатые данные клиенту; его вызывает http.ResponseController.Flush.
+func (c *compressWriter) FlushError() error {
+	if c.zw != nil {
+		if err := c.zw.Flush(); err != nil {
+			return fmt.Errorf("flushing gzip writer: %w", err)
+		}
+	}
+	return http.NewResponseController(c.w).Flush()
+}
+
+// Close дописывает сжатый поток и возвращает gzip.Writer в пул.
 func (c *compressWriter) Close() error {
-	if err := c.zw.Close(); err != nil {
-		Log.Error().Err(err).Msg("Failed to close gzip writer")
-		log.Printf("[compressWriter] Close() returned: %v\n", err)
+	if c.zw == nil {
+		return nil
+	}
+	zw := c.zw
+	c.zw = nil
+	defer gzipWriters.Put(zw)
+	if err := zw.Close(); err != nil {
+		c.logger.Error("Failed to close gzip writer", "error", err)
 		return fmt.Errorf("closing gzip writer: %w", err)
 	}
-	log.Printf("[compressWriter] Close() closed ok\n")
+	observeGzip(c.in, c.out.n)
 	return nil
 }
 
 type compressReader struct {
-	r  io.ReadCloser
-	zr *gzip.Reader
+	r      io.ReadCloser
+	zr     *gzip.Reader
+	logger logging.Logger
 }
 
-func newCompressReader(r io.ReadCloser) (*compressReader, error) {
+func newCompressReader(r io.ReadCloser, logger logging.Logger) (*compressReader, error) {
 	zr, err := gzip.NewReader(r)
 	if err != nil {
-		Log.Error().Err(err).Msg("Failed to create gzip reader")
+		logger.Error("Failed to create gzip reader", "error", err)
 		return nil, fmt.Errorf("creating gzip reader: %w", err)
 	}
-	return &compressReader{r: r, zr: zr}, nil
+	return &compressReader{r: r, zr: zr, logger: logger}, nil
 }
 
 func (c *compressReader) Read(p []byte) (int, error) {
 	n, err := c.zr.Read(p)
 	if err != nil && !errors.Is(err, io.EOF) {
-		Log.Error().Err(err).Msg("Failed to read from gzip reader")
+		c.logger.Error("Failed to read from gzip reader", "error", err)
 		return n, fmt.Errorf("reading gzip data: %w", err)
 	}
 	return n, err
@@ -89,12 +142,11 @@ func (c *compressReader) Read(p []byte) (int, error) {
 
 func (c *compressReader) Close() error {
 	if err := c.zr.Close(); err != nil {
-		Log.Error().Err(err).Msg("Failed to close gzip reader")
+		c.logger.Error("Failed to close gzip reader", "error", err)
 		return fmt.Errorf("closing gzip reader: %w", err)
 	}
 	if err := c.r.Close(); err != nil {
-		log.Println("Failed to close underlying reader", err)
-		Log.Error().Err(err).Msg("Failed to close underlying reader")
+		c.logger.Error("Failed to close underlying reader", "error", err)
 		return fmt.Errorf("closing underlying reader: %w", err)
 	}
 	return nil
@@ -103,46 +155,35 @@ func (c *compressReader) Close() error {
 // GzipMiddleware handles both gzip compression (response) and decompression (request).
 func GzipMiddleware(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		Log.Info().
-			Str(acceptEncodingHeader, r.Header.Get(acceptEncodingHeader)).
-			Str(contentEncodingHeader, r.Header.Get(contentEncodingHeader)).
-			Msg("Processing gzip middleware")
-
-		log.Println("accept-encoding", r.Header.Get(acceptEncodingHeader))
-		log.Println("content-encoding", r.Header.Get(contentEncodingHeader))
-		log.Println("endpoint:", r.URL)
-		log.Println("headers:", r.Header)
-
+		logger := logging.FromContext(r.Context())
 		ow := w
 		if strings.Contains(r.Header.Get(acceptEncodingHeader), gzipEncoding) {
-			log.Println("CHANGING TO COMPRESS WRITER")
-			Log.Info().Msg("Client supports gzip encoding; wrapping response writer")
-			cw := newCompressWriter(w)
-			if cw == nil {
-				http.Error(w, "Failed to create gzip writer", http.StatusInternalServerError)
-				return
-			}
+			gzipMetrics.Add(negotiatedGzip, 1)
+			cw := newCompressWriter(w, logger)
 			ow = cw
 			defer func() {
 				if err := cw.Close(); err != nil {
-					Log.Error().Err(err).Msg("Error closing compressWriter")
+					logger.Error("Error closing compressWriter", "error", err)
 				}
 			}()
+		} else {
+			gzipMetrics.Add(negotiatedIdentity, 1)
 		}
 
 		if strings.Contains(r.Header.Get(contentEncodingHeader), gzipEncoding) {
-			Log.Info().Msg("Request body is gzip-encoded; decompressing")
-			cr, err := newCompressReader(r.Body)
-			log.Println("After new:", err)
+			logger.Info("Request body is gzip-encoded; decompressing")
+			cr, err := newCompressReader(r.Body, logger)
 			if err != nil {
-				Log.Error().Err(err).Msg("Failed to create gzip reader for request")
-				http.Error(w, "Invalid gzip stream", http.StatusBadRequest)
+				gzipMetrics.Add(requestGzipInvalid, 1)
+				logger.Error("Failed to create gzip reader for request", "error", err)
+				Problem(w, r, "Invalid gzip stream", http.StatusBadRequest)
 				return
 			}
+			gzipMetrics.Add(requestGzip, 1)
 			r.Body = cr
 			defer func() {
 				if err := cr.Close(); err != nil {
-					Log.Error().Err(err).Msg("Error closing compressReader")
+					logger.Error("Error closing compressReader", "error", err)
 				}
 			}()
 		}
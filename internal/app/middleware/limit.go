@@ -0,0 +1,46 @@
+// Internal/app/middleware/limit.go.
+
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/dkolesni-prog/transformer/internal/logging"
+)
+
+// LimitConcurrency ограничивает число одновременно обрабатываемых запросов: чтений
+// (GET и HEAD, то есть в основном редиректы) не больше maxReads, остальных — не больше
+// maxWrites. Лишние запросы сразу получают 503, не дожидаясь пула соединений с БД.
+// Ноль снимает ограничение.
+func LimitConcurrency(maxReads, maxWrites int) func(http.Handler) http.Handler {
+	reads := newSemaphore(maxReads)
+	writes := newSemaphore(maxWrites)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sem := writes
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				sem = reads
+			}
+			if sem == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+				next.ServeHTTP(w, r)
+			default:
+				logging.FromContext(r.Context()).Warn("Shedding request: too many in flight", "method", r.Method, "uri", r.RequestURI)
+				w.Header().Set("Retry-After", "1")
+				Problem(w, r, "Server is busy", http.StatusServiceUnavailable)
+			}
+		})
+	}
+}
+
+func newSemaphore(size int) chan struct{} {
+	if size <= 0 {
+		return nil
+	}
+	return make(chan struct{}, size)
+}
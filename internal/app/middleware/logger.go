@@ -7,29 +7,85 @@ import (
 	"errors"
 	"io"
 	"net/http"
-	"os"
 	"strconv"
+	"sync"
 	"time"
 
-	"github.com/rs/zerolog"
+	"github.com/dkolesni-prog/transformer/internal/logging"
 )
 
-var Log = zerolog.Nop()
+// maxPooledBuffer — буферы крупнее (после выгрузок и больших ответов) не возвращаются в пул,
+// чтобы один экспорт не держал память до конца жизни процесса.
+const maxPooledBuffer = 64 << 10
 
-func Initialize(level string, version string) {
-	parsedLevel, _ := zerolog.ParseLevel(level)
-	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout}).With().
-		Str("version", version).
-		Timestamp().
-		Logger().Level(parsedLevel)
-	Log = logger
+// logBuffers хранит буферы, в которые WithLogging копирует тела запроса и ответа.
+var logBuffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+func getLogBuffer() *bytes.Buffer {
+	buf := logBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putLogBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBuffer {
+		logBuffers.Put(buf)
+	}
+}
+
+// bodyCapture копит для лога первые limit байт тела, остальное отбрасывает.
+// Write никогда не возвращает ошибку, поэтому годится для io.TeeReader.
+type bodyCapture struct {
+	buf       *bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func newBodyCapture(limit int) *bodyCapture {
+	return &bodyCapture{buf: getLogBuffer(), limit: limit}
+}
+
+func (c *bodyCapture) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := c.limit - c.buf.Len(); room < n {
+		c.truncated = true
+		p = p[:max(room, 0)]
+	}
+	c.buf.Write(p)
+	return n, nil
+}
+
+// String — захваченное тело; обрезанное помечается многоточием.
+func (c *bodyCapture) String() string {
+	if c.truncated {
+		return c.buf.String() + "…"
+	}
+	return c.buf.String()
+}
+
+func (c *bodyCapture) release() {
+	putLogBuffer(c.buf)
 }
 
 type responseWriter struct {
 	http.ResponseWriter
-	buffer     bytes.Buffer
+	// capture == nil — тело ответа не логируется.
+	capture    *bodyCapture
+	streaming  bool
 	statusCode int
 	size       int
+	logger     logging.Logger
+}
+
+// Unwrap даёт http.ResponseController добраться до исходного writer.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// FlushError отмечает ответ потоковым: его тело дальше не копится для лога.
+func (rw *responseWriter) FlushError() error {
+	rw.streaming = true
+	return http.NewResponseController(rw.ResponseWriter).Flush()
 }
 
 func (rw *responseWriter) WriteHeader(code int) {
@@ -40,49 +96,65 @@ func (rw *responseWriter) WriteHeader(code int) {
 func (rw *responseWriter) Write(b []byte) (int, error) {
 	size, err := rw.ResponseWriter.Write(b)
 	if err != nil {
-		Log.Error().Err(err).Msg("Failed to write to the ResponseWriter of rw")
+		rw.logger.Error("Failed to write to the ResponseWriter of rw", "error", err)
 		return size, errors.New("responseWriter.Write to ResponseWriter failed: " + err.Error())
 	}
 	rw.size += size
 
-	_, bufferErr := rw.buffer.Write(b)
-	if bufferErr != nil {
-		Log.Error().Err(bufferErr).Msg("Failed to write to the buffer of rw")
-		return size, errors.New("responseWriter.Write to buffer failed: " + bufferErr.Error())
+	// Потоковые ответы живут долго, копить их тело для лога нельзя.
+	if rw.capture == nil || rw.streaming || rw.Header().Get("Content-Type") == "text/event-stream" {
+		return size, nil
 	}
-
+	_, _ = rw.capture.Write(b[:size])
 	return size, nil
 }
 
-func WithLogging(h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-
-		var requestBody bytes.Buffer
-		if r.Body != nil {
-			tee := io.TeeReader(r.Body, &requestBody)
-			r.Body = io.NopCloser(tee)
-		}
-
-		ww := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
-
-		// обработка запроса.
-		h.ServeHTTP(ww, r)
-
-		duration := time.Since(start)
-
-		Log.Info().
-			Str("uri", r.RequestURI).
-			Str("method", r.Method).
-			Dur("duration", duration).
-			Str("request_body", requestBody.String()).
-			Str("size", strconv.FormatInt(r.ContentLength, 10)).
-			Msg("Запрос получен")
-
-		Log.Info().
-			Int("status", ww.statusCode).
-			Int("size", ww.size).
-			Str("response_body", ww.buffer.String()).
-			Msg("Ответ отправлен")
-	})
+// WithLogging пишет в logger запрос и ответ и кладёт logger в контекст запроса
+// для middleware и обработчиков ниже по цепочке. От тел запроса и ответа в лог
+// попадают первые maxBody байт; maxBody <= 0 — тела не логируются.
+func WithLogging(logger logging.Logger, maxBody int) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			r = r.WithContext(logging.NewContext(r.Context(), logger))
+
+			ww := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK, logger: logger}
+			var requestBody *bodyCapture
+			if maxBody > 0 {
+				requestBody = newBodyCapture(maxBody)
+				defer requestBody.release()
+				if r.Body != nil && r.Body != http.NoBody {
+					r.Body = io.NopCloser(io.TeeReader(r.Body, requestBody))
+				}
+				ww.capture = newBodyCapture(maxBody)
+				defer ww.capture.release()
+			}
+
+			// обработка запроса.
+			h.ServeHTTP(ww, r)
+
+			duration := time.Since(start)
+
+			requestArgs := []any{
+				"uri", r.RequestURI,
+				"request_id", GetRequestID(r.Context()),
+				"method", r.Method,
+				"ip", AnonymizeIP(GetClientIP(r.Context())),
+				"duration", duration,
+				"size", strconv.FormatInt(r.ContentLength, 10),
+			}
+			responseArgs := []any{
+				"status", ww.statusCode,
+				"size", ww.size,
+			}
+			if maxBody > 0 {
+				requestArgs = append(requestArgs, "request_body", requestBody.String())
+				if !ww.streaming {
+					responseArgs = append(responseArgs, "response_body", ww.capture.String())
+				}
+			}
+			logger.Info("Запрос получен", requestArgs...)
+			logger.Info("Ответ отправлен", responseArgs...)
+		})
+	}
 }
@@ -0,0 +1,75 @@
+// Internal/app/middleware/metrics.go.
+
+package middleware
+
+import (
+	"expvar"
+	"net/http"
+	"strconv"
+)
+
+// Метрики middleware публикуются через expvar под именами "gzip" и "auth";
+// MetricsHandler отдаёт их вместе со стандартными memstats.
+var (
+	gzipMetrics = expvar.NewMap("gzip")
+	authMetrics = expvar.NewMap("auth")
+
+	// gzipRatio — отношение сжатого ответа к исходному.
+	gzipRatio = newHistogram(gzipMetrics, "ratio", 0.1, 0.25, 0.5, 0.75, 1)
+)
+
+// Исходы согласования сжатия ответа и запроса.
+const (
+	negotiatedGzip     = "negotiated_gzip"
+	negotiatedIdentity = "negotiated_identity"
+	requestGzip        = "request_gzip"
+	requestGzipInvalid = "request_gzip_invalid"
+)
+
+// Счётчики Auth.Middleware: новые куки по причине выдачи, продлённые, куки с неверной подписью
+// и исходы проверки API-токенов.
+const (
+	cookieIssuedMissing = "cookies_issued_missing"
+	cookieIssuedInvalid = "cookies_issued_invalid"
+	cookieRenewed       = "cookies_renewed"
+	signatureMismatch   = "signature_mismatch"
+	tokenAccepted       = "tokens_accepted"
+	tokenRejected       = "tokens_rejected"
+	tokenForbidden      = "tokens_forbidden"
+)
+
+// MetricsHandler отдаёт все переменные expvar в JSON.
+func MetricsHandler() http.Handler {
+	return expvar.Handler()
+}
+
+// observeGzip учитывает один сжатый ответ.
+func observeGzip(in, out int64) {
+	gzipMetrics.Add("bytes_in", in)
+	gzipMetrics.Add("bytes_out", out)
+	if in > 0 {
+		gzipRatio.observe(float64(out) / float64(in))
+	}
+}
+
+// histogram — накопительные счётчики по верхним границам корзин, как le в Prometheus.
+type histogram struct {
+	bounds []float64
+	m      *expvar.Map
+}
+
+func newHistogram(parent *expvar.Map, name string, bounds ...float64) *histogram {
+	h := &histogram{bounds: bounds, m: new(expvar.Map).Init()}
+	parent.Set(name, h.m)
+	return h
+}
+
+func (h *histogram) observe(v float64) {
+	for _, b := range h.bounds {
+		if v <= b {
+			h.m.Add("le_"+strconv.FormatFloat(b, 'g', -1, 64), 1)
+		}
+	}
+	h.m.Add("le_inf", 1)
+	h.m.AddFloat("sum", v)
+}
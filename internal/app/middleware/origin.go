@@ -0,0 +1,78 @@
+// Internal/app/middleware/origin.go.
+
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// RequestOrigin возвращает схему и хост, по которым клиент обратился к сервису. Если
+// соединение пришло от доверенного прокси, они берутся из Forwarded (первый элемент,
+// RFC 7239) или из X-Forwarded-Proto и X-Forwarded-Host; иначе — из r.TLS и r.Host.
+// Неверные значения из заголовков пропускаются.
+func RequestOrigin(r *http.Request, trusted []netip.Prefix) (scheme, host string) {
+	scheme, host = "http", r.Host
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	if !isTrusted(peer, trusted) {
+		return scheme, host
+	}
+
+	fwdProto, fwdHost := forwardedOrigin(r.Header.Get("Forwarded"))
+	if fwdProto == "" {
+		fwdProto = firstValue(r.Header.Get("X-Forwarded-Proto"))
+	}
+	if fwdHost == "" {
+		fwdHost = firstValue(r.Header.Get("X-Forwarded-Host"))
+	}
+	if p := strings.ToLower(fwdProto); p == "http" || p == "https" {
+		scheme = p
+	}
+	if validHost(fwdHost) {
+		host = fwdHost
+	}
+	return scheme, host
+}
+
+// forwardedOrigin достаёт proto и host из первого элемента заголовка Forwarded: его
+// добавил ближайший к клиенту прокси.
+func forwardedOrigin(header string) (proto, host string) {
+	first, _, _ := strings.Cut(header, ",")
+	for _, pair := range strings.Split(first, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, `"`)
+		switch strings.ToLower(key) {
+		case "proto":
+			proto = value
+		case "host":
+			host = value
+		}
+	}
+	return proto, host
+}
+
+func firstValue(header string) string {
+	first, _, _ := strings.Cut(header, ",")
+	return strings.TrimSpace(first)
+}
+
+// validHost пропускает только "хост[:порт]" без пути, учётных данных и пробелов.
+func validHost(host string) bool {
+	if host == "" || strings.ContainsAny(host, "/\\@?# \t") {
+		return false
+	}
+	u, err := url.Parse("http://" + host)
+	return err == nil && u.Host == host
+}
@@ -0,0 +1,69 @@
+// Internal/app/middleware/problem.go.
+
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ContentTypeProblem — тип тела ошибок API (RFC 7807).
+const ContentTypeProblem = "application/problem+json"
+
+// ProblemDetails — тело ошибки по RFC 7807. Code — машиночитаемая причина
+// (например, destination_private), Extensions — прочие поля ответа.
+type ProblemDetails struct {
+	Type       string
+	Title      string
+	Status     int
+	Detail     string
+	Instance   string
+	RequestID  string
+	Code       string
+	Extensions map[string]any
+}
+
+func (p ProblemDetails) MarshalJSON() ([]byte, error) {
+	body := make(map[string]any, len(p.Extensions)+7)
+	for k, v := range p.Extensions {
+		body[k] = v
+	}
+	body["type"] = p.Type
+	body["title"] = p.Title
+	body["status"] = p.Status
+	for k, v := range map[string]string{"detail": p.Detail, "instance": p.Instance, "request_id": p.RequestID, "code": p.Code} {
+		if v != "" {
+			body[k] = v
+		}
+	}
+	return json.Marshal(body)
+}
+
+// WriteProblem отвечает p как application/problem+json. Незаданные тип, заголовок,
+// instance и ID запроса берутся из статуса и r.
+func WriteProblem(w http.ResponseWriter, r *http.Request, p ProblemDetails) {
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+	if p.RequestID == "" {
+		p.RequestID = GetRequestID(r.Context())
+	}
+	// Как http.Error: тело ответа меняется, длина и кодировка от обработчика ему не подходят.
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", ContentTypeProblem)
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+// Problem — замена http.Error для API: ошибка status с пояснением detail.
+func Problem(w http.ResponseWriter, r *http.Request, detail string, status int) {
+	WriteProblem(w, r, ProblemDetails{Status: status, Detail: detail})
+}
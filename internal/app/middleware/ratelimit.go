@@ -0,0 +1,41 @@
+// Internal/app/middleware/ratelimit.go.
+
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/dkolesni-prog/transformer/internal/logging"
+	"github.com/dkolesni-prog/transformer/internal/ratelimit"
+)
+
+// RateLimit ограничивает частоту запросов с одного IP (см. ClientIP): сверх лимита
+// отвечаем 429 с Retry-After. Если лимитер недоступен (Redis упал), запрос пропускается —
+// ограничение частоты не должно останавливать сервис. nil снимает ограничение.
+func RateLimit(limiter ratelimit.Limiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limiter == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := GetClientIP(r.Context())
+			d, err := limiter.Allow(r.Context(), ip)
+			if err != nil {
+				logging.FromContext(r.Context()).Error("Rate limiter unavailable, letting request through", "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(d.Limit))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(d.Remaining))
+			if !d.Allowed {
+				logging.FromContext(r.Context()).Warn("Rate limit exceeded", "ip", ip, "method", r.Method, "uri", r.RequestURI)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.RetryAfter.Seconds()))))
+				Problem(w, r, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
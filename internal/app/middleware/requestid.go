@@ -0,0 +1,44 @@
+// Internal/app/middleware/requestid.go.
+
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+)
+
+// RequestIDHeader — заголовок с ID запроса в запросе и ответе.
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// validRequestID ограничивает ID, пришедший от клиента или прокси: он попадает в логи и ответы.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// RequestID берёт ID запроса из X-Request-ID (его мог выставить балансировщик) или
+// создаёт новый, кладёт его в контекст и возвращает клиенту в том же заголовке.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// GetRequestID достаёт ID запроса из контекста; пусто вне RequestID.
+func GetRequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
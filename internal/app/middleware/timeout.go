@@ -0,0 +1,135 @@
+// Internal/app/middleware/timeout.go.
+
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dkolesni-prog/transformer/internal/logging"
+)
+
+// Timeout ограничивает обработку запроса сроком d: контекст запроса отменяется, и если
+// обработчик к этому моменту ещё не начал отвечать, клиент сразу получает 504, а поздний
+// ответ отбрасывается. Начатый ответ (например, поток) дописывается до возврата обработчика.
+// d <= 0 отключает ограничение.
+func Timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if d <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+
+			tw := &timeoutWriter{w: w, h: make(http.Header), ctx: ctx}
+			done := make(chan struct{})
+			panicked := make(chan any, 1)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panicked <- p
+					}
+					close(done)
+				}()
+				next.ServeHTTP(tw, r.WithContext(ctx))
+			}()
+
+			select {
+			case <-done:
+				select {
+				case p := <-panicked:
+					panic(p)
+				default:
+				}
+				return
+			case <-ctx.Done():
+			}
+
+			tw.mu.Lock()
+			if tw.wroteHeader {
+				tw.mu.Unlock()
+				<-done
+				return
+			}
+			tw.timedOut = true
+			tw.mu.Unlock()
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				logging.FromContext(r.Context()).Warn("Request timed out", "uri", r.RequestURI, "timeout", d)
+				Problem(w, r, "Request timed out", http.StatusGatewayTimeout)
+			}
+		})
+	}
+}
+
+// timeoutWriter не даёт обработчику писать в ответ после того, как Timeout ответил сам.
+// Заголовки копятся отдельно и попадают в исходный writer вместе со статусом.
+type timeoutWriter struct {
+	w http.ResponseWriter
+	h http.Header
+	// ctx — контекст со сроком: обработчик видит его отмену раньше, чем Timeout успевает
+	// выставить timedOut, поэтому истёкший срок проверяется и при каждой записи.
+	ctx         context.Context
+	mu          sync.Mutex
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.h
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.expiredLocked() || tw.wroteHeader {
+		return
+	}
+	tw.writeHeaderLocked(code)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.expiredLocked() {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !tw.wroteHeader {
+		tw.writeHeaderLocked(http.StatusOK)
+	}
+	return tw.w.Write(b)
+}
+
+// FlushError нужен потоковым ответам; его вызывает http.ResponseController.Flush.
+func (tw *timeoutWriter) FlushError() error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.expiredLocked() {
+		return http.ErrHandlerTimeout
+	}
+	if !tw.wroteHeader {
+		tw.writeHeaderLocked(http.StatusOK)
+	}
+	return http.NewResponseController(tw.w).Flush()
+}
+
+// expiredLocked сообщает, что писать уже нельзя: Timeout ответил сам или срок истёк
+// до начала ответа (тогда ответит Timeout). Вызывается под tw.mu.
+func (tw *timeoutWriter) expiredLocked() bool {
+	if !tw.timedOut && !tw.wroteHeader && errors.Is(tw.ctx.Err(), context.DeadlineExceeded) {
+		tw.timedOut = true
+	}
+	return tw.timedOut
+}
+
+func (tw *timeoutWriter) writeHeaderLocked(code int) {
+	dst := tw.w.Header()
+	for k, v := range tw.h {
+		dst[k] = v
+	}
+	tw.wroteHeader = true
+	tw.w.WriteHeader(code)
+}
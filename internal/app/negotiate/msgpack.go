@@ -0,0 +1,110 @@
+// Internal/app/negotiate/msgpack.go.
+
+package negotiate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+)
+
+// EncodeMsgpack пишет JSON-представление v в MessagePack: целые числа — в самом
+// коротком целом формате, дробные — float64, ключи объектов — по алфавиту.
+func EncodeMsgpack(w io.Writer, v any) error {
+	tree, err := Tree(v)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	writeMsgpack(&buf, tree)
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+func writeMsgpack(b *bytes.Buffer, node any) {
+	switch n := node.(type) {
+	case nil:
+		b.WriteByte(0xc0)
+	case bool:
+		if n {
+			b.WriteByte(0xc3)
+		} else {
+			b.WriteByte(0xc2)
+		}
+	case json.Number:
+		if i, err := n.Int64(); err == nil {
+			writeMsgpackInt(b, i)
+			return
+		}
+		f, _ := n.Float64()
+		b.WriteByte(0xcb)
+		_ = binary.Write(b, binary.BigEndian, math.Float64bits(f))
+	case string:
+		writeMsgpackHeader(b, len(n), 0xa0, 32, 0xd9, 0xda, 0xdb)
+		b.WriteString(n)
+	case []any:
+		writeMsgpackHeader(b, len(n), 0x90, 16, 0, 0xdc, 0xdd)
+		for _, item := range n {
+			writeMsgpack(b, item)
+		}
+	case map[string]any:
+		writeMsgpackHeader(b, len(n), 0x80, 16, 0, 0xde, 0xdf)
+		for _, k := range sortedKeys(n) {
+			writeMsgpack(b, k)
+			writeMsgpack(b, n[k])
+		}
+	}
+}
+
+// writeMsgpackHeader пишет длину: в самом байте типа fix, если она меньше fixLimit,
+// иначе с 8-, 16- или 32-битной длиной (code8 == 0 — у типа нет 8-битного варианта).
+func writeMsgpackHeader(b *bytes.Buffer, n int, fix byte, fixLimit int, code8, code16, code32 byte) {
+	switch {
+	case n < fixLimit:
+		b.WriteByte(fix | byte(n))
+	case code8 != 0 && n <= math.MaxUint8:
+		b.WriteByte(code8)
+		b.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		b.WriteByte(code16)
+		_ = binary.Write(b, binary.BigEndian, uint16(n))
+	default:
+		b.WriteByte(code32)
+		_ = binary.Write(b, binary.BigEndian, uint32(n))
+	}
+}
+
+func writeMsgpackInt(b *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i < 128:
+		b.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		b.WriteByte(byte(int8(i)))
+	case i >= 0 && i <= math.MaxUint8:
+		b.WriteByte(0xcc)
+		b.WriteByte(byte(i))
+	case i >= 0 && i <= math.MaxUint16:
+		b.WriteByte(0xcd)
+		_ = binary.Write(b, binary.BigEndian, uint16(i))
+	case i >= 0 && i <= math.MaxUint32:
+		b.WriteByte(0xce)
+		_ = binary.Write(b, binary.BigEndian, uint32(i))
+	case i > 0:
+		b.WriteByte(0xcf)
+		_ = binary.Write(b, binary.BigEndian, uint64(i))
+	case i >= math.MinInt8:
+		b.WriteByte(0xd0)
+		_ = binary.Write(b, binary.BigEndian, int8(i))
+	case i >= math.MinInt16:
+		b.WriteByte(0xd1)
+		_ = binary.Write(b, binary.BigEndian, int16(i))
+	case i >= math.MinInt32:
+		b.WriteByte(0xd2)
+		_ = binary.Write(b, binary.BigEndian, int32(i))
+	default:
+		b.WriteByte(0xd3)
+		_ = binary.Write(b, binary.BigEndian, i)
+	}
+}
@@ -0,0 +1,165 @@
+// Internal/app/negotiate/negotiate.go.
+
+// Package negotiate выбирает формат ответа API по заголовку Accept.
+package negotiate
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Encoder пишет значение в своём формате. Поля называются так же, как в JSON:
+// форматы кроме JSON строятся из JSON-представления значения (см. Tree).
+type Encoder interface {
+	Encode(w io.Writer, v any) error
+}
+
+// EncoderFunc позволяет зарегистрировать функцию как Encoder.
+type EncoderFunc func(w io.Writer, v any) error
+
+func (f EncoderFunc) Encode(w io.Writer, v any) error {
+	return f(w, v)
+}
+
+// Format — кодировщик и Content-Type его ответа.
+type Format struct {
+	ContentType string
+	Encoder     Encoder
+}
+
+// Registry — форматы по MIME-типу. Первый зарегистрированный отдаётся, когда клиент
+// не прислал Accept или не принимает ни один из известных форматов.
+type Registry struct {
+	mu      sync.RWMutex
+	types   []string
+	formats map[string]Format
+}
+
+func NewRegistry() *Registry {
+	return &Registry{formats: make(map[string]Format)}
+}
+
+// Register добавляет формат или заменяет уже известный. Пустой ContentType — сам mediaType.
+func (r *Registry) Register(mediaType string, f Format) {
+	mediaType = strings.ToLower(mediaType)
+	if f.ContentType == "" {
+		f.ContentType = mediaType
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.formats[mediaType]; !ok {
+		r.types = append(r.types, mediaType)
+	}
+	r.formats[mediaType] = f
+}
+
+// Negotiate выбирает формат по Accept: наибольший q, при равных — порядок в заголовке.
+// "*/*" и "type/*" дают первый подходящий зарегистрированный формат.
+func (r *Registry) Negotiate(accept string) Format {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	best, bestQ := "", 0.0
+	for _, rng := range parseAccept(accept) {
+		if rng.q <= bestQ {
+			continue
+		}
+		if mediaType := r.match(rng.mediaType); mediaType != "" {
+			best, bestQ = mediaType, rng.q
+		}
+	}
+	if best == "" && len(r.types) > 0 {
+		best = r.types[0]
+	}
+	return r.formats[best]
+}
+
+func (r *Registry) match(pattern string) string {
+	if _, ok := r.formats[pattern]; ok {
+		return pattern
+	}
+	prefix, ok := strings.CutSuffix(pattern, "*")
+	if !ok {
+		return ""
+	}
+	for _, t := range r.types {
+		if strings.HasPrefix(t, prefix) {
+			return t
+		}
+	}
+	return ""
+}
+
+type mediaRange struct {
+	mediaType string
+	q         float64
+}
+
+func parseAccept(accept string) []mediaRange {
+	var out []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if raw, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(raw, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			out = append(out, mediaRange{mediaType: mediaType, q: q})
+		}
+	}
+	return out
+}
+
+// Default — форматы API: JSON (по умолчанию), XML и MessagePack.
+var Default = newDefault()
+
+func newDefault() *Registry {
+	r := NewRegistry()
+	jsonEnc := EncoderFunc(func(w io.Writer, v any) error {
+		return json.NewEncoder(w).Encode(v)
+	})
+	r.Register("application/json", Format{ContentType: "application/json; charset=utf-8", Encoder: jsonEnc})
+	r.Register("application/xml", Format{ContentType: "application/xml; charset=utf-8", Encoder: EncoderFunc(EncodeXML)})
+	r.Register("text/xml", Format{ContentType: "text/xml; charset=utf-8", Encoder: EncoderFunc(EncodeXML)})
+	r.Register("application/msgpack", Format{Encoder: EncoderFunc(EncodeMsgpack)})
+	r.Register("application/x-msgpack", Format{Encoder: EncoderFunc(EncodeMsgpack)})
+	return r
+}
+
+// Register добавляет формат в Default.
+func Register(mediaType string, f Format) {
+	Default.Register(mediaType, f)
+}
+
+// Tree переводит v в JSON-представление: map[string]any, []any, string, json.Number, bool или nil.
+func Tree(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.UseNumber()
+	var tree any
+	if err := dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,63 @@
+// Internal/app/negotiate/xml.go.
+
+package negotiate
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var xmlName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9._-]*$`)
+
+// EncodeXML пишет v в корневой элемент <response>: поле объекта становится элементом
+// с тем же именем (ключ, не годный в имя, — <entry key="...">), элемент массива — <item>.
+func EncodeXML(w io.Writer, v any) error {
+	tree, err := Tree(v)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	_, _ = bw.WriteString(xml.Header)
+	writeXML(bw, "response", "", tree)
+	_ = bw.WriteByte('\n')
+	return bw.Flush()
+}
+
+func writeXML(w *bufio.Writer, name, key string, node any) {
+	_, _ = w.WriteString("<" + name)
+	if key != "" {
+		_, _ = w.WriteString(` key="`)
+		_ = xml.EscapeText(w, []byte(key))
+		_ = w.WriteByte('"')
+	}
+	_ = w.WriteByte('>')
+	switch n := node.(type) {
+	case map[string]any:
+		for _, k := range sortedKeys(n) {
+			if xmlName.MatchString(k) && !strings.HasPrefix(strings.ToLower(k), "xml") {
+				writeXML(w, k, "", n[k])
+			} else {
+				writeXML(w, "entry", k, n[k])
+			}
+		}
+	case []any:
+		for _, item := range n {
+			writeXML(w, "item", "", item)
+		}
+	case string:
+		_ = xml.EscapeText(w, []byte(n))
+	case json.Number:
+		_, _ = w.WriteString(n.String())
+	case bool:
+		if n {
+			_, _ = w.WriteString("true")
+		} else {
+			_, _ = w.WriteString("false")
+		}
+	}
+	_, _ = w.WriteString("</" + name + ">")
+}
@@ -0,0 +1,73 @@
+// Internal/audit/audit.go.
+
+package audit
+
+import (
+	"context"
+	"time"
+)
+
+// Action — тип изменяющей операции над короткой ссылкой.
+type Action string
+
+const (
+	ActionCreate   Action = "create"
+	ActionDelete   Action = "delete"
+	ActionUpdate   Action = "update"
+	ActionTransfer Action = "transfer"
+	ActionRestore  Action = "restore"
+	// ActionMeta — изменение настроек ссылки (пароль, варианты, таргетинг).
+	ActionMeta Action = "meta"
+	// ActionErase — ссылка удалена окончательно вместе с аккаунтом; автор в событии не хранится.
+	ActionErase Action = "erase"
+)
+
+// Event — одна запись аудита: кто, откуда, когда и что сделал с shortID.
+type Event struct {
+	Time        time.Time `json:"time"`
+	Action      Action    `json:"action"`
+	UserID      string    `json:"user_id"`
+	IP          string    `json:"ip"`
+	ShortID     string    `json:"short_id"`
+	OriginalURL string    `json:"original_url,omitempty"`
+	// TargetUserID — новый владелец при передаче ссылки.
+	TargetUserID string `json:"target_user_id,omitempty"`
+}
+
+// Filter ограничивает выборку из журнала. Пустые поля не фильтруют.
+type Filter struct {
+	Since   time.Time
+	UserID  string
+	ShortID string
+	Action  Action
+	Limit   int
+}
+
+// Log — приёмник аудита (append-only файл или таблица в БД).
+type Log interface {
+	Write(ctx context.Context, events ...Event) error
+	Query(ctx context.Context, f Filter) ([]Event, error)
+	// Erase удаляет события, где userID — автор или получатель ссылки, и возвращает их число.
+	Erase(ctx context.Context, userID string) (int, error)
+	Close() error
+}
+
+func (e Event) mentions(userID string) bool {
+	return e.UserID == userID || e.TargetUserID == userID
+}
+
+func (f Filter) match(e Event) bool {
+	if f.UserID != "" && e.UserID != f.UserID {
+		return false
+	}
+	if f.ShortID != "" && e.ShortID != f.ShortID {
+		return false
+	}
+	if f.Action != "" && e.Action != f.Action {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	return true
+}
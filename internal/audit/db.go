@@ -0,0 +1,133 @@
+// Internal/audit/db.go.
+
+package audit
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/dkolesni-prog/transformer/internal/logging"
+)
+
+// DBLog хранит события в таблице audit_log.
+type DBLog struct {
+	pool   *pgxpool.Pool
+	logger logging.Logger
+}
+
+func NewDBLog(pool *pgxpool.Pool, logger logging.Logger) *DBLog {
+	return &DBLog{pool: pool, logger: logger}
+}
+
+// Bootstrap creates the audit table if it doesn't exist.
+func (l *DBLog) Bootstrap(ctx context.Context) error {
+	const schema = `
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    action VARCHAR(16) NOT NULL,
+    user_id VARCHAR(64) NOT NULL,
+    ip VARCHAR(64) NOT NULL,
+    short_id VARCHAR(16) NOT NULL,
+    original_url TEXT NOT NULL DEFAULT '',
+    target_user_id VARCHAR(64) NOT NULL DEFAULT ''
+);
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS target_user_id VARCHAR(64) NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS audit_log_user_id_idx ON audit_log (user_id);
+`
+	if _, execErr := l.pool.Exec(ctx, schema); execErr != nil {
+		l.logger.Error("Could not create audit table", "error", execErr)
+		return errors.New("cannot create audit table: " + execErr.Error())
+	}
+	return nil
+}
+
+func (l *DBLog) Write(ctx context.Context, events ...Event) error {
+	const sqlInsert = `
+INSERT INTO audit_log (created_at, action, user_id, ip, short_id, original_url, target_user_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7);
+`
+	for _, e := range events {
+		if _, execErr := l.pool.Exec(ctx, sqlInsert,
+			e.Time, string(e.Action), e.UserID, e.IP, e.ShortID, e.OriginalURL, e.TargetUserID); execErr != nil {
+			l.logger.Error("Audit insert failed", "error", execErr)
+			return errors.New("audit insert: " + execErr.Error())
+		}
+	}
+	return nil
+}
+
+func (l *DBLog) Query(ctx context.Context, f Filter) ([]Event, error) {
+	var (
+		conds []string
+		args  []any
+	)
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, cond+" $"+strconv.Itoa(len(args)))
+	}
+	if f.UserID != "" {
+		add("user_id =", f.UserID)
+	}
+	if f.ShortID != "" {
+		add("short_id =", f.ShortID)
+	}
+	if f.Action != "" {
+		add("action =", string(f.Action))
+	}
+	if !f.Since.IsZero() {
+		add("created_at >=", f.Since)
+	}
+
+	query := `SELECT created_at, action, user_id, ip, short_id, original_url, target_user_id FROM audit_log`
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY id"
+	if f.Limit > 0 {
+		query += " LIMIT " + strconv.Itoa(f.Limit)
+	}
+
+	rows, queryErr := l.pool.Query(ctx, query, args...)
+	if queryErr != nil {
+		l.logger.Error("Audit query failed", "error", queryErr)
+		return nil, errors.New("audit query: " + queryErr.Error())
+	}
+	defer rows.Close()
+
+	var out []Event
+	for rows.Next() {
+		var e Event
+		var action string
+		if scanErr := rows.Scan(&e.Time, &action, &e.UserID, &e.IP, &e.ShortID, &e.OriginalURL, &e.TargetUserID); scanErr != nil {
+			return nil, errors.New("rows.Scan: " + scanErr.Error())
+		}
+		e.Action = Action(action)
+		out = append(out, e)
+	}
+	if rowsErr := rows.Err(); rowsErr != nil {
+		return nil, errors.New("rows.Err: " + rowsErr.Error())
+	}
+	return out, nil
+}
+
+// Erase удаляет события, где userID — автор или получатель ссылки, одним DELETE.
+func (l *DBLog) Erase(ctx context.Context, userID string) (int, error) {
+	const sqlDelete = `DELETE FROM audit_log WHERE user_id = $1 OR target_user_id = $1;`
+
+	tag, execErr := l.pool.Exec(ctx, sqlDelete, userID)
+	if execErr != nil {
+		l.logger.Error("Audit erase failed", "error", execErr)
+		return 0, errors.New("audit erase: " + execErr.Error())
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// Close is a no-op: the pool is owned by the RDB store.
+func (l *DBLog) Close() error {
+	return nil
+}
@@ -0,0 +1,144 @@
+// Internal/audit/file.go.
+
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/dkolesni-prog/transformer/internal/logging"
+)
+
+// FileLog пишет события построчно в JSON в append-only файл.
+type FileLog struct {
+	mu     sync.Mutex
+	file   *os.File
+	path   string
+	logger logging.Logger
+}
+
+func NewFileLog(path string, logger logging.Logger) (*FileLog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open audit file: %w", err)
+	}
+	return &FileLog{file: f, path: path, logger: logger}, nil
+}
+
+func (l *FileLog) Write(ctx context.Context, events ...Event) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("marshal audit event: %w", err)
+		}
+		data = append(data, '\n')
+		if _, wErr := l.file.Write(data); wErr != nil {
+			return fmt.Errorf("write audit event: %w", wErr)
+		}
+	}
+	return nil
+}
+
+func (l *FileLog) Query(ctx context.Context, f Filter) ([]Event, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rf, err := os.Open(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open audit file: %w", err)
+	}
+	defer rf.Close()
+
+	var out []Event
+	sc := bufio.NewScanner(rf)
+	for sc.Scan() {
+		var e Event
+		if unmarshalErr := json.Unmarshal(sc.Bytes(), &e); unmarshalErr != nil {
+			l.logger.Error("Error unmarshaling audit line", "error", unmarshalErr)
+			continue
+		}
+		if !f.match(e) {
+			continue
+		}
+		out = append(out, e)
+		if f.Limit > 0 && len(out) >= f.Limit {
+			break
+		}
+	}
+	if scErr := sc.Err(); scErr != nil {
+		return nil, fmt.Errorf("scanner: %w", scErr)
+	}
+	return out, nil
+}
+
+// Erase переписывает файл без событий пользователя: из append-only журнала иначе не удалить.
+func (l *FileLog) Erase(ctx context.Context, userID string) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		return 0, fmt.Errorf("read audit file: %w", err)
+	}
+	var kept bytes.Buffer
+	erased := 0
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		var e Event
+		if len(bytes.TrimSpace(line)) > 0 && json.Unmarshal(line, &e) == nil && e.mentions(userID) {
+			erased++
+			continue
+		}
+		kept.Write(line)
+	}
+	if erased == 0 {
+		return 0, nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".*")
+	if err != nil {
+		return 0, fmt.Errorf("create audit file: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(kept.Bytes()); err != nil {
+		_ = tmp.Close()
+		return 0, fmt.Errorf("write audit file: %w", err)
+	}
+	if err := tmp.Chmod(0o600); err != nil {
+		_ = tmp.Close()
+		return 0, fmt.Errorf("chmod audit file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, fmt.Errorf("close audit file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), l.path); err != nil {
+		return 0, fmt.Errorf("replace audit file: %w", err)
+	}
+
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return erased, fmt.Errorf("reopen audit file: %w", err)
+	}
+	_ = l.file.Close()
+	l.file = f
+	return erased, nil
+}
+
+func (l *FileLog) Close() error {
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("close audit file: %w", err)
+	}
+	return nil
+}
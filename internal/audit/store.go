@@ -0,0 +1,141 @@
+// Internal/audit/store.go.
+
+package audit
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/dkolesni-prog/transformer/internal/app/middleware"
+	"github.com/dkolesni-prog/transformer/internal/config"
+	"github.com/dkolesni-prog/transformer/internal/logging"
+	"github.com/dkolesni-prog/transformer/internal/store"
+)
+
+// Store оборачивает store.Store и пишет в журнал все изменяющие операции.
+type Store struct {
+	store.Store
+	log    Log
+	logger logging.Logger
+}
+
+func NewStore(s store.Store, log Log, logger logging.Logger) *Store {
+	return &Store{Store: s, log: log, logger: logger}
+}
+
+func (s *Store) Save(ctx context.Context, userID string, u *url.URL, meta store.LinkMeta, cfg *config.Config) (string, error) {
+	res, err := s.Store.Save(ctx, userID, u, meta, cfg)
+	if err == nil {
+		s.write(ctx, newEvent(ctx, ActionCreate, userID, store.ShortIDFromURL(res, cfg.BaseURL), u.String()))
+	}
+	return res, err
+}
+
+func (s *Store) SaveBatch(ctx context.Context, userID string, urls []*url.URL, metas []store.LinkMeta, cfg *config.Config) ([]store.SavedURL, error) {
+	res, err := s.Store.SaveBatch(ctx, userID, urls, metas, cfg)
+	if err == nil {
+		events := make([]Event, 0, len(res))
+		for i, saved := range res {
+			if saved.Existing {
+				continue
+			}
+			events = append(events, newEvent(ctx, ActionCreate, userID, store.ShortIDFromURL(saved.ShortURL, cfg.BaseURL), urls[i].String()))
+		}
+		s.write(ctx, events...)
+	}
+	return res, err
+}
+
+func (s *Store) DeleteBatch(ctx context.Context, userID string, shortIDs []string) ([]store.DeleteResult, error) {
+	results, err := s.Store.DeleteBatch(ctx, userID, shortIDs)
+	if err == nil {
+		events := make([]Event, 0, len(results))
+		for _, res := range results {
+			if res.Status == store.Deleted {
+				events = append(events, newEvent(ctx, ActionDelete, userID, res.ShortID, ""))
+			}
+		}
+		if len(events) > 0 {
+			s.write(ctx, events...)
+		}
+	}
+	return results, err
+}
+
+func (s *Store) RestoreBatch(ctx context.Context, userID string, shortIDs []string) ([]string, error) {
+	restored, err := s.Store.RestoreBatch(ctx, userID, shortIDs)
+	if len(restored) > 0 {
+		events := make([]Event, 0, len(restored))
+		for _, sid := range restored {
+			events = append(events, newEvent(ctx, ActionRestore, userID, sid, ""))
+		}
+		s.write(ctx, events...)
+	}
+	return restored, err
+}
+
+func (s *Store) UpdateURL(ctx context.Context, userID, shortID string, u *url.URL) error {
+	err := s.Store.UpdateURL(ctx, userID, shortID, u)
+	if err == nil {
+		s.write(ctx, newEvent(ctx, ActionUpdate, userID, shortID, u.String()))
+	}
+	return err
+}
+
+func (s *Store) TransferOwner(ctx context.Context, fromUserID, shortID, toUserID string) error {
+	err := s.Store.TransferOwner(ctx, fromUserID, shortID, toUserID)
+	if err == nil {
+		e := newEvent(ctx, ActionTransfer, fromUserID, shortID, "")
+		e.TargetUserID = toUserID
+		s.write(ctx, e)
+	}
+	return err
+}
+
+func (s *Store) SetMeta(ctx context.Context, userID, shortID string, meta store.LinkMeta) error {
+	err := s.Store.SetMeta(ctx, userID, shortID, meta)
+	if err == nil {
+		s.write(ctx, newEvent(ctx, ActionMeta, userID, shortID, ""))
+	}
+	return err
+}
+
+// EraseUser пишет по событию на каждую стёртую ссылку без userID: журнал пользователя
+// чистится следом (Log.Erase), а факт удаления должен остаться.
+func (s *Store) EraseUser(ctx context.Context, userID string) ([]string, error) {
+	erased, err := s.Store.EraseUser(ctx, userID)
+	if len(erased) > 0 {
+		events := make([]Event, 0, len(erased))
+		for _, sid := range erased {
+			events = append(events, newEvent(ctx, ActionErase, "", sid, ""))
+		}
+		s.write(ctx, events...)
+	}
+	return erased, err
+}
+
+func (s *Store) Close(ctx context.Context) error {
+	if err := s.log.Close(); err != nil {
+		s.logger.Error("Could not close audit log", "error", err)
+	}
+	return s.Store.Close(ctx)
+}
+
+// write не роняет основную операцию: ошибка аудита только логируется.
+func (s *Store) write(ctx context.Context, events ...Event) {
+	if err := s.log.Write(ctx, events...); err != nil {
+		s.logger.Error("Could not write audit events", "error", err)
+	}
+}
+
+func newEvent(ctx context.Context, action Action, userID, shortID, originalURL string) Event {
+	return Event{
+		Time:        time.Now().UTC(),
+		Action:      action,
+		UserID:      userID,
+		IP:          middleware.AnonymizeIP(middleware.GetClientIP(ctx)),
+		ShortID:     shortID,
+		OriginalURL: originalURL,
+	}
+}
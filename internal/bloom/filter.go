@@ -0,0 +1,56 @@
+// Internal/bloom/filter.go.
+
+// Package bloom отвечает «такой ссылки нет» без обращения к хранилищу: сканеры перебирают
+// случайные shortID, и без фильтра каждый такой запрос стоил бы запроса к БД.
+package bloom
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// Filter — фильтр Блума: Test может ошибиться в сторону «есть», но не «нет».
+// Не потокобезопасен, синхронизацию обеспечивает Store.
+type Filter struct {
+	bits []uint64
+	m    uint64
+	k    uint64
+}
+
+// New рассчитывает фильтр на n элементов с долей ложных срабатываний fpRate.
+func New(n int, fpRate float64) *Filter {
+	n = max(n, 1)
+	m := uint64(math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	m = max(m, 64)
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	return &Filter{bits: make([]uint64, (m+63)/64), m: m, k: max(k, 1)}
+}
+
+// Add добавляет id.
+func (f *Filter) Add(id string) {
+	h1, h2 := hashes(id)
+	for i := range f.k {
+		bit := (h1 + i*h2) % f.m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// Test сообщает, мог ли id быть добавлен.
+func (f *Filter) Test(id string) bool {
+	h1, h2 := hashes(id)
+	for i := range f.k {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// hashes делит FNV-1a на два хэша для схемы h1 + i*h2 (Kirsch–Mitzenmacher).
+func hashes(id string) (uint64, uint64) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(id))
+	sum := h.Sum64()
+	return sum & 0xffffffff, sum>>32 | 1
+}
@@ -0,0 +1,171 @@
+// Internal/bloom/store.go.
+
+package bloom
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/dkolesni-prog/transformer/internal/config"
+	"github.com/dkolesni-prog/transformer/internal/logging"
+	"github.com/dkolesni-prog/transformer/internal/store"
+)
+
+const (
+	// fpRate — доля несуществующих id, которые всё же дойдут до хранилища.
+	fpRate = 0.01
+	// headroom — во сколько раз фильтр больше числа ссылок при пересборке, чтобы новые
+	// ссылки до следующей пересборки не поднимали долю ложных срабатываний.
+	headroom    = 2
+	minCapacity = 1 << 16
+)
+
+// metrics — "negatives" (ответили 404 без хранилища), "rebuilds" и "links" (в последней
+// пересборке). Публикуются через expvar под именем "bloom".
+var metrics = expvar.NewMap("bloom")
+
+// Store отвечает ErrNotFound на LoadFull без обращения к хранилищу, если id нет в фильтре.
+// Фильтр собирается из ExportRecords в Start и пополняется сохранениями этого процесса;
+// ссылки, созданные другими экземплярами или импортом, он узнаёт при следующей пересборке.
+// До первой сборки все запросы идут в хранилище.
+type Store struct {
+	store.Store
+	logger logging.Logger
+
+	mu       sync.RWMutex
+	filter   *Filter
+	building bool
+	// added — id, сохранённые во время пересборки; попадут и в новый фильтр.
+	added []string
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewStore(s store.Store, logger logging.Logger) *Store {
+	return &Store{Store: s, logger: logger}
+}
+
+// Start собирает фильтр сразу и затем каждые interval в фоне.
+func (s *Store) Start(interval time.Duration) {
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := s.Rebuild(context.Background()); err != nil {
+				s.logger.Error("Could not rebuild bloom filter", "error", err)
+			}
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Rebuild собирает фильтр заново по всем записям, включая удалённые: на них отвечает 410, а не 404.
+func (s *Store) Rebuild(ctx context.Context) error {
+	s.mu.Lock()
+	s.building = true
+	s.added = nil
+	s.mu.Unlock()
+
+	var ids []string
+	err := s.Store.ExportRecords(ctx, func(rec store.Record) error {
+		ids = append(ids, rec.ShortURL)
+		return nil
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.building = false
+	if err != nil {
+		s.added = nil
+		return err
+	}
+	f := New(max(len(ids)*headroom, minCapacity), fpRate)
+	for _, id := range append(ids, s.added...) {
+		f.Add(id)
+	}
+	s.filter = f
+	s.added = nil
+	metrics.Add("rebuilds", 1)
+	metrics.Set("links", intVar(len(ids)))
+	return nil
+}
+
+func intVar(n int) *expvar.Int {
+	v := new(expvar.Int)
+	v.Set(int64(n))
+	return v
+}
+
+func (s *Store) add(ids ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		if s.filter != nil {
+			s.filter.Add(id)
+		}
+		if s.building {
+			s.added = append(s.added, id)
+		}
+	}
+}
+
+func (s *Store) LoadFull(ctx context.Context, shortID string) (*url.URL, bool, error) {
+	s.mu.RLock()
+	absent := s.filter != nil && !s.filter.Test(shortID)
+	s.mu.RUnlock()
+	if absent {
+		metrics.Add("negatives", 1)
+		return nil, false, store.ErrNotFound
+	}
+	return s.Store.LoadFull(ctx, shortID)
+}
+
+func (s *Store) Save(ctx context.Context, userID string, u *url.URL, meta store.LinkMeta, cfg *config.Config) (string, error) {
+	link, err := s.Store.Save(ctx, userID, u, meta, cfg)
+	if err == nil || errors.Is(err, store.ErrConflict) {
+		s.add(store.ShortIDFromURL(link, cfg.BaseURL))
+	}
+	return link, err
+}
+
+func (s *Store) SaveBatch(ctx context.Context, userID string, urls []*url.URL, metas []store.LinkMeta, cfg *config.Config) ([]store.SavedURL, error) {
+	saved, err := s.Store.SaveBatch(ctx, userID, urls, metas, cfg)
+	ids := make([]string, 0, len(saved))
+	for _, item := range saved {
+		ids = append(ids, store.ShortIDFromURL(item.ShortURL, cfg.BaseURL))
+	}
+	s.add(ids...)
+	return saved, err
+}
+
+func (s *Store) ImportRecords(ctx context.Context, records []store.Record) error {
+	err := s.Store.ImportRecords(ctx, records)
+	// Часть записей могла сохраниться и при ошибке; лишние id фильтр не портят.
+	ids := make([]string, 0, len(records))
+	for _, rec := range records {
+		ids = append(ids, rec.ShortURL)
+	}
+	s.add(ids...)
+	return err
+}
+
+// Close останавливает пересборку.
+func (s *Store) Close(ctx context.Context) error {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+	}
+	return s.Store.Close(ctx)
+}
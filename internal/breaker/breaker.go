@@ -0,0 +1,103 @@
+// Internal/breaker/breaker.go.
+
+package breaker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dkolesni-prog/transformer/internal/logging"
+	"github.com/dkolesni-prog/transformer/internal/store"
+)
+
+type state int
+
+const (
+	stateClosed state = iota
+	stateOpen
+	stateHalfOpen
+)
+
+// Breaker размыкается после threshold подряд идущих сбоев и в течение cooldown
+// сразу отвечает store.UnavailableError. После cooldown пропускает один пробный вызов.
+type Breaker struct {
+	mu        sync.Mutex
+	state     state
+	failures  int
+	openedAt  time.Time
+	threshold int
+	cooldown  time.Duration
+	logger    logging.Logger
+}
+
+func New(threshold int, cooldown time.Duration, logger logging.Logger) *Breaker {
+	return &Breaker{threshold: threshold, cooldown: cooldown, logger: logger}
+}
+
+// Do выполняет fn, если цепь не разомкнута, и учитывает результат. Истёкший срок ctx
+// вызывающего — не сбой хранилища: такой результат не учитывается, как и context.Canceled.
+func (b *Breaker) Do(ctx context.Context, fn func() error) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+	err := fn()
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() != nil {
+		b.skip()
+		return err
+	}
+	b.record(err)
+	return err
+}
+
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case stateOpen:
+		left := b.cooldown - time.Since(b.openedAt)
+		if left > 0 {
+			return &store.UnavailableError{RetryAfter: left}
+		}
+		b.state = stateHalfOpen
+		return nil
+	case stateHalfOpen:
+		// Пробный вызов уже в полёте — остальных не пускаем.
+		return &store.UnavailableError{RetryAfter: b.cooldown}
+	default:
+		return nil
+	}
+}
+
+// skip отпускает пробный вызов, результат которого не учитывается: следующий вызов снова станет пробным.
+func (b *Breaker) skip() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == stateHalfOpen {
+		b.state = stateOpen
+	}
+}
+
+func (b *Breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !store.IsFailure(err) {
+		if b.state != stateClosed {
+			b.logger.Info("Circuit breaker closed")
+		}
+		b.state = stateClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == stateHalfOpen || b.failures >= b.threshold {
+		if b.state != stateOpen {
+			b.logger.Warn("Circuit breaker opened", "error", err, "failures", b.failures)
+		}
+		b.state = stateOpen
+		b.openedAt = time.Now()
+	}
+}
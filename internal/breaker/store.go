@@ -0,0 +1,150 @@
+// Internal/breaker/store.go.
+
+package breaker
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/dkolesni-prog/transformer/internal/config"
+	"github.com/dkolesni-prog/transformer/internal/store"
+)
+
+// Store пропускает все обращения к хранилищу через Breaker.
+type Store struct {
+	store.Store
+	breaker *Breaker
+}
+
+func NewStore(s store.Store, b *Breaker) *Store {
+	return &Store{Store: s, breaker: b}
+}
+
+func (s *Store) Save(ctx context.Context, userID string, u *url.URL, meta store.LinkMeta, cfg *config.Config) (string, error) {
+	var res string
+	err := s.breaker.Do(ctx, func() error {
+		var saveErr error
+		res, saveErr = s.Store.Save(ctx, userID, u, meta, cfg)
+		return saveErr
+	})
+	return res, err
+}
+
+func (s *Store) SaveBatch(ctx context.Context, userID string, urls []*url.URL, metas []store.LinkMeta, cfg *config.Config) ([]store.SavedURL, error) {
+	var res []store.SavedURL
+	err := s.breaker.Do(ctx, func() error {
+		var saveErr error
+		res, saveErr = s.Store.SaveBatch(ctx, userID, urls, metas, cfg)
+		return saveErr
+	})
+	return res, err
+}
+
+func (s *Store) LoadFull(ctx context.Context, shortID string) (*url.URL, bool, error) {
+	var (
+		u         *url.URL
+		isDeleted bool
+	)
+	err := s.breaker.Do(ctx, func() error {
+		var loadErr error
+		u, isDeleted, loadErr = s.Store.LoadFull(ctx, shortID)
+		return loadErr
+	})
+	return u, isDeleted, err
+}
+
+func (s *Store) LoadUserURLs(ctx context.Context, userID string, baseURL string) ([]store.UserURL, error) {
+	var res []store.UserURL
+	err := s.breaker.Do(ctx, func() error {
+		var loadErr error
+		res, loadErr = s.Store.LoadUserURLs(ctx, userID, baseURL)
+		return loadErr
+	})
+	return res, err
+}
+
+func (s *Store) DeleteBatch(ctx context.Context, userID string, shortIDs []string) ([]store.DeleteResult, error) {
+	var results []store.DeleteResult
+	err := s.breaker.Do(ctx, func() error {
+		var deleteErr error
+		results, deleteErr = s.Store.DeleteBatch(ctx, userID, shortIDs)
+		return deleteErr
+	})
+	return results, err
+}
+
+func (s *Store) RestoreBatch(ctx context.Context, userID string, shortIDs []string) ([]string, error) {
+	var restored []string
+	err := s.breaker.Do(ctx, func() error {
+		var restoreErr error
+		restored, restoreErr = s.Store.RestoreBatch(ctx, userID, shortIDs)
+		return restoreErr
+	})
+	return restored, err
+}
+
+func (s *Store) UpdateURL(ctx context.Context, userID, shortID string, u *url.URL) error {
+	return s.breaker.Do(ctx, func() error {
+		return s.Store.UpdateURL(ctx, userID, shortID, u)
+	})
+}
+
+func (s *Store) TransferOwner(ctx context.Context, fromUserID, shortID, toUserID string) error {
+	return s.breaker.Do(ctx, func() error {
+		return s.Store.TransferOwner(ctx, fromUserID, shortID, toUserID)
+	})
+}
+
+func (s *Store) EraseUser(ctx context.Context, userID string) ([]string, error) {
+	var erased []string
+	err := s.breaker.Do(ctx, func() error {
+		var eraseErr error
+		erased, eraseErr = s.Store.EraseUser(ctx, userID)
+		return eraseErr
+	})
+	return erased, err
+}
+
+func (s *Store) ImportRecords(ctx context.Context, records []store.Record) error {
+	return s.breaker.Do(ctx, func() error {
+		return s.Store.ImportRecords(ctx, records)
+	})
+}
+
+func (s *Store) ExportRecords(ctx context.Context, fn func(store.Record) error) error {
+	return s.breaker.Do(ctx, func() error {
+		return s.Store.ExportRecords(ctx, fn)
+	})
+}
+
+func (s *Store) LoadOwner(ctx context.Context, shortID string) (string, error) {
+	var userID string
+	err := s.breaker.Do(ctx, func() error {
+		var loadErr error
+		userID, loadErr = s.Store.LoadOwner(ctx, shortID)
+		return loadErr
+	})
+	return userID, err
+}
+
+func (s *Store) LoadMeta(ctx context.Context, shortID string) (store.LinkMeta, error) {
+	var meta store.LinkMeta
+	err := s.breaker.Do(ctx, func() error {
+		var loadErr error
+		meta, loadErr = s.Store.LoadMeta(ctx, shortID)
+		return loadErr
+	})
+	return meta, err
+}
+
+func (s *Store) SetMeta(ctx context.Context, userID, shortID string, meta store.LinkMeta) error {
+	return s.breaker.Do(ctx, func() error {
+		return s.Store.SetMeta(ctx, userID, shortID, meta)
+	})
+}
+
+func (s *Store) Ping(ctx context.Context) error {
+	return s.breaker.Do(ctx, func() error {
+		return s.Store.Ping(ctx)
+	})
+}
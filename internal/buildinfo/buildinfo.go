@@ -0,0 +1,67 @@
+// Internal/buildinfo/buildinfo.go.
+
+// Package buildinfo описывает сборку бинаря: версию, коммит, время сборки и версию Go.
+//
+// Значения задаются при сборке:
+//
+//	go build -ldflags "-X github.com/dkolesni-prog/transformer/internal/buildinfo.Version=v1.2.0 \
+//	  -X github.com/dkolesni-prog/transformer/internal/buildinfo.Commit=$(git rev-parse HEAD) \
+//	  -X github.com/dkolesni-prog/transformer/internal/buildinfo.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Без ldflags они берутся из debug.ReadBuildInfo (версия модуля и данные VCS).
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Задаются через -ldflags "-X ...".
+var (
+	Version string
+	Commit  string
+	Date    string
+)
+
+// devVersion — версия сборки без ldflags и без версии модуля (go build из рабочей копии).
+const devVersion = "dev"
+
+// Info — ответ GET /version в JSON.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	GoVersion string `json:"go_version"`
+	// Modified — сборка из рабочей копии с незакоммиченными изменениями.
+	Modified bool `json:"modified,omitempty"`
+}
+
+// Get собирает Info: значения из ldflags важнее данных debug.ReadBuildInfo.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, BuildTime: Date, GoVersion: runtime.Version()}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.time":
+				if info.BuildTime == "" {
+					info.BuildTime = s.Value
+				}
+			case "vcs.modified":
+				info.Modified = s.Value == "true"
+			}
+		}
+	}
+
+	if info.Version == "" {
+		info.Version = devVersion
+	}
+	return info
+}
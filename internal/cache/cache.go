@@ -0,0 +1,282 @@
+// Internal/cache/cache.go.
+
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"maps"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dkolesni-prog/transformer/internal/logging"
+	"github.com/dkolesni-prog/transformer/internal/store"
+)
+
+// Invalidator рассылает и принимает списки shortID, которые нужно выкинуть из кэша.
+type Invalidator interface {
+	Publish(ctx context.Context, shortIDs []string) error
+	Subscribe(ctx context.Context, evict func(shortIDs []string))
+	Close() error
+}
+
+// entry — закэшированная ссылка: адрес из LoadFull и метаданные из LoadMeta, каждое
+// со своим признаком загрузки. noMeta — LoadMeta ответил store.ErrNotFound.
+type entry struct {
+	shortID   string
+	expires   time.Time
+	url       *url.URL
+	isDeleted bool
+	hasURL    bool
+	meta      store.LinkMeta
+	noMeta    bool
+	hasMeta   bool
+}
+
+// Store — LRU-кэш LoadFull и LoadMeta поверх любого store.Store: переход по ссылке
+// обходится без обращений к хранилищу.
+type Store struct {
+	store.Store
+	mu   sync.Mutex
+	size int
+	ttl  time.Duration
+	// gen растёт при каждой инвалидации. Чтение из хранилища, начатое до неё, могло
+	// получить старое значение, и put его не сохраняет.
+	gen         uint64
+	order       *list.List
+	items       map[string]*list.Element
+	invalidator Invalidator
+	logger      logging.Logger
+	cancel      context.CancelFunc
+}
+
+// NewStore оборачивает s кэшем на size записей, каждая живёт не дольше ttl (0 — без срока);
+// invalidator может быть nil (один инстанс).
+func NewStore(s store.Store, size int, ttl time.Duration, invalidator Invalidator, logger logging.Logger) *Store {
+	c := &Store{
+		Store:       s,
+		size:        size,
+		ttl:         ttl,
+		order:       list.New(),
+		items:       make(map[string]*list.Element, size),
+		invalidator: invalidator,
+		logger:      logger,
+	}
+	if invalidator != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		c.cancel = cancel
+		go invalidator.Subscribe(ctx, c.evict)
+	}
+	return c
+}
+
+func (c *Store) LoadFull(ctx context.Context, shortID string) (*url.URL, bool, error) {
+	e, ok, gen := c.get(shortID)
+	if ok && e.hasURL {
+		return e.url, e.isDeleted, nil
+	}
+
+	u, isDeleted, err := c.Store.LoadFull(ctx, shortID)
+	if err != nil {
+		return u, isDeleted, err
+	}
+	c.put(shortID, gen, func(e *entry) {
+		e.url, e.isDeleted, e.hasURL = u, isDeleted, true
+	})
+	return u, isDeleted, nil
+}
+
+// LoadMeta кэширует и отсутствие метаданных: у большинства ссылок их нет.
+func (c *Store) LoadMeta(ctx context.Context, shortID string) (store.LinkMeta, error) {
+	e, ok, gen := c.get(shortID)
+	if ok && e.hasMeta {
+		if e.noMeta {
+			return store.LinkMeta{}, store.ErrNotFound
+		}
+		return cloneMeta(e.meta), nil
+	}
+
+	meta, err := c.Store.LoadMeta(ctx, shortID)
+	notFound := errors.Is(err, store.ErrNotFound)
+	if err != nil && !notFound {
+		return meta, err
+	}
+	c.put(shortID, gen, func(e *entry) {
+		e.meta, e.noMeta, e.hasMeta = cloneMeta(meta), notFound, true
+	})
+	return meta, err
+}
+
+func (c *Store) SetMeta(ctx context.Context, userID, shortID string, meta store.LinkMeta) error {
+	err := c.Store.SetMeta(ctx, userID, shortID, meta)
+	c.Invalidate(ctx, []string{shortID})
+	return err
+}
+
+// ImportRecords сбрасывает кэш импортированных ссылок: запись могла заменить адрес или метаданные.
+func (c *Store) ImportRecords(ctx context.Context, records []store.Record) error {
+	err := c.Store.ImportRecords(ctx, records)
+	ids := make([]string, 0, len(records))
+	for _, rec := range records {
+		ids = append(ids, rec.ShortURL)
+	}
+	if len(ids) > 0 {
+		c.Invalidate(ctx, ids)
+	}
+	return err
+}
+
+func (c *Store) DeleteBatch(ctx context.Context, userID string, shortIDs []string) ([]store.DeleteResult, error) {
+	results, err := c.Store.DeleteBatch(ctx, userID, shortIDs)
+	c.Invalidate(ctx, shortIDs)
+	return results, err
+}
+
+// RestoreBatch сбрасывает кэш: в нём могла остаться пометка удаления.
+func (c *Store) RestoreBatch(ctx context.Context, userID string, shortIDs []string) ([]string, error) {
+	restored, err := c.Store.RestoreBatch(ctx, userID, shortIDs)
+	if len(restored) > 0 {
+		c.Invalidate(ctx, restored)
+	}
+	return restored, err
+}
+
+func (c *Store) EraseUser(ctx context.Context, userID string) ([]string, error) {
+	erased, err := c.Store.EraseUser(ctx, userID)
+	if len(erased) > 0 {
+		c.Invalidate(ctx, erased)
+	}
+	return erased, err
+}
+
+func (c *Store) UpdateURL(ctx context.Context, userID, shortID string, u *url.URL) error {
+	err := c.Store.UpdateURL(ctx, userID, shortID, u)
+	c.Invalidate(ctx, []string{shortID})
+	return err
+}
+
+// Invalidate выкидывает shortIDs локально и, если настроено, на остальных инстансах.
+func (c *Store) Invalidate(ctx context.Context, shortIDs []string) {
+	c.evict(shortIDs)
+	if c.invalidator == nil {
+		return
+	}
+	if err := c.invalidator.Publish(ctx, shortIDs); err != nil {
+		c.logger.Error("Could not publish cache invalidation", "error", err)
+	}
+}
+
+func (c *Store) Close(ctx context.Context) error {
+	if c.invalidator != nil {
+		c.cancel()
+		if err := c.invalidator.Close(); err != nil {
+			c.logger.Error("Could not close cache invalidator", "error", err)
+		}
+	}
+	return c.Store.Close(ctx)
+}
+
+// HotIDs возвращает до n закэшированных shortID, недавно запрошенные первыми.
+func (c *Store) HotIDs(n int) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ids := make([]string, 0, min(n, c.order.Len()))
+	for el := c.order.Front(); el != nil && len(ids) < n; el = el.Next() {
+		e, _ := el.Value.(*entry)
+		ids = append(ids, e.shortID)
+	}
+	return ids
+}
+
+// Warm загружает в кэш ссылки shortIDs, самые горячие первыми, чтобы после перезапуска
+// они не пошли в хранилище все разом. Лишние сверх ёмкости кэша и ненайденные пропускаются.
+// Возвращает число ссылок, оказавшихся в кэше.
+func (c *Store) Warm(ctx context.Context, shortIDs []string) int {
+	if len(shortIDs) > c.size {
+		shortIDs = shortIDs[:c.size]
+	}
+	warmed := 0
+	// С конца, чтобы самые горячие оказались в начале LRU.
+	for i := len(shortIDs) - 1; i >= 0 && ctx.Err() == nil; i-- {
+		if _, _, err := c.LoadFull(ctx, shortIDs[i]); err == nil {
+			// Переход читает и метаданные; ошибку покажет первый же переход.
+			_, _ = c.LoadMeta(ctx, shortIDs[i])
+			warmed++
+		}
+	}
+	return warmed
+}
+
+// get возвращает копию записи и поднимает её в начало LRU. Просроченная запись удаляется.
+// gen — поколение кэша на момент чтения, его надо передать в put.
+func (c *Store) get(shortID string) (entry, bool, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[shortID]
+	if !ok {
+		return entry{}, false, c.gen
+	}
+	e, _ := el.Value.(*entry)
+	if c.ttl > 0 && time.Now().After(e.expires) {
+		c.order.Remove(el)
+		delete(c.items, shortID)
+		return entry{}, false, c.gen
+	}
+	c.order.MoveToFront(el)
+	return *e, true, c.gen
+}
+
+// put дополняет запись shortID через fill, создавая её при необходимости. Если после get
+// с поколением gen была инвалидация, значение могло устареть и не сохраняется.
+func (c *Store) put(shortID string, gen uint64, fill func(e *entry)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+	if el, ok := c.items[shortID]; ok {
+		e, _ := el.Value.(*entry)
+		fill(e)
+		c.order.MoveToFront(el)
+		return
+	}
+	e := &entry{shortID: shortID, expires: time.Now().Add(c.ttl)}
+	fill(e)
+	c.items[shortID] = c.order.PushFront(e)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		old, _ := c.order.Remove(oldest).(*entry)
+		delete(c.items, old.shortID)
+	}
+}
+
+func (c *Store) evict(shortIDs []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	for _, sid := range shortIDs {
+		if el, ok := c.items[sid]; ok {
+			c.order.Remove(el)
+			delete(c.items, sid)
+		}
+	}
+}
+
+// cloneMeta копирует срезы и карты метаданных, чтобы вызывающий не менял закэшированную запись.
+func cloneMeta(m store.LinkMeta) store.LinkMeta {
+	m.Tags = slices.Clone(m.Tags)
+	m.Variants = slices.Clone(m.Variants)
+	m.Geo = maps.Clone(m.Geo)
+	if m.Devices != nil {
+		devices := *m.Devices
+		m.Devices = &devices
+	}
+	return m
+}
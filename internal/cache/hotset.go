@@ -0,0 +1,62 @@
+// Internal/cache/hotset.go.
+
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LoadHotSet читает shortID, сохранённые SaveHotSet; отсутствующий файл — пустой список.
+func LoadHotSet(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open hot set: %w", err)
+	}
+	defer f.Close()
+
+	var ids []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if id := strings.TrimSpace(scanner.Text()); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read hot set %s: %w", path, err)
+	}
+	return ids, nil
+}
+
+// SaveHotSet записывает shortIDs по одному на строку через временный файл, чтобы
+// падение посреди записи не оставило обрезанный список.
+func SaveHotSet(path string, shortIDs []string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create hot set: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for _, id := range shortIDs {
+		_, _ = w.WriteString(id + "\n")
+	}
+	if err := w.Flush(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write hot set: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close hot set: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replace hot set: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+// Internal/cache/redis.go.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/dkolesni-prog/transformer/internal/logging"
+)
+
+const invalidationChannel = "shortener:cache:invalidate"
+
+// RedisInvalidator рассылает инвалидации через Redis pub/sub.
+type RedisInvalidator struct {
+	client *redis.Client
+	logger logging.Logger
+}
+
+func NewRedisInvalidator(ctx context.Context, addr string, logger logging.Logger) (*RedisInvalidator, error) {
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("redis ping: %w", err)
+	}
+	return &RedisInvalidator{client: client, logger: logger}, nil
+}
+
+func (r *RedisInvalidator) Publish(ctx context.Context, shortIDs []string) error {
+	if len(shortIDs) == 0 {
+		return nil
+	}
+	if err := r.client.Publish(ctx, invalidationChannel, strings.Join(shortIDs, ",")).Err(); err != nil {
+		return fmt.Errorf("redis publish: %w", err)
+	}
+	return nil
+}
+
+// Subscribe блокируется до отмены ctx. Свои же сообщения тоже приходят — повторное
+// удаление из кэша безвредно.
+func (r *RedisInvalidator) Subscribe(ctx context.Context, evict func(shortIDs []string)) {
+	sub := r.client.Subscribe(ctx, invalidationChannel)
+	defer func() {
+		if err := sub.Close(); err != nil {
+			r.logger.Error("Could not close redis subscription", "error", err)
+		}
+	}()
+
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			evict(strings.Split(msg.Payload, ","))
+		}
+	}
+}
+
+func (r *RedisInvalidator) Close() error {
+	if err := r.client.Close(); err != nil {
+		return fmt.Errorf("redis close: %w", err)
+	}
+	return nil
+}
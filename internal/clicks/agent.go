@@ -0,0 +1,110 @@
+// Internal/clicks/agent.go.
+
+package clicks
+
+import (
+	"net/url"
+	"strings"
+)
+
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+
+	// directReferrer — ключ разбивки для переходов без Referer.
+	directReferrer = "direct"
+)
+
+// botMarkers — подстроки User-Agent (в нижнем регистре) краулеров, превью мессенджеров и HTTP-библиотек.
+var botMarkers = []string{
+	"bot", "crawl", "spider", "slurp", "facebookexternalhit", "embedly", "preview",
+	"headlesschrome", "lighthouse", "curl/", "wget/", "python-requests", "python-urllib",
+	"go-http-client", "okhttp", "axios/", "java/", "libwww-perl", "httpclient",
+}
+
+// browserFamilies проверяются по порядку: Edge и Opera содержат "Chrome/", а Chrome — "Safari/".
+var browserFamilies = []struct {
+	marker, family string
+}{
+	{"Edg/", "Edge"},
+	{"OPR/", "Opera"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"YaBrowser/", "Yandex"},
+	{"Firefox/", "Firefox"},
+	{"FxiOS/", "Firefox"},
+	{"CriOS/", "Chrome"},
+	{"Chrome/", "Chrome"},
+	{"Safari/", "Safari"},
+}
+
+// describeClick заполняет производные поля перехода: домен источника, браузер и тип устройства.
+func describeClick(c *Click) {
+	c.Bot = c.Bot || isBot(c.UserAgent)
+	c.RefDomain = referrerDomain(c.Referrer)
+	c.Browser = browserFamily(c.UserAgent)
+	c.Device = DeviceType(c.UserAgent)
+}
+
+// referrerDomain возвращает хост источника без "www.".
+func referrerDomain(ref string) string {
+	if ref == "" {
+		return ""
+	}
+	u, err := url.Parse(ref)
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// isBot распознаёт автоматических клиентов по User-Agent.
+func isBot(ua string) bool {
+	ua = strings.ToLower(ua)
+	for _, m := range botMarkers {
+		if strings.Contains(ua, m) {
+			return true
+		}
+	}
+	return false
+}
+
+func browserFamily(ua string) string {
+	if ua == "" {
+		return ""
+	}
+	for _, b := range browserFamilies {
+		if strings.Contains(ua, b.marker) {
+			return b.family
+		}
+	}
+	return "Other"
+}
+
+// DeviceType относит клиента к desktop, mobile или tablet; пусто для пустого User-Agent.
+func DeviceType(ua string) string {
+	if ua == "" {
+		return ""
+	}
+	switch {
+	case strings.Contains(ua, "iPad"), strings.Contains(ua, "Tablet"),
+		strings.Contains(ua, "Android") && !strings.Contains(ua, "Mobile"):
+		return DeviceTablet
+	case strings.Contains(ua, "Mobi"), strings.Contains(ua, "iPhone"):
+		return DeviceMobile
+	default:
+		return DeviceDesktop
+	}
+}
+
+// MobileOS возвращает "ios" или "android" для соответствующих устройств, иначе пусто.
+func MobileOS(ua string) string {
+	switch {
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"), strings.Contains(ua, "iPod"):
+		return "ios"
+	case strings.Contains(ua, "Android"):
+		return "android"
+	default:
+		return ""
+	}
+}
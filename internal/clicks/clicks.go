@@ -0,0 +1,85 @@
+// Internal/clicks/clicks.go.
+
+// Package clicks записывает переходы по коротким ссылкам и считает по ним статистику.
+package clicks
+
+import (
+	"context"
+	"time"
+)
+
+// Click — один переход по короткой ссылке.
+type Click struct {
+	Time      time.Time `json:"time"`
+	ShortID   string    `json:"short_id"`
+	IP        string    `json:"ip,omitempty"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	// Country — ISO-код страны, Region — ISO 3166-2 вида "DE-BE"; заполняются GeoIP.
+	Country string `json:"country,omitempty"`
+	Region  string `json:"region,omitempty"`
+	// RefDomain, Browser и Device выводятся из Referrer и UserAgent при записи.
+	RefDomain string `json:"referrer_domain,omitempty"`
+	Browser   string `json:"browser,omitempty"`
+	Device    string `json:"device,omitempty"`
+	// Bot — краулер или HEAD-проверка ссылки; в счётчики переходов не входит.
+	Bot bool `json:"bot,omitempty"`
+	// Variant — адрес сплит-теста, на который перенаправлен посетитель.
+	Variant string `json:"variant,omitempty"`
+}
+
+// LinkStats — сводка переходов по одной ссылке за окно.
+// Clicks и разбивки считают только людей, переходы ботов сведены в Bots.
+type LinkStats struct {
+	Clicks    int            `json:"clicks"`
+	Bots      int            `json:"bots"`
+	Countries map[string]int `json:"countries,omitempty"`
+	Regions   map[string]int `json:"regions,omitempty"`
+	// Referrers — по домену источника; переходы без Referer учтены как "direct".
+	Referrers map[string]int `json:"referrers,omitempty"`
+	Browsers  map[string]int `json:"browsers,omitempty"`
+	Devices   map[string]int `json:"devices,omitempty"`
+	Variants  map[string]int `json:"variants,omitempty"`
+}
+
+func (s *LinkStats) add(c Click, n int) {
+	if c.Bot {
+		s.Bots += n
+		return
+	}
+	s.Clicks += n
+	inc(&s.Countries, c.Country, n)
+	inc(&s.Regions, c.Region, n)
+	if c.RefDomain == "" {
+		inc(&s.Referrers, directReferrer, n)
+	} else {
+		inc(&s.Referrers, c.RefDomain, n)
+	}
+	inc(&s.Browsers, c.Browser, n)
+	inc(&s.Devices, c.Device, n)
+	inc(&s.Variants, c.Variant, n)
+}
+
+func inc(m *map[string]int, key string, n int) {
+	if key == "" {
+		return
+	}
+	if *m == nil {
+		*m = make(map[string]int)
+	}
+	(*m)[key] += n
+}
+
+// Log — хранилище переходов (память или таблица clicks в БД).
+type Log interface {
+	Record(ctx context.Context, clicks ...Click) error
+	// Counts возвращает число переходов людей с момента since по каждому из shortIDs; ссылки без переходов отсутствуют.
+	Counts(ctx context.Context, shortIDs []string, since time.Time) (map[string]int, error)
+	Stats(ctx context.Context, shortID string, since time.Time) (LinkStats, error)
+	// Top возвращает до limit ссылок с наибольшим числом переходов людей с момента since,
+	// самые посещаемые первыми.
+	Top(ctx context.Context, limit int, since time.Time) ([]string, error)
+	// Delete удаляет все переходы по shortIDs и возвращает их число.
+	Delete(ctx context.Context, shortIDs []string) (int, error)
+	Close() error
+}
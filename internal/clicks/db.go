@@ -0,0 +1,192 @@
+// Internal/clicks/db.go.
+
+package clicks
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/dkolesni-prog/transformer/internal/logging"
+)
+
+// DBLog хранит переходы в таблице clicks.
+type DBLog struct {
+	pool   *pgxpool.Pool
+	logger logging.Logger
+	// partitionMonths > 0 — clicks секционирована по месяцам, столько месяцев создаётся заранее.
+	partitionMonths int
+	stop            chan struct{}
+	done            chan struct{}
+}
+
+func NewDBLog(pool *pgxpool.Pool, logger logging.Logger) *DBLog {
+	return &DBLog{pool: pool, logger: logger}
+}
+
+// clickColumns — колонки clicks кроме id, общие для обычной и секционированной таблицы.
+const clickColumns = `
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    short_id VARCHAR(16) NOT NULL,
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    referrer TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    country VARCHAR(2) NOT NULL DEFAULT '',
+    region VARCHAR(8) NOT NULL DEFAULT '',
+    referrer_domain VARCHAR(255) NOT NULL DEFAULT '',
+    browser VARCHAR(32) NOT NULL DEFAULT '',
+    device VARCHAR(16) NOT NULL DEFAULT '',
+    bot BOOLEAN NOT NULL DEFAULT FALSE,
+    variant TEXT NOT NULL DEFAULT ''`
+
+// clickUpgrades доводит до текущей схемы таблицы, созданные старыми версиями.
+const clickUpgrades = `
+ALTER TABLE clicks ADD COLUMN IF NOT EXISTS country VARCHAR(2) NOT NULL DEFAULT '';
+ALTER TABLE clicks ADD COLUMN IF NOT EXISTS region VARCHAR(8) NOT NULL DEFAULT '';
+ALTER TABLE clicks ADD COLUMN IF NOT EXISTS referrer_domain VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE clicks ADD COLUMN IF NOT EXISTS browser VARCHAR(32) NOT NULL DEFAULT '';
+ALTER TABLE clicks ADD COLUMN IF NOT EXISTS device VARCHAR(16) NOT NULL DEFAULT '';
+ALTER TABLE clicks ADD COLUMN IF NOT EXISTS bot BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE clicks ADD COLUMN IF NOT EXISTS variant TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS clicks_short_id_created_at_idx ON clicks (short_id, created_at);
+`
+
+// Bootstrap creates the clicks table if it doesn't exist.
+func (l *DBLog) Bootstrap(ctx context.Context) error {
+	if l.partitionMonths > 0 {
+		return l.bootstrapPartitioned(ctx)
+	}
+	schema := `
+CREATE TABLE IF NOT EXISTS clicks (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,` + clickColumns + `
+);` + clickUpgrades
+	if _, execErr := l.pool.Exec(ctx, schema); execErr != nil {
+		l.logger.Error("Could not create clicks table", "error", execErr)
+		return errors.New("cannot create clicks table: " + execErr.Error())
+	}
+	return nil
+}
+
+// Record пишет пачку переходов одним COPY.
+func (l *DBLog) Record(ctx context.Context, clicks ...Click) error {
+	rows := make([][]any, 0, len(clicks))
+	for _, c := range clicks {
+		rows = append(rows, []any{c.Time, c.ShortID, c.IP, c.Referrer, c.UserAgent, c.Country, c.Region, c.RefDomain, c.Browser, c.Device, c.Bot, c.Variant})
+	}
+	_, copyErr := l.pool.CopyFrom(ctx,
+		pgx.Identifier{"clicks"},
+		[]string{"created_at", "short_id", "ip", "referrer", "user_agent", "country", "region", "referrer_domain", "browser", "device", "bot", "variant"},
+		pgx.CopyFromRows(rows))
+	if copyErr != nil {
+		l.logger.Error("Clicks insert failed", "error", copyErr)
+		return errors.New("clicks insert: " + copyErr.Error())
+	}
+	return nil
+}
+
+func (l *DBLog) Counts(ctx context.Context, shortIDs []string, since time.Time) (map[string]int, error) {
+	const sqlSelect = `
+SELECT short_id, count(*)
+FROM clicks
+WHERE short_id = ANY($1)
+  AND created_at >= $2
+  AND NOT bot
+GROUP BY short_id;
+`
+	rows, queryErr := l.pool.Query(ctx, sqlSelect, shortIDs, since)
+	if queryErr != nil {
+		l.logger.Error("Clicks count query failed", "error", queryErr)
+		return nil, errors.New("clicks count: " + queryErr.Error())
+	}
+	defer rows.Close()
+
+	out := make(map[string]int)
+	for rows.Next() {
+		var id string
+		var n int
+		if scanErr := rows.Scan(&id, &n); scanErr != nil {
+			return nil, errors.New("rows.Scan: " + scanErr.Error())
+		}
+		out[id] = n
+	}
+	if rowsErr := rows.Err(); rowsErr != nil {
+		return nil, errors.New("rows.Err: " + rowsErr.Error())
+	}
+	return out, nil
+}
+
+// Stats агрегирует переходы по всем разрезам на стороне БД.
+func (l *DBLog) Stats(ctx context.Context, shortID string, since time.Time) (LinkStats, error) {
+	const sqlSelect = `
+SELECT country, region, referrer_domain, browser, device, bot, variant, count(*)
+FROM clicks
+WHERE short_id = $1
+  AND created_at >= $2
+GROUP BY country, region, referrer_domain, browser, device, bot, variant;
+`
+	rows, queryErr := l.pool.Query(ctx, sqlSelect, shortID, since)
+	if queryErr != nil {
+		l.logger.Error("Clicks stats query failed", "error", queryErr)
+		return LinkStats{}, errors.New("clicks stats: " + queryErr.Error())
+	}
+	defer rows.Close()
+
+	var stats LinkStats
+	for rows.Next() {
+		var c Click
+		var n int
+		if scanErr := rows.Scan(&c.Country, &c.Region, &c.RefDomain, &c.Browser, &c.Device, &c.Bot, &c.Variant, &n); scanErr != nil {
+			return LinkStats{}, errors.New("rows.Scan: " + scanErr.Error())
+		}
+		stats.add(c, n)
+	}
+	if rowsErr := rows.Err(); rowsErr != nil {
+		return LinkStats{}, errors.New("rows.Err: " + rowsErr.Error())
+	}
+	return stats, nil
+}
+
+func (l *DBLog) Top(ctx context.Context, limit int, since time.Time) ([]string, error) {
+	const sqlSelect = `
+SELECT short_id
+FROM clicks
+WHERE created_at >= $1
+  AND NOT bot
+GROUP BY short_id
+ORDER BY count(*) DESC, short_id
+LIMIT $2;
+`
+	rows, queryErr := l.pool.Query(ctx, sqlSelect, since, limit)
+	if queryErr != nil {
+		l.logger.Error("Top links query failed", "error", queryErr)
+		return nil, errors.New("top links: " + queryErr.Error())
+	}
+	ids, collectErr := pgx.CollectRows(rows, pgx.RowTo[string])
+	if collectErr != nil {
+		return nil, errors.New("top links rows: " + collectErr.Error())
+	}
+	return ids, nil
+}
+
+func (l *DBLog) Delete(ctx context.Context, shortIDs []string) (int, error) {
+	const sqlDelete = `DELETE FROM clicks WHERE short_id = ANY($1);`
+
+	tag, execErr := l.pool.Exec(ctx, sqlDelete, shortIDs)
+	if execErr != nil {
+		l.logger.Error("Clicks delete failed", "error", execErr)
+		return 0, errors.New("clicks delete: " + execErr.Error())
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// Close stops partition maintenance; the pool is owned by the RDB store.
+func (l *DBLog) Close() error {
+	if l.stop != nil {
+		close(l.stop)
+		<-l.done
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+// Internal/clicks/geoip.go.
+
+package clicks
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Enricher дополняет переход перед записью. Вызывается в фоне трекера, не на пути редиректа.
+type Enricher interface {
+	Enrich(c *Click)
+}
+
+// Locator определяет страну и регион по IP прямо в запросе, например для гео-редиректов.
+type Locator interface {
+	Locate(ip string) (country, region string)
+}
+
+// GeoIP определяет страну и регион по базе MaxMind GeoIP2/GeoLite2 (City или Country).
+type GeoIP struct {
+	db *maxminddb.Reader
+}
+
+func OpenGeoIP(path string) (*GeoIP, error) {
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open GeoIP database: %w", err)
+	}
+	return &GeoIP{db: db}, nil
+}
+
+type geoRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"subdivisions"`
+}
+
+// Enrich не повторяет поиск, если страну уже определили при редиректе.
+func (g *GeoIP) Enrich(c *Click) {
+	if c.Country != "" {
+		return
+	}
+	c.Country, c.Region = g.Locate(c.IP)
+}
+
+func (g *GeoIP) Locate(ip string) (country, region string) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return "", ""
+	}
+	var rec geoRecord
+	if err := g.db.Lookup(addr, &rec); err != nil {
+		return "", ""
+	}
+	country = rec.Country.ISOCode
+	if country != "" && len(rec.Subdivisions) > 0 && rec.Subdivisions[0].ISOCode != "" {
+		region = country + "-" + rec.Subdivisions[0].ISOCode
+	}
+	return country, region
+}
+
+func (g *GeoIP) Close() error {
+	return g.db.Close()
+}
@@ -0,0 +1,113 @@
+// Internal/clicks/memory.go.
+
+package clicks
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxPerLink — сколько последних переходов по одной ссылке держит MemoryLog.
+const maxPerLink = 10000
+
+// MemoryLog хранит переходы в памяти процесса; используется, когда нет БД.
+type MemoryLog struct {
+	mu     sync.RWMutex
+	byLink map[string][]Click
+}
+
+func NewMemoryLog() *MemoryLog {
+	return &MemoryLog{byLink: make(map[string][]Click)}
+}
+
+func (l *MemoryLog) Record(ctx context.Context, clicks ...Click) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, c := range clicks {
+		list := append(l.byLink[c.ShortID], c)
+		if len(list) > maxPerLink {
+			list = list[len(list)-maxPerLink:]
+		}
+		l.byLink[c.ShortID] = list
+	}
+	return nil
+}
+
+func (l *MemoryLog) Counts(ctx context.Context, shortIDs []string, since time.Time) (map[string]int, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	out := make(map[string]int)
+	for _, id := range shortIDs {
+		n := 0
+		for _, c := range l.byLink[id] {
+			if !c.Bot && !c.Time.Before(since) {
+				n++
+			}
+		}
+		if n > 0 {
+			out[id] = n
+		}
+	}
+	return out, nil
+}
+
+func (l *MemoryLog) Top(ctx context.Context, limit int, since time.Time) ([]string, error) {
+	l.mu.RLock()
+	counts := make(map[string]int)
+	for id, list := range l.byLink {
+		for _, c := range list {
+			if !c.Bot && !c.Time.Before(since) {
+				counts[id]++
+			}
+		}
+	}
+	l.mu.RUnlock()
+
+	ids := make([]string, 0, len(counts))
+	for id := range counts {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if counts[ids[i]] != counts[ids[j]] {
+			return counts[ids[i]] > counts[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids, nil
+}
+
+func (l *MemoryLog) Stats(ctx context.Context, shortID string, since time.Time) (LinkStats, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var stats LinkStats
+	for _, c := range l.byLink[shortID] {
+		if !c.Time.Before(since) {
+			stats.add(c, 1)
+		}
+	}
+	return stats, nil
+}
+
+func (l *MemoryLog) Delete(ctx context.Context, shortIDs []string) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	deleted := 0
+	for _, id := range shortIDs {
+		deleted += len(l.byLink[id])
+		delete(l.byLink, id)
+	}
+	return deleted, nil
+}
+
+func (l *MemoryLog) Close() error {
+	return nil
+}
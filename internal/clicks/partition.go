@@ -0,0 +1,156 @@
+// Internal/clicks/partition.go.
+
+package clicks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Секционированная clicks: по разделу на месяц плюс clicks_default для строк вне всех
+// диапазонов, чтобы запись не падала, если обслуживание отстало (раздел на месяц, строки
+// которого уже лежат в clicks_default, не создастся, пока их не перенести). short_urls не
+// секционируется: уникальность short_id и md5(original_url) потребовала бы включить
+// created_at в ключ.
+
+const partitionedSchema = `
+CREATE SEQUENCE IF NOT EXISTS clicks_partitioned_id_seq;
+CREATE TABLE IF NOT EXISTS clicks (
+    id BIGINT NOT NULL DEFAULT nextval('clicks_partitioned_id_seq'),` + clickColumns + `,
+    PRIMARY KEY (id, created_at)
+) PARTITION BY RANGE (created_at);
+CREATE TABLE IF NOT EXISTS clicks_default PARTITION OF clicks DEFAULT;
+`
+
+// execer — общее у пула и транзакции.
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// WithPartitions включает помесячное секционирование clicks; months — сколько месяцев
+// вперёд держать готовые разделы. Вызывать до Bootstrap.
+func (l *DBLog) WithPartitions(months int) *DBLog {
+	l.partitionMonths = months
+	return l
+}
+
+// bootstrapPartitioned создаёт секционированную clicks. Обычная таблица от прежних версий
+// переносится целиком в одной транзакции: переименовывается, строки копируются в разделы,
+// старая таблица удаляется.
+func (l *DBLog) bootstrapPartitioned(ctx context.Context) error {
+	tx, beginErr := l.pool.Begin(ctx)
+	if beginErr != nil {
+		return errors.New("cannot begin tx: " + beginErr.Error())
+	}
+	// Rollback will be a no-op if Commit succeeds.
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	var kind string
+	kindErr := tx.QueryRow(ctx, `SELECT relkind::text FROM pg_class WHERE oid = to_regclass('clicks');`).Scan(&kind)
+	if kindErr != nil && !errors.Is(kindErr, pgx.ErrNoRows) {
+		return errors.New("cannot inspect clicks table: " + kindErr.Error())
+	}
+
+	from := time.Now().UTC()
+	legacy := kind == "r"
+	if legacy {
+		const rename = clickUpgrades + `
+LOCK TABLE clicks IN EXCLUSIVE MODE;
+ALTER TABLE clicks RENAME TO clicks_unpartitioned;
+ALTER INDEX clicks_short_id_created_at_idx RENAME TO clicks_unpartitioned_short_id_created_at_idx;
+`
+		if _, execErr := tx.Exec(ctx, rename); execErr != nil {
+			l.logger.Error("Could not move old clicks table aside", "error", execErr)
+			return errors.New("cannot rename clicks table: " + execErr.Error())
+		}
+		var oldest *time.Time
+		if scanErr := tx.QueryRow(ctx, `SELECT min(created_at) FROM clicks_unpartitioned;`).Scan(&oldest); scanErr != nil {
+			return errors.New("cannot read oldest click: " + scanErr.Error())
+		}
+		if oldest != nil && oldest.Before(from) {
+			from = *oldest
+		}
+	}
+
+	if _, execErr := tx.Exec(ctx, partitionedSchema+clickUpgrades); execErr != nil {
+		l.logger.Error("Could not create partitioned clicks table", "error", execErr)
+		return errors.New("cannot create clicks table: " + execErr.Error())
+	}
+	if partErr := l.createPartitions(ctx, tx, from, l.partitionMonths); partErr != nil {
+		return partErr
+	}
+
+	if legacy {
+		const move = `
+INSERT INTO clicks (created_at, short_id, ip, referrer, user_agent, country, region, referrer_domain, browser, device, bot, variant)
+SELECT created_at, short_id, ip, referrer, user_agent, country, region, referrer_domain, browser, device, bot, variant
+FROM clicks_unpartitioned;
+DROP TABLE clicks_unpartitioned;
+`
+		if _, execErr := tx.Exec(ctx, move); execErr != nil {
+			l.logger.Error("Could not move clicks into partitions", "error", execErr)
+			return errors.New("cannot move clicks: " + execErr.Error())
+		}
+		l.logger.Info("Converted clicks table to monthly partitions", "oldest", from)
+	}
+
+	if commitErr := tx.Commit(ctx); commitErr != nil {
+		return errors.New("cannot commit tx: " + commitErr.Error())
+	}
+	return nil
+}
+
+// EnsurePartitions создаёт недостающие разделы с текущего месяца на partitionMonths вперёд.
+func (l *DBLog) EnsurePartitions(ctx context.Context) error {
+	return l.createPartitions(ctx, l.pool, time.Now().UTC(), l.partitionMonths)
+}
+
+// createPartitions создаёт разделы clicks_pYYYYMM с месяца from по месяц через ahead от текущего.
+func (l *DBLog) createPartitions(ctx context.Context, db execer, from time.Time, ahead int) error {
+	month := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC)
+	now := time.Now().UTC()
+	last := time.Date(now.Year(), now.Month()+time.Month(ahead), 1, 0, 0, 0, 0, time.UTC)
+	for ; !month.After(last); month = month.AddDate(0, 1, 0) {
+		sql := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS clicks_p%s PARTITION OF clicks FOR VALUES FROM ('%s') TO ('%s');`,
+			month.Format("200601"), month.Format(time.DateOnly), month.AddDate(0, 1, 0).Format(time.DateOnly))
+		if _, execErr := db.Exec(ctx, sql); execErr != nil {
+			l.logger.Error("Could not create clicks partition", "error", execErr, "month", month.Format("2006-01"))
+			return errors.New("cannot create clicks partition: " + execErr.Error())
+		}
+	}
+	return nil
+}
+
+// MaintainPartitions раз в interval досоздаёт разделы на будущие месяцы, пока не вызван Close.
+func (l *DBLog) MaintainPartitions(interval time.Duration) {
+	if l.partitionMonths <= 0 || interval <= 0 || l.stop != nil {
+		return
+	}
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+	go func() {
+		defer close(l.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-l.stop:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+				err := l.EnsurePartitions(ctx)
+				cancel()
+				if err != nil {
+					l.logger.Error("Clicks partition maintenance failed", "error", err)
+				}
+			}
+		}
+	}()
+}
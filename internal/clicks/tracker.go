@@ -0,0 +1,207 @@
+// Internal/clicks/tracker.go.
+
+package clicks
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/dkolesni-prog/transformer/internal/app/middleware"
+	"github.com/dkolesni-prog/transformer/internal/logging"
+)
+
+const (
+	queueSize     = 4096
+	flushSize     = 256
+	flushInterval = time.Second
+	// subscriberBuffer — сколько переходов ждёт медленного подписчика, прежде чем их начнут пропускать.
+	subscriberBuffer = 64
+)
+
+// Tracker копит переходы в очереди и пишет их в Log пачками в фоне,
+// чтобы запись статистики не задерживала редирект.
+// Подписчики получают переходы, прошедшие через этот экземпляр сервиса.
+type Tracker struct {
+	log       Log
+	logger    logging.Logger
+	enrichers []Enricher
+	queue     chan Click
+	wg        sync.WaitGroup
+
+	subsMu sync.Mutex
+	subs   map[string]map[chan Click]struct{}
+
+	// threshold и onThreshold — см. OnThreshold.
+	threshold   int
+	onThreshold func(shortID string, clicks int)
+}
+
+func NewTracker(log Log, logger logging.Logger, enrichers ...Enricher) *Tracker {
+	t := &Tracker{
+		log:       log,
+		logger:    logger,
+		enrichers: enrichers,
+		queue:     make(chan Click, queueSize),
+		subs:      make(map[string]map[chan Click]struct{}),
+	}
+	t.wg.Add(1)
+	go t.loop()
+	return t
+}
+
+// Track ставит переход в очередь и никогда не блокирует запрос. На nil-трекере — no-op.
+func (t *Tracker) Track(c Click) {
+	if t == nil {
+		return
+	}
+	t.publish(c)
+	select {
+	case t.queue <- c:
+	default:
+		t.logger.Warn("Click queue is full, dropping click", "short_id", c.ShortID)
+	}
+}
+
+// Locate определяет страну и регион IP первым обогатителем, который это умеет.
+// Без базы GeoIP (и на nil-трекере) возвращает пустые строки.
+func (t *Tracker) Locate(ip string) (country, region string) {
+	if t == nil {
+		return "", ""
+	}
+	for _, e := range t.enrichers {
+		if l, ok := e.(Locator); ok {
+			return l.Locate(ip)
+		}
+	}
+	return "", ""
+}
+
+// Subscribe возвращает поток переходов по shortID и функцию отписки.
+func (t *Tracker) Subscribe(shortID string) (<-chan Click, func()) {
+	ch := make(chan Click, subscriberBuffer)
+	t.subsMu.Lock()
+	if t.subs[shortID] == nil {
+		t.subs[shortID] = make(map[chan Click]struct{})
+	}
+	t.subs[shortID][ch] = struct{}{}
+	t.subsMu.Unlock()
+
+	return ch, func() {
+		t.subsMu.Lock()
+		defer t.subsMu.Unlock()
+		delete(t.subs[shortID], ch)
+		if len(t.subs[shortID]) == 0 {
+			delete(t.subs, shortID)
+		}
+	}
+}
+
+func (t *Tracker) publish(c Click) {
+	t.subsMu.Lock()
+	defer t.subsMu.Unlock()
+	for ch := range t.subs[c.ShortID] {
+		select {
+		case ch <- c:
+		default:
+		}
+	}
+}
+
+// OnThreshold вызывает fn, когда у ссылки после записи очередной пачки становится не меньше
+// n переходов людей за всё время (по Log, то есть с учётом других экземпляров сервиса).
+// fn вызывается из фоновой записи один раз на ссылку — тем экземпляром, чья пачка перешла
+// порог. Вызывается до первого Track.
+func (t *Tracker) OnThreshold(n int, fn func(shortID string, clicks int)) {
+	t.threshold = n
+	t.onThreshold = fn
+}
+
+// checkThreshold находит ссылки, которые перешли порог пачкой batch.
+func (t *Tracker) checkThreshold(ctx context.Context, batch []Click) {
+	added := make(map[string]int)
+	for _, c := range batch {
+		if !c.Bot {
+			added[c.ShortID]++
+		}
+	}
+	if len(added) == 0 {
+		return
+	}
+	ids := make([]string, 0, len(added))
+	for id := range added {
+		ids = append(ids, id)
+	}
+	counts, err := t.log.Counts(ctx, ids, time.Time{})
+	if err != nil {
+		t.logger.Error("Could not count clicks for threshold", "error", err)
+		return
+	}
+	for id, total := range counts {
+		if total >= t.threshold && total-added[id] < t.threshold {
+			t.onThreshold(id, total)
+		}
+	}
+}
+
+// Log даёт доступ к хранилищу для чтения статистики.
+func (t *Tracker) Log() Log {
+	return t.log
+}
+
+// Close дописывает накопленные переходы и закрывает Log.
+func (t *Tracker) Close() error {
+	close(t.queue)
+	t.wg.Wait()
+	for _, e := range t.enrichers {
+		if c, ok := e.(io.Closer); ok {
+			_ = c.Close()
+		}
+	}
+	return t.log.Close()
+}
+
+func (t *Tracker) loop() {
+	defer t.wg.Done()
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Click, 0, flushSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := t.log.Record(ctx, batch...); err != nil {
+			t.logger.Error("Could not record clicks", "error", err, "clicks", len(batch))
+		} else if t.onThreshold != nil && t.threshold > 0 {
+			t.checkThreshold(ctx, batch)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case c, ok := <-t.queue:
+			if !ok {
+				flush()
+				return
+			}
+			describeClick(&c)
+			for _, e := range t.enrichers {
+				e.Enrich(&c)
+			}
+			// Обезличиваем после GeoIP: по хешу адреса страну уже не найти.
+			c.IP = middleware.AnonymizeIP(c.IP)
+			batch = append(batch, c)
+			if len(batch) >= flushSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
@@ -5,17 +5,185 @@ package config
 import (
 	"flag"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/dkolesni-prog/transformer/internal/helpers"
 )
 
 type Config struct {
-	RunAddr         string
-	BaseURL         string
+	RunAddr string
+	BaseURL string
+	// RoutePrefix — путь вида "/s", под которым смонтированы все маршруты; он же добавляется
+	// к BaseURL и базовым URL арендаторов (см. ApplyRoutePrefix). Пусто — корень.
+	RoutePrefix string
+	// BaseURLFromHost — строить короткие ссылки от схемы и хоста запроса (с учётом Forwarded
+	// от TrustedProxies), а не от BaseURL. Арендаторы из TenantBaseURLs сохраняют свои адреса.
+	BaseURLFromHost bool
+	// RequestTimeout — срок обработки запроса; пачкам и выгрузке даётся BatchRequestTimeout.
+	RequestTimeout      time.Duration
+	BatchRequestTimeout time.Duration
+	// MaxConcurrentReads и MaxConcurrentWrites — сколько запросов (GET/HEAD и остальных)
+	// обрабатывается одновременно; сверх этого отвечаем 503. Ноль — без ограничения.
+	MaxConcurrentReads  int
+	MaxConcurrentWrites int
+	// RateLimit — сколько запросов в минуту пропускается с одного IP, RateLimitBurst — сколько
+	// подряд (0 — столько же, сколько RateLimit); сверх этого отвечаем 429. Ноль — без ограничения.
+	RateLimit      int
+	RateLimitBurst int
+	// RateLimitBackend — где считаются запросы: memory (в каждом экземпляре свой счёт)
+	// или redis (общий счёт экземпляров за балансировщиком, нужен RedisAddr).
+	RateLimitBackend string
+	// BackgroundTimeout — срок фоновых операций, начатых запросом (подгрузка заголовков).
+	BackgroundTimeout time.Duration
+	// UpgradeTimeout — сколько старый процесс ждёт готовности нового при передаче сокета по SIGUSR2.
+	UpgradeTimeout time.Duration
+	// LogBodyLimit — сколько байт тела запроса и ответа попадает в лог; 0 — тела не логируются.
+	LogBodyLimit int
+	// TenantBaseURLs — дополнительные базовые URL через запятую; домен выбирается по Host.
+	TenantBaseURLs  string
 	FileStoragePath string
 	DatabaseDSN     string
-	SecretKey       string
+	// DatabaseShards — DSN дополнительных шардов через запятую; DatabaseDSN — шард 0.
+	// Ссылки раскладываются по шардам по shortID, поэтому порядок менять нельзя, а при
+	// добавлении шарда уже сохранённые ссылки нужно перенести (export/import).
+	DatabaseShards string
+	ReplicaDSN     string
+
+	DBMaxConns          int
+	DBMinConns          int
+	DBMaxConnLifetime   time.Duration
+	DBHealthCheckPeriod time.Duration
+	DBMaxRetries        int
+	DBRetryBackoff      time.Duration
+	BreakerThreshold    int
+	BreakerCooldown     time.Duration
+	// SlowOpThreshold — операции хранилища дольше этого пишутся в лог и считаются в
+	// метриках store_slow_ops; 0 — не замеряются.
+	SlowOpThreshold        time.Duration
+	Failover               bool
+	FailoverInterval       time.Duration
+	FileCheckpointInterval time.Duration
+	FileSync               string
+	FileFlushInterval      time.Duration
+	MemoryMaxEntries       int
+	MemoryTTL              time.Duration
+	MemoryEviction         string
+	MemorySnapshotPath     string
+	MemorySnapshotInterval time.Duration
+	// RetentionIdle — удалять ссылки без переходов за этот срок; 0 выключает чистку.
+	RetentionIdle     time.Duration
+	RetentionMinAge   time.Duration
+	RetentionInterval time.Duration
+	RetentionDryRun   bool
+
+	MaxURLLength        int
+	URLTrailingSlash    string
+	StripTrackingParams bool
+	// ConflictAliases — при повторном сокращении чужого адреса показывать ссылку и в списке повторившего.
+	ConflictAliases bool
+	// UTMTemplate — шаблон UTM-параметров по умолчанию для ссылок без собственного.
+	UTMTemplate       string
+	BlockedDomains    string
+	BlocklistFile     string
+	AllowedDomains    string
+	AllowlistFile     string
+	ProbeDestinations bool
+	ProbeTimeout      time.Duration
+	FetchTitles       bool
+	// ClicksPartitionMonths > 0 секционирует clicks по месяцам с таким запасом разделов вперёд.
+	ClicksPartitionMonths int
+	// GeoIPDBPath — база MaxMind GeoIP2/GeoLite2 (.mmdb) для стран и регионов переходов.
+	GeoIPDBPath        string
+	ReservedIDs        string
+	ProfanityWordlist  string
+	CaseInsensitiveIDs bool
+	// HashIDs — выводить короткий id из хэша нормализованного адреса: один адрес даёт одну
+	// ссылку во всех экземплярах; при коллизии id удлиняется.
+	HashIDs bool
+	// IDGrowthThreshold — доля занятых случайных id, при которой их длина растёт на символ;
+	// 0 — длина постоянна.
+	IDGrowthThreshold float64
+
+	SecretKey string
+	// CookieSecure ("auto", "true", "false"), CookieSameSite ("lax", "strict", "none") и сроки
+	// куки пользователя; auto ставит Secure, если BaseURL на https. CookieRenewAfter — через
+	// сколько после выдачи кука продлевается на следующем запросе, 0 — не продлевать.
+	CookieSecure     string
+	CookieSameSite   string
+	CookieMaxAge     time.Duration
+	CookieRenewAfter time.Duration
+	// AnonymizeIPs — "", "truncate" или "hash": как писать IP клиентов в логи, аудит и статистику.
+	AnonymizeIPs string
+	// TrustedProxies — сети прокси через запятую (CIDR или адреса), которым верим в X-Forwarded-For
+	// и X-Real-IP, а с BaseURLFromHost — и в Forwarded, X-Forwarded-Proto и X-Forwarded-Host.
+	TrustedProxies string
+	// CSRFProtection — требовать CSRF-токен у изменяющих запросов из браузера (см. middleware.CSRF).
+	CSRFProtection bool
+	AuditFilePath  string
+	// OrgsFilePath — JSON-файл с организациями, когда хранилище не в БД.
+	OrgsFilePath string
+	// TokensFilePath — JSON-файл с API-токенами пользователей, когда хранилище не в БД.
+	TokensFilePath string
+	// Accounts включает регистрацию по email и паролю поверх userID из куки.
+	Accounts bool
+	// AccountsFilePath — JSON-файл с учётными записями, когда хранилище не в БД.
+	AccountsFilePath string
+	// AccountsRequireVerified — не пускать по паролю, пока email не подтверждён.
+	AccountsRequireVerified bool
+	// SMTP* — сервер для писем подтверждения email; без SMTPAddr ссылка подтверждения
+	// только пишется в лог.
+	SMTPAddr     string
+	SMTPFrom     string
+	SMTPUsername string
+	SMTPPassword string
+	// JobsFilePath — журнал очереди фоновых заданий, когда хранилище не в БД; пусто — очередь в памяти.
+	JobsFilePath string
+	// JobWorkers — сколько фоновых заданий выполняется одновременно.
+	JobWorkers int
+	// Schedule* — cron-расписания обслуживающих задач внутри сервера (см. scheduler.Parse);
+	// пусто — задача по расписанию не запускается. Заданное расписание заменяет
+	// периодический снимок файла, воркер чистки и досоздание разделов clicks.
+	ScheduleCompaction string
+	SchedulePurge      string
+	ScheduleRetention  string
+	SchedulePartitions string
+	AdminToken         string
+	// RobotsFile — свой robots.txt; по умолчанию обход коротких ссылок запрещён.
+	RobotsFile string
+	// Branding — название сервиса на главной странице.
+	Branding string
+	// WebhookURLs — адреса webhook через запятую; "url|секрет" подписывает доставки на url
+	// своим секретом вместо WebhookSecret.
+	WebhookURLs   string
+	WebhookSecret string
+	// WebhookMaxAttempts — сколько раз пробовать доставить событие; после этого доставка
+	// остаётся проваленной (dead letter) и видна в GET /api/admin/jobs/failed?kind=webhook.
+	WebhookMaxAttempts int
+	// WebhookRetryBackoff — пауза перед первым повтором доставки, дальше она удваивается.
+	WebhookRetryBackoff time.Duration
+	// SlackWebhookURL и TelegramBotToken с TelegramChatID — куда слать уведомления операторам
+	// (пометка ссылки, переключение хранилища, порог переходов); пусто — не слать.
+	SlackWebhookURL  string
+	TelegramBotToken string
+	TelegramChatID   string
+	// NotifyClickThreshold — уведомить, когда у ссылки станет столько переходов; 0 — не уведомлять.
+	NotifyClickThreshold int
+	CacheSize            int
+	// CacheTTL — сколько запись живёт в кэше переходов; страхует от потерянной инвалидации,
+	// например при сбое Redis. 0 — без срока.
+	CacheTTL time.Duration
+	// CacheWarmup — сколько горячих ссылок загрузить в кэш при старте: из CacheHotSetFile,
+	// куда при остановке пишутся недавно запрошенные, а без него — самые посещаемые за сутки.
+	CacheWarmup     int
+	CacheHotSetFile string
+	// BloomRebuildInterval — как часто пересобирать фильтр Блума существующих shortID, по
+	// которому несуществующие ссылки получают 404 без запроса к хранилищу; 0 — без фильтра.
+	// Ссылки других экземпляров фильтр узнаёт только при пересборке.
+	BloomRebuildInterval time.Duration
+	RedisAddr            string
 }
 
 var parseOnce sync.Once
@@ -26,9 +194,106 @@ func NewConfig() *Config {
 	parseOnce.Do(func() {
 		flag.StringVar(&cfg.RunAddr, "a", ":8080", "address and port to run server")
 		flag.StringVar(&cfg.BaseURL, "b", "http://localhost:8080/", "base URL for shortened links")
+		flag.BoolVar(&cfg.BaseURLFromHost, "base-url-from-host", false, "build short URLs from the request scheme and Host (Forwarded headers from trusted proxies) instead of -b")
+		flag.StringVar(&cfg.RoutePrefix, "route-prefix", "", "path prefix all routes are served under, e.g. /s (also appended to the base URL)")
+		flag.DurationVar(&cfg.RequestTimeout, "request-timeout", 30*time.Second, "deadline for handling a request (0 disables)")
+		flag.IntVar(&cfg.MaxConcurrentReads, "max-concurrent-reads", 0, "max in-flight GET/HEAD requests, excess gets 503 (0 is unlimited)")
+		flag.IntVar(&cfg.MaxConcurrentWrites, "max-concurrent-writes", 0, "max in-flight write requests, excess gets 503 (0 is unlimited)")
+		flag.IntVar(&cfg.RateLimit, "rate-limit", 0, "max requests per minute from one IP, excess gets 429 (0 is unlimited)")
+		flag.IntVar(&cfg.RateLimitBurst, "rate-limit-burst", 0, "max back-to-back requests from one IP (0 is the -rate-limit value)")
+		flag.StringVar(&cfg.RateLimitBackend, "rate-limit-backend", "memory", "where request rates are counted: memory or redis (shared across instances, needs -redis)")
+		flag.DurationVar(&cfg.BackgroundTimeout, "background-timeout", 30*time.Second, "deadline for store operations a request leaves running in background (0 disables)")
+		flag.IntVar(&cfg.LogBodyLimit, "log-body-limit", 1024, "bytes of request and response bodies to log (0 disables)")
+		flag.DurationVar(&cfg.UpgradeTimeout, "upgrade-timeout", 30*time.Second, "how long to wait for the new process to start serving on SIGUSR2")
+		flag.DurationVar(&cfg.BatchRequestTimeout, "batch-request-timeout", 2*time.Minute, "deadline for batch and export requests (0 disables)")
+		flag.StringVar(&cfg.TenantBaseURLs, "tenant-base-urls", "", "comma-separated extra base URLs selected by Host header")
 		flag.StringVar(&cfg.FileStoragePath, "f", "shortener_data.json", "path to file with shortener data")
 		flag.StringVar(&cfg.DatabaseDSN, "d", "", "connection string to database")
+		flag.StringVar(&cfg.DatabaseShards, "database-shards", "", "comma-separated connection strings of extra database shards")
+		flag.StringVar(&cfg.ReplicaDSN, "replica-dsn", "", "connection string to read replica")
+		flag.IntVar(&cfg.DBMaxConns, "db-max-conns", 0, "max connections in the DB pool (0 keeps pgx default)")
+		flag.IntVar(&cfg.DBMinConns, "db-min-conns", 0, "min idle connections in the DB pool")
+		flag.DurationVar(&cfg.DBMaxConnLifetime, "db-max-conn-lifetime", 0, "max lifetime of a DB connection")
+		flag.DurationVar(&cfg.DBHealthCheckPeriod, "db-health-check-period", 0, "period of DB pool health checks")
+		flag.IntVar(&cfg.DBMaxRetries, "db-max-retries", 3, "retries of transient DB errors")
+		flag.DurationVar(&cfg.DBRetryBackoff, "db-retry-backoff", 50*time.Millisecond, "initial backoff between DB retries")
+		flag.IntVar(&cfg.BreakerThreshold, "breaker-threshold", 5, "consecutive DB failures before the circuit opens")
+		flag.DurationVar(&cfg.BreakerCooldown, "breaker-cooldown", 10*time.Second, "how long the DB circuit stays open")
+		flag.DurationVar(&cfg.SlowOpThreshold, "slow-op-threshold", 500*time.Millisecond, "log storage operations slower than this (0 disables)")
+		flag.DurationVar(&cfg.FileCheckpointInterval, "file-checkpoint-interval", time.Hour, "how often to snapshot the file store and truncate its WAL (0 disables)")
+		flag.StringVar(&cfg.FileSync, "file-sync", "batch", "file store durability: always (fsync per write), batch or none")
+		flag.DurationVar(&cfg.FileFlushInterval, "file-flush-interval", time.Second, "flush period of the file store in batch mode")
+		flag.IntVar(&cfg.MemoryMaxEntries, "memory-max-entries", 0, "max links kept by the memory store (0 is unlimited)")
+		flag.DurationVar(&cfg.MemoryTTL, "memory-ttl", 0, "lifetime of links in the memory store (0 is forever)")
+		flag.StringVar(&cfg.MemoryEviction, "memory-eviction", "fifo", "memory store eviction policy: fifo or lru")
+		flag.StringVar(&cfg.MemorySnapshotPath, "memory-snapshot", "", "file to periodically snapshot the memory store to")
+		flag.DurationVar(&cfg.MemorySnapshotInterval, "memory-snapshot-interval", 5*time.Minute, "period of memory store snapshots")
+		flag.DurationVar(&cfg.RetentionIdle, "retention-idle", 0, "delete links with no clicks for this long, e.g. 4320h (0 disables)")
+		flag.DurationVar(&cfg.RetentionMinAge, "retention-min-age", 0, "never delete links younger than this")
+		flag.DurationVar(&cfg.RetentionInterval, "retention-interval", 24*time.Hour, "how often the retention job runs")
+		flag.BoolVar(&cfg.RetentionDryRun, "retention-dry-run", false, "only report links the retention job would delete")
+		flag.IntVar(&cfg.ClicksPartitionMonths, "clicks-partition-months", 0, "partition the clicks table by month, keeping this many months created ahead (0 disables)")
+		flag.BoolVar(&cfg.ConflictAliases, "conflict-aliases", false, "list an already shortened URL for every user who shortens it again")
+		flag.BoolVar(&cfg.Failover, "failover", false, "switch to file/memory storage while the DB is down")
+		flag.DurationVar(&cfg.FailoverInterval, "failover-interval", 5*time.Second, "how often to probe the DB while failed over")
+		flag.IntVar(&cfg.MaxURLLength, "max-url-length", 2048, "max length of a stored URL (0 is unlimited)")
+		flag.StringVar(&cfg.URLTrailingSlash, "url-trailing-slash", "keep", "trailing slash policy for stored URLs: keep, strip or add")
+		flag.BoolVar(&cfg.StripTrackingParams, "strip-tracking", false, "drop utm_*, gclid and fbclid query parameters from stored URLs")
+		flag.StringVar(&cfg.UTMTemplate, "utm-template", "", "query template appended on redirect, e.g. utm_source=shortener&utm_campaign={tag}")
+		flag.StringVar(&cfg.BlockedDomains, "blocked-domains", "", "comma-separated destination domains (or * patterns) that may not be shortened")
+		flag.StringVar(&cfg.BlocklistFile, "blocklist", "", "file with blocked destination domains, one per line")
+		flag.StringVar(&cfg.AllowedDomains, "allowed-domains", "", "comma-separated destination domains (or * patterns); if set, only these may be shortened")
+		flag.StringVar(&cfg.AllowlistFile, "allowlist", "", "file with allowed destination domains, one per line")
+		flag.BoolVar(&cfg.ProbeDestinations, "probe-destinations", false, "check that destinations respond before shortening them")
+		flag.DurationVar(&cfg.ProbeTimeout, "probe-timeout", 3*time.Second, "timeout of a destination check")
+		flag.BoolVar(&cfg.FetchTitles, "fetch-titles", false, "fetch page titles of new links in background")
+		flag.StringVar(&cfg.GeoIPDBPath, "geoip-db", "", "path to a MaxMind GeoIP2/GeoLite2 database for click geolocation")
+		flag.StringVar(&cfg.ReservedIDs, "reserved-ids", "", "comma-separated extra words that may not be used as short IDs")
+		flag.StringVar(&cfg.ProfanityWordlist, "profanity-wordlist", "", "file with words that generated short IDs must not contain")
+		flag.BoolVar(&cfg.CaseInsensitiveIDs, "case-insensitive-ids", false, "generate lowercase short IDs and ignore case on lookup")
+		flag.BoolVar(&cfg.HashIDs, "hash-ids", false, "derive short IDs from a hash of the URL instead of picking them at random")
+		flag.Float64Var(&cfg.IDGrowthThreshold, "id-growth-threshold", 0.05, "share of taken random short IDs that makes new ones a character longer (0 disables)")
 		flag.StringVar(&cfg.SecretKey, "secret", "", "secret key for cookie signing")
+		flag.StringVar(&cfg.CookieSecure, "cookie-secure", "auto", "Secure attribute of the user cookie: auto (if base URL is https), true or false")
+		flag.StringVar(&cfg.CookieSameSite, "cookie-samesite", "lax", "SameSite attribute of the user cookie: lax, strict or none")
+		flag.DurationVar(&cfg.CookieMaxAge, "cookie-max-age", 365*24*time.Hour, "lifetime of the user cookie")
+		flag.DurationVar(&cfg.CookieRenewAfter, "cookie-renew-after", 24*time.Hour, "reissue the user cookie with a fresh lifetime once it is this old (0 disables)")
+		flag.StringVar(&cfg.TrustedProxies, "trusted-proxies", "", "comma-separated proxy CIDRs whose X-Forwarded-For/X-Real-IP are trusted")
+		flag.BoolVar(&cfg.CSRFProtection, "csrf", true, "require a CSRF token on cookie-authenticated browser requests that change data")
+		flag.StringVar(&cfg.AnonymizeIPs, "anonymize-ips", "", "store client IPs truncated (truncate) or hashed (hash) in logs and analytics")
+		flag.StringVar(&cfg.AuditFilePath, "audit-file", "", "path to append-only audit log file")
+		flag.StringVar(&cfg.OrgsFilePath, "orgs-file", "", "path to organizations file (ignored with a database)")
+		flag.StringVar(&cfg.TokensFilePath, "tokens-file", "", "path to API tokens file (ignored with a database)")
+		flag.BoolVar(&cfg.Accounts, "accounts", false, "enable email/password accounts")
+		flag.StringVar(&cfg.AccountsFilePath, "accounts-file", "", "path to accounts file (ignored with a database)")
+		flag.BoolVar(&cfg.AccountsRequireVerified, "accounts-require-verified", false, "refuse password login until the email is verified")
+		flag.StringVar(&cfg.SMTPAddr, "smtp-addr", "", "SMTP server host:port for verification emails")
+		flag.StringVar(&cfg.SMTPFrom, "smtp-from", "", "sender address of verification emails")
+		flag.StringVar(&cfg.SMTPUsername, "smtp-username", "", "SMTP user name")
+		flag.StringVar(&cfg.SMTPPassword, "smtp-password", "", "SMTP password")
+		flag.StringVar(&cfg.JobsFilePath, "jobs-file", "", "path to the background job queue file (ignored with a database)")
+		flag.IntVar(&cfg.JobWorkers, "job-workers", 2, "number of background jobs run at once")
+		flag.StringVar(&cfg.ScheduleCompaction, "schedule-compaction", "", "cron schedule for file store snapshots, e.g. \"0 3 * * *\" (empty disables)")
+		flag.StringVar(&cfg.SchedulePurge, "schedule-purge", "", "cron schedule for hard-deleting soft-deleted links (empty disables)")
+		flag.StringVar(&cfg.ScheduleRetention, "schedule-retention", "", "cron schedule for the retention sweep, replaces -retention-interval (empty disables)")
+		flag.StringVar(&cfg.SchedulePartitions, "schedule-partitions", "", "cron schedule for creating upcoming clicks partitions (empty disables)")
+		flag.StringVar(&cfg.AdminToken, "admin-token", "", "bearer token for admin endpoints")
+		flag.StringVar(&cfg.RobotsFile, "robots-file", "", "file served as /robots.txt instead of the default")
+		flag.StringVar(&cfg.Branding, "branding", "URL shortener", "service name shown on the homepage")
+		flag.StringVar(&cfg.WebhookURLs, "webhooks", "", "comma-separated webhook URLs for link events, url|secret to sign with a per-endpoint secret")
+		flag.StringVar(&cfg.WebhookSecret, "webhook-secret", "", "default secret for signing webhook payloads")
+		flag.IntVar(&cfg.WebhookMaxAttempts, "webhook-max-attempts", 8, "delivery attempts per webhook event before it is dead-lettered")
+		flag.DurationVar(&cfg.WebhookRetryBackoff, "webhook-retry-backoff", 10*time.Second, "delay before the first webhook retry, doubled on each further attempt")
+		flag.StringVar(&cfg.SlackWebhookURL, "slack-webhook-url", "", "Slack incoming webhook URL for operator notifications")
+		flag.StringVar(&cfg.TelegramBotToken, "telegram-bot-token", "", "Telegram bot token for operator notifications")
+		flag.StringVar(&cfg.TelegramChatID, "telegram-chat-id", "", "Telegram chat ID for operator notifications")
+		flag.IntVar(&cfg.NotifyClickThreshold, "notify-click-threshold", 0, "notify when a link reaches this many clicks (0 disables)")
+		flag.IntVar(&cfg.CacheSize, "cache-size", 0, "max entries in the in-process redirect cache (0 disables it)")
+		flag.DurationVar(&cfg.CacheTTL, "cache-ttl", 5*time.Minute, "max age of an entry in the redirect cache (0 keeps entries until evicted)")
+		flag.IntVar(&cfg.CacheWarmup, "cache-warmup", 0, "number of hot links loaded into the cache at startup (0 disables warm-up)")
+		flag.StringVar(&cfg.CacheHotSetFile, "cache-hotset-file", "", "file keeping recently requested short IDs across restarts for cache warm-up")
+		flag.DurationVar(&cfg.BloomRebuildInterval, "bloom-rebuild-interval", 0, "rebuild period of the bloom filter answering unknown short IDs without storage (0 disables it)")
+		flag.StringVar(&cfg.RedisAddr, "redis", "", "redis address for cross-instance cache invalidation")
 		flag.Parse()
 	})
 	if envRunAddr, ok := os.LookupEnv("SERVER_ADDRESS"); ok {
@@ -37,19 +302,446 @@ func NewConfig() *Config {
 	if envBaseURL, ok := os.LookupEnv("BASE_URL"); ok {
 		cfg.BaseURL = envBaseURL
 	}
+	if envFromHost, ok := os.LookupEnv("BASE_URL_FROM_HOST"); ok {
+		if b, err := strconv.ParseBool(envFromHost); err == nil {
+			cfg.BaseURLFromHost = b
+		}
+	}
+	if envRoutePrefix, ok := os.LookupEnv("ROUTE_PREFIX"); ok {
+		cfg.RoutePrefix = envRoutePrefix
+	}
+	if envTimeout, ok := os.LookupEnv("REQUEST_TIMEOUT"); ok {
+		if d, err := time.ParseDuration(envTimeout); err == nil {
+			cfg.RequestTimeout = d
+		}
+	}
+	if envBatchTimeout, ok := os.LookupEnv("BATCH_REQUEST_TIMEOUT"); ok {
+		if d, err := time.ParseDuration(envBatchTimeout); err == nil {
+			cfg.BatchRequestTimeout = d
+		}
+	}
+	if envMaxReads, ok := os.LookupEnv("MAX_CONCURRENT_READS"); ok {
+		if n, err := strconv.Atoi(envMaxReads); err == nil {
+			cfg.MaxConcurrentReads = n
+		}
+	}
+	if envMaxWrites, ok := os.LookupEnv("MAX_CONCURRENT_WRITES"); ok {
+		if n, err := strconv.Atoi(envMaxWrites); err == nil {
+			cfg.MaxConcurrentWrites = n
+		}
+	}
+	if envRateLimit, ok := os.LookupEnv("RATE_LIMIT"); ok {
+		if n, err := strconv.Atoi(envRateLimit); err == nil {
+			cfg.RateLimit = n
+		}
+	}
+	if envRateLimitBurst, ok := os.LookupEnv("RATE_LIMIT_BURST"); ok {
+		if n, err := strconv.Atoi(envRateLimitBurst); err == nil {
+			cfg.RateLimitBurst = n
+		}
+	}
+	if envRateLimitBackend, ok := os.LookupEnv("RATE_LIMIT_BACKEND"); ok {
+		cfg.RateLimitBackend = envRateLimitBackend
+	}
+	if envBackgroundTimeout, ok := os.LookupEnv("BACKGROUND_TIMEOUT"); ok {
+		if d, err := time.ParseDuration(envBackgroundTimeout); err == nil {
+			cfg.BackgroundTimeout = d
+		}
+	}
+	if envLogBodyLimit, ok := os.LookupEnv("LOG_BODY_LIMIT"); ok {
+		if n, err := strconv.Atoi(envLogBodyLimit); err == nil {
+			cfg.LogBodyLimit = n
+		}
+	}
+	if envUpgradeTimeout, ok := os.LookupEnv("UPGRADE_TIMEOUT"); ok {
+		if d, err := time.ParseDuration(envUpgradeTimeout); err == nil {
+			cfg.UpgradeTimeout = d
+		}
+	}
+	if envTenants, ok := os.LookupEnv("TENANT_BASE_URLS"); ok {
+		cfg.TenantBaseURLs = envTenants
+	}
 	if envFilePath, ok := os.LookupEnv("FILE_STORAGE_PATH"); ok {
 		cfg.FileStoragePath = envFilePath
 	}
 	if envDatabaseDSN, ok := os.LookupEnv("DATABASE_DSN"); ok {
 		cfg.DatabaseDSN = envDatabaseDSN
 	}
+	if envShards, ok := os.LookupEnv("DATABASE_SHARDS"); ok {
+		cfg.DatabaseShards = envShards
+	}
+	if envReplicaDSN, ok := os.LookupEnv("DATABASE_REPLICA_DSN"); ok {
+		cfg.ReplicaDSN = envReplicaDSN
+	}
+	if envMaxConns, ok := os.LookupEnv("DB_MAX_CONNS"); ok {
+		if n, err := strconv.Atoi(envMaxConns); err == nil {
+			cfg.DBMaxConns = n
+		}
+	}
+	if envMinConns, ok := os.LookupEnv("DB_MIN_CONNS"); ok {
+		if n, err := strconv.Atoi(envMinConns); err == nil {
+			cfg.DBMinConns = n
+		}
+	}
+	if envLifetime, ok := os.LookupEnv("DB_MAX_CONN_LIFETIME"); ok {
+		if d, err := time.ParseDuration(envLifetime); err == nil {
+			cfg.DBMaxConnLifetime = d
+		}
+	}
+	if envHealthCheck, ok := os.LookupEnv("DB_HEALTH_CHECK_PERIOD"); ok {
+		if d, err := time.ParseDuration(envHealthCheck); err == nil {
+			cfg.DBHealthCheckPeriod = d
+		}
+	}
+	if envRetries, ok := os.LookupEnv("DB_MAX_RETRIES"); ok {
+		if n, err := strconv.Atoi(envRetries); err == nil {
+			cfg.DBMaxRetries = n
+		}
+	}
+	if envBackoff, ok := os.LookupEnv("DB_RETRY_BACKOFF"); ok {
+		if d, err := time.ParseDuration(envBackoff); err == nil {
+			cfg.DBRetryBackoff = d
+		}
+	}
+	if envThreshold, ok := os.LookupEnv("BREAKER_THRESHOLD"); ok {
+		if n, err := strconv.Atoi(envThreshold); err == nil {
+			cfg.BreakerThreshold = n
+		}
+	}
+	if envCooldown, ok := os.LookupEnv("BREAKER_COOLDOWN"); ok {
+		if d, err := time.ParseDuration(envCooldown); err == nil {
+			cfg.BreakerCooldown = d
+		}
+	}
+	if envSlowOp, ok := os.LookupEnv("SLOW_OP_THRESHOLD"); ok {
+		if d, err := time.ParseDuration(envSlowOp); err == nil {
+			cfg.SlowOpThreshold = d
+		}
+	}
 	if envSecret, ok := os.LookupEnv("SECRET_KEY"); ok {
 		cfg.SecretKey = envSecret
 	}
+	if envCookieSecure, ok := os.LookupEnv("COOKIE_SECURE"); ok {
+		cfg.CookieSecure = envCookieSecure
+	}
+	if envCookieSameSite, ok := os.LookupEnv("COOKIE_SAMESITE"); ok {
+		cfg.CookieSameSite = envCookieSameSite
+	}
+	if envCookieMaxAge, ok := os.LookupEnv("COOKIE_MAX_AGE"); ok {
+		if d, err := time.ParseDuration(envCookieMaxAge); err == nil {
+			cfg.CookieMaxAge = d
+		}
+	}
+	if envCookieRenew, ok := os.LookupEnv("COOKIE_RENEW_AFTER"); ok {
+		if d, err := time.ParseDuration(envCookieRenew); err == nil {
+			cfg.CookieRenewAfter = d
+		}
+	}
+	if envAnonymize, ok := os.LookupEnv("ANONYMIZE_IPS"); ok {
+		cfg.AnonymizeIPs = envAnonymize
+	}
+	if envTrustedProxies, ok := os.LookupEnv("TRUSTED_PROXIES"); ok {
+		cfg.TrustedProxies = envTrustedProxies
+	}
+	if envCSRF, ok := os.LookupEnv("CSRF_PROTECTION"); ok {
+		if b, err := strconv.ParseBool(envCSRF); err == nil {
+			cfg.CSRFProtection = b
+		}
+	}
+	if envAuditFile, ok := os.LookupEnv("AUDIT_FILE_PATH"); ok {
+		cfg.AuditFilePath = envAuditFile
+	}
+	if envOrgsFile, ok := os.LookupEnv("ORGS_FILE_PATH"); ok {
+		cfg.OrgsFilePath = envOrgsFile
+	}
+	if envTokensFile, ok := os.LookupEnv("TOKENS_FILE_PATH"); ok {
+		cfg.TokensFilePath = envTokensFile
+	}
+	if envAccounts, ok := os.LookupEnv("ACCOUNTS_ENABLED"); ok {
+		if b, err := strconv.ParseBool(envAccounts); err == nil {
+			cfg.Accounts = b
+		}
+	}
+	if envAccountsFile, ok := os.LookupEnv("ACCOUNTS_FILE_PATH"); ok {
+		cfg.AccountsFilePath = envAccountsFile
+	}
+	if envVerified, ok := os.LookupEnv("ACCOUNTS_REQUIRE_VERIFIED"); ok {
+		if b, err := strconv.ParseBool(envVerified); err == nil {
+			cfg.AccountsRequireVerified = b
+		}
+	}
+	if envSMTP, ok := os.LookupEnv("SMTP_ADDR"); ok {
+		cfg.SMTPAddr = envSMTP
+	}
+	if envFrom, ok := os.LookupEnv("SMTP_FROM"); ok {
+		cfg.SMTPFrom = envFrom
+	}
+	if envUser, ok := os.LookupEnv("SMTP_USERNAME"); ok {
+		cfg.SMTPUsername = envUser
+	}
+	if envPassword, ok := os.LookupEnv("SMTP_PASSWORD"); ok {
+		cfg.SMTPPassword = envPassword
+	}
+	if envJobsFile, ok := os.LookupEnv("JOBS_FILE_PATH"); ok {
+		cfg.JobsFilePath = envJobsFile
+	}
+	if envJobWorkers, ok := os.LookupEnv("JOB_WORKERS"); ok {
+		if n, err := strconv.Atoi(envJobWorkers); err == nil {
+			cfg.JobWorkers = n
+		}
+	}
+	if envSchedule, ok := os.LookupEnv("SCHEDULE_COMPACTION"); ok {
+		cfg.ScheduleCompaction = envSchedule
+	}
+	if envSchedule, ok := os.LookupEnv("SCHEDULE_PURGE"); ok {
+		cfg.SchedulePurge = envSchedule
+	}
+	if envSchedule, ok := os.LookupEnv("SCHEDULE_RETENTION"); ok {
+		cfg.ScheduleRetention = envSchedule
+	}
+	if envSchedule, ok := os.LookupEnv("SCHEDULE_PARTITIONS"); ok {
+		cfg.SchedulePartitions = envSchedule
+	}
+	if envAdminToken, ok := os.LookupEnv("ADMIN_TOKEN"); ok {
+		cfg.AdminToken = envAdminToken
+	}
+	if envRobots, ok := os.LookupEnv("ROBOTS_FILE"); ok {
+		cfg.RobotsFile = envRobots
+	}
+	if envBranding, ok := os.LookupEnv("BRANDING"); ok {
+		cfg.Branding = envBranding
+	}
+	if envWebhooks, ok := os.LookupEnv("WEBHOOK_URLS"); ok {
+		cfg.WebhookURLs = envWebhooks
+	}
+	if envWebhookSecret, ok := os.LookupEnv("WEBHOOK_SECRET"); ok {
+		cfg.WebhookSecret = envWebhookSecret
+	}
+	if envAttempts, ok := os.LookupEnv("WEBHOOK_MAX_ATTEMPTS"); ok {
+		if n, err := strconv.Atoi(envAttempts); err == nil {
+			cfg.WebhookMaxAttempts = n
+		}
+	}
+	if envBackoff, ok := os.LookupEnv("WEBHOOK_RETRY_BACKOFF"); ok {
+		if d, err := time.ParseDuration(envBackoff); err == nil {
+			cfg.WebhookRetryBackoff = d
+		}
+	}
+	if envSlack, ok := os.LookupEnv("SLACK_WEBHOOK_URL"); ok {
+		cfg.SlackWebhookURL = envSlack
+	}
+	if envToken, ok := os.LookupEnv("TELEGRAM_BOT_TOKEN"); ok {
+		cfg.TelegramBotToken = envToken
+	}
+	if envChat, ok := os.LookupEnv("TELEGRAM_CHAT_ID"); ok {
+		cfg.TelegramChatID = envChat
+	}
+	if envThreshold, ok := os.LookupEnv("NOTIFY_CLICK_THRESHOLD"); ok {
+		if n, err := strconv.Atoi(envThreshold); err == nil {
+			cfg.NotifyClickThreshold = n
+		}
+	}
+	if envCacheSize, ok := os.LookupEnv("CACHE_SIZE"); ok {
+		if n, err := strconv.Atoi(envCacheSize); err == nil {
+			cfg.CacheSize = n
+		}
+	}
+	if envCacheTTL, ok := os.LookupEnv("CACHE_TTL"); ok {
+		if d, err := time.ParseDuration(envCacheTTL); err == nil {
+			cfg.CacheTTL = d
+		}
+	}
+	if envWarmup, ok := os.LookupEnv("CACHE_WARMUP"); ok {
+		if n, err := strconv.Atoi(envWarmup); err == nil {
+			cfg.CacheWarmup = n
+		}
+	}
+	if envHotSet, ok := os.LookupEnv("CACHE_HOTSET_FILE"); ok {
+		cfg.CacheHotSetFile = envHotSet
+	}
+	if envBloom, ok := os.LookupEnv("BLOOM_REBUILD_INTERVAL"); ok {
+		if d, err := time.ParseDuration(envBloom); err == nil {
+			cfg.BloomRebuildInterval = d
+		}
+	}
+	if envRedisAddr, ok := os.LookupEnv("REDIS_ADDR"); ok {
+		cfg.RedisAddr = envRedisAddr
+	}
+	if envFailover, ok := os.LookupEnv("FAILOVER"); ok {
+		if b, err := strconv.ParseBool(envFailover); err == nil {
+			cfg.Failover = b
+		}
+	}
+	if envFailoverInterval, ok := os.LookupEnv("FAILOVER_INTERVAL"); ok {
+		if d, err := time.ParseDuration(envFailoverInterval); err == nil {
+			cfg.FailoverInterval = d
+		}
+	}
+	if envCheckpoint, ok := os.LookupEnv("FILE_CHECKPOINT_INTERVAL"); ok {
+		if d, err := time.ParseDuration(envCheckpoint); err == nil {
+			cfg.FileCheckpointInterval = d
+		}
+	}
+	if envFileSync, ok := os.LookupEnv("FILE_SYNC"); ok {
+		cfg.FileSync = envFileSync
+	}
+	if envFlush, ok := os.LookupEnv("FILE_FLUSH_INTERVAL"); ok {
+		if d, err := time.ParseDuration(envFlush); err == nil {
+			cfg.FileFlushInterval = d
+		}
+	}
+	if envMaxEntries, ok := os.LookupEnv("MEMORY_MAX_ENTRIES"); ok {
+		if n, err := strconv.Atoi(envMaxEntries); err == nil {
+			cfg.MemoryMaxEntries = n
+		}
+	}
+	if envMemoryTTL, ok := os.LookupEnv("MEMORY_TTL"); ok {
+		if d, err := time.ParseDuration(envMemoryTTL); err == nil {
+			cfg.MemoryTTL = d
+		}
+	}
+	if envEviction, ok := os.LookupEnv("MEMORY_EVICTION"); ok {
+		cfg.MemoryEviction = envEviction
+	}
+	if envSnapshot, ok := os.LookupEnv("MEMORY_SNAPSHOT_PATH"); ok {
+		cfg.MemorySnapshotPath = envSnapshot
+	}
+	if envSnapshotInterval, ok := os.LookupEnv("MEMORY_SNAPSHOT_INTERVAL"); ok {
+		if d, err := time.ParseDuration(envSnapshotInterval); err == nil {
+			cfg.MemorySnapshotInterval = d
+		}
+	}
+	if envRetentionIdle, ok := os.LookupEnv("RETENTION_IDLE"); ok {
+		if d, err := time.ParseDuration(envRetentionIdle); err == nil {
+			cfg.RetentionIdle = d
+		}
+	}
+	if envRetentionMinAge, ok := os.LookupEnv("RETENTION_MIN_AGE"); ok {
+		if d, err := time.ParseDuration(envRetentionMinAge); err == nil {
+			cfg.RetentionMinAge = d
+		}
+	}
+	if envRetentionInterval, ok := os.LookupEnv("RETENTION_INTERVAL"); ok {
+		if d, err := time.ParseDuration(envRetentionInterval); err == nil {
+			cfg.RetentionInterval = d
+		}
+	}
+	if envRetentionDryRun, ok := os.LookupEnv("RETENTION_DRY_RUN"); ok {
+		if b, err := strconv.ParseBool(envRetentionDryRun); err == nil {
+			cfg.RetentionDryRun = b
+		}
+	}
+	if envMaxURLLength, ok := os.LookupEnv("MAX_URL_LENGTH"); ok {
+		if n, err := strconv.Atoi(envMaxURLLength); err == nil {
+			cfg.MaxURLLength = n
+		}
+	}
+	if envTrailingSlash, ok := os.LookupEnv("URL_TRAILING_SLASH"); ok {
+		cfg.URLTrailingSlash = envTrailingSlash
+	}
+	if envUTM, ok := os.LookupEnv("UTM_TEMPLATE"); ok {
+		cfg.UTMTemplate = envUTM
+	}
+	if envStripTracking, ok := os.LookupEnv("STRIP_TRACKING_PARAMS"); ok {
+		if b, err := strconv.ParseBool(envStripTracking); err == nil {
+			cfg.StripTrackingParams = b
+		}
+	}
+	if envBlocked, ok := os.LookupEnv("BLOCKED_DOMAINS"); ok {
+		cfg.BlockedDomains = envBlocked
+	}
+	if envBlocklist, ok := os.LookupEnv("BLOCKLIST_FILE"); ok {
+		cfg.BlocklistFile = envBlocklist
+	}
+	if envAllowed, ok := os.LookupEnv("ALLOWED_DOMAINS"); ok {
+		cfg.AllowedDomains = envAllowed
+	}
+	if envAllowlist, ok := os.LookupEnv("ALLOWLIST_FILE"); ok {
+		cfg.AllowlistFile = envAllowlist
+	}
+	if envProbe, ok := os.LookupEnv("PROBE_DESTINATIONS"); ok {
+		if b, err := strconv.ParseBool(envProbe); err == nil {
+			cfg.ProbeDestinations = b
+		}
+	}
+	if envFetchTitles, ok := os.LookupEnv("FETCH_TITLES"); ok {
+		if b, err := strconv.ParseBool(envFetchTitles); err == nil {
+			cfg.FetchTitles = b
+		}
+	}
+	if envPartitions, ok := os.LookupEnv("CLICKS_PARTITION_MONTHS"); ok {
+		if n, err := strconv.Atoi(envPartitions); err == nil {
+			cfg.ClicksPartitionMonths = n
+		}
+	}
+	if envAliases, ok := os.LookupEnv("CONFLICT_ALIASES"); ok {
+		if b, err := strconv.ParseBool(envAliases); err == nil {
+			cfg.ConflictAliases = b
+		}
+	}
+	if envGeoIP, ok := os.LookupEnv("GEOIP_DB_PATH"); ok {
+		cfg.GeoIPDBPath = envGeoIP
+	}
+	if envProbeTimeout, ok := os.LookupEnv("PROBE_TIMEOUT"); ok {
+		if d, err := time.ParseDuration(envProbeTimeout); err == nil {
+			cfg.ProbeTimeout = d
+		}
+	}
+	if envReserved, ok := os.LookupEnv("RESERVED_IDS"); ok {
+		cfg.ReservedIDs = envReserved
+	}
+	if envWordlist, ok := os.LookupEnv("PROFANITY_WORDLIST"); ok {
+		cfg.ProfanityWordlist = envWordlist
+	}
+	if envCaseInsensitive, ok := os.LookupEnv("CASE_INSENSITIVE_IDS"); ok {
+		if b, err := strconv.ParseBool(envCaseInsensitive); err == nil {
+			cfg.CaseInsensitiveIDs = b
+		}
+	}
+	if envHashIDs, ok := os.LookupEnv("HASH_IDS"); ok {
+		if b, err := strconv.ParseBool(envHashIDs); err == nil {
+			cfg.HashIDs = b
+		}
+	}
+	if envGrowth, ok := os.LookupEnv("ID_GROWTH_THRESHOLD"); ok {
+		if f, err := strconv.ParseFloat(envGrowth, 64); err == nil {
+			cfg.IDGrowthThreshold = f
+		}
+	}
 	cfg.BaseURL = helpers.EnsureTrailingSlash(cfg.BaseURL)
+	cfg.ApplyRoutePrefix()
 
 	if cfg.SecretKey == "" {
 		cfg.SecretKey = "default-secret-key"
 	}
 	return &cfg
 }
+
+// ApplyRoutePrefix приводит RoutePrefix к виду "/s" и добавляет его к BaseURL.
+// Повторный вызов ничего не меняет.
+func (c *Config) ApplyRoutePrefix() {
+	prefix := NormalizeRoutePrefix(c.RoutePrefix)
+	// Уже применённый префикс не переписываем: конфиг может читаться из других горутин.
+	if baseURL := JoinRoutePrefix(c.BaseURL, prefix); prefix != c.RoutePrefix || baseURL != c.BaseURL {
+		c.RoutePrefix, c.BaseURL = prefix, baseURL
+	}
+}
+
+// NormalizeRoutePrefix убирает лишние слеши: "s/", "/s" и "/s/" дают "/s", "/" — пустую строку.
+func NormalizeRoutePrefix(prefix string) string {
+	prefix = strings.Trim(strings.TrimSpace(prefix), "/")
+	if prefix == "" {
+		return ""
+	}
+	return "/" + prefix
+}
+
+// JoinRoutePrefix добавляет нормализованный prefix к базовому URL, если тот ещё не
+// оканчивается на него, и возвращает URL со слешем на конце.
+func JoinRoutePrefix(baseURL, prefix string) string {
+	baseURL = helpers.EnsureTrailingSlash(baseURL)
+	if prefix == "" || strings.HasSuffix(baseURL, prefix+"/") {
+		return baseURL
+	}
+	return strings.TrimSuffix(baseURL, "/") + prefix + "/"
+}
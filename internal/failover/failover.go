@@ -0,0 +1,522 @@
+// Internal/failover/failover.go.
+
+package failover
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/dkolesni-prog/transformer/internal/config"
+	"github.com/dkolesni-prog/transformer/internal/logging"
+	"github.com/dkolesni-prog/transformer/internal/store"
+)
+
+// pendingOp — запись, сделанная во вторичное хранилище, которую надо повторить в основном.
+type pendingOp struct {
+	records   []store.Record
+	userID    string
+	deleteIDs []string
+	// restoreIDs — shortID, с которых надо снять пометку удаления.
+	restoreIDs []string
+	// metaID — shortID, для которого надо повторить SetMeta с meta.
+	metaID string
+	meta   store.LinkMeta
+	// updateID — shortID, которому надо повторить UpdateURL на updateURL.
+	updateID  string
+	updateURL *url.URL
+	// transferID — shortID, который надо передать пользователю transferTo.
+	transferID string
+	transferTo string
+	// erase — повторить EraseUser для userID.
+	erase bool
+}
+
+// Store переключает чтение и запись на secondary, когда primary стабильно падает,
+// копит изменения и проигрывает их в primary после его восстановления.
+type Store struct {
+	primary   store.Store
+	secondary store.Store
+	threshold int
+	interval  time.Duration
+	logger    logging.Logger
+
+	mu         sync.Mutex
+	failedOver bool
+	failures   int
+	pending    []pendingOp
+	// conflicts — записи, которые primary не принял при проигрывании, см. Conflicts.
+	conflicts []store.Record
+	// onSwitch — см. OnSwitch.
+	onSwitch func(failedOver bool, err error)
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewStore(primary, secondary store.Store, threshold int, interval time.Duration, logger logging.Logger) *Store {
+	s := &Store{
+		primary:   primary,
+		secondary: secondary,
+		threshold: threshold,
+		interval:  interval,
+		logger:    logger,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go s.watch()
+	return s
+}
+
+func (s *Store) Save(ctx context.Context, userID string, u *url.URL, meta store.LinkMeta, cfg *config.Config) (string, error) {
+	if !s.isFailedOver() {
+		res, err := s.primary.Save(ctx, userID, u, meta, cfg)
+		if !s.observe(err) {
+			return res, err
+		}
+	}
+	res, err := s.secondary.Save(ctx, userID, u, meta, cfg)
+	if err == nil {
+		s.enqueue(pendingOp{records: []store.Record{{
+			ShortURL:    store.ShortIDFromURL(res, cfg.BaseURL),
+			OriginalURL: u.String(),
+			UserID:      userID,
+			Meta:        recordMeta(meta),
+		}}})
+	}
+	return res, err
+}
+
+// recordMeta — meta для проигрывания в primary; nil, если настроек нет.
+func recordMeta(meta store.LinkMeta) *store.LinkMeta {
+	if meta.IsZero() {
+		return nil
+	}
+	return &meta
+}
+
+func (s *Store) SaveBatch(ctx context.Context, userID string, urls []*url.URL, metas []store.LinkMeta, cfg *config.Config) ([]store.SavedURL, error) {
+	if !s.isFailedOver() {
+		res, err := s.primary.SaveBatch(ctx, userID, urls, metas, cfg)
+		if !s.observe(err) {
+			return res, err
+		}
+	}
+	res, err := s.secondary.SaveBatch(ctx, userID, urls, metas, cfg)
+	if err == nil {
+		// Уже существовавшие ссылки не повторяем: они не созданы этим вызовом и могут
+		// принадлежать другому пользователю.
+		records := make([]store.Record, 0, len(res))
+		for i, saved := range res {
+			if saved.Existing {
+				continue
+			}
+			rec := store.Record{
+				ShortURL:    store.ShortIDFromURL(saved.ShortURL, cfg.BaseURL),
+				OriginalURL: urls[i].String(),
+				UserID:      userID,
+			}
+			if i < len(metas) {
+				rec.Meta = recordMeta(metas[i])
+			}
+			records = append(records, rec)
+		}
+		if len(records) > 0 {
+			s.enqueue(pendingOp{records: records})
+		}
+	}
+	return res, err
+}
+
+// LoadFull во время переключения ищет ссылку сначала в secondary, затем в primary:
+// ссылки, созданные до переключения, есть только там, а primary может отвечать на чтение.
+func (s *Store) LoadFull(ctx context.Context, shortID string) (*url.URL, bool, error) {
+	if !s.isFailedOver() {
+		u, isDeleted, err := s.primary.LoadFull(ctx, shortID)
+		if !s.observe(err) {
+			return u, isDeleted, err
+		}
+	}
+	u, isDeleted, err := s.secondary.LoadFull(ctx, shortID)
+	if !errors.Is(err, store.ErrNotFound) {
+		return u, isDeleted, err
+	}
+	if primaryURL, primaryDeleted, primaryErr := s.primary.LoadFull(ctx, shortID); primaryErr == nil {
+		return primaryURL, primaryDeleted, nil
+	}
+	return u, isDeleted, err
+}
+
+func (s *Store) LoadUserURLs(ctx context.Context, userID string, baseURL string) ([]store.UserURL, error) {
+	if !s.isFailedOver() {
+		res, err := s.primary.LoadUserURLs(ctx, userID, baseURL)
+		if !s.observe(err) {
+			return res, err
+		}
+	}
+	return s.secondary.LoadUserURLs(ctx, userID, baseURL)
+}
+
+// DeleteBatch во время переключения отвечает по secondary: ссылки, созданные до него
+// только в primary, там не найдутся, но удаление всё равно повторится в primary.
+func (s *Store) DeleteBatch(ctx context.Context, userID string, shortIDs []string) ([]store.DeleteResult, error) {
+	if !s.isFailedOver() {
+		results, err := s.primary.DeleteBatch(ctx, userID, shortIDs)
+		if !s.observe(err) {
+			return results, err
+		}
+	}
+	results, err := s.secondary.DeleteBatch(ctx, userID, shortIDs)
+	if err == nil {
+		s.enqueue(pendingOp{userID: userID, deleteIDs: shortIDs})
+	}
+	return results, err
+}
+
+func (s *Store) RestoreBatch(ctx context.Context, userID string, shortIDs []string) ([]string, error) {
+	if !s.isFailedOver() {
+		restored, err := s.primary.RestoreBatch(ctx, userID, shortIDs)
+		if !s.observe(err) {
+			return restored, err
+		}
+	}
+	restored, err := s.secondary.RestoreBatch(ctx, userID, shortIDs)
+	if err == nil && len(restored) > 0 {
+		s.enqueue(pendingOp{userID: userID, restoreIDs: restored})
+	}
+	return restored, err
+}
+
+func (s *Store) UpdateURL(ctx context.Context, userID, shortID string, u *url.URL) error {
+	if !s.isFailedOver() {
+		err := s.primary.UpdateURL(ctx, userID, shortID, u)
+		if !s.observe(err) {
+			return err
+		}
+	}
+	err := s.secondary.UpdateURL(ctx, userID, shortID, u)
+	if err == nil {
+		s.enqueue(pendingOp{userID: userID, updateID: shortID, updateURL: u})
+	}
+	return err
+}
+
+func (s *Store) TransferOwner(ctx context.Context, fromUserID, shortID, toUserID string) error {
+	if !s.isFailedOver() {
+		err := s.primary.TransferOwner(ctx, fromUserID, shortID, toUserID)
+		if !s.observe(err) {
+			return err
+		}
+	}
+	err := s.secondary.TransferOwner(ctx, fromUserID, shortID, toUserID)
+	if err == nil {
+		s.enqueue(pendingOp{userID: fromUserID, transferID: shortID, transferTo: toUserID})
+	}
+	return err
+}
+
+// EraseUser чистит оба хранилища: в secondary могли остаться ссылки, созданные во время переключения.
+func (s *Store) EraseUser(ctx context.Context, userID string) ([]string, error) {
+	if !s.isFailedOver() {
+		erased, err := s.primary.EraseUser(ctx, userID)
+		if !s.observe(err) {
+			if err == nil {
+				if _, secondaryErr := s.secondary.EraseUser(ctx, userID); secondaryErr != nil {
+					s.logger.Error("Could not erase user from secondary storage", "error", secondaryErr)
+				}
+			}
+			return erased, err
+		}
+	}
+	erased, err := s.secondary.EraseUser(ctx, userID)
+	if err == nil {
+		s.enqueue(pendingOp{userID: userID, erase: true})
+	}
+	return erased, err
+}
+
+func (s *Store) ImportRecords(ctx context.Context, records []store.Record) error {
+	if !s.isFailedOver() {
+		err := s.primary.ImportRecords(ctx, records)
+		if !s.observe(err) {
+			return err
+		}
+	}
+	err := s.secondary.ImportRecords(ctx, records)
+	if err == nil {
+		s.enqueue(pendingOp{records: records})
+	}
+	return err
+}
+
+func (s *Store) ExportRecords(ctx context.Context, fn func(store.Record) error) error {
+	if s.isFailedOver() {
+		return s.secondary.ExportRecords(ctx, fn)
+	}
+	return s.primary.ExportRecords(ctx, fn)
+}
+
+func (s *Store) LoadOwner(ctx context.Context, shortID string) (string, error) {
+	if !s.isFailedOver() {
+		userID, err := s.primary.LoadOwner(ctx, shortID)
+		if !s.observe(err) {
+			return userID, err
+		}
+	}
+	return s.secondary.LoadOwner(ctx, shortID)
+}
+
+func (s *Store) LoadMeta(ctx context.Context, shortID string) (store.LinkMeta, error) {
+	if !s.isFailedOver() {
+		meta, err := s.primary.LoadMeta(ctx, shortID)
+		if !s.observe(err) {
+			return meta, err
+		}
+	}
+	return s.secondary.LoadMeta(ctx, shortID)
+}
+
+func (s *Store) SetMeta(ctx context.Context, userID, shortID string, meta store.LinkMeta) error {
+	if !s.isFailedOver() {
+		err := s.primary.SetMeta(ctx, userID, shortID, meta)
+		if !s.observe(err) {
+			return err
+		}
+	}
+	err := s.secondary.SetMeta(ctx, userID, shortID, meta)
+	if err == nil {
+		s.enqueue(pendingOp{userID: userID, metaID: shortID, meta: meta})
+	}
+	return err
+}
+
+// Ping отражает состояние основного хранилища: работа на secondary — это деградация.
+func (s *Store) Ping(ctx context.Context) error {
+	return s.primary.Ping(ctx)
+}
+
+func (s *Store) Bootstrap(ctx context.Context) error {
+	return s.primary.Bootstrap(ctx)
+}
+
+func (s *Store) Close(ctx context.Context) error {
+	close(s.stop)
+	<-s.done
+	return errors.Join(s.primary.Close(ctx), s.secondary.Close(ctx))
+}
+
+// OnSwitch вызывает fn при каждом переключении: на secondary (failedOver, err — ошибка
+// primary) и обратно (err == nil). Если хранилище уже работает на secondary (NewReconnecting),
+// fn вызывается сразу. fn вызывается под блокировкой хранилища и не должна ждать.
+func (s *Store) OnSwitch(fn func(failedOver bool, err error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onSwitch = fn
+	if s.failedOver {
+		fn(true, &store.UnavailableError{RetryAfter: s.interval})
+	}
+}
+
+// Conflicts возвращает записи, которые при проигрывании не легли в primary: там уже был
+// такой shortID с другим адресом или владельцем либо такой адрес под другим shortID.
+// Такие записи остаются только в secondary и требуют ручного разбора.
+func (s *Store) Conflicts() []store.Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]store.Record(nil), s.conflicts...)
+}
+
+func (s *Store) isFailedOver() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failedOver
+}
+
+// observe учитывает результат вызова primary и сообщает, нужно ли повторить его на secondary.
+func (s *Store) observe(err error) bool {
+	if !store.IsFailure(err) {
+		s.mu.Lock()
+		s.failures = 0
+		s.mu.Unlock()
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var unavailable *store.UnavailableError
+	s.failures++
+	if s.failures < s.threshold && !errors.As(err, &unavailable) {
+		return false
+	}
+	if !s.failedOver {
+		s.logger.Error("Primary storage failed, switching to secondary", "error", err, "failures", s.failures)
+		s.failedOver = true
+		if s.onSwitch != nil {
+			s.onSwitch(true, err)
+		}
+	}
+	return true
+}
+
+func (s *Store) enqueue(op pendingOp) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(s.pending, op)
+}
+
+// watch периодически проверяет primary и возвращает на него трафик.
+func (s *Store) watch() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if s.isFailedOver() {
+				s.tryRecover()
+			}
+		}
+	}
+}
+
+func (s *Store) tryRecover() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.interval)
+	defer cancel()
+
+	if err := s.primary.Ping(ctx); err != nil {
+		return
+	}
+
+	// Проигрываем копию очереди без блокировки: запросы продолжают писать в secondary
+	// и дописывают очередь, а удаляется из неё только проигранное.
+	for {
+		s.mu.Lock()
+		if len(s.pending) == 0 {
+			s.failedOver = false
+			s.failures = 0
+			s.logger.Info("Primary storage recovered, switched back")
+			if s.onSwitch != nil {
+				s.onSwitch(false, nil)
+			}
+			s.mu.Unlock()
+			return
+		}
+		pending := append([]pendingOp(nil), s.pending...)
+		s.mu.Unlock()
+
+		replayed := 0
+		var err error
+		for _, op := range pending {
+			if err = s.replay(ctx, op); err != nil {
+				break
+			}
+			replayed++
+		}
+
+		s.mu.Lock()
+		s.pending = s.pending[replayed:]
+		left := len(s.pending)
+		s.mu.Unlock()
+		if err != nil {
+			s.logger.Warn("Replay to primary storage failed", "error", err, "pending", left)
+			return
+		}
+	}
+}
+
+func (s *Store) replay(ctx context.Context, op pendingOp) error {
+	if len(op.records) > 0 {
+		return s.replayRecords(ctx, op.records)
+	}
+	if op.erase {
+		_, err := s.primary.EraseUser(ctx, op.userID)
+		return err
+	}
+	if len(op.restoreIDs) > 0 {
+		_, err := s.primary.RestoreBatch(ctx, op.userID, op.restoreIDs)
+		return err
+	}
+	if op.transferID != "" {
+		if err := s.primary.TransferOwner(ctx, op.userID, op.transferID, op.transferTo); !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+		return nil
+	}
+	if op.updateID != "" {
+		if err := s.primary.UpdateURL(ctx, op.userID, op.updateID, op.updateURL); !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+		return nil
+	}
+	if op.metaID != "" {
+		// Ссылки может не оказаться в primary, если её создали до переключения только в нём.
+		if err := s.primary.SetMeta(ctx, op.userID, op.metaID, op.meta); !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+		return nil
+	}
+	_, err := s.primary.DeleteBatch(ctx, op.userID, op.deleteIDs)
+	return err
+}
+
+// replayRecords импортирует записи в primary и сверяет результат: ImportRecords молча
+// пропускает строки, совпавшие по shortID или адресу, и такие записи нельзя считать
+// проигранными.
+func (s *Store) replayRecords(ctx context.Context, records []store.Record) error {
+	if err := s.primary.ImportRecords(ctx, records); err != nil {
+		return err
+	}
+	var conflicts []store.Record
+	for _, rec := range records {
+		ok, err := s.replayed(ctx, rec)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			conflicts = append(conflicts, rec)
+		}
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+	for _, rec := range conflicts {
+		s.logger.Error("Record conflicts with primary storage, kept in secondary only",
+			"short_id", rec.ShortURL, "url", rec.OriginalURL, "user_id", rec.UserID)
+	}
+	s.mu.Lock()
+	s.conflicts = append(s.conflicts, conflicts...)
+	s.mu.Unlock()
+	return nil
+}
+
+// replayed сообщает, лежит ли rec в primary с тем же адресом и владельцем.
+func (s *Store) replayed(ctx context.Context, rec store.Record) (bool, error) {
+	u, _, err := s.primary.LoadFull(ctx, rec.ShortURL)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if u.String() != rec.OriginalURL {
+		return false, nil
+	}
+	if rec.UserID == "" {
+		return true, nil
+	}
+	owner, err := s.primary.LoadOwner(ctx, rec.ShortURL)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return owner == rec.UserID, nil
+}
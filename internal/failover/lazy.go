@@ -0,0 +1,193 @@
+// Internal/failover/lazy.go.
+
+package failover
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/dkolesni-prog/transformer/internal/config"
+	"github.com/dkolesni-prog/transformer/internal/logging"
+	"github.com/dkolesni-prog/transformer/internal/store"
+)
+
+// Connector открывает основное хранилище (например, NewRDB + Bootstrap).
+type Connector func(ctx context.Context) (store.Store, error)
+
+// NewReconnecting стартует сразу на secondary и в фоне раз в interval пытается
+// подключить основное хранилище; после успеха переносит в него накопленные записи.
+func NewReconnecting(connect Connector, secondary store.Store, interval time.Duration, logger logging.Logger) *Store {
+	s := NewStore(&lazyStore{connect: connect, interval: interval, logger: logger}, secondary, 1, interval, logger)
+	s.failedOver = true
+	return s
+}
+
+// lazyStore отвечает UnavailableError, пока Ping не сумеет подключиться.
+type lazyStore struct {
+	mu       sync.Mutex
+	connect  Connector
+	interval time.Duration
+	logger   logging.Logger
+	s        store.Store
+}
+
+func (l *lazyStore) get() (store.Store, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.s == nil {
+		return nil, &store.UnavailableError{RetryAfter: l.interval}
+	}
+	return l.s, nil
+}
+
+// Ping подключается без блокировки, чтобы медленное подключение не держало запросы,
+// которым нужен только get. Если параллельный Ping успел раньше, лишнее подключение закрывается.
+func (l *lazyStore) Ping(ctx context.Context) error {
+	if s, err := l.get(); err == nil {
+		return s.Ping(ctx)
+	}
+	s, err := l.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	if l.s != nil {
+		l.mu.Unlock()
+		if err := s.Close(ctx); err != nil {
+			l.logger.Warn("Could not close redundant primary storage connection", "error", err)
+		}
+		return nil
+	}
+	l.s = s
+	l.mu.Unlock()
+	l.logger.Info("Primary storage connected in background, promoting it")
+	return nil
+}
+
+func (l *lazyStore) Save(ctx context.Context, userID string, u *url.URL, meta store.LinkMeta, cfg *config.Config) (string, error) {
+	s, err := l.get()
+	if err != nil {
+		return "", err
+	}
+	return s.Save(ctx, userID, u, meta, cfg)
+}
+
+func (l *lazyStore) SaveBatch(ctx context.Context, userID string, urls []*url.URL, metas []store.LinkMeta, cfg *config.Config) ([]store.SavedURL, error) {
+	s, err := l.get()
+	if err != nil {
+		return nil, err
+	}
+	return s.SaveBatch(ctx, userID, urls, metas, cfg)
+}
+
+func (l *lazyStore) LoadFull(ctx context.Context, shortID string) (*url.URL, bool, error) {
+	s, err := l.get()
+	if err != nil {
+		return nil, false, err
+	}
+	return s.LoadFull(ctx, shortID)
+}
+
+func (l *lazyStore) LoadUserURLs(ctx context.Context, userID string, baseURL string) ([]store.UserURL, error) {
+	s, err := l.get()
+	if err != nil {
+		return nil, err
+	}
+	return s.LoadUserURLs(ctx, userID, baseURL)
+}
+
+func (l *lazyStore) DeleteBatch(ctx context.Context, userID string, shortIDs []string) ([]store.DeleteResult, error) {
+	s, err := l.get()
+	if err != nil {
+		return nil, err
+	}
+	return s.DeleteBatch(ctx, userID, shortIDs)
+}
+
+func (l *lazyStore) RestoreBatch(ctx context.Context, userID string, shortIDs []string) ([]string, error) {
+	s, err := l.get()
+	if err != nil {
+		return nil, err
+	}
+	return s.RestoreBatch(ctx, userID, shortIDs)
+}
+
+func (l *lazyStore) UpdateURL(ctx context.Context, userID, shortID string, u *url.URL) error {
+	s, err := l.get()
+	if err != nil {
+		return err
+	}
+	return s.UpdateURL(ctx, userID, shortID, u)
+}
+
+func (l *lazyStore) TransferOwner(ctx context.Context, fromUserID, shortID, toUserID string) error {
+	s, err := l.get()
+	if err != nil {
+		return err
+	}
+	return s.TransferOwner(ctx, fromUserID, shortID, toUserID)
+}
+
+func (l *lazyStore) EraseUser(ctx context.Context, userID string) ([]string, error) {
+	s, err := l.get()
+	if err != nil {
+		return nil, err
+	}
+	return s.EraseUser(ctx, userID)
+}
+
+func (l *lazyStore) ImportRecords(ctx context.Context, records []store.Record) error {
+	s, err := l.get()
+	if err != nil {
+		return err
+	}
+	return s.ImportRecords(ctx, records)
+}
+
+func (l *lazyStore) ExportRecords(ctx context.Context, fn func(store.Record) error) error {
+	s, err := l.get()
+	if err != nil {
+		return err
+	}
+	return s.ExportRecords(ctx, fn)
+}
+
+func (l *lazyStore) LoadOwner(ctx context.Context, shortID string) (string, error) {
+	s, err := l.get()
+	if err != nil {
+		return "", err
+	}
+	return s.LoadOwner(ctx, shortID)
+}
+
+func (l *lazyStore) LoadMeta(ctx context.Context, shortID string) (store.LinkMeta, error) {
+	s, err := l.get()
+	if err != nil {
+		return store.LinkMeta{}, err
+	}
+	return s.LoadMeta(ctx, shortID)
+}
+
+func (l *lazyStore) SetMeta(ctx context.Context, userID, shortID string, meta store.LinkMeta) error {
+	s, err := l.get()
+	if err != nil {
+		return err
+	}
+	return s.SetMeta(ctx, userID, shortID, meta)
+}
+
+func (l *lazyStore) Bootstrap(ctx context.Context) error {
+	return nil
+}
+
+func (l *lazyStore) Close(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.s == nil {
+		return nil
+	}
+	return l.s.Close(ctx)
+}
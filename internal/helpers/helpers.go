@@ -7,20 +7,21 @@ import (
 	"errors"
 	"math/big"
 	"strings"
-
-	"github.com/dkolesni-prog/transformer/internal/app/middleware"
 )
 
 func RandStringRunes(n int) (string, error) {
-	letterRunes := []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
+	return RandStringFrom("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789", n)
+}
+
+// RandStringFrom собирает случайную строку длины n из символов alphabet.
+func RandStringFrom(alphabet string, n int) (string, error) {
+	letterRunes := []rune(alphabet)
 	b := make([]rune, n)
 
 	for i := range b {
 		num, err := rand.Int(rand.Reader, big.NewInt(int64(len(letterRunes))))
 		if err != nil {
-			wrappedErr := errors.New("error generating random number")
-			middleware.Log.Error().Err(err).Msg("RandStringRunes failed")
-			return "", wrappedErr
+			return "", errors.New("error generating random number: " + err.Error())
 		}
 		b[i] = letterRunes[num.Int64()]
 	}
@@ -0,0 +1,224 @@
+// Internal/jobs/db.go.
+
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/dkolesni-prog/transformer/internal/logging"
+)
+
+// DBQueue хранит задания в таблице jobs. Claim берёт строку через FOR UPDATE SKIP LOCKED,
+// так что несколько экземпляров сервиса на одной БД не выполняют задание дважды.
+type DBQueue struct {
+	pool   *pgxpool.Pool
+	logger logging.Logger
+}
+
+func NewDBQueue(pool *pgxpool.Pool, logger logging.Logger) *DBQueue {
+	return &DBQueue{pool: pool, logger: logger}
+}
+
+// Bootstrap creates the jobs table if it doesn't exist.
+func (q *DBQueue) Bootstrap(ctx context.Context) error {
+	const schema = `
+CREATE TABLE IF NOT EXISTS jobs (
+    id VARCHAR(32) PRIMARY KEY,
+    kind VARCHAR(32) NOT NULL,
+    user_id VARCHAR(64) NOT NULL DEFAULT '',
+    payload JSONB,
+    status VARCHAR(16) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    result JSONB,
+    error TEXT NOT NULL DEFAULT '',
+    run_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS jobs_status_run_at_idx ON jobs (status, run_at);
+`
+	if _, execErr := q.pool.Exec(ctx, schema); execErr != nil {
+		q.logger.Error("Could not create jobs table", "error", execErr)
+		return errors.New("cannot create jobs table: " + execErr.Error())
+	}
+	return nil
+}
+
+func (q *DBQueue) Enqueue(ctx context.Context, job Job) error {
+	const sqlInsert = `
+INSERT INTO jobs (id, kind, user_id, payload, status, run_at, created_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $7);
+`
+	if _, execErr := q.pool.Exec(ctx, sqlInsert,
+		job.ID, job.Kind, job.UserID, nullJSON(job.Payload), string(job.Status), job.RunAt, job.CreatedAt); execErr != nil {
+		q.logger.Error("Job insert failed", "error", execErr, "kind", job.Kind)
+		return errors.New("job insert: " + execErr.Error())
+	}
+	return nil
+}
+
+func (q *DBQueue) Claim(ctx context.Context, kinds []string, lease time.Duration) (Job, bool, error) {
+	const sqlClaim = `
+UPDATE jobs
+SET status = 'running',
+    attempts = attempts + 1,
+    locked_until = now() + $2 * interval '1 millisecond',
+    updated_at = now()
+WHERE id = (
+    SELECT id FROM jobs
+    WHERE kind = ANY($1)
+      AND ((status = 'pending' AND run_at <= now())
+        OR (status = 'running' AND locked_until < now()))
+    ORDER BY run_at, created_at
+    LIMIT 1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING ` + jobColumns + `;`
+	job, err := scanJob(q.pool.QueryRow(ctx, sqlClaim, kinds, lease.Milliseconds()))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Job{}, false, nil
+	}
+	if err != nil {
+		q.logger.Error("Job claim failed", "error", err)
+		return Job{}, false, errors.New("job claim: " + err.Error())
+	}
+	return job, true, nil
+}
+
+func (q *DBQueue) Complete(ctx context.Context, id string, result json.RawMessage) error {
+	const sqlUpdate = `
+UPDATE jobs
+SET status = 'completed', result = $2, error = '', locked_until = NULL, updated_at = now()
+WHERE id = $1;
+`
+	return q.update(ctx, "complete", sqlUpdate, id, nullJSON(result))
+}
+
+func (q *DBQueue) Fail(ctx context.Context, id, errMsg string, retryAt time.Time) error {
+	if retryAt.IsZero() {
+		const sqlFail = `
+UPDATE jobs
+SET status = 'failed', error = $2, locked_until = NULL, updated_at = now()
+WHERE id = $1;
+`
+		return q.update(ctx, "fail", sqlFail, id, errMsg)
+	}
+	const sqlRetry = `
+UPDATE jobs
+SET status = 'pending', error = $2, run_at = $3, locked_until = NULL, updated_at = now()
+WHERE id = $1;
+`
+	return q.update(ctx, "retry", sqlRetry, id, errMsg, retryAt)
+}
+
+func (q *DBQueue) update(ctx context.Context, op, sql string, args ...any) error {
+	tag, execErr := q.pool.Exec(ctx, sql, args...)
+	if execErr != nil {
+		q.logger.Error("Job update failed", "error", execErr, "op", op)
+		return errors.New("job " + op + ": " + execErr.Error())
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (q *DBQueue) Get(ctx context.Context, id string) (Job, error) {
+	const sqlSelect = `SELECT ` + jobColumns + ` FROM jobs WHERE id = $1;`
+	job, err := scanJob(q.pool.QueryRow(ctx, sqlSelect, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Job{}, ErrNotFound
+	}
+	if err != nil {
+		q.logger.Error("Job select failed", "error", err)
+		return Job{}, errors.New("job select: " + err.Error())
+	}
+	return job, nil
+}
+
+func (q *DBQueue) Failed(ctx context.Context, kind string, limit int) ([]Job, error) {
+	const sqlSelect = `
+SELECT ` + jobColumns + `
+FROM jobs
+WHERE kind = $1 AND status = 'failed'
+ORDER BY updated_at DESC
+LIMIT $2;`
+	rows, err := q.pool.Query(ctx, sqlSelect, kind, limit)
+	if err != nil {
+		q.logger.Error("Failed jobs select failed", "error", err, "kind", kind)
+		return nil, errors.New("failed jobs select: " + err.Error())
+	}
+	out, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Job, error) { return scanJob(row) })
+	if err != nil {
+		q.logger.Error("Failed jobs scan failed", "error", err, "kind", kind)
+		return nil, errors.New("failed jobs scan: " + err.Error())
+	}
+	return out, nil
+}
+
+func (q *DBQueue) Requeue(ctx context.Context, id string) (Job, error) {
+	const sqlUpdate = `
+UPDATE jobs
+SET status = 'pending', attempts = 0, run_at = now(), updated_at = now()
+WHERE id = $1 AND status = 'failed'
+RETURNING ` + jobColumns + `;`
+	job, err := scanJob(q.pool.QueryRow(ctx, sqlUpdate, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Job{}, ErrNotFound
+	}
+	if err != nil {
+		q.logger.Error("Job requeue failed", "error", err)
+		return Job{}, errors.New("job requeue: " + err.Error())
+	}
+	return job, nil
+}
+
+func (q *DBQueue) Prune(ctx context.Context, before time.Time) (int, error) {
+	const sqlDelete = `DELETE FROM jobs WHERE status IN ('completed', 'failed') AND updated_at < $1;`
+	tag, execErr := q.pool.Exec(ctx, sqlDelete, before)
+	if execErr != nil {
+		q.logger.Error("Job prune failed", "error", execErr)
+		return 0, errors.New("job prune: " + execErr.Error())
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// Close ничего не делает: пулом владеет хранилище.
+func (q *DBQueue) Close() error {
+	return nil
+}
+
+const jobColumns = `id, kind, user_id, payload, status, attempts, result, error, run_at, locked_until, created_at, updated_at`
+
+func scanJob(row pgx.Row) (Job, error) {
+	var (
+		job         Job
+		status      string
+		lockedUntil *time.Time
+	)
+	err := row.Scan(&job.ID, &job.Kind, &job.UserID, &job.Payload, &status, &job.Attempts,
+		&job.Result, &job.Error, &job.RunAt, &lockedUntil, &job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		return Job{}, err
+	}
+	job.Status = Status(status)
+	if lockedUntil != nil {
+		job.LockedUntil = *lockedUntil
+	}
+	return job, nil
+}
+
+// nullJSON превращает пустой JSON в NULL: пустая строка — не валидный JSONB.
+func nullJSON(raw json.RawMessage) any {
+	if len(raw) == 0 {
+		return nil
+	}
+	return string(raw)
+}
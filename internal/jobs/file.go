@@ -0,0 +1,202 @@
+// Internal/jobs/file.go.
+
+package jobs
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dkolesni-prog/transformer/internal/logging"
+)
+
+// FileQueue — MemoryQueue с журналом: каждое изменение дописывает в файл новое
+// состояние задания строкой JSON, при открытии побеждает последняя строка.
+// При открытии и в Prune журнал переписывается одной строкой на задание.
+type FileQueue struct {
+	mu     sync.Mutex
+	mem    *MemoryQueue
+	file   *os.File
+	path   string
+	logger logging.Logger
+}
+
+func NewFileQueue(path string, logger logging.Logger) (*FileQueue, error) {
+	q := &FileQueue{mem: NewMemoryQueue(), path: path, logger: logger}
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	if err := q.rewrite(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func (q *FileQueue) Enqueue(ctx context.Context, job Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.mem.put(job)
+	return q.append(job)
+}
+
+func (q *FileQueue) Claim(ctx context.Context, kinds []string, lease time.Duration) (Job, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.mem.claim(kinds, lease)
+	if !ok {
+		return Job{}, false, nil
+	}
+	return job, true, q.append(job)
+}
+
+func (q *FileQueue) Complete(ctx context.Context, id string, result json.RawMessage) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, err := q.mem.complete(id, result)
+	if err != nil {
+		return err
+	}
+	return q.append(job)
+}
+
+func (q *FileQueue) Fail(ctx context.Context, id, errMsg string, retryAt time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, err := q.mem.fail(id, errMsg, retryAt)
+	if err != nil {
+		return err
+	}
+	return q.append(job)
+}
+
+func (q *FileQueue) Get(ctx context.Context, id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.mem.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return *job, nil
+}
+
+func (q *FileQueue) Failed(ctx context.Context, kind string, limit int) ([]Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.mem.failed(kind, limit), nil
+}
+
+func (q *FileQueue) Requeue(ctx context.Context, id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, err := q.mem.requeue(id)
+	if err != nil {
+		return Job{}, err
+	}
+	return job, q.append(job)
+}
+
+func (q *FileQueue) Prune(ctx context.Context, before time.Time) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	pruned := q.mem.prune(before)
+	if pruned == 0 {
+		return 0, nil
+	}
+	return pruned, q.rewrite()
+}
+
+func (q *FileQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.file.Close(); err != nil {
+		return fmt.Errorf("close jobs file: %w", err)
+	}
+	return nil
+}
+
+func (q *FileQueue) load() error {
+	f, err := os.Open(q.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open jobs file: %w", err)
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		var job Job
+		if unmarshalErr := json.Unmarshal(sc.Bytes(), &job); unmarshalErr != nil || job.ID == "" {
+			// Оборванная при падении последняя строка.
+			q.logger.Warn("Skipping broken line in jobs file", "error", unmarshalErr, "path", q.path)
+			continue
+		}
+		q.mem.put(job)
+	}
+	if scErr := sc.Err(); scErr != nil {
+		return fmt.Errorf("read jobs file: %w", scErr)
+	}
+	return nil
+}
+
+func (q *FileQueue) append(job Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("marshal job: %w", err)
+	}
+	data = append(data, '\n')
+	if _, err := q.file.Write(data); err != nil {
+		return fmt.Errorf("write job: %w", err)
+	}
+	return nil
+}
+
+// rewrite заменяет журнал текущим состоянием через временный файл и rename.
+func (q *FileQueue) rewrite() error {
+	tmp, err := os.CreateTemp(filepath.Dir(q.path), filepath.Base(q.path)+".*")
+	if err != nil {
+		return fmt.Errorf("create jobs file: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, job := range q.mem.jobs {
+		if err := enc.Encode(job); err != nil {
+			_ = tmp.Close()
+			return fmt.Errorf("write jobs file: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write jobs file: %w", err)
+	}
+	if err := tmp.Chmod(0o600); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("chmod jobs file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close jobs file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), q.path); err != nil {
+		return fmt.Errorf("replace jobs file: %w", err)
+	}
+
+	f, err := os.OpenFile(q.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("open jobs file: %w", err)
+	}
+	if q.file != nil {
+		_ = q.file.Close()
+	}
+	q.file = f
+	return nil
+}
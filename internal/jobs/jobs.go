@@ -0,0 +1,110 @@
+// Internal/jobs/jobs.go.
+
+// Package jobs — очередь фоновых заданий, которая переживает перезапуск процесса:
+// отложенные удаления, доставка webhook, чистка удалённых ссылок. Задания хранятся
+// в таблице БД, в файле или (без того и другого) в памяти и выполняются Runner.
+//
+// Взятое в работу задание арендуется на срок lease: если процесс упал, не дойдя до
+// Complete или Fail, после истечения аренды задание снова выдаётся Claim — в том числе
+// другому экземпляру сервиса на той же БД.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrNotFound — задания с таким ID нет (или оно уже забыто после Prune).
+var ErrNotFound = errors.New("job not found")
+
+// Status — состояние задания.
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+)
+
+// Job — одно задание очереди.
+type Job struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	// UserID — владелец задания, если оно поставлено от имени пользователя.
+	UserID  string          `json:"user_id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Status  Status          `json:"status"`
+	// Attempts — сколько раз задание выдавалось в работу.
+	Attempts int             `json:"attempts"`
+	Result   json.RawMessage `json:"result,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	// RunAt — раньше этого времени задание не выдаётся (отложенный повтор).
+	RunAt time.Time `json:"run_at"`
+	// LockedUntil — до этого времени задание в работе; после — считается брошенным.
+	LockedUntil time.Time `json:"locked_until,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Finished — задание выполнено или окончательно провалено.
+func (j Job) Finished() bool {
+	return j.Status == StatusCompleted || j.Status == StatusFailed
+}
+
+// ready — задание можно выдать в работу в момент now.
+func (j Job) ready(now time.Time, kinds []string) bool {
+	if !hasKind(kinds, j.Kind) {
+		return false
+	}
+	switch j.Status {
+	case StatusPending:
+		return !j.RunAt.After(now)
+	case StatusRunning:
+		return j.LockedUntil.Before(now)
+	}
+	return false
+}
+
+func hasKind(kinds []string, kind string) bool {
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Queue — хранилище заданий.
+type Queue interface {
+	// Enqueue сохраняет новое задание в состоянии pending.
+	Enqueue(ctx context.Context, job Job) error
+	// Claim выдаёт самое раннее готовое задание одного из kinds и арендует его на lease.
+	// false — готовых заданий нет.
+	Claim(ctx context.Context, kinds []string, lease time.Duration) (Job, bool, error)
+	// Complete отмечает задание выполненным с результатом result.
+	Complete(ctx context.Context, id string, result json.RawMessage) error
+	// Fail записывает ошибку. С ненулевым retryAt задание вернётся в очередь к этому
+	// времени, с нулевым — провалено окончательно.
+	Fail(ctx context.Context, id, errMsg string, retryAt time.Time) error
+	// Get возвращает задание; ErrNotFound, если его нет.
+	Get(ctx context.Context, id string) (Job, error)
+	// Failed возвращает до limit окончательно проваленных заданий kind, недавние первыми.
+	Failed(ctx context.Context, kind string, limit int) ([]Job, error)
+	// Requeue возвращает проваленное задание в очередь с новым счётчиком попыток;
+	// ErrNotFound, если проваленного задания с таким ID нет.
+	Requeue(ctx context.Context, id string) (Job, error)
+	// Prune забывает завершённые задания, обновлённые раньше before, и возвращает их число.
+	Prune(ctx context.Context, before time.Time) (int, error)
+	Close() error
+}
+
+func newJobID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,178 @@
+// Internal/jobs/memory.go.
+
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryQueue держит задания в памяти процесса; с ним очередь не переживает перезапуск.
+type MemoryQueue struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{jobs: make(map[string]*Job)}
+}
+
+func (q *MemoryQueue) Enqueue(ctx context.Context, job Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.put(job)
+	return nil
+}
+
+func (q *MemoryQueue) Claim(ctx context.Context, kinds []string, lease time.Duration) (Job, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.claim(kinds, lease)
+	return job, ok, nil
+}
+
+func (q *MemoryQueue) Complete(ctx context.Context, id string, result json.RawMessage) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, err := q.complete(id, result)
+	return err
+}
+
+func (q *MemoryQueue) Fail(ctx context.Context, id, errMsg string, retryAt time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, err := q.fail(id, errMsg, retryAt)
+	return err
+}
+
+func (q *MemoryQueue) Get(ctx context.Context, id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return *job, nil
+}
+
+func (q *MemoryQueue) Failed(ctx context.Context, kind string, limit int) ([]Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.failed(kind, limit), nil
+}
+
+func (q *MemoryQueue) Requeue(ctx context.Context, id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.requeue(id)
+}
+
+func (q *MemoryQueue) Prune(ctx context.Context, before time.Time) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.prune(before), nil
+}
+
+func (q *MemoryQueue) Close() error {
+	return nil
+}
+
+// Дальше — изменения под q.mu, их же использует FileQueue. Каждое возвращает
+// новое состояние задания, чтобы его можно было записать в журнал.
+
+func (q *MemoryQueue) put(job Job) {
+	q.jobs[job.ID] = &job
+}
+
+func (q *MemoryQueue) claim(kinds []string, lease time.Duration) (Job, bool) {
+	now := time.Now().UTC()
+	var next *Job
+	for _, job := range q.jobs {
+		if !job.ready(now, kinds) {
+			continue
+		}
+		if next == nil || job.RunAt.Before(next.RunAt) ||
+			(job.RunAt.Equal(next.RunAt) && job.CreatedAt.Before(next.CreatedAt)) {
+			next = job
+		}
+	}
+	if next == nil {
+		return Job{}, false
+	}
+	next.Status = StatusRunning
+	next.Attempts++
+	next.LockedUntil = now.Add(lease)
+	next.UpdatedAt = now
+	return *next, true
+}
+
+func (q *MemoryQueue) complete(id string, result json.RawMessage) (Job, error) {
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	job.Status = StatusCompleted
+	job.Result = result
+	job.Error = ""
+	job.LockedUntil = time.Time{}
+	job.UpdatedAt = time.Now().UTC()
+	return *job, nil
+}
+
+func (q *MemoryQueue) fail(id, errMsg string, retryAt time.Time) (Job, error) {
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	job.Error = errMsg
+	job.LockedUntil = time.Time{}
+	job.UpdatedAt = time.Now().UTC()
+	if retryAt.IsZero() {
+		job.Status = StatusFailed
+	} else {
+		job.Status = StatusPending
+		job.RunAt = retryAt.UTC()
+	}
+	return *job, nil
+}
+
+func (q *MemoryQueue) failed(kind string, limit int) []Job {
+	var out []Job
+	for _, job := range q.jobs {
+		if job.Kind == kind && job.Status == StatusFailed {
+			out = append(out, *job)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].UpdatedAt.After(out[j].UpdatedAt) })
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+func (q *MemoryQueue) requeue(id string) (Job, error) {
+	job, ok := q.jobs[id]
+	if !ok || job.Status != StatusFailed {
+		return Job{}, ErrNotFound
+	}
+	now := time.Now().UTC()
+	job.Status = StatusPending
+	job.Attempts = 0
+	job.RunAt = now
+	job.UpdatedAt = now
+	return *job, nil
+}
+
+func (q *MemoryQueue) prune(before time.Time) int {
+	pruned := 0
+	for id, job := range q.jobs {
+		if job.Finished() && job.UpdatedAt.Before(before) {
+			delete(q.jobs, id)
+			pruned++
+		}
+	}
+	return pruned
+}
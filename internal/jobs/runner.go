@@ -0,0 +1,262 @@
+// Internal/jobs/runner.go.
+
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dkolesni-prog/transformer/internal/logging"
+)
+
+const (
+	// pollInterval — как часто простаивающий воркер заглядывает в очередь; новое
+	// задание этого процесса будит воркеры сразу.
+	pollInterval = time.Second
+	// lease — на сколько задание арендуется воркером.
+	lease = 10 * time.Minute
+	// firstBackoff — Retry.Backoff по умолчанию.
+	firstBackoff = time.Second
+	// Retention — сколько помнятся завершённые задания.
+	Retention    = 24 * time.Hour
+	pruneEvery   = time.Hour
+	defaultTries = 5
+)
+
+// Handler выполняет задание и возвращает результат, который сохраняется в Job.Result.
+type Handler func(ctx context.Context, job Job) (any, error)
+
+// Retry — политика повторов заданий одного вида. Задержка перед повтором начинается с
+// Backoff и удваивается с каждой неудачной попыткой, но не превышает MaxBackoff.
+type Retry struct {
+	// MaxAttempts <= 0 — пять попыток.
+	MaxAttempts int
+	// Backoff <= 0 — одна секунда.
+	Backoff time.Duration
+	// MaxBackoff <= 0 — без ограничения.
+	MaxBackoff time.Duration
+}
+
+// delay — пауза перед попыткой attempt+1 после неудачной попытки attempt.
+func (p Retry) delay(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt; i++ {
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			break
+		}
+		d *= 2
+	}
+	if p.MaxBackoff > 0 {
+		d = min(d, p.MaxBackoff)
+	}
+	return d
+}
+
+type kindHandler struct {
+	run   Handler
+	retry Retry
+}
+
+// Runner раздаёт задания из Queue воркерам. Обработчики регистрируются через Handle
+// до Start: задания без обработчика Runner не берёт.
+type Runner struct {
+	queue    Queue
+	logger   logging.Logger
+	handlers map[string]kindHandler
+	kinds    []string
+	wake     chan struct{}
+	// started — Start уже вызван: воркеры читают handlers и kinds без блокировки.
+	started bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewRunner(queue Queue, logger logging.Logger) *Runner {
+	r := &Runner{
+		queue:    queue,
+		logger:   logger,
+		handlers: make(map[string]kindHandler),
+		wake:     make(chan struct{}, 1),
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	return r
+}
+
+// Handle регистрирует обработчик заданий kind. maxAttempts <= 0 — пять попыток.
+func (r *Runner) Handle(kind string, maxAttempts int, h Handler) {
+	r.HandleRetry(kind, Retry{MaxAttempts: maxAttempts}, h)
+}
+
+// HandleRetry регистрирует обработчик заданий kind со своей политикой повторов.
+// Регистрация после Start — ошибка программы.
+func (r *Runner) HandleRetry(kind string, retry Retry, h Handler) {
+	if r.started {
+		panic("jobs: handler " + kind + " registered after Start")
+	}
+	if retry.MaxAttempts <= 0 {
+		retry.MaxAttempts = defaultTries
+	}
+	if retry.Backoff <= 0 {
+		retry.Backoff = firstBackoff
+	}
+	if _, ok := r.handlers[kind]; !ok {
+		r.kinds = append(r.kinds, kind)
+	}
+	r.handlers[kind] = kindHandler{run: h, retry: retry}
+}
+
+// Enqueue ставит задание kind с payload (в JSON) и возвращает его ID.
+func (r *Runner) Enqueue(ctx context.Context, kind, userID string, payload any) (string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("marshal %s payload: %w", kind, err)
+	}
+	now := time.Now().UTC()
+	job := Job{
+		ID:        newJobID(),
+		Kind:      kind,
+		UserID:    userID,
+		Payload:   data,
+		Status:    StatusPending,
+		RunAt:     now,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := r.queue.Enqueue(ctx, job); err != nil {
+		return "", fmt.Errorf("enqueue %s: %w", kind, err)
+	}
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+	return job.ID, nil
+}
+
+// Get возвращает задание из очереди.
+func (r *Runner) Get(ctx context.Context, id string) (Job, error) {
+	return r.queue.Get(ctx, id)
+}
+
+// Failed возвращает до limit окончательно проваленных заданий kind, недавние первыми.
+func (r *Runner) Failed(ctx context.Context, kind string, limit int) ([]Job, error) {
+	return r.queue.Failed(ctx, kind, limit)
+}
+
+// Requeue возвращает проваленное задание в очередь: оно снова получает все попытки.
+func (r *Runner) Requeue(ctx context.Context, id string) (Job, error) {
+	job, err := r.queue.Requeue(ctx, id)
+	if err != nil {
+		return Job{}, err
+	}
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// Start запускает workers воркеров и периодическую чистку завершённых заданий.
+func (r *Runner) Start(workers int) {
+	r.started = true
+	for range max(workers, 1) {
+		r.wg.Add(1)
+		go r.work()
+	}
+	r.wg.Add(1)
+	go r.pruneLoop()
+}
+
+// Stop не даёт воркерам брать новые задания, отменяет контекст выполняемых и ждёт
+// их до истечения ctx. Прерванные задания останутся арендованными и после истечения
+// аренды будут выполнены снова, поэтому обработчики должны быть идемпотентны.
+func (r *Runner) Stop(ctx context.Context) error {
+	r.cancel()
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *Runner) work() {
+	defer r.wg.Done()
+	for {
+		if r.ctx.Err() != nil {
+			return
+		}
+		job, ok, err := r.queue.Claim(r.ctx, r.kinds, lease)
+		if err != nil {
+			r.logger.Error("Could not claim job", "error", err)
+		}
+		if ok {
+			r.run(job)
+			continue
+		}
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-r.wake:
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func (r *Runner) run(job Job) {
+	h := r.handlers[job.Kind]
+	result, err := h.run(r.ctx, job)
+	// Итог записывается и при остановке: контекст воркеров уже может быть отменён.
+	ctx := context.WithoutCancel(r.ctx)
+	if err == nil {
+		data, marshalErr := json.Marshal(result)
+		if marshalErr != nil {
+			r.logger.Error("Could not marshal job result", "error", marshalErr, "kind", job.Kind, "job", job.ID)
+		}
+		if completeErr := r.queue.Complete(ctx, job.ID, data); completeErr != nil {
+			r.logger.Error("Could not complete job", "error", completeErr, "kind", job.Kind, "job", job.ID)
+		}
+		return
+	}
+
+	var retryAt time.Time
+	if job.Attempts < h.retry.MaxAttempts {
+		retryAt = time.Now().Add(h.retry.delay(job.Attempts))
+		r.logger.Warn("Job failed, will retry", "error", err, "kind", job.Kind, "job", job.ID, "attempt", job.Attempts)
+	} else {
+		r.logger.Error("Job failed after retries", "error", err, "kind", job.Kind, "job", job.ID, "attempts", job.Attempts)
+	}
+	if failErr := r.queue.Fail(ctx, job.ID, err.Error(), retryAt); failErr != nil {
+		r.logger.Error("Could not record job failure", "error", failErr, "kind", job.Kind, "job", job.ID)
+	}
+}
+
+func (r *Runner) pruneLoop() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(pruneEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+			pruned, err := r.queue.Prune(r.ctx, time.Now().Add(-Retention))
+			if err != nil {
+				r.logger.Error("Could not prune finished jobs", "error", err)
+				continue
+			}
+			if pruned > 0 {
+				r.logger.Info("Pruned finished jobs", "pruned", pruned)
+			}
+		}
+	}
+}
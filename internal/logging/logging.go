@@ -0,0 +1,75 @@
+// Internal/logging/logging.go.
+
+package logging
+
+import (
+	"context"
+	"io"
+
+	"github.com/rs/zerolog"
+)
+
+// Logger — всё, что сервису нужно от лога: сообщение уровня и пары ключ-значение
+// ("error", err, "short_id", id). Набор методов совпадает с *slog.Logger, так что
+// его можно передать без обёртки; New даёт прежний консольный вывод zerolog.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// New пишет в out в консольном формате zerolog с уровнем level и версией сервиса в каждой строке.
+func New(out io.Writer, level, version string) Logger {
+	parsedLevel, _ := zerolog.ParseLevel(level)
+	return zerologLogger{zerolog.New(zerolog.ConsoleWriter{Out: out}).With().
+		Str("version", version).
+		Timestamp().
+		Logger().Level(parsedLevel)}
+}
+
+// Nop ничего не пишет; удобен в тестах и как значение по умолчанию.
+func Nop() Logger {
+	return nopLogger{}
+}
+
+type ctxKey struct{}
+
+// NewContext кладёт лог в контекст, например лог запроса для middleware ниже по цепочке.
+func NewContext(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext достаёт лог из контекста; без него — Nop.
+func FromContext(ctx context.Context) Logger {
+	if l, ok := ctx.Value(ctxKey{}).(Logger); ok {
+		return l
+	}
+	return nopLogger{}
+}
+
+type zerologLogger struct {
+	l zerolog.Logger
+}
+
+func (z zerologLogger) Debug(msg string, args ...any) { z.write(z.l.Debug(), msg, args) }
+func (z zerologLogger) Info(msg string, args ...any)  { z.write(z.l.Info(), msg, args) }
+func (z zerologLogger) Warn(msg string, args ...any)  { z.write(z.l.Warn(), msg, args) }
+func (z zerologLogger) Error(msg string, args ...any) { z.write(z.l.Error(), msg, args) }
+
+func (zerologLogger) write(e *zerolog.Event, msg string, args []any) {
+	if e == nil {
+		return
+	}
+	if len(args) > 0 {
+		e = e.Fields(args)
+	}
+	e.Msg(msg)
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...any) {}
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Warn(string, ...any)  {}
+func (nopLogger) Error(string, ...any) {}
@@ -0,0 +1,107 @@
+// Internal/notify/notify.go.
+
+// Package notify шлёт операторам короткие сообщения в Slack и Telegram, когда что-то
+// требует внимания: ссылка набрала много переходов, адрес помечен вредоносным,
+// хранилище переключилось на резервное.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dkolesni-prog/transformer/internal/logging"
+)
+
+const (
+	// queueSize — сколько сообщений ждёт отправки; остальные отбрасываются с записью в лог.
+	queueSize   = 64
+	sendTimeout = 10 * time.Second
+)
+
+// Sender доставляет текст в один канал.
+type Sender interface {
+	Send(ctx context.Context, text string) error
+	Name() string
+}
+
+// Notifier рассылает сообщения всем Sender в фоне: Notify не ждёт сети и не блокирует
+// запрос. nil-Notifier ничего не делает.
+type Notifier struct {
+	senders []Sender
+	logger  logging.Logger
+	queue   chan string
+	wg      sync.WaitGroup
+}
+
+// New запускает рассылку; без senders — nil.
+func New(logger logging.Logger, senders ...Sender) *Notifier {
+	if len(senders) == 0 {
+		return nil
+	}
+	n := &Notifier{senders: senders, logger: logger, queue: make(chan string, queueSize)}
+	n.wg.Add(1)
+	go n.loop()
+	return n
+}
+
+// Notify ставит сообщение в очередь.
+func (n *Notifier) Notify(format string, args ...any) {
+	if n == nil {
+		return
+	}
+	text := fmt.Sprintf(format, args...)
+	select {
+	case n.queue <- text:
+	default:
+		n.logger.Warn("Notification queue is full, dropping message", "text", text)
+	}
+}
+
+// Close отправляет то, что уже в очереди, и останавливает рассылку.
+func (n *Notifier) Close() {
+	if n == nil {
+		return
+	}
+	close(n.queue)
+	n.wg.Wait()
+}
+
+func (n *Notifier) loop() {
+	defer n.wg.Done()
+	for text := range n.queue {
+		for _, s := range n.senders {
+			ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+			if err := s.Send(ctx, text); err != nil {
+				n.logger.Error("Could not send notification", "error", err, "channel", s.Name())
+			}
+			cancel()
+		}
+	}
+}
+
+// postJSON отправляет payload и считает ошибкой любой ответ не 2xx.
+func postJSON(ctx context.Context, client *http.Client, target string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal notification: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("build notification request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post notification: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("notification endpoint responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+// Internal/notify/senders.go.
+
+package notify
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// telegramAPI — адрес Bot API; в тестах подменяется через Telegram.APIURL.
+const telegramAPI = "https://api.telegram.org"
+
+// Slack пишет в канал через incoming webhook.
+type Slack struct {
+	WebhookURL string
+	Client     *http.Client
+}
+
+func (s *Slack) Name() string { return "slack" }
+
+func (s *Slack) Send(ctx context.Context, text string) error {
+	return postJSON(ctx, clientOrDefault(s.Client), s.WebhookURL, map[string]string{"text": text})
+}
+
+// Telegram пишет в чат ChatID от имени бота с токеном Token.
+type Telegram struct {
+	Token  string
+	ChatID string
+	// APIURL — адрес Bot API; пусто — api.telegram.org.
+	APIURL string
+	Client *http.Client
+}
+
+func (t *Telegram) Name() string { return "telegram" }
+
+func (t *Telegram) Send(ctx context.Context, text string) error {
+	api := t.APIURL
+	if api == "" {
+		api = telegramAPI
+	}
+	target := strings.TrimRight(api, "/") + "/bot" + t.Token + "/sendMessage"
+	err := postJSON(ctx, clientOrDefault(t.Client), target, map[string]any{
+		"chat_id":                  t.ChatID,
+		"text":                     text,
+		"disable_web_page_preview": true,
+	})
+	if err != nil && t.Token != "" {
+		// Токен входит в адрес запроса, а значит, и в текст ошибки net/http.
+		return redactedError{err: err, secret: t.Token}
+	}
+	return err
+}
+
+func clientOrDefault(c *http.Client) *http.Client {
+	if c != nil {
+		return c
+	}
+	return &http.Client{Timeout: sendTimeout}
+}
+
+// redactedError скрывает secret в тексте ошибки.
+type redactedError struct {
+	err    error
+	secret string
+}
+
+func (e redactedError) Error() string {
+	return strings.ReplaceAll(e.err.Error(), e.secret, "***")
+}
+
+func (e redactedError) Unwrap() error {
+	return e.err
+}
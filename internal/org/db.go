@@ -0,0 +1,151 @@
+// Internal/org/db.go.
+
+package org
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/dkolesni-prog/transformer/internal/logging"
+)
+
+// DBDirectory хранит организации в таблицах orgs и org_members.
+type DBDirectory struct {
+	pool   *pgxpool.Pool
+	logger logging.Logger
+}
+
+func NewDBDirectory(pool *pgxpool.Pool, logger logging.Logger) *DBDirectory {
+	return &DBDirectory{pool: pool, logger: logger}
+}
+
+// Bootstrap creates the organization tables if they don't exist.
+func (d *DBDirectory) Bootstrap(ctx context.Context) error {
+	const schema = `
+CREATE TABLE IF NOT EXISTS orgs (
+    name VARCHAR(64) PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE TABLE IF NOT EXISTS org_members (
+    org VARCHAR(64) NOT NULL REFERENCES orgs (name) ON DELETE CASCADE,
+    user_id VARCHAR(64) NOT NULL,
+    role VARCHAR(16) NOT NULL,
+    PRIMARY KEY (org, user_id)
+);
+`
+	if _, execErr := d.pool.Exec(ctx, schema); execErr != nil {
+		d.logger.Error("Could not create organization tables", "error", execErr)
+		return errors.New("cannot create organization tables: " + execErr.Error())
+	}
+	return nil
+}
+
+func (d *DBDirectory) Create(ctx context.Context, org, adminUserID string) error {
+	if !ValidName(org) {
+		return ErrInvalidName
+	}
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return errors.New("begin tx: " + err.Error())
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, execErr := tx.Exec(ctx, `INSERT INTO orgs (name) VALUES ($1);`, org); execErr != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(execErr, &pgErr) && pgErr.Code == "23505" {
+			return ErrExists
+		}
+		return errors.New("insert org: " + execErr.Error())
+	}
+	if _, execErr := tx.Exec(ctx,
+		`INSERT INTO org_members (org, user_id, role) VALUES ($1, $2, $3);`,
+		org, adminUserID, string(RoleAdmin)); execErr != nil {
+		return errors.New("insert org admin: " + execErr.Error())
+	}
+	if commitErr := tx.Commit(ctx); commitErr != nil {
+		return errors.New("commit tx: " + commitErr.Error())
+	}
+	return nil
+}
+
+func (d *DBDirectory) Role(ctx context.Context, org, userID string) (Role, error) {
+	const sqlSelect = `
+SELECT m.role
+FROM orgs o
+LEFT JOIN org_members m ON m.org = o.name AND m.user_id = $2
+WHERE o.name = $1;
+`
+	var role *string
+	err := d.pool.QueryRow(ctx, sqlSelect, org, userID).Scan(&role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", errors.New("select org role: " + err.Error())
+	}
+	if role == nil {
+		return "", ErrNotMember
+	}
+	return Role(*role), nil
+}
+
+func (d *DBDirectory) SetMember(ctx context.Context, org, userID string, role Role) error {
+	const sqlUpsert = `
+INSERT INTO org_members (org, user_id, role)
+VALUES ($1, $2, $3)
+ON CONFLICT (org, user_id) DO UPDATE SET role = EXCLUDED.role;
+`
+	if _, err := d.pool.Exec(ctx, sqlUpsert, org, userID, string(role)); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return ErrNotFound
+		}
+		return errors.New("upsert org member: " + err.Error())
+	}
+	return nil
+}
+
+func (d *DBDirectory) RemoveMember(ctx context.Context, org, userID string) error {
+	if _, err := d.Role(ctx, org, userID); err != nil {
+		return err
+	}
+	if _, err := d.pool.Exec(ctx, `DELETE FROM org_members WHERE org = $1 AND user_id = $2;`, org, userID); err != nil {
+		return errors.New("delete org member: " + err.Error())
+	}
+	return nil
+}
+
+func (d *DBDirectory) Members(ctx context.Context, org string) ([]Member, error) {
+	var exists bool
+	if err := d.pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM orgs WHERE name = $1);`, org).Scan(&exists); err != nil {
+		return nil, errors.New("select org: " + err.Error())
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	rows, err := d.pool.Query(ctx, `SELECT user_id, role FROM org_members WHERE org = $1 ORDER BY user_id;`, org)
+	if err != nil {
+		return nil, errors.New("select org members: " + err.Error())
+	}
+	defer rows.Close()
+
+	var out []Member
+	for rows.Next() {
+		var m Member
+		var role string
+		if scanErr := rows.Scan(&m.UserID, &role); scanErr != nil {
+			return nil, errors.New("rows.Scan: " + scanErr.Error())
+		}
+		m.Role = Role(role)
+		out = append(out, m)
+	}
+	if rowsErr := rows.Err(); rowsErr != nil {
+		return nil, errors.New("rows.Err: " + rowsErr.Error())
+	}
+	return out, nil
+}
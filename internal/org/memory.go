@@ -0,0 +1,134 @@
+// Internal/org/memory.go.
+
+package org
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// MemoryDirectory держит организации в памяти и, если задан path,
+// целиком переписывает их в JSON-файл после каждого изменения.
+type MemoryDirectory struct {
+	mu   sync.RWMutex
+	orgs map[string]map[string]Role
+	path string
+}
+
+func NewMemoryDirectory() *MemoryDirectory {
+	return &MemoryDirectory{orgs: make(map[string]map[string]Role)}
+}
+
+// NewFileDirectory загружает организации из path (если файл есть) и сохраняет изменения туда же.
+func NewFileDirectory(path string) (*MemoryDirectory, error) {
+	d := NewMemoryDirectory()
+	d.path = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read organizations file: %w", err)
+	}
+	if unmarshalErr := json.Unmarshal(data, &d.orgs); unmarshalErr != nil {
+		return nil, fmt.Errorf("parse organizations file: %w", unmarshalErr)
+	}
+	return d, nil
+}
+
+func (d *MemoryDirectory) Create(ctx context.Context, org, adminUserID string) error {
+	if !ValidName(org) {
+		return ErrInvalidName
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.orgs[org]; ok {
+		return ErrExists
+	}
+	d.orgs[org] = map[string]Role{adminUserID: RoleAdmin}
+	return d.persist()
+}
+
+func (d *MemoryDirectory) Role(ctx context.Context, org, userID string) (Role, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	members, ok := d.orgs[org]
+	if !ok {
+		return "", ErrNotFound
+	}
+	role, ok := members[userID]
+	if !ok {
+		return "", ErrNotMember
+	}
+	return role, nil
+}
+
+func (d *MemoryDirectory) SetMember(ctx context.Context, org, userID string, role Role) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	members, ok := d.orgs[org]
+	if !ok {
+		return ErrNotFound
+	}
+	members[userID] = role
+	return d.persist()
+}
+
+func (d *MemoryDirectory) RemoveMember(ctx context.Context, org, userID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	members, ok := d.orgs[org]
+	if !ok {
+		return ErrNotFound
+	}
+	if _, isMember := members[userID]; !isMember {
+		return ErrNotMember
+	}
+	delete(members, userID)
+	return d.persist()
+}
+
+func (d *MemoryDirectory) Members(ctx context.Context, org string) ([]Member, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	members, ok := d.orgs[org]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	out := make([]Member, 0, len(members))
+	for userID, role := range members {
+		out = append(out, Member{UserID: userID, Role: role})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].UserID < out[j].UserID })
+	return out, nil
+}
+
+// persist вызывается под d.mu.
+func (d *MemoryDirectory) persist() error {
+	if d.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(d.orgs)
+	if err != nil {
+		return fmt.Errorf("marshal organizations: %w", err)
+	}
+	tmp := d.path + ".tmp"
+	if wErr := os.WriteFile(tmp, data, 0o600); wErr != nil {
+		return fmt.Errorf("write organizations file: %w", wErr)
+	}
+	if rErr := os.Rename(tmp, d.path); rErr != nil {
+		return fmt.Errorf("replace organizations file: %w", rErr)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+// Internal/org/org.go.
+
+package org
+
+import (
+	"context"
+	"errors"
+	"regexp"
+)
+
+// Role — права участника организации. Каждая следующая роль включает предыдущие.
+type Role string
+
+const (
+	RoleViewer Role = "viewer"
+	RoleEditor Role = "editor"
+	RoleAdmin  Role = "admin"
+)
+
+var (
+	// ErrNotFound — организации нет.
+	ErrNotFound = errors.New("organization not found")
+	// ErrExists — организация с таким именем уже заведена.
+	ErrExists = errors.New("organization already exists")
+	// ErrNotMember — пользователь не состоит в организации.
+	ErrNotMember = errors.New("not a member of the organization")
+	// ErrInvalidName — имя организации не подходит под nameRe.
+	ErrInvalidName = errors.New("invalid organization name")
+)
+
+// ownerPrefix отделяет ссылки организаций от ссылок пользователей с куками.
+const ownerPrefix = "org:"
+
+var nameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// Member — участник организации и его роль.
+type Member struct {
+	UserID string `json:"user_id"`
+	Role   Role   `json:"role"`
+}
+
+// Directory хранит организации и членство в них.
+type Directory interface {
+	// Create заводит организацию, adminUserID становится её администратором.
+	Create(ctx context.Context, org, adminUserID string) error
+	// Role возвращает роль userID; ErrNotFound или ErrNotMember, если её нет.
+	Role(ctx context.Context, org, userID string) (Role, error)
+	// SetMember добавляет участника или меняет его роль.
+	SetMember(ctx context.Context, org, userID string, role Role) error
+	// RemoveMember исключает участника; ErrNotMember, если его не было.
+	RemoveMember(ctx context.Context, org, userID string) error
+	Members(ctx context.Context, org string) ([]Member, error)
+}
+
+// OwnerID — идентификатор, под которым ссылки организации лежат в store.Store.
+func OwnerID(org string) string {
+	return ownerPrefix + org
+}
+
+// ValidName сообщает, годится ли строка в имя организации.
+func ValidName(org string) bool {
+	return nameRe.MatchString(org)
+}
+
+// ValidRole сообщает, известна ли роль.
+func ValidRole(role Role) bool {
+	return role == RoleViewer || role == RoleEditor || role == RoleAdmin
+}
+
+// Allows сообщает, достаточно ли роли r для действия, требующего need.
+func (r Role) Allows(need Role) bool {
+	return rank(r) >= rank(need)
+}
+
+func rank(r Role) int {
+	switch r {
+	case RoleViewer:
+		return 1
+	case RoleEditor:
+		return 2
+	case RoleAdmin:
+		return 3
+	}
+	return 0
+}
@@ -0,0 +1,63 @@
+// Internal/ratelimit/memory.go.
+
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepEvery — как часто Memory забывает полные корзины: их состояние совпадает с новой.
+const sweepEvery = time.Minute
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// Memory держит корзины в памяти процесса: за балансировщиком у каждого экземпляра
+// свой счёт, для общего лимита нужен Redis.
+type Memory struct {
+	limit Limit
+	now   func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewMemory(limit Limit) *Memory {
+	return &Memory{limit: limit, now: time.Now, buckets: make(map[string]*bucket)}
+}
+
+func (m *Memory) Allow(_ context.Context, key string) (Decision, error) {
+	now := m.now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now.Sub(m.lastSweep) >= sweepEvery {
+		m.sweep(now)
+	}
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: m.limit.burst(), at: now}
+		m.buckets[key] = b
+	}
+	var d Decision
+	d, b.tokens = decide(m.limit, m.refill(b, now))
+	b.at = now
+	return d, nil
+}
+
+func (m *Memory) refill(b *bucket, now time.Time) float64 {
+	return min(m.limit.burst(), b.tokens+now.Sub(b.at).Seconds()*m.limit.perSecond())
+}
+
+func (m *Memory) sweep(now time.Time) {
+	for key, b := range m.buckets {
+		if m.refill(b, now) >= m.limit.burst() {
+			delete(m.buckets, key)
+		}
+	}
+	m.lastSweep = now
+}
@@ -0,0 +1,57 @@
+// Internal/ratelimit/ratelimit.go.
+
+// Package ratelimit ограничивает частоту запросов по ключу (IP клиента) корзиной токенов:
+// корзина вмещает Burst запросов и пополняется на PerMinute токенов в минуту.
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Limit — параметры корзины. Burst <= 0 — равен PerMinute.
+type Limit struct {
+	PerMinute int
+	Burst     int
+}
+
+func (l Limit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.PerMinute)
+}
+
+// perSecond — скорость пополнения корзины.
+func (l Limit) perSecond() float64 {
+	return float64(l.PerMinute) / 60
+}
+
+// Decision — итог проверки запроса.
+type Decision struct {
+	Allowed bool
+	// Limit — ёмкость корзины, Remaining — сколько запросов ещё пройдёт сразу.
+	Limit     int
+	Remaining int
+	// RetryAfter — когда появится следующий токен; только для отказа.
+	RetryAfter time.Duration
+}
+
+// Limiter решает, пропустить ли очередной запрос с ключом key.
+type Limiter interface {
+	Allow(ctx context.Context, key string) (Decision, error)
+}
+
+// decide списывает токен из корзины с tokens токенами (уже пополненной) и возвращает
+// итог и остаток.
+func decide(l Limit, tokens float64) (Decision, float64) {
+	d := Decision{Limit: int(l.burst())}
+	if tokens >= 1 {
+		tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = time.Duration((1 - tokens) / l.perSecond() * float64(time.Second))
+	}
+	d.Remaining = int(tokens)
+	return d, tokens
+}
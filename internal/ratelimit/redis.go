@@ -0,0 +1,80 @@
+// Internal/ratelimit/redis.go.
+
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const keyPrefix = "shortener:ratelimit:"
+
+// tokenBucket пополняет и списывает корзину атомарно. Время берётся у Redis, чтобы
+// расхождение часов экземпляров не влияло на счёт; ключ живёт, пока корзина не полна.
+var tokenBucket = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'at')
+local tokens = tonumber(state[1]) or burst
+local at = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - at) * rate)
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'at', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate))
+return {allowed, tostring(tokens)}
+`)
+
+// Redis держит корзины в Redis, общие для всех экземпляров сервиса.
+type Redis struct {
+	client *redis.Client
+	limit  Limit
+}
+
+func NewRedis(ctx context.Context, addr string, limit Limit) (*Redis, error) {
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("redis ping: %w", err)
+	}
+	return &Redis{client: client, limit: limit}, nil
+}
+
+func (r *Redis) Allow(ctx context.Context, key string) (Decision, error) {
+	perMilli := r.limit.perSecond() / 1000
+	res, err := tokenBucket.Run(ctx, r.client, []string{keyPrefix + key}, perMilli, r.limit.burst()).Slice()
+	if err != nil {
+		return Decision{}, fmt.Errorf("redis rate limit: %w", err)
+	}
+	if len(res) != 2 {
+		return Decision{}, fmt.Errorf("redis rate limit: unexpected reply %v", res)
+	}
+	allowed, _ := res[0].(int64)
+	raw, _ := res[1].(string)
+	tokens, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return Decision{}, fmt.Errorf("redis rate limit: bad token count %q", raw)
+	}
+	// Скрипт уже списал токен: восстанавливаем остаток до списания для общего расчёта.
+	if allowed == 1 {
+		tokens++
+	}
+	d, _ := decide(r.limit, math.Max(tokens, 0))
+	return d, nil
+}
+
+func (r *Redis) Close() error {
+	if err := r.client.Close(); err != nil {
+		return fmt.Errorf("redis close: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+// Internal/retention/retention.go.
+
+// Package retention удаляет давно заброшенные ссылки, чтобы долгоживущий инстанс не рос бесконечно.
+package retention
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dkolesni-prog/transformer/internal/clicks"
+	"github.com/dkolesni-prog/transformer/internal/logging"
+	"github.com/dkolesni-prog/transformer/internal/store"
+)
+
+const (
+	// countsBatch — сколько shortID за раз отдаём в clicks.Log.Counts.
+	countsBatch = 500
+	// maxReported — сколько shortID попадает в отчёт; остальные только считаются.
+	maxReported = 1000
+)
+
+// ErrShortHistory — журнал переходов помнит меньше, чем Policy.Idle, и по нему нельзя
+// понять, что ссылкой не пользуются (например, журнал в памяти после рестарта).
+var ErrShortHistory = errors.New("retention: click history is shorter than the idle period")
+
+// Policy — ссылка устаревшая, если ей больше MinAge и по ней не было переходов людей за Idle.
+type Policy struct {
+	Idle   time.Duration
+	MinAge time.Duration
+	DryRun bool
+	// HistorySince — с какого момента журнал переходов полон; нулевое значение — с самого начала.
+	HistorySince time.Time
+}
+
+// Report — итог одного прохода.
+type Report struct {
+	DryRun  bool      `json:"dry_run"`
+	Cutoff  time.Time `json:"cutoff"`
+	Checked int       `json:"checked"`
+	Stale   int       `json:"stale"`
+	Deleted int       `json:"deleted"`
+	// Links — первые maxReported устаревших ссылок.
+	Links []string `json:"links,omitempty"`
+}
+
+type candidate struct {
+	shortID string
+	userID  string
+}
+
+// Run находит устаревшие ссылки и, если это не DryRun, мягко удаляет их через DeleteBatch
+// от имени владельцев — так срабатывают аудит и вебхуки (событие link.expired, см.
+// store.WithExpiry). Окончательно их убирает purge.
+func Run(ctx context.Context, s store.Store, log clicks.Log, p Policy) (Report, error) {
+	now := time.Now().UTC()
+	rep := Report{DryRun: p.DryRun, Cutoff: now.Add(-p.Idle)}
+	if p.Idle <= 0 {
+		return rep, errors.New("retention: idle period is not set")
+	}
+	if rep.Cutoff.Before(p.HistorySince) {
+		return rep, ErrShortHistory
+	}
+
+	bornBefore := now.Add(-p.MinAge)
+	var candidates []candidate
+	err := s.ExportRecords(ctx, func(rec store.Record) error {
+		if rec.IsDeleted {
+			return nil
+		}
+		rep.Checked++
+		if rec.CreatedAt.IsZero() || !rec.CreatedAt.Before(bornBefore) || !rec.CreatedAt.Before(rep.Cutoff) {
+			return nil
+		}
+		candidates = append(candidates, candidate{shortID: rec.ShortURL, userID: rec.UserID})
+		return nil
+	})
+	if err != nil {
+		return rep, err
+	}
+
+	byUser := make(map[string][]string)
+	for start := 0; start < len(candidates); start += countsBatch {
+		chunk := candidates[start:min(start+countsBatch, len(candidates))]
+		ids := make([]string, len(chunk))
+		for i, c := range chunk {
+			ids[i] = c.shortID
+		}
+		counts, countErr := log.Counts(ctx, ids, rep.Cutoff)
+		if countErr != nil {
+			return rep, countErr
+		}
+		for _, c := range chunk {
+			if counts[c.shortID] > 0 {
+				continue
+			}
+			rep.Stale++
+			if len(rep.Links) < maxReported {
+				rep.Links = append(rep.Links, c.shortID)
+			}
+			byUser[c.userID] = append(byUser[c.userID], c.shortID)
+		}
+	}
+	if p.DryRun {
+		return rep, nil
+	}
+
+	ctx = store.WithExpiry(ctx)
+	for userID, ids := range byUser {
+		results, delErr := s.DeleteBatch(ctx, userID, ids)
+		if delErr != nil {
+			return rep, delErr
+		}
+		rep.Deleted += store.CountDeleted(results)
+	}
+	return rep, nil
+}
+
+// Worker запускает Run раз в interval до Stop.
+type Worker struct {
+	logger logging.Logger
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// Start запускает фоновую чистку; первый проход — через interval после старта.
+func Start(s store.Store, log clicks.Log, p Policy, interval time.Duration, logger logging.Logger) *Worker {
+	w := &Worker{logger: logger, stop: make(chan struct{}), done: make(chan struct{})}
+	go w.loop(s, log, p, interval)
+	return w
+}
+
+// Stop дожидается окончания текущего прохода.
+func (w *Worker) Stop() {
+	close(w.stop)
+	<-w.done
+}
+
+func (w *Worker) loop(s store.Store, log clicks.Log, p Policy, interval time.Duration) {
+	defer close(w.done)
+	if interval <= 0 {
+		<-w.stop
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				select {
+				case <-w.stop:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+			rep, err := Run(ctx, s, log, p)
+			cancel()
+			w.logReport(rep, err)
+		}
+	}
+}
+
+func (w *Worker) logReport(rep Report, err error) {
+	switch {
+	case errors.Is(err, ErrShortHistory):
+		w.logger.Info("Retention skipped: click history is too short yet", "cutoff", rep.Cutoff)
+	case err != nil:
+		w.logger.Error("Retention run failed", "error", err, "deleted", rep.Deleted)
+	default:
+		w.logger.Info("Retention run finished",
+			"dry_run", rep.DryRun,
+			"checked", rep.Checked,
+			"stale", rep.Stale,
+			"deleted", rep.Deleted,
+			"links", rep.Links,
+		)
+	}
+}
@@ -0,0 +1,160 @@
+// Internal/scheduler/cron.go.
+
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule — разобранное cron-выражение.
+type Schedule struct {
+	expr   string
+	every  time.Duration
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	// domStar и dowStar — поле задано как "*": по правилам cron день подходит, если
+	// совпал любой из двух ограниченных, а "*" в одном из них не ограничивает.
+	domStar bool
+	dowStar bool
+}
+
+var shorthands = map[string]string{
+	"@yearly":  "0 0 1 1 *",
+	"@monthly": "0 0 1 * *",
+	"@weekly":  "0 0 * * 0",
+	"@daily":   "0 0 * * *",
+	"@hourly":  "0 * * * *",
+}
+
+// Parse разбирает выражение из пяти полей "минута час день месяц день_недели" (числа,
+// "*", списки через запятую, диапазоны a-b и шаг /n; воскресенье — 0 или 7),
+// а также @hourly, @daily, @weekly, @monthly, @yearly и "@every 15m".
+func Parse(expr string) (Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if d, ok := strings.CutPrefix(expr, "@every "); ok {
+		every, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil || every < time.Second {
+			return Schedule{}, fmt.Errorf("cron %q: want @every with a duration of at least 1s", expr)
+		}
+		return Schedule{expr: expr, every: every}, nil
+	}
+	full := expr
+	if s, ok := shorthands[expr]; ok {
+		full = s
+	}
+	fields := strings.Fields(full)
+	if len(fields) != 5 {
+		return Schedule{}, fmt.Errorf("cron %q: want 5 fields, got %d", expr, len(fields))
+	}
+
+	s := Schedule{expr: expr, domStar: fields[2] == "*", dowStar: fields[4] == "*"}
+	var err error
+	if s.minute, err = parseField(fields[0], 0, 59); err != nil {
+		return Schedule{}, fmt.Errorf("cron %q minute: %w", expr, err)
+	}
+	if s.hour, err = parseField(fields[1], 0, 23); err != nil {
+		return Schedule{}, fmt.Errorf("cron %q hour: %w", expr, err)
+	}
+	if s.dom, err = parseField(fields[2], 1, 31); err != nil {
+		return Schedule{}, fmt.Errorf("cron %q day of month: %w", expr, err)
+	}
+	if s.month, err = parseField(fields[3], 1, 12); err != nil {
+		return Schedule{}, fmt.Errorf("cron %q month: %w", expr, err)
+	}
+	if s.dow, err = parseField(fields[4], 0, 7); err != nil {
+		return Schedule{}, fmt.Errorf("cron %q day of week: %w", expr, err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+// parseField возвращает битовую маску допустимых значений поля.
+func parseField(field string, lo, hi int) (uint64, error) {
+	var mask uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("bad step %q", part)
+			}
+			step = n
+		}
+		from, to := lo, hi
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			a, b, _ := strings.Cut(rng, "-")
+			var errA, errB error
+			from, errA = strconv.Atoi(a)
+			to, errB = strconv.Atoi(b)
+			if errA != nil || errB != nil || from > to {
+				return 0, fmt.Errorf("bad range %q", part)
+			}
+		default:
+			n, err := strconv.Atoi(rng)
+			if err != nil {
+				return 0, fmt.Errorf("bad value %q", part)
+			}
+			from = n
+			if !hasStep {
+				to = n
+			}
+		}
+		if from < lo || to > hi {
+			return 0, fmt.Errorf("%q is out of %d-%d", part, lo, hi)
+		}
+		for v := from; v <= to; v += step {
+			mask |= 1 << v
+		}
+	}
+	return mask, nil
+}
+
+// String возвращает исходное выражение.
+func (s Schedule) String() string {
+	return s.expr
+}
+
+// Next — ближайший момент запуска строго после t (с точностью до минуты, в часовом поясе t).
+// Для невыполнимого выражения (например, 30 февраля) — нулевое время.
+func (s Schedule) Next(t time.Time) time.Time {
+	if s.every > 0 {
+		return t.Add(s.every)
+	}
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,187 @@
+// Internal/scheduler/scheduler.go.
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dkolesni-prog/transformer/internal/logging"
+)
+
+// Func — обслуживающая задача. Результат (например, число удалённых записей) попадает в статус.
+type Func func(ctx context.Context) (any, error)
+
+// Status — состояние задачи для админского API.
+type Status struct {
+	Name     string `json:"name"`
+	Schedule string `json:"schedule"`
+	Running  bool   `json:"running"`
+	// NextRun — следующий плановый запуск; нулевой, если планировщик остановлен.
+	NextRun      *time.Time `json:"next_run,omitempty"`
+	LastStart    *time.Time `json:"last_start,omitempty"`
+	LastEnd      *time.Time `json:"last_end,omitempty"`
+	LastDuration string     `json:"last_duration,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	LastResult   any        `json:"last_result,omitempty"`
+	Runs         int        `json:"runs"`
+	Failures     int        `json:"failures"`
+}
+
+type task struct {
+	schedule Schedule
+	run      Func
+
+	mu     sync.Mutex
+	status Status
+}
+
+// Scheduler запускает задачи по cron-расписанию внутри процесса. Запуски одной задачи
+// не перекрываются: пропущенные, пока задача работала, не догоняются.
+type Scheduler struct {
+	logger logging.Logger
+	tasks  map[string]*task
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	started bool
+}
+
+func New(logger logging.Logger) *Scheduler {
+	s := &Scheduler{logger: logger, tasks: make(map[string]*task)}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s
+}
+
+// Add регистрирует задачу name с расписанием expr. Пустое expr — задача выключена и не
+// добавляется. Вызывается до Start.
+func (s *Scheduler) Add(name, expr string, run Func) error {
+	if expr == "" {
+		return nil
+	}
+	if s.started {
+		return fmt.Errorf("scheduler: task %s added after start", name)
+	}
+	schedule, err := Parse(expr)
+	if err != nil {
+		return fmt.Errorf("schedule %s: %w", name, err)
+	}
+	s.tasks[name] = &task{
+		schedule: schedule,
+		run:      run,
+		status:   Status{Name: name, Schedule: schedule.String()},
+	}
+	return nil
+}
+
+// Start запускает по горутине на задачу.
+func (s *Scheduler) Start() {
+	s.started = true
+	for _, t := range s.tasks {
+		s.wg.Add(1)
+		go s.loop(t)
+	}
+}
+
+// Stop отменяет контекст выполняемых задач и ждёт их завершения до истечения ctx.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.cancel()
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Statuses возвращает состояние всех задач, отсортированное по имени.
+func (s *Scheduler) Statuses() []Status {
+	out := make([]Status, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		t.mu.Lock()
+		out = append(out, t.status)
+		t.mu.Unlock()
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Run выполняет задачу name вне расписания и ждёт её. false — такой задачи нет.
+func (s *Scheduler) Run(ctx context.Context, name string) (Status, bool) {
+	t, ok := s.tasks[name]
+	if !ok {
+		return Status{}, false
+	}
+	s.execute(ctx, t)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status, true
+}
+
+func (s *Scheduler) loop(t *task) {
+	defer s.wg.Done()
+	for {
+		next := t.schedule.Next(time.Now())
+		if next.IsZero() {
+			s.logger.Warn("Scheduled task will never run", "task", t.status.Name, "schedule", t.schedule.String())
+			return
+		}
+		t.mu.Lock()
+		t.status.NextRun = &next
+		t.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			t.mu.Lock()
+			t.status.NextRun = nil
+			t.mu.Unlock()
+			return
+		case <-timer.C:
+		}
+		s.execute(s.ctx, t)
+	}
+}
+
+// execute запускает задачу, если она ещё не выполняется, и записывает итог.
+func (s *Scheduler) execute(ctx context.Context, t *task) {
+	t.mu.Lock()
+	if t.status.Running {
+		t.mu.Unlock()
+		s.logger.Warn("Scheduled task is still running, skipping", "task", t.status.Name)
+		return
+	}
+	start := time.Now()
+	t.status.Running = true
+	t.status.LastStart = &start
+	t.mu.Unlock()
+
+	result, err := t.run(ctx)
+
+	end := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status.Running = false
+	t.status.LastEnd = &end
+	t.status.LastDuration = end.Sub(start).Round(time.Millisecond).String()
+	t.status.LastResult = result
+	t.status.Runs++
+	t.status.LastError = ""
+	if err != nil {
+		t.status.Failures++
+		t.status.LastError = err.Error()
+		s.logger.Error("Scheduled task failed", "error", err, "task", t.status.Name)
+		return
+	}
+	s.logger.Info("Scheduled task finished", "task", t.status.Name, "duration", t.status.LastDuration)
+}
@@ -0,0 +1,55 @@
+// Internal/shard/ring.go.
+
+// Package shard раскладывает ссылки по нескольким базам Postgres согласованным хэшированием
+// shortID, когда одной базе не хватает производительности на запись.
+package shard
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// replicas — точек на кольце у каждого шарда: чем больше, тем ровнее распределение.
+const replicas = 128
+
+// Ring — кольцо согласованного хэширования. Шарды называются по номеру, поэтому порядок
+// баз менять нельзя; новая база в конце списка забирает примерно 1/N ключей, и их записи
+// надо перенести самостоятельно.
+type Ring struct {
+	points []uint64
+	owners map[uint64]int
+}
+
+func NewRing(shards int) *Ring {
+	r := &Ring{owners: make(map[uint64]int, shards*replicas)}
+	for i := range shards {
+		for v := range replicas {
+			p := hash("shard-" + strconv.Itoa(i) + "#" + strconv.Itoa(v))
+			if _, taken := r.owners[p]; taken {
+				continue
+			}
+			r.owners[p] = i
+			r.points = append(r.points, p)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// Owner — номер шарда, которому принадлежит shortID.
+func (r *Ring) Owner(shortID string) int {
+	h := hash(shortID)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+// hash — первые 8 байт SHA-256: у FNV близкие id ("abc1", "abc2") ложатся рядом на кольце.
+func hash(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
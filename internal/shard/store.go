@@ -0,0 +1,296 @@
+// Internal/shard/store.go.
+
+package shard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/dkolesni-prog/transformer/internal/config"
+	"github.com/dkolesni-prog/transformer/internal/shortid"
+	"github.com/dkolesni-prog/transformer/internal/store"
+)
+
+// idLength — длина первого кандидата в id, по которому выбирается шард для нового адреса.
+const idLength = 8
+
+// Shard — хранилище одного шарда; store.RDB.
+type Shard interface {
+	store.Store
+	AcceptIDs(fn func(shortID string) bool)
+}
+
+// Store распределяет ссылки по шардам по их shortID. Операции с одной ссылкой идут в её
+// шард, списки пользователя и выгрузка обходят все. Повтор адреса распознаётся только
+// внутри шарда: со случайными id один адрес может попасть в два шарда, с shortid.HashIDs — нет.
+type Store struct {
+	shards []Shard
+	ring   *Ring
+}
+
+func New(shards []Shard) *Store {
+	s := &Store{shards: shards, ring: NewRing(len(shards))}
+	for i, sh := range shards {
+		sh.AcceptIDs(func(shortID string) bool { return s.ring.Owner(shortID) == i })
+	}
+	return s
+}
+
+func (s *Store) owner(shortID string) Shard {
+	return s.shards[s.ring.Owner(shortID)]
+}
+
+// forURL выбирает шард для нового адреса по первому кандидату в id: для случайных id это
+// равномерно, для shortid.HashIDs — всегда тот же шард, что и в прошлый раз.
+func (s *Store) forURL(u *url.URL) (int, error) {
+	id, err := shortid.ForURL(u.String(), idLength, 0)
+	if err != nil {
+		return 0, fmt.Errorf("pick shard: %w", err)
+	}
+	return s.ring.Owner(id), nil
+}
+
+// byShard раскладывает ids по шардам; idx[i] — позиции ids[i] в исходном списке.
+func (s *Store) byShard(ids []string) (groups map[int][]string, idx map[int][]int) {
+	groups = make(map[int][]string)
+	idx = make(map[int][]int)
+	for i, id := range ids {
+		n := s.ring.Owner(id)
+		groups[n] = append(groups[n], id)
+		idx[n] = append(idx[n], i)
+	}
+	return groups, idx
+}
+
+// each выполняет fn на всех шардах одновременно.
+func (s *Store) each(ctx context.Context, fn func(ctx context.Context, i int, sh Shard) error) error {
+	g, ctx := errgroup.WithContext(ctx)
+	for i, sh := range s.shards {
+		g.Go(func() error { return fn(ctx, i, sh) })
+	}
+	return g.Wait()
+}
+
+func (s *Store) Bootstrap(ctx context.Context) error {
+	return s.each(ctx, func(ctx context.Context, i int, sh Shard) error {
+		if err := sh.Bootstrap(ctx); err != nil {
+			return fmt.Errorf("shard %d: %w", i, err)
+		}
+		return nil
+	})
+}
+
+func (s *Store) Save(ctx context.Context, userID string, u *url.URL, meta store.LinkMeta, cfg *config.Config) (string, error) {
+	n, err := s.forURL(u)
+	if err != nil {
+		return "", err
+	}
+	return s.shards[n].Save(ctx, userID, u, meta, cfg)
+}
+
+// SaveBatch делит пачку по шардам и сохраняет части параллельно.
+func (s *Store) SaveBatch(ctx context.Context, userID string, urls []*url.URL, metas []store.LinkMeta, cfg *config.Config) ([]store.SavedURL, error) {
+	groups := make(map[int][]*url.URL)
+	idx := make(map[int][]int)
+	groupMetas := make(map[int][]store.LinkMeta)
+	for i, u := range urls {
+		n, err := s.forURL(u)
+		if err != nil {
+			return nil, err
+		}
+		groups[n] = append(groups[n], u)
+		idx[n] = append(idx[n], i)
+		if i < len(metas) {
+			groupMetas[n] = append(groupMetas[n], metas[i])
+		}
+	}
+	out := make([]store.SavedURL, len(urls))
+	err := s.each(ctx, func(ctx context.Context, n int, sh Shard) error {
+		if len(groups[n]) == 0 {
+			return nil
+		}
+		saved, err := sh.SaveBatch(ctx, userID, groups[n], groupMetas[n], cfg)
+		if err != nil {
+			return err
+		}
+		for j, item := range saved {
+			out[idx[n][j]] = item
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *Store) LoadFull(ctx context.Context, shortID string) (*url.URL, bool, error) {
+	return s.owner(shortID).LoadFull(ctx, shortID)
+}
+
+// LoadUserURLs собирает ссылки пользователя со всех шардов, по порядку шардов.
+func (s *Store) LoadUserURLs(ctx context.Context, userID string, baseURL string) ([]store.UserURL, error) {
+	parts := make([][]store.UserURL, len(s.shards))
+	err := s.each(ctx, func(ctx context.Context, i int, sh Shard) error {
+		list, err := sh.LoadUserURLs(ctx, userID, baseURL)
+		parts[i] = list
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var out []store.UserURL
+	for _, part := range parts {
+		out = append(out, part...)
+	}
+	return out, nil
+}
+
+func (s *Store) DeleteBatch(ctx context.Context, userID string, shortIDs []string) ([]store.DeleteResult, error) {
+	groups, idx := s.byShard(shortIDs)
+	out := make([]store.DeleteResult, len(shortIDs))
+	err := s.each(ctx, func(ctx context.Context, n int, sh Shard) error {
+		if len(groups[n]) == 0 {
+			return nil
+		}
+		results, err := sh.DeleteBatch(ctx, userID, groups[n])
+		if err != nil {
+			return err
+		}
+		for j, res := range results {
+			out[idx[n][j]] = res
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *Store) RestoreBatch(ctx context.Context, userID string, shortIDs []string) ([]string, error) {
+	groups, _ := s.byShard(shortIDs)
+	var mu sync.Mutex
+	var restored []string
+	err := s.each(ctx, func(ctx context.Context, n int, sh Shard) error {
+		if len(groups[n]) == 0 {
+			return nil
+		}
+		ids, err := sh.RestoreBatch(ctx, userID, groups[n])
+		mu.Lock()
+		restored = append(restored, ids...)
+		mu.Unlock()
+		return err
+	})
+	return restored, err
+}
+
+func (s *Store) UpdateURL(ctx context.Context, userID, shortID string, u *url.URL) error {
+	return s.owner(shortID).UpdateURL(ctx, userID, shortID, u)
+}
+
+func (s *Store) TransferOwner(ctx context.Context, fromUserID, shortID, toUserID string) error {
+	return s.owner(shortID).TransferOwner(ctx, fromUserID, shortID, toUserID)
+}
+
+func (s *Store) EraseUser(ctx context.Context, userID string) ([]string, error) {
+	var mu sync.Mutex
+	var erased []string
+	err := s.each(ctx, func(ctx context.Context, _ int, sh Shard) error {
+		ids, err := sh.EraseUser(ctx, userID)
+		mu.Lock()
+		erased = append(erased, ids...)
+		mu.Unlock()
+		return err
+	})
+	return erased, err
+}
+
+func (s *Store) ImportRecords(ctx context.Context, records []store.Record) error {
+	groups := make(map[int][]store.Record)
+	for _, rec := range records {
+		n := s.ring.Owner(rec.ShortURL)
+		groups[n] = append(groups[n], rec)
+	}
+	return s.each(ctx, func(ctx context.Context, n int, sh Shard) error {
+		if len(groups[n]) == 0 {
+			return nil
+		}
+		return sh.ImportRecords(ctx, groups[n])
+	})
+}
+
+// ExportRecords обходит шарды по очереди: fn не обязана быть потокобезопасной.
+func (s *Store) ExportRecords(ctx context.Context, fn func(store.Record) error) error {
+	for _, sh := range s.shards {
+		if err := sh.ExportRecords(ctx, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) LoadOwner(ctx context.Context, shortID string) (string, error) {
+	return s.owner(shortID).LoadOwner(ctx, shortID)
+}
+
+func (s *Store) LoadMeta(ctx context.Context, shortID string) (store.LinkMeta, error) {
+	return s.owner(shortID).LoadMeta(ctx, shortID)
+}
+
+func (s *Store) SetMeta(ctx context.Context, userID, shortID string, meta store.LinkMeta) error {
+	return s.owner(shortID).SetMeta(ctx, userID, shortID, meta)
+}
+
+// PurgeDeleted чистит шарды, которые это умеют, и возвращает общее число записей.
+func (s *Store) PurgeDeleted(ctx context.Context) (int, error) {
+	var mu sync.Mutex
+	total := 0
+	err := s.each(ctx, func(ctx context.Context, _ int, sh Shard) error {
+		purger, ok := sh.(store.Purger)
+		if !ok {
+			return nil
+		}
+		n, err := purger.PurgeDeleted(ctx)
+		mu.Lock()
+		total += n
+		mu.Unlock()
+		return err
+	})
+	return total, err
+}
+
+func (s *Store) Ping(ctx context.Context) error {
+	return s.each(ctx, func(ctx context.Context, i int, sh Shard) error {
+		if err := sh.Ping(ctx); err != nil {
+			return fmt.Errorf("shard %d: %w", i, err)
+		}
+		return nil
+	})
+}
+
+// Close закрывает все шарды, даже если какой-то вернул ошибку.
+func (s *Store) Close(ctx context.Context) error {
+	var errs []error
+	for i, sh := range s.shards {
+		if err := sh.Close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("shard %d: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Primary возвращает шард 0: в его базе живут аудит, задания, организации и клики.
+func (s *Store) Primary() Shard {
+	return s.shards[0]
+}
+
+// Shards возвращает шарды в порядке кольца.
+func (s *Store) Shards() []Shard {
+	return s.shards
+}
@@ -0,0 +1,79 @@
+// Internal/shortid/growth.go.
+
+package shortid
+
+import "sync"
+
+const (
+	// growthWindow — по скольким кандидатам оценивается доля коллизий.
+	growthWindow = 200
+	// maxGrowth — на сколько символов длина может вырасти сверх запрошенной.
+	maxGrowth = 8
+)
+
+// growth следит за долей случайных кандидатов, которые оказались заняты. Когда она
+// достигает порога, пространство ключей считается насыщенным и длина растёт на символ.
+type growth struct {
+	mu         sync.Mutex
+	threshold  float64
+	onGrow     func(extra int)
+	extra      int
+	tries      int
+	collisions int
+}
+
+var idGrowth = &growth{}
+
+func (g *growth) configure(threshold float64, onGrow func(extra int)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.threshold = threshold
+	g.onGrow = onGrow
+	g.extra, g.tries, g.collisions = 0, 0, 0
+}
+
+func (g *growth) current() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.extra
+}
+
+func (g *growth) observe(collided bool) {
+	g.mu.Lock()
+	if g.threshold <= 0 {
+		g.mu.Unlock()
+		return
+	}
+	g.tries++
+	if collided {
+		g.collisions++
+	}
+	if g.tries < growthWindow {
+		g.mu.Unlock()
+		return
+	}
+	grown := float64(g.collisions)/float64(g.tries) >= g.threshold && g.extra < maxGrowth
+	if grown {
+		g.extra++
+	}
+	g.tries, g.collisions = 0, 0
+	extra, onGrow := g.extra, g.onGrow
+	g.mu.Unlock()
+	if grown && onGrow != nil {
+		onGrow(extra)
+	}
+}
+
+// Observe сообщает, был ли случайный кандидат из ForURL уже занят. Кандидаты режима
+// HashIDs не учитываются: их коллизии не говорят о насыщении.
+func Observe(collided bool) {
+	if HashIDs() {
+		return
+	}
+	idGrowth.observe(collided)
+}
+
+// Growth — на сколько символов случайные id сейчас длиннее запрошенных.
+func Growth() int {
+	return idGrowth.current()
+}
@@ -0,0 +1,237 @@
+// Internal/shortid/shortid.go.
+
+// Package shortid генерирует и проверяет короткие идентификаторы ссылок.
+package shortid
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/dkolesni-prog/transformer/internal/helpers"
+)
+
+// defaultReserved — первые сегменты путей, занятые роутером или типичные для
+// служебных URL. Такой shortID перекрывался бы маршрутом или путал бы пользователей.
+var defaultReserved = []string{
+	"api", "admin", "ping", "version", "health", "healthz", "ready", "metrics",
+	"debug", "static", "assets", "ui", "login", "logout", "favicon.ico", "robots.txt",
+}
+
+// defaultBadWords — короткие грубые слова, которые реально выпадают в 8 случайных символах.
+var defaultBadWords = []string{
+	"anal", "anus", "cock", "cunt", "dick", "fag", "fuck", "nazi",
+	"piss", "porn", "shit", "slut", "tits", "twat", "whore",
+}
+
+var (
+	// ErrReserved — id совпадает со служебным словом.
+	ErrReserved = errors.New("short ID is reserved")
+	// ErrOffensive — id содержит слово из стоп-листа.
+	ErrOffensive = errors.New("short ID contains an offensive word")
+)
+
+// Options настраивают генератор. Пустые поля — встроенные списки.
+type Options struct {
+	// Reserved — дополнительные служебные слова через запятую.
+	Reserved string
+	// WordlistPath — файл стоп-слов (по одному на строку, # — комментарий),
+	// заменяет встроенный список.
+	WordlistPath string
+	// CaseInsensitive — генерировать id только в нижнем регистре и приводить к нему при поиске.
+	CaseInsensitive bool
+	// HashIDs — выводить id из хэша адреса, а не выбирать случайно (см. ForURL).
+	HashIDs bool
+	// GrowthThreshold — доля занятых случайных кандидатов, при которой id удлиняются на
+	// символ (см. Observe); 0 — длина не растёт. OnGrow узнаёт о каждом удлинении.
+	GrowthThreshold float64
+	OnGrow          func(extra int)
+}
+
+// MaxLen — наибольшая длина id из ForURL: столько вмещают колонки short_id в RDB.
+const MaxLen = 16
+
+const (
+	alphabetMixed = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	alphabetLower = "abcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+var (
+	mu       sync.RWMutex
+	reserved = makeSet(defaultReserved, "")
+	badWords = defaultBadWords
+	alphabet = alphabetMixed
+	fold     = false
+	hashIDs  = false
+)
+
+// Init применяет опции оператора к генератору.
+func Init(opts Options) error {
+	words := defaultBadWords
+	if opts.WordlistPath != "" {
+		loaded, err := loadWordlist(opts.WordlistPath)
+		if err != nil {
+			return err
+		}
+		words = loaded
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	reserved = makeSet(defaultReserved, opts.Reserved)
+	badWords = words
+	fold = opts.CaseInsensitive
+	hashIDs = opts.HashIDs
+	idGrowth.configure(opts.GrowthThreshold, opts.OnGrow)
+	alphabet = alphabetMixed
+	if fold {
+		alphabet = alphabetLower
+	}
+	return nil
+}
+
+// Fold приводит id к виду для поиска: в регистронезависимом режиме — к нижнему регистру.
+// Второе значение false, если id от этого не изменился.
+func Fold(id string) (string, bool) {
+	mu.RLock()
+	enabled := fold
+	mu.RUnlock()
+	if !enabled {
+		return id, false
+	}
+	folded := strings.ToLower(id)
+	return folded, folded != id
+}
+
+// IsReserved сообщает, занят ли id служебным словом. Регистр не важен.
+func IsReserved(id string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	_, ok := reserved[strings.ToLower(id)]
+	return ok
+}
+
+// IsOffensive сообщает, содержит ли id слово из стоп-листа. Регистр не важен.
+func IsOffensive(id string) bool {
+	id = strings.ToLower(id)
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, w := range badWords {
+		if strings.Contains(id, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate проверяет id, заданный пользователем.
+func Validate(id string) error {
+	if IsReserved(id) {
+		return ErrReserved
+	}
+	if IsOffensive(id) {
+		return ErrOffensive
+	}
+	return nil
+}
+
+// Generate возвращает случайный id длины n, прошедший Validate.
+func Generate(n int) (string, error) {
+	mu.RLock()
+	chars := alphabet
+	mu.RUnlock()
+	for {
+		id, err := helpers.RandStringFrom(chars, n)
+		if err != nil {
+			return "", err
+		}
+		if Validate(id) == nil {
+			return id, nil
+		}
+	}
+}
+
+// HashIDs сообщает, включён ли режим Options.HashIDs.
+func HashIDs() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return hashIDs
+}
+
+// ForURL возвращает кандидата в id для rawURL, попытка attempt начинается с нуля. Обычно
+// это случайный id длины n, удлинённой по Growth; с третьей попытки он ещё на символ длиннее,
+// чтобы сохранение не отказывало, пока Observe не набрал статистику. В режиме HashIDs —
+// первые n+attempt символов SHA-256 от rawURL в алфавите генератора: один адрес даёт один id
+// во всех экземплярах без обращения к хранилищу, а при коллизии следующая попытка удлиняет
+// id на символ. Кандидаты, не прошедшие Validate, пропускаются тем же удлинением.
+// Длина id не превышает MaxLen.
+func ForURL(rawURL string, n, attempt int) (string, error) {
+	mu.RLock()
+	chars, hashed := alphabet, hashIDs
+	mu.RUnlock()
+	if !hashed {
+		return Generate(min(n+Growth()+attempt/2, MaxLen))
+	}
+	digits := hashDigits(rawURL, chars)
+	for length := n; length <= min(len(digits), MaxLen); length++ {
+		id := digits[:length]
+		if Validate(id) != nil {
+			continue
+		}
+		if attempt == 0 {
+			return id, nil
+		}
+		attempt--
+	}
+	return "", fmt.Errorf("no hash-based short ID left for %q", rawURL)
+}
+
+// hashDigits записывает SHA-256 от rawURL числом в системе счисления chars.
+func hashDigits(rawURL string, chars string) string {
+	sum := sha256.Sum256([]byte(rawURL))
+	num := new(big.Int).SetBytes(sum[:])
+	base := big.NewInt(int64(len(chars)))
+	digit := new(big.Int)
+	var out []byte
+	for num.Sign() > 0 {
+		num.DivMod(num, base, digit)
+		out = append(out, chars[digit.Int64()])
+	}
+	return string(out)
+}
+
+func makeSet(words []string, csv string) map[string]struct{} {
+	set := make(map[string]struct{}, len(words))
+	for _, w := range append(words, strings.Split(csv, ",")...) {
+		if w = strings.ToLower(strings.TrimSpace(w)); w != "" {
+			set[w] = struct{}{}
+		}
+	}
+	return set
+}
+
+func loadWordlist(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open wordlist: %w", err)
+	}
+	defer f.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if w := strings.ToLower(strings.TrimSpace(line)); w != "" {
+			words = append(words, w)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read wordlist %s: %w", path, err)
+	}
+	return words, nil
+}
@@ -0,0 +1,125 @@
+// Internal/slowlog/store.go.
+
+// Package slowlog пишет в лог обращения к хранилищу дольше порога, чтобы потерянный индекс
+// был заметен по логам раньше, чем по жалобам.
+package slowlog
+
+import (
+	"context"
+	"expvar"
+	"net/url"
+	"time"
+
+	"github.com/dkolesni-prog/transformer/internal/config"
+	"github.com/dkolesni-prog/transformer/internal/logging"
+	"github.com/dkolesni-prog/transformer/internal/store"
+)
+
+// slowOps — число медленных операций по именам и всего ("total"), публикуется через expvar.
+var slowOps = expvar.NewMap("store_slow_ops")
+
+// Store замеряет операции хранилища. Импорт и выгрузка не замеряются: они проходят по
+// всем записям, и выгрузка ещё ждёт клиента, так что их длительность ничего не говорит.
+type Store struct {
+	store.Store
+	threshold time.Duration
+	logger    logging.Logger
+}
+
+func NewStore(s store.Store, threshold time.Duration, logger logging.Logger) *Store {
+	return &Store{Store: s, threshold: threshold, logger: logger}
+}
+
+// observe вызывается через defer с моментом начала операции op и её параметрами,
+// уже очищенными от личных данных.
+func (s *Store) observe(op string, start time.Time, params ...any) {
+	elapsed := time.Since(start)
+	if elapsed < s.threshold {
+		return
+	}
+	slowOps.Add(op, 1)
+	slowOps.Add("total", 1)
+	s.logger.Warn("Slow store operation", append([]any{"op", op, "duration", elapsed}, params...)...)
+}
+
+// user оставляет от userID начало, достаточное, чтобы сопоставить записи лога.
+func user(userID string) string {
+	const keep = 4
+	if len(userID) <= keep {
+		return userID
+	}
+	return userID[:keep] + "…"
+}
+
+// host оставляет от адреса схему и хост: путь и запрос могут содержать токены.
+func host(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func (s *Store) Save(ctx context.Context, userID string, u *url.URL, meta store.LinkMeta, cfg *config.Config) (string, error) {
+	defer s.observe("Save", time.Now(), "user", user(userID), "url", host(u))
+	return s.Store.Save(ctx, userID, u, meta, cfg)
+}
+
+func (s *Store) SaveBatch(ctx context.Context, userID string, urls []*url.URL, metas []store.LinkMeta, cfg *config.Config) ([]store.SavedURL, error) {
+	defer s.observe("SaveBatch", time.Now(), "user", user(userID), "count", len(urls))
+	return s.Store.SaveBatch(ctx, userID, urls, metas, cfg)
+}
+
+func (s *Store) LoadFull(ctx context.Context, shortID string) (*url.URL, bool, error) {
+	defer s.observe("LoadFull", time.Now(), "short_id", shortID)
+	return s.Store.LoadFull(ctx, shortID)
+}
+
+func (s *Store) LoadUserURLs(ctx context.Context, userID string, baseURL string) ([]store.UserURL, error) {
+	defer s.observe("LoadUserURLs", time.Now(), "user", user(userID))
+	return s.Store.LoadUserURLs(ctx, userID, baseURL)
+}
+
+func (s *Store) DeleteBatch(ctx context.Context, userID string, shortIDs []string) ([]store.DeleteResult, error) {
+	defer s.observe("DeleteBatch", time.Now(), "user", user(userID), "count", len(shortIDs))
+	return s.Store.DeleteBatch(ctx, userID, shortIDs)
+}
+
+func (s *Store) RestoreBatch(ctx context.Context, userID string, shortIDs []string) ([]string, error) {
+	defer s.observe("RestoreBatch", time.Now(), "user", user(userID), "count", len(shortIDs))
+	return s.Store.RestoreBatch(ctx, userID, shortIDs)
+}
+
+func (s *Store) UpdateURL(ctx context.Context, userID, shortID string, u *url.URL) error {
+	defer s.observe("UpdateURL", time.Now(), "user", user(userID), "short_id", shortID, "url", host(u))
+	return s.Store.UpdateURL(ctx, userID, shortID, u)
+}
+
+func (s *Store) TransferOwner(ctx context.Context, fromUserID, shortID, toUserID string) error {
+	defer s.observe("TransferOwner", time.Now(), "user", user(fromUserID), "short_id", shortID, "to_user", user(toUserID))
+	return s.Store.TransferOwner(ctx, fromUserID, shortID, toUserID)
+}
+
+func (s *Store) EraseUser(ctx context.Context, userID string) ([]string, error) {
+	defer s.observe("EraseUser", time.Now(), "user", user(userID))
+	return s.Store.EraseUser(ctx, userID)
+}
+
+func (s *Store) LoadOwner(ctx context.Context, shortID string) (string, error) {
+	defer s.observe("LoadOwner", time.Now(), "short_id", shortID)
+	return s.Store.LoadOwner(ctx, shortID)
+}
+
+func (s *Store) LoadMeta(ctx context.Context, shortID string) (store.LinkMeta, error) {
+	defer s.observe("LoadMeta", time.Now(), "short_id", shortID)
+	return s.Store.LoadMeta(ctx, shortID)
+}
+
+func (s *Store) SetMeta(ctx context.Context, userID, shortID string, meta store.LinkMeta) error {
+	defer s.observe("SetMeta", time.Now(), "user", user(userID), "short_id", shortID)
+	return s.Store.SetMeta(ctx, userID, shortID, meta)
+}
+
+func (s *Store) Ping(ctx context.Context) error {
+	defer s.observe("Ping", time.Now())
+	return s.Store.Ping(ctx)
+}
@@ -4,63 +4,188 @@ package store
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/url"
 	"strings"
+	"sync/atomic"
+	"time"
 
-	"github.com/dkolesni-prog/transformer/internal/app/middleware"
 	"github.com/dkolesni-prog/transformer/internal/config"
-	"github.com/dkolesni-prog/transformer/internal/helpers"
+	"github.com/dkolesni-prog/transformer/internal/logging"
+	"github.com/dkolesni-prog/transformer/internal/shortid"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/sync/singleflight"
 )
 
+// replicaCooldown — сколько времени не ходим в реплику после её ошибки.
+const replicaCooldown = 10 * time.Second
+
+// RDBOptions tunes pgxpool (zero values keep the pgx defaults) and retries of transient errors.
+type RDBOptions struct {
+	MaxConns          int32
+	MinConns          int32
+	MaxConnLifetime   time.Duration
+	HealthCheckPeriod time.Duration
+	MaxRetries        int
+	RetryBackoff      time.Duration
+}
+
 // RDB is our database wrapper.
 type RDB struct {
-	pool *pgxpool.Pool
+	pool    *pgxpool.Pool
+	replica *pgxpool.Pool
+	opts    RDBOptions
+	logger  logging.Logger
+	// replicaDownUntil — unix nano, до которого чтения идут в primary.
+	replicaDownUntil atomic.Int64
+	// inflight склеивает одновременные Save одного и того же адреса.
+	inflight singleflight.Group
+	// accept — какие short_id эта база может выдавать (см. AcceptIDs); nil — любые.
+	accept func(shortID string) bool
+	// outbox — изменения пишут события в таблицу outbox (см. EnableOutbox).
+	outbox bool
+}
+
+// savedLink — итог одной вставки в Save.
+type savedLink struct {
+	shortID  string
+	ownerID  string
+	existing bool
 }
 
 // NewRDB initializes a new RDB instance.
-func NewRDB(ctx context.Context, dsn string) (*RDB, error) {
+func NewRDB(ctx context.Context, dsn string, opts RDBOptions, logger logging.Logger) (*RDB, error) {
+	pool, err := newPool(ctx, dsn, opts, logger)
+	if err != nil {
+		return nil, err
+	}
+	return &RDB{pool: pool, opts: opts, logger: logger}, nil
+}
+
+// ConnectReplica attaches a read replica used by LoadFull and LoadUserURLs.
+func (r *RDB) ConnectReplica(ctx context.Context, dsn string) error {
+	replica, err := newPool(ctx, dsn, r.opts, r.logger)
+	if err != nil {
+		return err
+	}
+	r.replica = replica
+	return nil
+}
+
+func newPool(ctx context.Context, dsn string, opts RDBOptions, logger logging.Logger) (*pgxpool.Pool, error) {
 	cfg, parseErr := pgxpool.ParseConfig(dsn)
 	if parseErr != nil {
-		middleware.Log.Error().Err(parseErr).Msg("Could not parse DSN")
+		logger.Error("Could not parse DSN", "error", parseErr)
 		return nil, errors.New("parse DSN error: " + parseErr.Error())
 	}
+	if opts.MaxConns > 0 {
+		cfg.MaxConns = opts.MaxConns
+	}
+	if opts.MinConns > 0 {
+		cfg.MinConns = opts.MinConns
+	}
+	if opts.MaxConnLifetime > 0 {
+		cfg.MaxConnLifetime = opts.MaxConnLifetime
+	}
+	if opts.HealthCheckPeriod > 0 {
+		cfg.HealthCheckPeriod = opts.HealthCheckPeriod
+	}
+	cfg.ConnConfig.Tracer = queryTracer{logger: logger}
 
 	pool, poolErr := pgxpool.NewWithConfig(ctx, cfg)
 	if poolErr != nil {
-		middleware.Log.Error().Err(poolErr).Msg("Could not create pgxpool")
+		logger.Error("Could not create pgxpool", "error", poolErr)
 		return nil, errors.New("cannot create pgxpool: " + poolErr.Error())
 	}
 
 	if pingErr := pool.Ping(ctx); pingErr != nil {
-		middleware.Log.Error().Err(pingErr).Msg("Could not ping database")
+		logger.Error("Could not ping database", "error", pingErr)
 		// Close doesn't return an error, so we just call it
 		pool.Close()
 
 		return nil, errors.New("failed ping: " + pingErr.Error())
 	}
 
-	return &RDB{pool: pool}, nil
+	return pool, nil
+}
+
+// AcceptIDs restricts generated short_ids to those accepted by fn, so that a shard only
+// creates links it owns. Call before the first Save.
+func (r *RDB) AcceptIDs(fn func(shortID string) bool) {
+	r.accept = fn
 }
 
-// Bootstrap creates the table if it doesn't exist.
+// nextID returns the first candidate from attempt on that r accepts, and the attempt it came
+// from. Rejected random candidates are redrawn; hash-based ones are extended instead, since
+// a redraw would repeat them.
+func (r *RDB) nextID(rawURL string, n, attempt int) (string, int, error) {
+	for {
+		id, err := shortid.ForURL(rawURL, n, attempt)
+		if err != nil || r.accept == nil || r.accept(id) {
+			return id, attempt, err
+		}
+		if shortid.HashIDs() {
+			attempt++
+		}
+	}
+}
+
+// reader returns the replica unless it is missing or recently failed.
+func (r *RDB) reader() *pgxpool.Pool {
+	if r.replica == nil || time.Now().UnixNano() < r.replicaDownUntil.Load() {
+		return r.pool
+	}
+	return r.replica
+}
+
+func (r *RDB) markReplicaDown(err error) {
+	r.logger.Warn("Read replica failed, falling back to primary", "error", err)
+	r.replicaDownUntil.Store(time.Now().Add(replicaCooldown).UnixNano())
+}
+
+// Bootstrap creates the table if it doesn't exist and upgrades older schemas.
+// original_url is TEXT with uniqueness enforced on its md5: a plain btree
+// index can't hold keys longer than ~2.7KB.
 func (r *RDB) Bootstrap(ctx context.Context) error {
 	const schema = `
 CREATE TABLE IF NOT EXISTS short_urls (
     id INTEGER GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
     short_id VARCHAR(16) UNIQUE NOT NULL,
-    original_url VARCHAR(2048) UNIQUE NOT NULL,
+    original_url TEXT NOT NULL,
     user_id VARCHAR(64) NOT NULL,
     is_deleted BOOLEAN NOT NULL DEFAULT false,
     created_at TIMESTAMP NOT NULL DEFAULT NOW(),
-    deleted_at TIMESTAMP
+    deleted_at TIMESTAMP,
+    meta JSONB
+);
+ALTER TABLE short_urls ADD COLUMN IF NOT EXISTS meta JSONB;
+ALTER TABLE short_urls ALTER COLUMN original_url TYPE TEXT;
+ALTER TABLE short_urls DROP CONSTRAINT IF EXISTS short_urls_original_url_key;
+CREATE UNIQUE INDEX IF NOT EXISTS short_urls_original_url_md5 ON short_urls (md5(original_url));
+CREATE INDEX IF NOT EXISTS short_urls_user_id_is_deleted_idx ON short_urls (user_id, is_deleted);
+CREATE TABLE IF NOT EXISTS link_tags (
+    short_id VARCHAR(16) NOT NULL REFERENCES short_urls (short_id) ON DELETE CASCADE,
+    tag VARCHAR(32) NOT NULL,
+    PRIMARY KEY (short_id, tag)
+);
+CREATE INDEX IF NOT EXISTS link_tags_tag_idx ON link_tags (tag);
+CREATE TABLE IF NOT EXISTS link_aliases (
+    user_id VARCHAR(64) NOT NULL,
+    short_id VARCHAR(16) NOT NULL REFERENCES short_urls (short_id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, short_id)
+);
+CREATE TABLE IF NOT EXISTS outbox (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
 );
 `
 	tx, beginErr := r.pool.Begin(ctx)
 	if beginErr != nil {
-		middleware.Log.Error().Err(beginErr).Msg("Could not begin transaction in Bootstrap")
+		r.logger.Error("Could not begin transaction in Bootstrap", "error", beginErr)
 		return errors.New("cannot begin tx: " + beginErr.Error())
 	}
 	// Rollback will be a no-op if Commit succeeds.
@@ -69,49 +194,97 @@ CREATE TABLE IF NOT EXISTS short_urls (
 	}()
 
 	if _, execErr := tx.Exec(ctx, schema); execErr != nil {
-		middleware.Log.Error().Err(execErr).Msg("Could not create table in Bootstrap")
+		r.logger.Error("Could not create table in Bootstrap", "error", execErr)
 		return errors.New("cannot create table: " + execErr.Error())
 	}
 	if commitErr := tx.Commit(ctx); commitErr != nil {
-		middleware.Log.Error().Err(commitErr).Msg("Could not commit transaction in Bootstrap")
+		r.logger.Error("Could not commit transaction in Bootstrap", "error", commitErr)
 		return errors.New("cannot commit tx: " + commitErr.Error())
 	}
 	return nil
 }
 
-// Save inserts a single URL. Tries maxRetries times to generate a random short_id.
-func (r *RDB) Save(ctx context.Context, userID string, urlToSave *url.URL, cfg *config.Config) (string, error) {
+// Save inserts a single URL. Concurrent saves of the same URL are coalesced: one caller
+// inserts, the rest get its link back as a conflict, as if they had come a moment later.
+func (r *RDB) Save(ctx context.Context, userID string, urlToSave *url.URL, meta LinkMeta, cfg *config.Config) (string, error) {
+	link, leader, err := coalesceSave(ctx, &r.inflight, urlToSave.String(), func() (savedLink, error) {
+		return r.save(ctx, userID, urlToSave, meta)
+	})
+	if err != nil {
+		return "", err
+	}
+	if leader && !link.existing {
+		return ensureSlash(cfg.BaseURL) + link.shortID, nil
+	}
+	if cfg.ConflictAliases && link.ownerID != userID {
+		if aliasErr := r.addAlias(ctx, userID, link.shortID); aliasErr != nil {
+			return "", aliasErr
+		}
+	}
+	return ensureSlash(cfg.BaseURL) + link.shortID, &ConflictError{OwnerID: link.ownerID}
+}
+
+// coalesceSave выполняет save одного из одновременных вызовов с тем же key (leader = true),
+// остальные получают его результат. Если вставку оборвала отмена чужого запроса, а наш
+// ещё жив, вставку делаем сами.
+func coalesceSave(ctx context.Context, g *singleflight.Group, key string, save func() (savedLink, error)) (savedLink, bool, error) {
+	leader := false
+	v, err, _ := g.Do(key, func() (any, error) {
+		leader = true
+		return save()
+	})
+	if !leader && errors.Is(err, context.Canceled) && ctx.Err() == nil {
+		// Отвалился клиент, чей запрос делал вставку, а не наш.
+		link, retryErr := save()
+		return link, true, retryErr
+	}
+	if err != nil {
+		return savedLink{}, leader, err
+	}
+	return v.(savedLink), leader, nil
+}
+
+// save tries maxRetries short_id candidates (random, or hash-based with shortid.HashIDs).
+func (r *RDB) save(ctx context.Context, userID string, urlToSave *url.URL, meta LinkMeta) (savedLink, error) {
 	const maxRetries = 5
 	const randLen = 8
 
-	for range make([]struct{}, maxRetries) {
-		randomID, genErr := helpers.RandStringRunes(randLen)
+	stored, tags := splitTags(storedMeta(meta))
+	for try, attempt := 0, 0; try < maxRetries; try, attempt = try+1, attempt+1 {
+		randomID, next, genErr := r.nextID(urlToSave.String(), randLen, attempt)
+		attempt = next
 		if genErr != nil {
-			middleware.Log.Error().Err(genErr).Msg("Could not generate random short_id")
-			return "", errors.New("failed to generate random ID: " + genErr.Error())
+			r.logger.Error("Could not generate random short_id", "error", genErr)
+			return savedLink{}, errors.New("failed to generate random ID: " + genErr.Error())
 		}
 
-		sqlInsert := `
-INSERT INTO short_urls (short_id, original_url, user_id)
-VALUES ($1, $2, $3)
-ON CONFLICT (original_url) DO NOTHING
-RETURNING short_id;
-`
 		var shortID string
-		scanErr := r.pool.QueryRow(ctx, sqlInsert, randomID, urlToSave.String(), userID).Scan(&shortID)
+		scanErr := r.retry(ctx, "Save", func() error {
+			return r.mutate(ctx, func(q querier) ([]Event, error) {
+				if err := q.QueryRow(ctx, sqlSaveLink, randomID, urlToSave.String(), userID, stored, tags).Scan(&shortID); err != nil {
+					return nil, err
+				}
+				return []Event{NewEvent(EventCreated, userID, shortID, urlToSave.String())}, nil
+			})
+		})
 		if scanErr == nil {
-			return ensureSlash(cfg.BaseURL) + shortID, nil
+			shortid.Observe(false)
+			return savedLink{shortID: shortID, ownerID: userID}, nil
+		}
+		if isUniqueViolation(scanErr) {
+			// Занят сам short_id: для случайных id это признак насыщения.
+			shortid.Observe(true)
+			continue
 		}
 
 		if errors.Is(scanErr, pgx.ErrNoRows) {
-			var existingID string
-			confSQL := `SELECT short_id FROM short_urls WHERE original_url=$1;`
-			if selErr := r.pool.QueryRow(ctx, confSQL, urlToSave.String()).Scan(&existingID); selErr == nil {
-				return ensureSlash(cfg.BaseURL) + existingID, errors.New("conflict: URL already exists")
+			existingID, ownerID, confErr := r.resolveConflict(ctx, urlToSave)
+			if confErr == nil {
+				return savedLink{shortID: existingID, ownerID: ownerID, existing: true}, nil
 			}
 		}
 	}
-	return "", errors.New("failed to generate a unique short_id after retries")
+	return savedLink{}, errors.New("failed to generate a unique short_id after retries")
 }
 
 // LoadFull retrieves the original URL and is_deleted flag by short_id.
@@ -124,48 +297,57 @@ WHERE short_id = $1;
 	var rawURL string
 	var isDeleted bool
 
-	scanErr := r.pool.QueryRow(ctx, sqlSelect, shortID).Scan(&rawURL, &isDeleted)
+	db := r.reader()
+	scanErr := r.retry(ctx, "LoadFull", func() error {
+		return db.QueryRow(ctx, sqlSelect, shortID).Scan(&rawURL, &isDeleted)
+	})
+	if scanErr != nil && db != r.pool {
+		// Реплика могла отстать или упасть: перепроверяем в primary.
+		if !errors.Is(scanErr, pgx.ErrNoRows) {
+			r.markReplicaDown(scanErr)
+		}
+		scanErr = r.retry(ctx, "LoadFull", func() error {
+			return r.pool.QueryRow(ctx, sqlSelect, shortID).Scan(&rawURL, &isDeleted)
+		})
+	}
 	if errors.Is(scanErr, pgx.ErrNoRows) {
-		return nil, false, errors.New("not found")
+		return nil, false, ErrNotFound
 	}
 	if scanErr != nil {
-		middleware.Log.Error().Err(scanErr).Msg("LoadFull query failed")
+		r.logger.Error("LoadFull query failed", "error", scanErr)
 		return nil, false, errors.New("LoadFull query: " + scanErr.Error())
 	}
 
 	parsed, parseErr := url.Parse(rawURL)
 	if parseErr != nil {
-		middleware.Log.Error().Err(parseErr).Msg("Bad URL in DB record")
+		r.logger.Error("Bad URL in DB record", "error", parseErr)
 		return nil, false, errors.New("bad URL in DB: " + parseErr.Error())
 	}
 	return parsed, isDeleted, nil
 }
 
 // SaveBatch inserts a list of URLs using pgx.Batch to minimize round trips.
-func (r *RDB) SaveBatch(ctx context.Context, userID string, urls []*url.URL, cfg *config.Config) ([]string, error) {
+// A URL repeated within the batch is inserted once and shares its short_id.
+func (r *RDB) SaveBatch(ctx context.Context, userID string, urls []*url.URL, metas []LinkMeta, cfg *config.Config) ([]SavedURL, error) {
 	const maxRetries = 5
 	const randLen = 8
 
 	batch := &pgx.Batch{}
-	genMap := make(map[string]string)
+	unique, slot := uniqueURLs(urls)
+	metas = uniqueMetas(metas, slot, len(unique))
 
 	// Prepare batch of INSERT statements.
-	for _, u := range urls {
+	for i, u := range unique {
 		success := false
-		for range make([]struct{}, maxRetries) {
-			randVal, genErr := helpers.RandStringRunes(randLen)
+		for attempt := range maxRetries {
+			randVal, _, genErr := r.nextID(u.String(), randLen, attempt)
 			if genErr != nil {
-				middleware.Log.Error().Err(genErr).Msg("Could not generate random short_id in SaveBatch")
+				r.logger.Error("Could not generate random short_id in SaveBatch", "error", genErr)
 				return nil, errors.New("rand string error: " + genErr.Error())
 			}
 
-			genMap[u.String()] = randVal
-			batch.Queue(`
-INSERT INTO short_urls (short_id, original_url, user_id)
-VALUES ($1, $2, $3)
-ON CONFLICT (original_url) DO NOTHING
-RETURNING short_id;
-`, randVal, u.String(), userID)
+			stored, tags := splitTags(storedMeta(metas[i]))
+			batch.Queue(sqlSaveLink, randVal, u.String(), userID, stored, tags)
 
 			success = true
 			break
@@ -175,102 +357,594 @@ RETURNING short_id;
 		}
 	}
 
-	br := r.pool.SendBatch(ctx, batch)
+	var results []SavedURL
+	err := r.retry(ctx, "SaveBatch", func() error {
+		return r.mutate(ctx, func(q querier) ([]Event, error) {
+			var sendErr error
+			results, sendErr = r.sendBatch(ctx, q, batch, userID, unique, cfg)
+			if sendErr != nil {
+				return nil, sendErr
+			}
+			var events []Event
+			for i, saved := range results {
+				if !saved.Existing {
+					events = append(events, NewEvent(EventCreated, userID, ShortIDFromURL(saved.ShortURL, cfg.BaseURL), unique[i].String()))
+				}
+			}
+			return events, nil
+		})
+	})
+	if err != nil {
+		r.logger.Error("Batch execution failed in SaveBatch", "error", err)
+		return nil, errors.New("batch execution failed: " + err.Error())
+	}
+	return expandSaved(results, slot), nil
+}
+
+// sendBatch executes the prepared INSERTs and resolves conflicts to existing short_ids.
+// Conflicts are looked up on the pool: the existing rows are already committed.
+func (r *RDB) sendBatch(ctx context.Context, q querier, batch *pgx.Batch, userID string, urls []*url.URL, cfg *config.Config) ([]SavedURL, error) {
+	br := q.SendBatch(ctx, batch)
 	defer func() {
 		if closeErr := br.Close(); closeErr != nil {
-			middleware.Log.Error().Err(closeErr).Msg("Could not close batch results in SaveBatch")
+			r.logger.Error("Could not close batch results in SaveBatch", "error", closeErr)
 		}
 	}()
 
-	var results []string
+	results := make([]SavedURL, 0, len(urls))
 	for _, u := range urls {
+		saved := SavedURL{OwnerID: userID}
 		var returnedID string
 		scanErr := br.QueryRow().Scan(&returnedID)
 		if errors.Is(scanErr, pgx.ErrNoRows) {
 			// ON CONFLICT DO NOTHING triggered => find existing short_id
-			confSQL := `SELECT short_id FROM short_urls WHERE original_url = $1;`
-			var existingID string
-			if selErr := r.pool.QueryRow(ctx, confSQL, u.String()).Scan(&existingID); selErr == nil {
-				returnedID = existingID
-			} else {
-				middleware.Log.Error().Err(selErr).Msg("Failed to retrieve existing short_id in SaveBatch")
-				return nil, errors.New("failed to retrieve existing short_id: " + selErr.Error())
+			var confErr error
+			returnedID, saved.OwnerID, confErr = r.resolveConflict(ctx, u)
+			if confErr != nil {
+				return nil, confErr
+			}
+			if cfg.ConflictAliases && saved.OwnerID != userID {
+				if aliasErr := r.addAlias(ctx, userID, returnedID); aliasErr != nil {
+					return nil, aliasErr
+				}
 			}
+			saved.Existing = true
 		} else if scanErr != nil {
-			middleware.Log.Error().Err(scanErr).Msg("Batch execution failed in SaveBatch")
-			return nil, errors.New("batch execution failed: " + scanErr.Error())
+			if isUniqueViolation(scanErr) {
+				shortid.Observe(true)
+			}
+			return nil, scanErr
 		}
-		results = append(results, ensureSlash(cfg.BaseURL)+returnedID)
+		if !saved.Existing {
+			shortid.Observe(false)
+		}
+		saved.ShortURL = ensureSlash(cfg.BaseURL) + returnedID
+		results = append(results, saved)
 	}
-
 	return results, nil
 }
 
+// resolveConflict находит уже сокращённый адрес и его владельца.
+func (r *RDB) resolveConflict(ctx context.Context, u *url.URL) (string, string, error) {
+	const confSQL = `SELECT short_id, user_id FROM short_urls WHERE md5(original_url) = md5($1);`
+
+	var shortID, ownerID string
+	if selErr := r.pool.QueryRow(ctx, confSQL, u.String()).Scan(&shortID, &ownerID); selErr != nil {
+		return "", "", fmt.Errorf("failed to retrieve existing short_id: %w", selErr)
+	}
+	return shortID, ownerID, nil
+}
+
+// addAlias записывает чужую ссылку в link_aliases, чтобы она попала в список userID
+// (cfg.ConflictAliases).
+func (r *RDB) addAlias(ctx context.Context, userID, shortID string) error {
+	const aliasSQL = `INSERT INTO link_aliases (user_id, short_id) VALUES ($1, $2) ON CONFLICT DO NOTHING;`
+
+	if _, aliasErr := r.pool.Exec(ctx, aliasSQL, userID, shortID); aliasErr != nil {
+		r.logger.Error("Could not add link alias", "error", aliasErr)
+		return errors.New("add link alias: " + aliasErr.Error())
+	}
+	return nil
+}
+
 // LoadUserURLs retrieves all non-deleted URLs for a given user.
 func (r *RDB) LoadUserURLs(ctx context.Context, userID string, baseURL string) ([]UserURL, error) {
+	db := r.reader()
+	out, err := r.loadUserURLs(ctx, db, userID, baseURL)
+	if err != nil && db != r.pool {
+		r.markReplicaDown(err)
+		db = r.pool
+		out, err = r.loadUserURLs(ctx, db, userID, baseURL)
+	}
+	if err != nil {
+		r.logger.Error("LoadUserURLs query failed", "error", err)
+		return nil, errors.New("LoadUserURLs: " + err.Error())
+	}
+	return out, nil
+}
+
+func (r *RDB) loadUserURLs(ctx context.Context, db *pgxpool.Pool, userID string, baseURL string) ([]UserURL, error) {
 	const sqlSelect = `
-SELECT short_id, original_url
-FROM short_urls
+WITH listed AS (
+    SELECT short_id FROM short_urls WHERE user_id = $1 AND is_deleted = false
+    UNION
+    SELECT short_id FROM link_aliases WHERE user_id = $1
+)
+SELECT s.short_id, s.original_url, COALESCE(s.meta->>'domain', ''),
+       COALESCE(s.meta->>'title', ''), COALESCE(s.meta->>'note', ''),
+       ARRAY(SELECT tag FROM link_tags t WHERE t.short_id = s.short_id ORDER BY tag),
+       s.user_id <> $1
+FROM listed
+JOIN short_urls s USING (short_id)
+WHERE s.is_deleted = false;
+`
+	var out []UserURL
+	err := r.retry(ctx, "LoadUserURLs", func() error {
+		out = nil
+		rows, queryErr := db.Query(ctx, sqlSelect, userID)
+		if queryErr != nil {
+			return queryErr
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var sid string
+			item := UserURL{}
+			if scanErr := rows.Scan(&sid, &item.OriginalURL, &item.Domain, &item.Title, &item.Note, &item.Tags, &item.Alias); scanErr != nil {
+				return fmt.Errorf("rows.Scan: %w", scanErr)
+			}
+			item.ShortURL = ensureSlash(baseURL) + sid
+			out = append(out, item)
+		}
+		return rows.Err()
+	})
+	return out, err
+}
+
+// DeleteBatch sets is_deleted = true for multiple shortIDs belonging to a single userID.
+// Aliases of other owners' links are simply dropped from the user's list.
+// Already deleted rows are left alone, so deleted_at keeps the first deletion time and
+// the lookup stays on the (user_id, is_deleted) index.
+// The final SELECT sees the rows as they were before the statement, which is enough
+// to tell owned, aliased and foreign IDs apart.
+func (r *RDB) DeleteBatch(ctx context.Context, userID string, shortIDs []string) ([]DeleteResult, error) {
+	const sqlUpdate = `
+WITH unaliased AS (
+    DELETE FROM link_aliases WHERE user_id = $1 AND short_id = ANY($2) RETURNING short_id
+), deleted AS (
+    UPDATE short_urls
+    SET is_deleted = true,
+        deleted_at = now()
+    WHERE user_id = $1
+      AND NOT is_deleted
+      AND short_id = ANY($2)
+)
+SELECT s.short_id,
+       s.user_id = $1 OR EXISTS (SELECT 1 FROM unaliased a WHERE a.short_id = s.short_id)
+FROM short_urls s
+WHERE s.short_id = ANY($2);
+`
+	owned := make(map[string]bool, len(shortIDs))
+	execErr := r.retry(ctx, "DeleteBatch", func() error {
+		return r.mutate(ctx, func(q querier) ([]Event, error) {
+			clear(owned)
+			rows, err := q.Query(ctx, sqlUpdate, userID, shortIDs)
+			if err != nil {
+				return nil, err
+			}
+			defer rows.Close()
+			var events []Event
+			for rows.Next() {
+				var (
+					sid  string
+					mine bool
+				)
+				if err := rows.Scan(&sid, &mine); err != nil {
+					return nil, err
+				}
+				owned[sid] = mine
+				if mine {
+					events = append(events, NewEvent(DeleteEvent(ctx), userID, sid, ""))
+				}
+			}
+			return events, rows.Err()
+		})
+	})
+	if execErr != nil {
+		r.logger.Error("DeleteBatch update failed", "error", execErr)
+		return nil, errors.New("DeleteBatch: " + execErr.Error())
+	}
+
+	results := make([]DeleteResult, len(shortIDs))
+	for i, sid := range shortIDs {
+		mine, found := owned[sid]
+		switch {
+		case !found:
+			results[i] = DeleteResult{ShortID: sid, Status: DeleteNotFound}
+		case mine:
+			results[i] = DeleteResult{ShortID: sid, Status: Deleted}
+		default:
+			results[i] = DeleteResult{ShortID: sid, Status: DeleteForbidden}
+		}
+	}
+	return results, nil
+}
+
+// RestoreBatch clears is_deleted on the user's deleted rows and returns their short IDs.
+// Rows already removed by PurgeDeleted are gone and simply not returned.
+func (r *RDB) RestoreBatch(ctx context.Context, userID string, shortIDs []string) ([]string, error) {
+	const sqlUpdate = `
+UPDATE short_urls
+SET is_deleted = false,
+    deleted_at = NULL
+WHERE user_id = $1
+  AND is_deleted
+  AND short_id = ANY($2)
+RETURNING short_id;
+`
+	var restored []string
+	execErr := r.retry(ctx, "RestoreBatch", func() error {
+		return r.mutate(ctx, func(q querier) ([]Event, error) {
+			restored = restored[:0]
+			rows, err := q.Query(ctx, sqlUpdate, userID, shortIDs)
+			if err != nil {
+				return nil, err
+			}
+			defer rows.Close()
+			var events []Event
+			for rows.Next() {
+				var sid string
+				if err := rows.Scan(&sid); err != nil {
+					return nil, err
+				}
+				restored = append(restored, sid)
+				events = append(events, NewEvent(EventRestored, userID, sid, ""))
+			}
+			return events, rows.Err()
+		})
+	})
+	if execErr != nil {
+		r.logger.Error("RestoreBatch update failed", "error", execErr)
+		return nil, errors.New("RestoreBatch: " + execErr.Error())
+	}
+	return restored, nil
+}
+
+// UpdateURL changes the destination of a live link owned by userID.
+// A destination already shortened elsewhere yields ErrConflict.
+func (r *RDB) UpdateURL(ctx context.Context, userID, shortID string, u *url.URL) error {
+	const sqlUpdate = `
+UPDATE short_urls
+SET original_url = $3
+WHERE user_id = $1
+  AND short_id = $2
+  AND NOT is_deleted;
+`
+	var updated int64
+	execErr := r.retry(ctx, "UpdateURL", func() error {
+		return r.mutate(ctx, func(q querier) ([]Event, error) {
+			tag, err := q.Exec(ctx, sqlUpdate, userID, shortID, u.String())
+			updated = tag.RowsAffected()
+			if err != nil || updated == 0 {
+				return nil, err
+			}
+			return []Event{NewEvent(EventUpdated, userID, shortID, u.String())}, nil
+		})
+	})
+	if isUniqueViolation(execErr) {
+		return ErrConflict
+	}
+	if execErr != nil {
+		r.logger.Error("UpdateURL failed", "error", execErr)
+		return errors.New("UpdateURL: " + execErr.Error())
+	}
+	if updated == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// TransferOwner reassigns a live link from fromUserID to toUserID in a single UPDATE.
+func (r *RDB) TransferOwner(ctx context.Context, fromUserID, shortID, toUserID string) error {
+	const sqlUpdate = `
+UPDATE short_urls
+SET user_id = $3
 WHERE user_id = $1
-  AND is_deleted = false;
+  AND short_id = $2
+  AND NOT is_deleted;
 `
-	rows, queryErr := r.pool.Query(ctx, sqlSelect, userID)
+	var updated int64
+	execErr := r.retry(ctx, "TransferOwner", func() error {
+		return r.mutate(ctx, func(q querier) ([]Event, error) {
+			tag, err := q.Exec(ctx, sqlUpdate, fromUserID, shortID, toUserID)
+			updated = tag.RowsAffected()
+			if err != nil || updated == 0 {
+				return nil, err
+			}
+			return []Event{NewEvent(EventTransferred, toUserID, shortID, "")}, nil
+		})
+	})
+	if execErr != nil {
+		r.logger.Error("TransferOwner failed", "error", execErr)
+		return errors.New("TransferOwner: " + execErr.Error())
+	}
+	if updated == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// EraseUser hard-deletes every row of the user; link_tags go with them by cascade.
+// The user's aliases are removed too, the aliased links stay with their owners.
+func (r *RDB) EraseUser(ctx context.Context, userID string) ([]string, error) {
+	const sqlDelete = `
+WITH unaliased AS (
+    DELETE FROM link_aliases WHERE user_id = $1
+)
+DELETE FROM short_urls WHERE user_id = $1 RETURNING short_id;`
+
+	var erased []string
+	execErr := r.retry(ctx, "EraseUser", func() error {
+		return r.mutate(ctx, func(q querier) ([]Event, error) {
+			erased = erased[:0]
+			rows, err := q.Query(ctx, sqlDelete, userID)
+			if err != nil {
+				return nil, err
+			}
+			erased, err = pgx.CollectRows(rows, pgx.RowTo[string])
+			if err != nil {
+				return nil, err
+			}
+			events := make([]Event, len(erased))
+			for i, sid := range erased {
+				events[i] = NewEvent(EventErased, "", sid, "")
+			}
+			return events, nil
+		})
+	})
+	if execErr != nil {
+		r.logger.Error("EraseUser failed", "error", execErr)
+		return nil, errors.New("EraseUser: " + execErr.Error())
+	}
+	return erased, nil
+}
+
+// ImportRecords inserts records with their own short_ids; rows clashing on short_id or original_url are skipped.
+func (r *RDB) ImportRecords(ctx context.Context, records []Record) error {
+	// Метки вставляются только для строк, которые вставились: у пропущенного дубля
+	// их вставка нарушила бы внешний ключ и оборвала весь пакет.
+	const sqlInsert = `
+WITH inserted AS (
+    INSERT INTO short_urls (short_id, original_url, user_id, is_deleted, created_at, meta)
+    VALUES ($1, $2, $3, $4, COALESCE($5, NOW()), $6)
+    ON CONFLICT DO NOTHING
+    RETURNING short_id
+)
+INSERT INTO link_tags (short_id, tag)
+SELECT short_id, unnest($7::text[]) FROM inserted
+ON CONFLICT DO NOTHING;
+`
+	batch := &pgx.Batch{}
+	for _, rec := range records {
+		var createdAt *time.Time
+		if !rec.CreatedAt.IsZero() {
+			createdAt = &rec.CreatedAt
+		}
+		meta, tags := splitTags(rec.Meta)
+		batch.Queue(sqlInsert, rec.ShortURL, rec.OriginalURL, rec.UserID, rec.IsDeleted, createdAt, meta, tags)
+	}
+	execErr := r.retry(ctx, "ImportRecords", func() error {
+		return r.pool.SendBatch(ctx, batch).Close()
+	})
+	if execErr != nil {
+		r.logger.Error("ImportRecords failed", "error", execErr)
+		return errors.New("ImportRecords: " + execErr.Error())
+	}
+	return nil
+}
+
+// ExportRecords streams every row, including soft-deleted ones, in insertion order.
+func (r *RDB) ExportRecords(ctx context.Context, fn func(Record) error) error {
+	const sqlSelect = `
+SELECT short_id, original_url, user_id, is_deleted, created_at, meta,
+       ARRAY(SELECT tag FROM link_tags t WHERE t.short_id = s.short_id ORDER BY tag)
+FROM short_urls s
+ORDER BY id;
+`
+	rows, queryErr := r.pool.Query(ctx, sqlSelect)
 	if queryErr != nil {
-		middleware.Log.Error().Err(queryErr).Msg("LoadUserURLs query failed")
-		return nil, errors.New("LoadUserURLs: " + queryErr.Error())
+		r.logger.Error("ExportRecords query failed", "error", queryErr)
+		return errors.New("ExportRecords: " + queryErr.Error())
 	}
 	defer rows.Close()
 
-	var out []UserURL
 	for rows.Next() {
-		var sid, orig string
-		scanErr := rows.Scan(&sid, &orig)
-		if scanErr != nil {
-			middleware.Log.Error().Err(scanErr).Msg("Rows scan failed in LoadUserURLs")
-			return nil, errors.New("rows.Scan: " + scanErr.Error())
+		var rec Record
+		var tags []string
+		if scanErr := rows.Scan(&rec.ShortURL, &rec.OriginalURL, &rec.UserID, &rec.IsDeleted, &rec.CreatedAt, &rec.Meta, &tags); scanErr != nil {
+			return errors.New("rows.Scan: " + scanErr.Error())
+		}
+		if len(tags) > 0 {
+			if rec.Meta == nil {
+				rec.Meta = &LinkMeta{}
+			}
+			rec.Meta.Tags = tags
+		}
+		if err := fn(rec); err != nil {
+			return err
 		}
-		out = append(out, UserURL{
-			ShortURL:    ensureSlash(baseURL) + sid,
-			OriginalURL: orig,
-		})
 	}
 	if rowsErr := rows.Err(); rowsErr != nil {
-		middleware.Log.Error().Err(rowsErr).Msg("Rows iteration error in LoadUserURLs")
-		return nil, errors.New("rows.Err: " + rowsErr.Error())
+		return errors.New("rows.Err: " + rowsErr.Error())
 	}
-	return out, nil
+	return nil
 }
 
-// DeleteBatch sets is_deleted = true for multiple shortIDs belonging to a single userID.
-func (r *RDB) DeleteBatch(ctx context.Context, userID string, shortIDs []string) error {
-	const sqlUpdate = `
-UPDATE short_urls
-SET is_deleted = true,
-    deleted_at = now()
-WHERE user_id = $1
-  AND short_id = ANY($2);
+const sqlInsertTags = `
+INSERT INTO link_tags (short_id, tag)
+SELECT $1, unnest($2::text[])
+ON CONFLICT DO NOTHING;
+`
+
+// sqlSaveLink вставляет ссылку вместе с meta и метками одним оператором: новая ссылка
+// не бывает видна без своих настроек. При конфликте по адресу не вставляется ничего.
+const sqlSaveLink = `
+WITH inserted AS (
+    INSERT INTO short_urls (short_id, original_url, user_id, meta)
+    VALUES ($1, $2, $3, $4)
+    ON CONFLICT ((md5(original_url))) DO NOTHING
+    RETURNING short_id
+), tagged AS (
+    INSERT INTO link_tags (short_id, tag)
+    SELECT short_id, unnest($5::text[]) FROM inserted
+    ON CONFLICT DO NOTHING
+)
+SELECT short_id FROM inserted;
+`
+
+// splitTags отделяет метки, которые лежат в link_tags, от остальной meta.
+func splitTags(meta *LinkMeta) (*LinkMeta, []string) {
+	if meta == nil || len(meta.Tags) == 0 {
+		return meta, nil
+	}
+	stored := *meta
+	stored.Tags = nil
+	return &stored, meta.Tags
+}
+
+// LoadOwner returns the user_id of the link, deleted or not.
+func (r *RDB) LoadOwner(ctx context.Context, shortID string) (string, error) {
+	const sqlSelect = `SELECT user_id FROM short_urls WHERE short_id = $1;`
+
+	var userID string
+	scanErr := r.retry(ctx, "LoadOwner", func() error {
+		return r.reader().QueryRow(ctx, sqlSelect, shortID).Scan(&userID)
+	})
+	if errors.Is(scanErr, pgx.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if scanErr != nil {
+		r.logger.Error("LoadOwner query failed", "error", scanErr)
+		return "", errors.New("LoadOwner: " + scanErr.Error())
+	}
+	return userID, nil
+}
+
+// LoadMeta reads the link settings stored in the meta column and the link_tags table.
+func (r *RDB) LoadMeta(ctx context.Context, shortID string) (LinkMeta, error) {
+	const sqlSelect = `
+SELECT meta, ARRAY(SELECT tag FROM link_tags WHERE short_id = $1 ORDER BY tag)
+FROM short_urls
+WHERE short_id = $1;
 `
-	if _, execErr := r.pool.Exec(ctx, sqlUpdate, userID, shortIDs); execErr != nil {
-		middleware.Log.Error().Err(execErr).Msg("DeleteBatch update failed")
-		return errors.New("DeleteBatch: " + execErr.Error())
+	var meta *LinkMeta
+	var tags []string
+	scanErr := r.retry(ctx, "LoadMeta", func() error {
+		return r.pool.QueryRow(ctx, sqlSelect, shortID).Scan(&meta, &tags)
+	})
+	if errors.Is(scanErr, pgx.ErrNoRows) {
+		return LinkMeta{}, ErrNotFound
+	}
+	if scanErr != nil {
+		r.logger.Error("LoadMeta query failed", "error", scanErr)
+		return LinkMeta{}, errors.New("LoadMeta query: " + scanErr.Error())
+	}
+	if meta == nil {
+		meta = &LinkMeta{}
+	}
+	if len(tags) > 0 {
+		meta.Tags = tags
+	}
+	return *meta, nil
+}
+
+// SetMeta replaces the link settings if the link belongs to userID.
+func (r *RDB) SetMeta(ctx context.Context, userID, shortID string, meta LinkMeta) error {
+	const sqlUpdate = `UPDATE short_urls SET meta = $3 WHERE user_id = $1 AND short_id = $2;`
+	const sqlClearTags = `DELETE FROM link_tags WHERE short_id = $1;`
+
+	stored, tags := splitTags(&meta)
+	var updated int64
+	execErr := r.retry(ctx, "SetMeta", func() error {
+		tx, err := r.pool.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = tx.Rollback(ctx) }()
+
+		tag, err := tx.Exec(ctx, sqlUpdate, userID, shortID, stored)
+		if err != nil {
+			return err
+		}
+		updated = tag.RowsAffected()
+		if updated == 0 {
+			return nil
+		}
+		if _, err = tx.Exec(ctx, sqlClearTags, shortID); err != nil {
+			return err
+		}
+		if len(tags) > 0 {
+			if _, err = tx.Exec(ctx, sqlInsertTags, shortID, tags); err != nil {
+				return err
+			}
+		}
+		return tx.Commit(ctx)
+	})
+	if execErr != nil {
+		r.logger.Error("SetMeta update failed", "error", execErr)
+		return errors.New("SetMeta: " + execErr.Error())
+	}
+	if updated == 0 {
+		return ErrNotFound
 	}
 	return nil
 }
 
+// PurgeDeleted hard-deletes soft-deleted rows.
+func (r *RDB) PurgeDeleted(ctx context.Context) (int, error) {
+	const sqlDelete = `DELETE FROM short_urls WHERE is_deleted;`
+
+	var purged int64
+	execErr := r.retry(ctx, "PurgeDeleted", func() error {
+		tag, err := r.pool.Exec(ctx, sqlDelete)
+		purged = tag.RowsAffected()
+		return err
+	})
+	if execErr != nil {
+		r.logger.Error("PurgeDeleted failed", "error", execErr)
+		return 0, errors.New("PurgeDeleted: " + execErr.Error())
+	}
+	return int(purged), nil
+}
+
 func (r *RDB) Ping(ctx context.Context) error {
 	pingErr := r.pool.Ping(ctx)
 	if pingErr != nil {
-		middleware.Log.Error().Err(pingErr).Msg("Ping to database failed")
+		r.logger.Error("Ping to database failed", "error", pingErr)
 		return errors.New("ping error: " + pingErr.Error())
 	}
 	return nil
 }
 
+// Pool exposes the connection pool for subsystems sharing the database.
+func (r *RDB) Pool() *pgxpool.Pool {
+	return r.pool
+}
+
 func (r *RDB) Close(ctx context.Context) error {
+	if r.replica != nil {
+		r.replica.Close()
+	}
 	r.pool.Close()
 	return nil
 }
 
+// isUniqueViolation — вставка или обновление нарушили уникальный индекс.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
 func ensureSlash(baseURL string) string {
 	if !strings.HasSuffix(baseURL, "/") {
 		return baseURL + "/"
@@ -0,0 +1,112 @@
+// internal/store/dbStorage_test.go
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/singleflight"
+)
+
+func TestCoalesceSave(t *testing.T) {
+	var g singleflight.Group
+	ctx := context.Background()
+
+	// Одновременные вызовы: вставку делает один, остальные получают его ссылку.
+	var calls atomic.Int32
+	started, release := make(chan struct{}), make(chan struct{})
+	save := func() (savedLink, error) {
+		if calls.Add(1) == 1 {
+			close(started)
+		}
+		<-release
+		return savedLink{shortID: "abc12345", ownerID: "first"}, nil
+	}
+	const callers = 5
+	type result struct {
+		link   savedLink
+		leader bool
+		err    error
+	}
+	results := make(chan result, callers)
+	var wg sync.WaitGroup
+	run := func() {
+		defer wg.Done()
+		link, leader, err := coalesceSave(ctx, &g, "https://example.com/hot", save)
+		results <- result{link, leader, err}
+	}
+	wg.Add(1)
+	go run()
+	<-started
+	for range callers - 1 {
+		wg.Add(1)
+		go run()
+	}
+	// Даём остальным встать в ожидание вставки первого.
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	leaders := 0
+	for res := range results {
+		require.NoError(t, res.err)
+		assert.Equal(t, "abc12345", res.link.shortID)
+		if res.leader {
+			leaders++
+		}
+	}
+	assert.Equal(t, 1, leaders)
+	assert.Equal(t, int32(1), calls.Load(), "only one insert for the same URL")
+
+	// Вставку первого оборвала отмена его запроса: живой вызов повторяет её сам,
+	// отменённый получает ошибку.
+	started, release = make(chan struct{}), make(chan struct{})
+	cancelled := func() (savedLink, error) {
+		close(started)
+		<-release
+		return savedLink{}, context.Canceled
+	}
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, _, err := coalesceSave(ctx, &g, "https://example.com/cancel", cancelled)
+		leaderErr <- err
+	}()
+	<-started
+	follower := make(chan result, 2)
+	retried := func() (savedLink, error) { return savedLink{shortID: "def12345", ownerID: "second"}, nil }
+	deadCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	for _, c := range []context.Context{ctx, deadCtx} {
+		go func() {
+			link, leader, err := coalesceSave(c, &g, "https://example.com/cancel", retried)
+			follower <- result{link, leader, err}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	assert.ErrorIs(t, <-leaderErr, context.Canceled)
+	for range 2 {
+		res := <-follower
+		if res.err != nil {
+			assert.ErrorIs(t, res.err, context.Canceled, "a cancelled caller does not retry")
+			continue
+		}
+		assert.True(t, res.leader)
+		assert.Equal(t, "def12345", res.link.shortID)
+	}
+
+	// Любая другая ошибка вставки отдаётся всем, без повтора.
+	boom := errors.New("connection reset")
+	_, leader, err := coalesceSave(ctx, &g, "https://example.com/broken", func() (savedLink, error) {
+		return savedLink{}, boom
+	})
+	assert.True(t, leader)
+	assert.ErrorIs(t, err, boom)
+}
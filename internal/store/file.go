@@ -11,35 +11,86 @@ import (
 	"os"
 	"strconv"
 	"sync"
+	"time"
 
-	"github.com/dkolesni-prog/transformer/internal/app/middleware"
 	"github.com/dkolesni-prog/transformer/internal/config"
-	"github.com/dkolesni-prog/transformer/internal/helpers"
+	"github.com/dkolesni-prog/transformer/internal/logging"
+	"github.com/dkolesni-prog/transformer/internal/shortid"
 )
 
 type Record struct {
-	UUID        string `json:"uuid"`
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
-	UserID      string `json:"user_id"`
-	IsDeleted   bool   `json:"is_deleted"`
+	UUID        string    `json:"uuid"`
+	ShortURL    string    `json:"short_url"`
+	OriginalURL string    `json:"original_url"`
+	UserID      string    `json:"user_id"`
+	IsDeleted   bool      `json:"is_deleted"`
+	CreatedAt   time.Time `json:"created_at,omitempty"`
+	Meta        *LinkMeta `json:"meta,omitempty"`
 }
 
+// Режимы сброса файла на диск.
+const (
+	// SyncAlways — fsync после каждой записи.
+	SyncAlways = "always"
+	// SyncBatch — записи копятся в буфере и сбрасываются с fsync раз в FileFlushInterval.
+	SyncBatch = "batch"
+)
+
+// Storage держит всё в памяти; на диске — снимок (filePath) и журнал изменений
+// после него (filePath + ".wal"). При старте снимок читается целиком, журнал
+// проигрывается поверх, а Checkpoint переносит журнал в новый снимок.
 type Storage struct {
 	mu                *sync.Mutex
 	keyShortValuelong map[string]Record
 	filePath          string
+	syncMode          string
+	logger            logging.Logger
+	wal               *os.File
+	w                 *bufio.Writer
+	// frozen — снимок записан более новой версией и не должен перезаписываться.
+	frozen bool
+	stop   chan struct{}
+	done   chan struct{}
 }
 
-func NewStorage(cfg *config.Config) *Storage {
+func NewStorage(cfg *config.Config, logger logging.Logger) *Storage {
 	s := &Storage{
 		mu:                &sync.Mutex{},
 		keyShortValuelong: make(map[string]Record),
 		filePath:          cfg.FileStoragePath,
+		syncMode:          cfg.FileSync,
+		logger:            logger,
+		stop:              make(chan struct{}),
+		done:              make(chan struct{}),
+	}
+	snapshotTorn, err := s.loadFromFile(s.filePath)
+	if errors.Is(err, errUnsupportedVersion) {
+		// Файл записан более новой версией — не трогаем его, чтобы не потерять данные.
+		s.logger.Error("Storage file left untouched", "error", err, "file", s.filePath)
+		s.frozen = true
+		if openErr := s.openWAL(0); openErr != nil {
+			s.logger.Error("Could not open WAL", "error", openErr)
+		}
+		go s.maintain(cfg.FileFlushInterval, 0)
+		return s
+	}
+	if err != nil {
+		s.logger.Error("Error loading snapshot from file", "error", err)
 	}
-	if err := s.loadFromFile(); err != nil {
-		middleware.Log.Error().Err(err).Msg("Error loading data from file")
+	walTorn, err := s.loadFromFile(s.walPath())
+	if err != nil {
+		s.logger.Error("Error replaying WAL", "error", err)
+	}
+	if snapshotTorn || walTorn {
+		// Хвост оборван падением — сразу делаем новый снимок через временный файл.
+		s.logger.Warn("Torn tail record in storage files, checkpointing", "file", s.filePath)
+	}
+	// Новый снимок при старте: журнал всегда начинается пустым, а файл v1
+	// заодно переписывается в текущий формат.
+	if checkpointErr := s.Checkpoint(); checkpointErr != nil {
+		s.logger.Error("Could not checkpoint storage file", "error", checkpointErr)
 	}
+	go s.maintain(cfg.FileFlushInterval, cfg.FileCheckpointInterval)
 	return s
 }
 
@@ -47,55 +98,81 @@ func (s *Storage) Bootstrap(ctx context.Context) error {
 	return nil
 }
 
-func (s *Storage) Save(ctx context.Context, userID string, urlToSave *url.URL, cfg *config.Config) (string, error) {
-	const maxRetries = 5
-	const randLen = 8
+func (s *Storage) Save(ctx context.Context, userID string, urlToSave *url.URL, meta LinkMeta, cfg *config.Config) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	for i := 0; i < maxRetries; i++ {
-		randVal, err := helpers.RandStringRunes(randLen)
-		if err != nil {
-			return "", fmt.Errorf("rand string error: %w", err)
-		}
-		s.mu.Lock()
-		_, exists := s.keyShortValuelong[randVal]
-		if !exists {
-			rec := Record{
-				ShortURL:    randVal,
-				OriginalURL: urlToSave.String(),
-				UserID:      userID,
-			}
-			s.keyShortValuelong[randVal] = rec
-			if err := s.saveRecord(rec); err != nil {
-				s.mu.Unlock()
-				return "", fmt.Errorf("saveRecord: %w", err)
-			}
-			s.mu.Unlock()
-			return ensureSlash(cfg.BaseURL) + randVal, nil
-		}
-		s.mu.Unlock()
+	link, err := pickShortID(urlToSave.String(), s.lookup(urlToSave.String()))
+	if err != nil {
+		return "", err
+	}
+	if link.existing {
+		return ensureSlash(cfg.BaseURL) + link.shortID, &ConflictError{OwnerID: link.ownerID}
 	}
-	return "", errors.New("could not generate unique URL")
+	rec := Record{
+		ShortURL:    link.shortID,
+		OriginalURL: urlToSave.String(),
+		UserID:      userID,
+		CreatedAt:   time.Now().UTC(),
+		Meta:        storedMeta(meta),
+	}
+	s.keyShortValuelong[link.shortID] = rec
+	if err := s.saveRecord(rec); err != nil {
+		return "", fmt.Errorf("saveRecord: %w", err)
+	}
+	return ensureSlash(cfg.BaseURL) + link.shortID, nil
 }
 
-func (s *Storage) SaveBatch(ctx context.Context, userID string, urls []*url.URL, cfg *config.Config) ([]string, error) {
+// lookup — проверка занятости id для pickShortID.
+func (s *Storage) lookup(rawURL string) func(id string) (string, bool, bool) {
+	return func(id string) (string, bool, bool) {
+		rec, ok := s.keyShortValuelong[id]
+		return rec.UserID, ok && !rec.IsDeleted && rec.OriginalURL == rawURL, ok
+	}
+}
+
+func (s *Storage) SaveBatch(ctx context.Context, userID string, urls []*url.URL, metas []LinkMeta, cfg *config.Config) ([]SavedURL, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	var results []string
-	for _, u := range urls {
-		key := strconv.Itoa(len(s.keyShortValuelong))
+	unique, slot := uniqueURLs(urls)
+	metas = uniqueMetas(metas, slot, len(unique))
+	var results []SavedURL
+	for i, u := range unique {
+		var key string
+		if shortid.HashIDs() {
+			link, err := pickShortID(u.String(), s.lookup(u.String()))
+			if err != nil {
+				return nil, err
+			}
+			if link.existing {
+				results = append(results, SavedURL{ShortURL: ensureSlash(cfg.BaseURL) + link.shortID, Existing: true, OwnerID: link.ownerID})
+				continue
+			}
+			key = link.shortID
+		} else {
+			// После импорта ключи могут быть заняты, поэтому ищем свободный.
+			seq := len(s.keyShortValuelong)
+			key = strconv.Itoa(seq)
+			for _, taken := s.keyShortValuelong[key]; taken || shortid.IsReserved(key); _, taken = s.keyShortValuelong[key] {
+				seq++
+				key = strconv.Itoa(seq)
+			}
+		}
 		rec := Record{
 			ShortURL:    key,
 			OriginalURL: u.String(),
 			UserID:      userID,
+			CreatedAt:   time.Now().UTC(),
+			Meta:        storedMeta(metas[i]),
 		}
 		s.keyShortValuelong[key] = rec
 		if err := s.saveRecord(rec); err != nil {
 			return nil, fmt.Errorf("save batch record: %w", err)
 		}
-		results = append(results, ensureSlash(cfg.BaseURL)+key)
+		results = append(results, SavedURL{ShortURL: ensureSlash(cfg.BaseURL) + key, OwnerID: userID})
 	}
-	return results, nil
+	return expandSaved(results, slot), nil
 }
 
 func (s *Storage) LoadFull(ctx context.Context, shortID string) (*url.URL, bool, error) {
@@ -104,7 +181,7 @@ func (s *Storage) LoadFull(ctx context.Context, shortID string) (*url.URL, bool,
 
 	rec, ok := s.keyShortValuelong[shortID]
 	if !ok {
-		return nil, false, errors.New("not found")
+		return nil, false, ErrNotFound
 	}
 	parsed, err := url.Parse(rec.OriginalURL)
 	if err != nil {
@@ -120,33 +197,209 @@ func (s *Storage) LoadUserURLs(ctx context.Context, userID string, baseURL strin
 	var result []UserURL
 	for shortID, rec := range s.keyShortValuelong {
 		if rec.UserID == userID && !rec.IsDeleted {
-			result = append(result, UserURL{
+			item := UserURL{
 				ShortURL:    ensureSlash(baseURL) + shortID,
 				OriginalURL: rec.OriginalURL,
-			})
+			}
+			rec.Meta.describe(&item)
+			result = append(result, item)
 		}
 	}
 	return result, nil
 }
 
-func (s *Storage) DeleteBatch(ctx context.Context, userID string, shortIDs []string) error {
+func (s *Storage) DeleteBatch(ctx context.Context, userID string, shortIDs []string) ([]DeleteResult, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	for _, sid := range shortIDs {
+	results := make([]DeleteResult, len(shortIDs))
+	for i, sid := range shortIDs {
+		results[i] = DeleteResult{ShortID: sid, Status: DeleteNotFound}
 		rec, ok := s.keyShortValuelong[sid]
 		if !ok {
 			continue
 		}
-		if rec.UserID == userID {
+		if rec.UserID != userID {
+			results[i].Status = DeleteForbidden
+			continue
+		}
+		results[i].Status = Deleted
+		if !rec.IsDeleted {
 			rec.IsDeleted = true
 			recSavErr := s.saveRecord(rec)
 			if recSavErr != nil {
-				middleware.Log.Error().Err(recSavErr).Msg("Error saving record after delete")
+				s.logger.Error("Error saving record after delete", "error", recSavErr)
 			}
 			s.keyShortValuelong[sid] = rec
 		}
 	}
+	return results, nil
+}
+
+func (s *Storage) RestoreBatch(ctx context.Context, userID string, shortIDs []string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var restored []string
+	for _, sid := range shortIDs {
+		rec, ok := s.keyShortValuelong[sid]
+		if !ok || rec.UserID != userID || !rec.IsDeleted {
+			continue
+		}
+		rec.IsDeleted = false
+		if err := s.saveRecord(rec); err != nil {
+			return restored, fmt.Errorf("save restored record: %w", err)
+		}
+		s.keyShortValuelong[sid] = rec
+		restored = append(restored, sid)
+	}
+	return restored, nil
+}
+
+func (s *Storage) UpdateURL(ctx context.Context, userID, shortID string, u *url.URL) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.keyShortValuelong[shortID]
+	if !ok || rec.UserID != userID || rec.IsDeleted {
+		return ErrNotFound
+	}
+	rec.OriginalURL = u.String()
+	if err := s.saveRecord(rec); err != nil {
+		return fmt.Errorf("save updated record: %w", err)
+	}
+	s.keyShortValuelong[shortID] = rec
+	return nil
+}
+
+func (s *Storage) TransferOwner(ctx context.Context, fromUserID, shortID, toUserID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.keyShortValuelong[shortID]
+	if !ok || rec.UserID != fromUserID || rec.IsDeleted {
+		return ErrNotFound
+	}
+	rec.UserID = toUserID
+	if err := s.saveRecord(rec); err != nil {
+		return fmt.Errorf("save transferred record: %w", err)
+	}
+	s.keyShortValuelong[shortID] = rec
+	return nil
+}
+
+func (s *Storage) ImportRecords(ctx context.Context, records []Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, rec := range records {
+		if _, exists := s.keyShortValuelong[rec.ShortURL]; exists {
+			continue
+		}
+		s.keyShortValuelong[rec.ShortURL] = rec
+		if err := s.saveRecord(rec); err != nil {
+			return fmt.Errorf("import record: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *Storage) ExportRecords(ctx context.Context, fn func(Record) error) error {
+	s.mu.Lock()
+	records := make([]Record, 0, len(s.keyShortValuelong))
+	for _, rec := range s.keyShortValuelong {
+		records = append(records, rec)
+	}
+	s.mu.Unlock()
+
+	for _, rec := range records {
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PurgeDeleted выкидывает удалённые записи и сразу делает снимок: в журнале их не вычеркнуть.
+func (s *Storage) PurgeDeleted(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	purged := 0
+	for sid, rec := range s.keyShortValuelong {
+		if rec.IsDeleted {
+			delete(s.keyShortValuelong, sid)
+			purged++
+		}
+	}
+	s.mu.Unlock()
+
+	if purged == 0 {
+		return 0, nil
+	}
+	if err := s.Checkpoint(); err != nil {
+		return purged, fmt.Errorf("checkpoint after purge: %w", err)
+	}
+	return purged, nil
+}
+
+// EraseUser, как и PurgeDeleted, сразу делает снимок, чтобы записи не остались в журнале.
+func (s *Storage) EraseUser(ctx context.Context, userID string) ([]string, error) {
+	s.mu.Lock()
+	var erased []string
+	for sid, rec := range s.keyShortValuelong {
+		if rec.UserID == userID {
+			delete(s.keyShortValuelong, sid)
+			erased = append(erased, sid)
+		}
+	}
+	s.mu.Unlock()
+
+	if len(erased) == 0 {
+		return nil, nil
+	}
+	if err := s.Checkpoint(); err != nil {
+		return erased, fmt.Errorf("checkpoint after erase: %w", err)
+	}
+	return erased, nil
+}
+
+func (s *Storage) LoadOwner(ctx context.Context, shortID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.keyShortValuelong[shortID]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return rec.UserID, nil
+}
+
+func (s *Storage) LoadMeta(ctx context.Context, shortID string) (LinkMeta, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.keyShortValuelong[shortID]
+	if !ok {
+		return LinkMeta{}, ErrNotFound
+	}
+	if rec.Meta == nil {
+		return LinkMeta{}, nil
+	}
+	return *rec.Meta, nil
+}
+
+func (s *Storage) SetMeta(ctx context.Context, userID, shortID string, meta LinkMeta) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.keyShortValuelong[shortID]
+	if !ok || rec.UserID != userID {
+		return ErrNotFound
+	}
+	rec.Meta = &meta
+	if err := s.saveRecord(rec); err != nil {
+		return fmt.Errorf("save meta: %w", err)
+	}
+	s.keyShortValuelong[shortID] = rec
 	return nil
 }
 
@@ -155,51 +408,143 @@ func (s *Storage) Ping(ctx context.Context) error {
 }
 
 func (s *Storage) Close(ctx context.Context) error {
-	return nil
+	close(s.stop)
+	<-s.done
+
+	checkpointErr := s.Checkpoint()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.wal == nil {
+		return checkpointErr
+	}
+	return errors.Join(checkpointErr, s.wal.Close())
 }
 
-func (s *Storage) loadFromFile() error {
-	f, err := os.Open(s.filePath)
-	if errors.Is(err, os.ErrNotExist) {
-		return nil
+// Checkpoint атомарно пишет новый снимок текущего состояния и обнуляет журнал.
+// Если упасть между этими шагами, журнал просто проиграется поверх свежего снимка.
+func (s *Storage) Checkpoint() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.frozen {
+		return s.flush()
+	}
+	if err := s.rewrite(); err != nil {
+		return err
+	}
+	if s.wal != nil {
+		_ = s.wal.Close()
 	}
+	return s.openWAL(os.O_TRUNC)
+}
+
+func (s *Storage) walPath() string {
+	return s.filePath + ".wal"
+}
+
+// rewrite пишет текущее состояние во временный файл и подменяет им снимок.
+func (s *Storage) rewrite() error {
+	return writeSnapshot(s.filePath, func(emit func(Record) error) error {
+		for _, rec := range s.keyShortValuelong {
+			if err := emit(rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Storage) openWAL(extraFlags int) error {
+	f, err := os.OpenFile(s.walPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND|extraFlags, 0o600)
 	if err != nil {
-		return fmt.Errorf("open file: %w", err)
+		s.wal, s.w = nil, nil
+		return fmt.Errorf("open WAL: %w", err)
 	}
-	defer f.Close()
+	s.wal = f
+	s.w = bufio.NewWriter(f)
+	return nil
+}
 
-	sc := bufio.NewScanner(f)
-	for sc.Scan() {
-		line := sc.Text()
-		var rec Record
-		if unmarshalErr := json.Unmarshal([]byte(line), &rec); unmarshalErr != nil {
-			middleware.Log.Error().Err(unmarshalErr).Msg("Error unmarshaling line")
-			continue
+// maintain сбрасывает буфер журнала в режиме batch и периодически делает снимок.
+func (s *Storage) maintain(flushInterval, checkpointInterval time.Duration) {
+	defer close(s.done)
+
+	var flushC, checkpointC <-chan time.Time
+	if s.syncMode == SyncBatch && flushInterval > 0 {
+		flushTicker := time.NewTicker(flushInterval)
+		defer flushTicker.Stop()
+		flushC = flushTicker.C
+	}
+	if checkpointInterval > 0 {
+		checkpointTicker := time.NewTicker(checkpointInterval)
+		defer checkpointTicker.Stop()
+		checkpointC = checkpointTicker.C
+	}
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-flushC:
+			s.mu.Lock()
+			err := s.flush()
+			s.mu.Unlock()
+			if err != nil {
+				s.logger.Error("File storage flush failed", "error", err)
+			}
+		case <-checkpointC:
+			if err := s.Checkpoint(); err != nil {
+				s.logger.Error("File storage checkpoint failed", "error", err)
+			}
 		}
-		s.keyShortValuelong[rec.ShortURL] = rec
 	}
-	if scErr := sc.Err(); scErr != nil {
-		return fmt.Errorf("scanner: %w", scErr)
+}
+
+func (s *Storage) flush() error {
+	if s.w == nil || s.w.Buffered() == 0 {
+		return nil
+	}
+	if err := s.w.Flush(); err != nil {
+		return fmt.Errorf("flush: %w", err)
+	}
+	if err := s.wal.Sync(); err != nil {
+		return fmt.Errorf("fsync: %w", err)
 	}
 	return nil
 }
 
+func (s *Storage) loadFromFile(path string) (bool, error) {
+	return readRecords(path, s.logger, func(rec Record) {
+		s.keyShortValuelong[rec.ShortURL] = rec
+	})
+}
+
+// saveRecord дописывает запись одной строкой в журнал. Вызывается под s.mu.
 func (s *Storage) saveRecord(rec Record) error {
 	data, err := json.Marshal(rec)
 	if err != nil {
 		return fmt.Errorf("marshal: %w", err)
 	}
-	f, err := os.OpenFile(s.filePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
-	if err != nil {
-		return fmt.Errorf("open file: %w", err)
+	if s.w == nil {
+		if openErr := s.openWAL(0); openErr != nil {
+			return openErr
+		}
 	}
-	defer f.Close()
-
-	if _, wErr := f.Write(data); wErr != nil {
+	data = append(data, '\n')
+	if _, wErr := s.w.Write(data); wErr != nil {
 		return fmt.Errorf("write data: %w", wErr)
 	}
-	if _, w2Err := f.WriteString("\n"); w2Err != nil {
-		return fmt.Errorf("write newline: %w", w2Err)
+	if s.syncMode == SyncBatch {
+		return nil
+	}
+	if flushErr := s.w.Flush(); flushErr != nil {
+		return fmt.Errorf("flush: %w", flushErr)
+	}
+	if s.syncMode == SyncAlways {
+		if syncErr := s.wal.Sync(); syncErr != nil {
+			return fmt.Errorf("fsync: %w", syncErr)
+		}
 	}
 	return nil
 }
@@ -216,11 +561,12 @@ func (s *Storage) SetIfAbsent(short, longURL string) (string, bool) {
 		ShortURL:    short,
 		OriginalURL: longURL,
 		UserID:      "", // тест не задаёт.
+		CreatedAt:   time.Now().UTC(),
 	}
 	s.keyShortValuelong[short] = rec
 
 	if err := s.saveRecord(rec); err != nil {
-		middleware.Log.Error().Err(err).Msg("Error saving record to file in SetIfAbsent")
+		s.logger.Error("Error saving record to file in SetIfAbsent", "error", err)
 	}
 	return short, true
 }
@@ -2,30 +2,74 @@
 package store
 
 import (
+	"container/list"
 	"context"
 	"errors"
 	"fmt"
 	"net/url"
 	"sync"
+	"time"
 
 	"github.com/dkolesni-prog/transformer/internal/config"
-	"github.com/dkolesni-prog/transformer/internal/helpers"
+	"github.com/dkolesni-prog/transformer/internal/logging"
+	"github.com/dkolesni-prog/transformer/internal/shortid"
 )
 
 type MemoryRecord struct {
 	OriginalURL string
 	UserID      string
 	IsDeleted   bool
+	CreatedAt   time.Time
+	Meta        *LinkMeta
+	// parsed — OriginalURL, разобранный при первом LoadFull, чтобы редирект не разбирал его каждый раз.
+	parsed *parsedURL
+}
+
+// parsedURL помнит, из какой строки получен u: после UpdateURL строка другая и разбор повторяется.
+type parsedURL struct {
+	raw string
+	u   *url.URL
+}
+
+// MemoryLimits ограничивают рост MemoryStorage. Нулевые значения — без ограничений.
+type MemoryLimits struct {
+	// MaxEntries — сколько записей держать; лишние вытесняются.
+	MaxEntries int
+	// TTL — срок жизни записи: с момента создания, а при LRU — с последнего обращения.
+	TTL time.Duration
+	// LRU — вытеснять давно не читавшиеся записи, а не самые старые.
+	LRU bool
+}
+
+type memoryEntry struct {
+	shortID string
+	touched time.Time
 }
 
 type MemoryStorage struct {
-	mu   sync.Mutex
-	data map[string]MemoryRecord
+	mu     sync.Mutex
+	data   map[string]MemoryRecord
+	limits MemoryLimits
+	// order — от свежих к старым (по созданию или, при LRU, по обращению).
+	order *list.List
+	elems map[string]*list.Element
+
+	snapshotPath string
+	logger       logging.Logger
+	stop         chan struct{}
+	done         chan struct{}
 }
 
 func NewMemoryStorage() *MemoryStorage {
+	return NewMemoryStorageWithLimits(MemoryLimits{})
+}
+
+func NewMemoryStorageWithLimits(limits MemoryLimits) *MemoryStorage {
 	return &MemoryStorage{
-		data: make(map[string]MemoryRecord),
+		data:   make(map[string]MemoryRecord),
+		limits: limits,
+		order:  list.New(),
+		elems:  make(map[string]*list.Element),
 	}
 }
 
@@ -33,61 +77,98 @@ func (m *MemoryStorage) Bootstrap(ctx context.Context) error {
 	return nil
 }
 
-func (m *MemoryStorage) Save(ctx context.Context, userID string, urlToSave *url.URL, cfg *config.Config) (string, error) {
-	const maxRetries = 5
-	const randLen = 8
+func (m *MemoryStorage) Save(ctx context.Context, userID string, urlToSave *url.URL, meta LinkMeta, cfg *config.Config) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	link, err := pickShortID(urlToSave.String(), m.lookup(urlToSave.String()))
+	if err != nil {
+		return "", err
+	}
+	if link.existing {
+		return ensureSlash(cfg.BaseURL) + link.shortID, &ConflictError{OwnerID: link.ownerID}
+	}
+	m.put(link.shortID, MemoryRecord{
+		OriginalURL: urlToSave.String(),
+		UserID:      userID,
+		IsDeleted:   false,
+		CreatedAt:   time.Now().UTC(),
+		Meta:        storedMeta(meta),
+	})
+	return ensureSlash(cfg.BaseURL) + link.shortID, nil
+}
 
-	for i := 0; i < maxRetries; i++ {
-		randVal, genErr := helpers.RandStringRunes(randLen)
-		if genErr != nil {
-			return "", fmt.Errorf("randVal: %w", genErr)
-		}
-		m.mu.Lock()
-		_, exists := m.data[randVal]
-		if !exists {
-			m.data[randVal] = MemoryRecord{
-				OriginalURL: urlToSave.String(),
-				UserID:      userID,
-				IsDeleted:   false,
-			}
-			m.mu.Unlock()
-			return ensureSlash(cfg.BaseURL) + randVal, nil
-		}
-		m.mu.Unlock()
+// lookup — проверка занятости id для pickShortID.
+func (m *MemoryStorage) lookup(rawURL string) func(id string) (string, bool, bool) {
+	return func(id string) (string, bool, bool) {
+		rec, ok := m.data[id]
+		return rec.UserID, ok && !rec.IsDeleted && rec.OriginalURL == rawURL, ok
 	}
-	return "", errors.New("could not generate unique short ID")
 }
 
-func (m *MemoryStorage) SaveBatch(ctx context.Context, userID string, urls []*url.URL, cfg *config.Config) ([]string, error) {
+func (m *MemoryStorage) SaveBatch(ctx context.Context, userID string, urls []*url.URL, metas []LinkMeta, cfg *config.Config) ([]SavedURL, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	var out []string
-	for _, u := range urls {
-		key := fmt.Sprintf("%x", len(m.data))
-		m.data[key] = MemoryRecord{
+	unique, slot := uniqueURLs(urls)
+	metas = uniqueMetas(metas, slot, len(unique))
+	var out []SavedURL
+	for i, u := range unique {
+		var key string
+		if shortid.HashIDs() {
+			link, err := pickShortID(u.String(), m.lookup(u.String()))
+			if err != nil {
+				return nil, err
+			}
+			if link.existing {
+				out = append(out, SavedURL{ShortURL: ensureSlash(cfg.BaseURL) + link.shortID, Existing: true, OwnerID: link.ownerID})
+				continue
+			}
+			key = link.shortID
+		} else {
+			// После вытеснения len(m.data) уменьшается, поэтому ищем незанятый ключ.
+			seq := len(m.data)
+			key = fmt.Sprintf("%x", seq)
+			for _, taken := m.data[key]; taken || shortid.IsReserved(key); _, taken = m.data[key] {
+				seq++
+				key = fmt.Sprintf("%x", seq)
+			}
+		}
+		m.put(key, MemoryRecord{
 			OriginalURL: u.String(),
 			UserID:      userID,
 			IsDeleted:   false,
-		}
-		out = append(out, ensureSlash(cfg.BaseURL)+key)
+			CreatedAt:   time.Now().UTC(),
+			Meta:        storedMeta(metas[i]),
+		})
+		out = append(out, SavedURL{ShortURL: ensureSlash(cfg.BaseURL) + key, OwnerID: userID})
 	}
-	return out, nil
+	return expandSaved(out, slot), nil
 }
 
 func (m *MemoryStorage) LoadFull(ctx context.Context, shortID string) (*url.URL, bool, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	m.evictExpired()
 	rec, ok := m.data[shortID]
 	if !ok {
-		return nil, false, errors.New("not found")
+		return nil, false, ErrNotFound
 	}
-	parsed, err := url.Parse(rec.OriginalURL)
-	if err != nil {
-		return nil, false, errors.New("invalid stored URL")
+	if m.limits.LRU {
+		m.touch(shortID)
 	}
-	return parsed, rec.IsDeleted, nil
+	if rec.parsed == nil || rec.parsed.raw != rec.OriginalURL {
+		parsed, err := url.Parse(rec.OriginalURL)
+		if err != nil {
+			return nil, false, errors.New("invalid stored URL")
+		}
+		rec.parsed = &parsedURL{raw: rec.OriginalURL, u: parsed}
+		m.data[shortID] = rec
+	}
+	// Копия: вызывающий может менять URL, общий разбор должен остаться прежним.
+	u := *rec.parsed.u
+	return &u, rec.IsDeleted, nil
 }
 
 func (m *MemoryStorage) LoadUserURLs(ctx context.Context, userID string, baseURL string) ([]UserURL, error) {
@@ -97,29 +178,187 @@ func (m *MemoryStorage) LoadUserURLs(ctx context.Context, userID string, baseURL
 	var res []UserURL
 	for shortID, rec := range m.data {
 		if rec.UserID == userID && !rec.IsDeleted {
-			res = append(res, UserURL{
+			item := UserURL{
 				ShortURL:    ensureSlash(baseURL) + shortID,
 				OriginalURL: rec.OriginalURL,
-			})
+			}
+			rec.Meta.describe(&item)
+			res = append(res, item)
 		}
 	}
 	return res, nil
 }
 
-func (m *MemoryStorage) DeleteBatch(ctx context.Context, userID string, shortIDs []string) error {
+func (m *MemoryStorage) DeleteBatch(ctx context.Context, userID string, shortIDs []string) ([]DeleteResult, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	for _, sid := range shortIDs {
+	results := make([]DeleteResult, len(shortIDs))
+	for i, sid := range shortIDs {
+		results[i] = DeleteResult{ShortID: sid, Status: DeleteNotFound}
 		rec, ok := m.data[sid]
 		if !ok {
 			continue
 		}
+		if rec.UserID != userID {
+			results[i].Status = DeleteForbidden
+			continue
+		}
+		rec.IsDeleted = true
+		m.data[sid] = rec
+		results[i].Status = Deleted
+	}
+	return results, nil
+}
+
+func (m *MemoryStorage) RestoreBatch(ctx context.Context, userID string, shortIDs []string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var restored []string
+	for _, sid := range shortIDs {
+		rec, ok := m.data[sid]
+		if !ok || rec.UserID != userID || !rec.IsDeleted {
+			continue
+		}
+		rec.IsDeleted = false
+		m.data[sid] = rec
+		restored = append(restored, sid)
+	}
+	return restored, nil
+}
+
+func (m *MemoryStorage) UpdateURL(ctx context.Context, userID, shortID string, u *url.URL) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, ok := m.data[shortID]
+	if !ok || rec.UserID != userID || rec.IsDeleted {
+		return ErrNotFound
+	}
+	rec.OriginalURL = u.String()
+	m.data[shortID] = rec
+	return nil
+}
+
+func (m *MemoryStorage) TransferOwner(ctx context.Context, fromUserID, shortID, toUserID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, ok := m.data[shortID]
+	if !ok || rec.UserID != fromUserID || rec.IsDeleted {
+		return ErrNotFound
+	}
+	rec.UserID = toUserID
+	m.data[shortID] = rec
+	return nil
+}
+
+func (m *MemoryStorage) ImportRecords(ctx context.Context, records []Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, rec := range records {
+		if _, exists := m.data[rec.ShortURL]; exists {
+			continue
+		}
+		m.put(rec.ShortURL, MemoryRecord{
+			OriginalURL: rec.OriginalURL,
+			UserID:      rec.UserID,
+			IsDeleted:   rec.IsDeleted,
+			CreatedAt:   rec.CreatedAt,
+			Meta:        rec.Meta,
+		})
+	}
+	return nil
+}
+
+func (m *MemoryStorage) ExportRecords(ctx context.Context, fn func(Record) error) error {
+	m.mu.Lock()
+	records := make([]Record, 0, len(m.data))
+	for shortID, rec := range m.data {
+		records = append(records, Record{
+			ShortURL:    shortID,
+			OriginalURL: rec.OriginalURL,
+			UserID:      rec.UserID,
+			IsDeleted:   rec.IsDeleted,
+			CreatedAt:   rec.CreatedAt,
+			Meta:        rec.Meta,
+		})
+	}
+	m.mu.Unlock()
+
+	for _, rec := range records {
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStorage) PurgeDeleted(ctx context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	purged := 0
+	for shortID, rec := range m.data {
+		if rec.IsDeleted {
+			m.remove(shortID)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (m *MemoryStorage) EraseUser(ctx context.Context, userID string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var erased []string
+	for shortID, rec := range m.data {
 		if rec.UserID == userID {
-			rec.IsDeleted = true
-			m.data[sid] = rec
+			m.remove(shortID)
+			erased = append(erased, shortID)
 		}
 	}
+	return erased, nil
+}
+
+func (m *MemoryStorage) LoadOwner(ctx context.Context, shortID string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, ok := m.data[shortID]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return rec.UserID, nil
+}
+
+func (m *MemoryStorage) LoadMeta(ctx context.Context, shortID string) (LinkMeta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, ok := m.data[shortID]
+	if !ok {
+		return LinkMeta{}, ErrNotFound
+	}
+	if rec.Meta == nil {
+		return LinkMeta{}, nil
+	}
+	return *rec.Meta, nil
+}
+
+func (m *MemoryStorage) SetMeta(ctx context.Context, userID, shortID string, meta LinkMeta) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, ok := m.data[shortID]
+	if !ok || rec.UserID != userID {
+		return ErrNotFound
+	}
+	rec.Meta = &meta
+	m.data[shortID] = rec
 	return nil
 }
 
@@ -128,5 +367,139 @@ func (m *MemoryStorage) Ping(ctx context.Context) error {
 }
 
 func (m *MemoryStorage) Close(ctx context.Context) error {
+	if m.snapshotPath == "" {
+		return nil
+	}
+	close(m.stop)
+	<-m.done
+	return m.Snapshot()
+}
+
+// EnableSnapshots загружает снимок из path (если он есть) и дальше сохраняет
+// состояние туда каждые interval и при Close. Ошибки фоновых снимков пишутся в logger.
+func (m *MemoryStorage) EnableSnapshots(path string, interval time.Duration, logger logging.Logger) error {
+	m.mu.Lock()
+	_, err := readRecords(path, logger, func(rec Record) {
+		m.put(rec.ShortURL, MemoryRecord{
+			OriginalURL: rec.OriginalURL,
+			UserID:      rec.UserID,
+			IsDeleted:   rec.IsDeleted,
+			CreatedAt:   rec.CreatedAt,
+			Meta:        rec.Meta,
+		})
+	})
+	m.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("load memory snapshot: %w", err)
+	}
+
+	m.snapshotPath = path
+	m.logger = logger
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+	go m.snapshotLoop(interval)
 	return nil
 }
+
+// Snapshot атомарно пишет текущее состояние на диск в формате файлового хранилища.
+func (m *MemoryStorage) Snapshot() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return writeSnapshot(m.snapshotPath, func(emit func(Record) error) error {
+		for shortID, rec := range m.data {
+			err := emit(Record{
+				ShortURL:    shortID,
+				OriginalURL: rec.OriginalURL,
+				UserID:      rec.UserID,
+				IsDeleted:   rec.IsDeleted,
+				CreatedAt:   rec.CreatedAt,
+				Meta:        rec.Meta,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (m *MemoryStorage) snapshotLoop(interval time.Duration) {
+	defer close(m.done)
+	if interval <= 0 {
+		<-m.stop
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			if err := m.Snapshot(); err != nil {
+				m.logger.Error("Memory store snapshot failed", "error", err)
+			}
+		}
+	}
+}
+
+// put сохраняет запись и вытесняет лишнее. Вызывается под m.mu.
+func (m *MemoryStorage) put(shortID string, rec MemoryRecord) {
+	m.data[shortID] = rec
+	m.touch(shortID)
+	m.evictExpired()
+	for m.limits.MaxEntries > 0 && len(m.data) > m.limits.MaxEntries {
+		m.removeOldest()
+	}
+}
+
+func (m *MemoryStorage) touch(shortID string) {
+	now := time.Now()
+	if el, ok := m.elems[shortID]; ok {
+		if e, isEntry := el.Value.(*memoryEntry); isEntry {
+			e.touched = now
+		}
+		m.order.MoveToFront(el)
+		return
+	}
+	m.elems[shortID] = m.order.PushFront(&memoryEntry{shortID: shortID, touched: now})
+}
+
+// evictExpired снимает с хвоста записи старше TTL. Вызывается под m.mu.
+func (m *MemoryStorage) evictExpired() {
+	if m.limits.TTL <= 0 {
+		return
+	}
+	deadline := time.Now().Add(-m.limits.TTL)
+	for {
+		el := m.order.Back()
+		if el == nil {
+			return
+		}
+		e, _ := el.Value.(*memoryEntry)
+		if e.touched.After(deadline) {
+			return
+		}
+		m.removeOldest()
+	}
+}
+
+func (m *MemoryStorage) removeOldest() {
+	el := m.order.Back()
+	if el == nil {
+		return
+	}
+	e, _ := el.Value.(*memoryEntry)
+	m.remove(e.shortID)
+}
+
+func (m *MemoryStorage) remove(shortID string) {
+	if el, ok := m.elems[shortID]; ok {
+		m.order.Remove(el)
+		delete(m.elems, shortID)
+	}
+	delete(m.data, shortID)
+}
@@ -0,0 +1,187 @@
+// internal/store/outbox.go
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Типы событий жизненного цикла ссылок.
+const (
+	EventCreated     = "link.created"
+	EventDeleted     = "link.deleted"
+	EventUpdated     = "link.updated"
+	EventTransferred = "link.transferred"
+	EventRestored    = "link.restored"
+	// EventErased — ссылка удалена окончательно вместе с аккаунтом владельца; user_id не передаётся.
+	EventErased = "link.erased"
+	// EventExpired — ссылку мягко удалила чистка устаревших ссылок, а не владелец (см. WithExpiry).
+	EventExpired = "link.expired"
+)
+
+type expiryKey struct{}
+
+// WithExpiry помечает удаления через ctx как истечение срока: DeleteBatch публикует
+// по ним EventExpired вместо EventDeleted.
+func WithExpiry(ctx context.Context) context.Context {
+	return context.WithValue(ctx, expiryKey{}, true)
+}
+
+// DeleteEvent — тип события для удаления ссылки в ctx.
+func DeleteEvent(ctx context.Context) string {
+	if expired, _ := ctx.Value(expiryKey{}).(bool); expired {
+		return EventExpired
+	}
+	return EventDeleted
+}
+
+// Event — изменение ссылки для внешних подписчиков.
+type Event struct {
+	Type        string    `json:"event"`
+	Time        time.Time `json:"time"`
+	UserID      string    `json:"user_id"`
+	ShortID     string    `json:"short_id"`
+	OriginalURL string    `json:"original_url,omitempty"`
+}
+
+func NewEvent(eventType, userID, shortID, originalURL string) Event {
+	return Event{
+		Type:        eventType,
+		Time:        time.Now().UTC(),
+		UserID:      userID,
+		ShortID:     shortID,
+		OriginalURL: originalURL,
+	}
+}
+
+// querier — то общее у пула и транзакции, чем пользуются изменения ссылок.
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+}
+
+// EnableOutbox makes every link mutation record its events in the outbox table within the
+// same transaction, to be picked up with DrainOutbox. Call before serving requests.
+func (r *RDB) EnableOutbox() {
+	r.outbox = true
+}
+
+// mutate runs fn directly on the pool, or, with the outbox enabled, in a transaction that
+// also stores the events fn returns: an event exists if and only if its change was committed.
+func (r *RDB) mutate(ctx context.Context, fn func(q querier) ([]Event, error)) error {
+	if !r.outbox {
+		_, err := fn(r.pool)
+		return err
+	}
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	// Rollback will be a no-op if Commit succeeds.
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+	events, err := fn(tx)
+	if err != nil {
+		return err
+	}
+	if err := writeOutbox(ctx, tx, events); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func writeOutbox(ctx context.Context, tx pgx.Tx, events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	const sqlInsert = `INSERT INTO outbox (payload) SELECT unnest($1::text[])::jsonb;`
+
+	payloads := make([]string, len(events))
+	for i, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return errors.New("marshal outbox event: " + err.Error())
+		}
+		payloads[i] = string(data)
+	}
+	if _, err := tx.Exec(ctx, sqlInsert, payloads); err != nil {
+		return errors.New("write outbox: " + err.Error())
+	}
+	return nil
+}
+
+// DrainOutbox hands up to limit oldest outbox events to fn and deletes them once fn succeeds.
+// The rows stay locked until then, so several instances never hand out the same event; an
+// error from fn or a crash leaves them for the next call, so delivery is at least once.
+// Returns how many outbox rows were taken.
+func (r *RDB) DrainOutbox(ctx context.Context, limit int, fn func([]Event) error) (int, error) {
+	const sqlSelect = `
+SELECT id, payload
+FROM outbox
+ORDER BY id
+LIMIT $1
+FOR UPDATE SKIP LOCKED;
+`
+	const sqlDelete = `DELETE FROM outbox WHERE id = ANY($1);`
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, errors.New("DrainOutbox: " + err.Error())
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	rows, err := tx.Query(ctx, sqlSelect, limit)
+	if err != nil {
+		return 0, errors.New("DrainOutbox: " + err.Error())
+	}
+	var (
+		ids    []int64
+		events []Event
+	)
+	for rows.Next() {
+		var (
+			id      int64
+			payload []byte
+			e       Event
+		)
+		if err := rows.Scan(&id, &payload); err != nil {
+			rows.Close()
+			return 0, errors.New("DrainOutbox scan: " + err.Error())
+		}
+		if err := json.Unmarshal(payload, &e); err != nil {
+			// Битое событие никто не доставит: удаляем его вместе с остальными.
+			r.logger.Error("Dropping malformed outbox event", "error", err, "id", id)
+		} else {
+			events = append(events, e)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, errors.New("DrainOutbox: " + err.Error())
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	if err := fn(events); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(ctx, sqlDelete, ids); err != nil {
+		return 0, errors.New("DrainOutbox delete: " + err.Error())
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, errors.New("DrainOutbox commit: " + err.Error())
+	}
+	return len(ids), nil
+}
@@ -0,0 +1,57 @@
+// internal/store/retry.go
+package store
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// retry повторяет fn при временных ошибках БД с экспоненциальной задержкой и джиттером.
+func (r *RDB) retry(ctx context.Context, op string, fn func() error) error {
+	backoff := r.opts.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt > r.opts.MaxRetries || !isTransient(err) {
+			return err
+		}
+		sleep := backoff
+		if backoff > 0 {
+			sleep = backoff/2 + time.Duration(rand.Int64N(int64(backoff)))
+		}
+		r.logger.Warn("Transient DB error, retrying", "error", err, "op", op, "attempt", attempt, "sleep", sleep)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(sleep):
+		}
+		backoff *= 2
+	}
+}
+
+// isTransient — сериализационные конфликты, дедлоки, обрывы соединения и переключения primary.
+func isTransient(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "40001", "40P01", "57P01", "57P02", "57P03":
+			return true
+		}
+		return strings.HasPrefix(pgErr.Code, "08")
+	}
+	if pgconn.SafeToRetry(err) || pgconn.Timeout(err) {
+		return true
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,122 @@
+// internal/store/snapshot.go
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dkolesni-prog/transformer/internal/logging"
+)
+
+// fileFormatVersion — текущая версия формата файла. v1 — строки Record без заголовка
+// и без created_at; v2 начинается со строки fileHeader.
+const fileFormatVersion = 2
+
+type fileHeader struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+}
+
+var errUnsupportedVersion = errors.New("unsupported storage file version")
+
+// writeSnapshot атомарно (временный файл + rename) пишет заголовок и все записи,
+// которые forEach передаст в emit.
+func writeSnapshot(path string, forEach func(emit func(Record) error) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".snapshot-*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	if encErr := enc.Encode(fileHeader{Format: "shortener", Version: fileFormatVersion}); encErr != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("encode header: %w", encErr)
+	}
+	emit := func(rec Record) error {
+		if encErr := enc.Encode(rec); encErr != nil {
+			return fmt.Errorf("encode record: %w", encErr)
+		}
+		return nil
+	}
+	if emitErr := forEach(emit); emitErr != nil {
+		_ = tmp.Close()
+		return emitErr
+	}
+	if flushErr := w.Flush(); flushErr != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("flush temp file: %w", flushErr)
+	}
+	if syncErr := tmp.Sync(); syncErr != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("sync temp file: %w", syncErr)
+	}
+	if closeErr := tmp.Close(); closeErr != nil {
+		return fmt.Errorf("close temp file: %w", closeErr)
+	}
+	if renameErr := os.Rename(tmp.Name(), path); renameErr != nil {
+		return fmt.Errorf("replace snapshot file: %w", renameErr)
+	}
+	return nil
+}
+
+// readRecords читает записи построчно и отдаёт их в fn. torn — последняя строка
+// оборвана (нет перевода строки или невалидный JSON) и файл нужно переписать.
+// Файлы v1 без заголовка читаются как есть; created_at им ставится по mtime файла.
+func readRecords(path string, logger logging.Logger, fn func(Record)) (bool, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+
+	var legacyCreatedAt time.Time
+	if info, statErr := f.Stat(); statErr == nil {
+		legacyCreatedAt = info.ModTime().UTC()
+	}
+
+	torn := false
+	first := true
+	rd := bufio.NewReader(f)
+	for {
+		line, readErr := rd.ReadBytes('\n')
+		if first && len(line) > 0 {
+			first = false
+			var header fileHeader
+			if json.Unmarshal(line, &header) == nil && header.Version > 0 {
+				if header.Version > fileFormatVersion {
+					return false, fmt.Errorf("%w: %d", errUnsupportedVersion, header.Version)
+				}
+				line = nil
+			}
+		}
+		if len(line) > 0 {
+			var rec Record
+			if unmarshalErr := json.Unmarshal(line, &rec); unmarshalErr != nil {
+				logger.Error("Error unmarshaling line", "error", unmarshalErr)
+			} else {
+				if rec.CreatedAt.IsZero() {
+					rec.CreatedAt = legacyCreatedAt
+				}
+				fn(rec)
+			}
+			torn = errors.Is(readErr, io.EOF)
+		}
+		if errors.Is(readErr, io.EOF) {
+			return torn, nil
+		}
+		if readErr != nil {
+			return false, fmt.Errorf("read file: %w", readErr)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// internal/store/stats.go
+package store
+
+import "context"
+
+// Stats — сводка по содержимому хранилища.
+type Stats struct {
+	Total   int `json:"total"`
+	Active  int `json:"active"`
+	Deleted int `json:"deleted"`
+	Users   int `json:"users"`
+}
+
+// CollectStats считает Stats полным проходом по ExportRecords.
+func CollectStats(ctx context.Context, s Store) (Stats, error) {
+	var st Stats
+	users := make(map[string]struct{})
+	err := s.ExportRecords(ctx, func(rec Record) error {
+		st.Total++
+		if rec.IsDeleted {
+			st.Deleted++
+		} else {
+			st.Active++
+		}
+		if rec.UserID != "" {
+			users[rec.UserID] = struct{}{}
+		}
+		return nil
+	})
+	st.Users = len(users)
+	return st, err
+}
+
+// FindRecord собирает запись по shortID из точечных запросов: адрес, владелец и настройки.
+// CreatedAt не заполняется.
+func FindRecord(ctx context.Context, s Store, shortID string) (Record, error) {
+	u, isDeleted, err := s.LoadFull(ctx, shortID)
+	if err != nil {
+		return Record{}, err
+	}
+	userID, err := s.LoadOwner(ctx, shortID)
+	if err != nil {
+		return Record{}, err
+	}
+	meta, err := s.LoadMeta(ctx, shortID)
+	if err != nil {
+		return Record{}, err
+	}
+	rec := Record{ShortURL: shortID, OriginalURL: u.String(), UserID: userID, IsDeleted: isDeleted}
+	if !meta.IsZero() {
+		rec.Meta = &meta
+	}
+	return rec, nil
+}
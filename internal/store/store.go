@@ -3,27 +3,289 @@ package store
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/url"
+	"strings"
+	"time"
 
 	"github.com/dkolesni-prog/transformer/internal/config"
+	"github.com/dkolesni-prog/transformer/internal/shortid"
 )
 
+var (
+	// ErrNotFound — shortID отсутствует в хранилище.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict — такой original_url уже сокращён; вместе с ошибкой возвращается существующая ссылка.
+	ErrConflict = errors.New("conflict: URL already exists")
+)
+
+// UnavailableError — хранилище временно недоступно, запрос стоит повторить через RetryAfter.
+type UnavailableError struct {
+	RetryAfter time.Duration
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("storage unavailable, retry after %s", e.RetryAfter)
+}
+
+// ConflictError — ErrConflict вместе с владельцем уже существующей ссылки.
+type ConflictError struct {
+	OwnerID string
+}
+
+func (e *ConflictError) Error() string {
+	return ErrConflict.Error()
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// SavedURL — итог сохранения одного адреса из SaveBatch.
+type SavedURL struct {
+	ShortURL string
+	// Existing — адрес уже был сокращён, новая запись не создавалась.
+	Existing bool
+	// OwnerID — владелец ссылки; у Existing может отличаться от сохранявшего.
+	OwnerID string
+}
+
+// DeleteStatus — итог удаления одного shortID из DeleteBatch.
+type DeleteStatus string
+
+const (
+	// Deleted — ссылка владельца помечена удалённой (или уже была), либо снят псевдоним.
+	Deleted DeleteStatus = "deleted"
+	// DeleteNotFound — такого shortID нет.
+	DeleteNotFound DeleteStatus = "not_found"
+	// DeleteForbidden — ссылка принадлежит другому пользователю.
+	DeleteForbidden DeleteStatus = "forbidden"
+)
+
+// DeleteResult — итог DeleteBatch для одного shortID.
+type DeleteResult struct {
+	ShortID string       `json:"id"`
+	Status  DeleteStatus `json:"status"`
+}
+
+// CountDeleted считает shortID, которые действительно удалены.
+func CountDeleted(results []DeleteResult) int {
+	n := 0
+	for _, res := range results {
+		if res.Status == Deleted {
+			n++
+		}
+	}
+	return n
+}
+
+// uniqueURLs убирает повторы из пачки: адрес сохраняется один раз, slot[i] — индекс urls[i] в unique.
+func uniqueURLs(urls []*url.URL) (unique []*url.URL, slot []int) {
+	seen := make(map[string]int, len(urls))
+	slot = make([]int, len(urls))
+	for i, u := range urls {
+		j, dup := seen[u.String()]
+		if !dup {
+			j = len(unique)
+			seen[u.String()] = j
+			unique = append(unique, u)
+		}
+		slot[i] = j
+	}
+	return unique, slot
+}
+
+// uniqueMetas выбирает для каждого адреса из uniqueURLs настройки его первого вхождения в пачку.
+func uniqueMetas(metas []LinkMeta, slot []int, n int) []LinkMeta {
+	out := make([]LinkMeta, n)
+	filled := make([]bool, n)
+	for i, j := range slot {
+		if i < len(metas) && !filled[j] {
+			out[j], filled[j] = metas[i], true
+		}
+	}
+	return out
+}
+
+// storedMeta — meta для записи в хранилище; nil, если настроек нет.
+func storedMeta(meta LinkMeta) *LinkMeta {
+	if meta.IsZero() {
+		return nil
+	}
+	return &meta
+}
+
+// expandSaved раскладывает результаты uniqueURLs обратно по позициям исходной пачки.
+func expandSaved(saved []SavedURL, slot []int) []SavedURL {
+	out := make([]SavedURL, len(slot))
+	for i, j := range slot {
+		out[i] = saved[j]
+	}
+	return out
+}
+
+// Unwrap снимает с s обёртки, отдающие вложенное хранилище методом Unwrap (например,
+// метрики), чтобы проверить, что за хранилище под ними: *RDB, *Storage или Purger.
+func Unwrap(s Store) Store {
+	for {
+		w, ok := s.(interface{ Unwrap() Store })
+		if !ok {
+			return s
+		}
+		s = w.Unwrap()
+	}
+}
+
+// pickShortID подбирает id для rawURL из кандидатов shortid.ForURL. lookup сообщает, занят ли
+// id, кем и тем ли адресом. Id, занятый тем же адресом (в режиме shortid.HashIDs это повторное
+// сокращение), возвращается как existing; удалённые записи lookup считает чужими.
+func pickShortID(rawURL string, lookup func(id string) (ownerID string, sameURL, taken bool)) (savedLink, error) {
+	const maxRetries = 5
+	const randLen = 8
+
+	for attempt := range maxRetries {
+		id, err := shortid.ForURL(rawURL, randLen, attempt)
+		if err != nil {
+			return savedLink{}, fmt.Errorf("short ID: %w", err)
+		}
+		ownerID, sameURL, taken := lookup(id)
+		if sameURL {
+			return savedLink{shortID: id, ownerID: ownerID, existing: true}, nil
+		}
+		shortid.Observe(taken)
+		if !taken {
+			return savedLink{shortID: id}, nil
+		}
+	}
+	return savedLink{}, errors.New("could not generate unique short ID")
+}
+
 // Вместо Load(...) теперь LoadFull(...) возвращает (URL, isDeleted, error).
 type Store interface {
-	Save(ctx context.Context, userID string, url *url.URL, cfg *config.Config) (string, error)
-	SaveBatch(ctx context.Context, userID string, urls []*url.URL, cfg *config.Config) ([]string, error)
+	// Save сохраняет ссылку вместе с meta одной записью: ссылка не бывает доступна без
+	// своих настроек. У уже существующей ссылки (ConflictError) meta не меняется.
+	Save(ctx context.Context, userID string, url *url.URL, meta LinkMeta, cfg *config.Config) (string, error)
+	// SaveBatch сохраняет адреса и возвращает результаты в том же порядке. metas — настройки
+	// новых ссылок по позициям urls; nil — без настроек.
+	SaveBatch(ctx context.Context, userID string, urls []*url.URL, metas []LinkMeta, cfg *config.Config) ([]SavedURL, error)
 	LoadFull(ctx context.Context, shortID string) (*url.URL, bool, error)
 
 	LoadUserURLs(ctx context.Context, userID string, baseURL string) ([]UserURL, error)
-	DeleteBatch(ctx context.Context, userID string, shortIDs []string) error
+	// DeleteBatch помечает ссылки владельца userID удалёнными и возвращает итог
+	// по каждому shortID в том же порядке; чужие и несуществующие не трогает.
+	DeleteBatch(ctx context.Context, userID string, shortIDs []string) ([]DeleteResult, error)
+	// RestoreBatch снимает пометку удаления со ссылок владельца userID и возвращает
+	// восстановленные shortID; чужие, живые и уже вычищенные пропускаются.
+	RestoreBatch(ctx context.Context, userID string, shortIDs []string) ([]string, error)
+	// UpdateURL меняет адрес назначения живой ссылки владельца userID; ErrNotFound, если такой нет.
+	UpdateURL(ctx context.Context, userID, shortID string, u *url.URL) error
+	// TransferOwner передаёт живую ссылку от fromUserID к toUserID; ErrNotFound, если у fromUserID такой нет.
+	TransferOwner(ctx context.Context, fromUserID, shortID, toUserID string) error
+	// EraseUser окончательно удаляет все ссылки пользователя, включая помеченные удалёнными,
+	// и возвращает их shortID.
+	EraseUser(ctx context.Context, userID string) ([]string, error)
+
+	// ImportRecords сохраняет записи с уже известными shortID; существующие не перезаписываются.
+	ImportRecords(ctx context.Context, records []Record) error
+	// ExportRecords по очереди отдаёт в fn все записи, включая удалённые.
+	ExportRecords(ctx context.Context, fn func(Record) error) error
+
+	// LoadOwner возвращает владельца ссылки, в том числе удалённой; ErrNotFound, если ссылки нет.
+	LoadOwner(ctx context.Context, shortID string) (string, error)
+	// LoadMeta возвращает настройки ссылки; ErrNotFound, если ссылки нет.
+	LoadMeta(ctx context.Context, shortID string) (LinkMeta, error)
+	// SetMeta заменяет настройки ссылки владельца userID; ErrNotFound, если у него такой нет.
+	SetMeta(ctx context.Context, userID, shortID string, meta LinkMeta) error
 
 	Ping(ctx context.Context) error
 	Close(ctx context.Context) error
 	Bootstrap(ctx context.Context) error
 }
 
+// Purger — хранилище, умеющее окончательно удалять помеченные записи.
+type Purger interface {
+	// PurgeDeleted физически удаляет записи с is_deleted и возвращает их число.
+	PurgeDeleted(ctx context.Context) (int, error)
+}
+
+// LinkMeta — необязательные настройки ссылки, хранятся вместе с записью.
+type LinkMeta struct {
+	// PasswordHash — bcrypt-хеш пароля; пусто — ссылка открыта всем.
+	PasswordHash string `json:"password_hash,omitempty"`
+	// Domain — хост арендатора, под которым создана ссылка; пусто — основной BaseURL.
+	Domain string `json:"domain,omitempty"`
+	// Tags — метки ссылки. В RDB хранятся в таблице link_tags, а не в колонке meta.
+	Tags []string `json:"tags,omitempty"`
+	// Title — заголовок для списка ссылок: задан пользователем или взят из <title> страницы.
+	Title string `json:"title,omitempty"`
+	Note  string `json:"note,omitempty"`
+	// Variants — адреса сплит-теста с весами; при переходе выбирается один из них вместо основного.
+	Variants []Variant `json:"variants,omitempty"`
+	// StickyVariants — показывать посетителю один и тот же вариант (по cookie).
+	StickyVariants bool `json:"sticky_variants,omitempty"`
+	// UTM — шаблон параметров, дописываемых к адресу при переходе, например
+	// "utm_source=shortener&utm_campaign={tag}". Сохранённый адрес не меняется.
+	UTM string `json:"utm,omitempty"`
+	// Devices — отдельные адреса для мобильных и десктопов, а также ссылки в магазины приложений.
+	Devices *DeviceTargets `json:"devices,omitempty"`
+	// Geo — адреса по стране ("DE"), региону ("DE-BY") или "EU" посетителя; самые приоритетные.
+	Geo map[string]string `json:"geo,omitempty"`
+	// Flagged — причина, по которой ссылку пометили подозрительной; перед переходом показывается предупреждение.
+	Flagged string `json:"flagged,omitempty"`
+}
+
+// DeviceTargets — адреса назначения по платформе посетителя; пустое поле не переопределяет основной адрес.
+type DeviceTargets struct {
+	Mobile  string `json:"mobile,omitempty"`
+	Desktop string `json:"desktop,omitempty"`
+	// IOS и Android — например, страницы приложения в App Store и Google Play; важнее Mobile.
+	IOS     string `json:"ios,omitempty"`
+	Android string `json:"android,omitempty"`
+}
+
+// Variant — один адрес назначения сплит-теста.
+type Variant struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// IsZero сообщает, что у ссылки нет никаких настроек и meta можно не сохранять.
+func (m *LinkMeta) IsZero() bool {
+	return m.PasswordHash == "" && m.Domain == "" && len(m.Tags) == 0 && m.Title == "" && m.Note == "" &&
+		len(m.Variants) == 0 && m.UTM == "" && m.Devices == nil && len(m.Geo) == 0 && m.Flagged == ""
+}
+
+// describe переносит в элемент списка поля meta, которые видит владелец.
+func (m *LinkMeta) describe(u *UserURL) {
+	if m == nil {
+		return
+	}
+	u.Domain, u.Tags, u.Title, u.Note = m.Domain, m.Tags, m.Title, m.Note
+}
+
 // UserURL — структура для вывода "своих" ссылок
 type UserURL struct {
 	ShortURL    string `json:"short_url"`
 	OriginalURL string `json:"original_url"`
+	// Domain — LinkMeta.Domain ссылки, чтобы отфильтровать список по арендатору.
+	Domain string   `json:"-"`
+	Tags   []string `json:"tags,omitempty"`
+	Title  string   `json:"title,omitempty"`
+	Note   string   `json:"note,omitempty"`
+	// Alias — ссылка чужая и попала в список, потому что пользователь сокращал тот же адрес.
+	Alias bool `json:"alias,omitempty"`
+}
+
+// ShortIDFromURL вырезает shortID из полного короткого URL.
+func ShortIDFromURL(shortURL, baseURL string) string {
+	return strings.TrimPrefix(shortURL, ensureSlash(baseURL))
+}
+
+// IsFailure отделяет сбои хранилища от штатных бизнес-ошибок.
+func IsFailure(err error) bool {
+	return err != nil &&
+		!errors.Is(err, ErrNotFound) &&
+		!errors.Is(err, ErrConflict) &&
+		!errors.Is(err, context.Canceled)
 }
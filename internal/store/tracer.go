@@ -0,0 +1,102 @@
+// internal/store/tracer.go
+package store
+
+import (
+	"context"
+	"expvar"
+	"strings"
+	"time"
+
+	"github.com/dkolesni-prog/transformer/internal/logging"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// sqlMetrics — счётчики SQL по первому слову запроса (select, insert, ...): <verb>_queries,
+// <verb>_errors, <verb>_rows и <verb>_seconds. Публикуются через expvar под именем "sql".
+var sqlMetrics = expvar.NewMap("sql")
+
+type traceKey struct{}
+
+type traceStart struct {
+	verb  string
+	start time.Time
+}
+
+// queryTracer замеряет каждый запрос пула: Query, QueryRow, Exec, запросы пачек и COPY.
+// Аргументы запросов не логируются: в них адреса и ID пользователей.
+type queryTracer struct {
+	logger logging.Logger
+}
+
+var (
+	_ pgx.QueryTracer    = queryTracer{}
+	_ pgx.BatchTracer    = queryTracer{}
+	_ pgx.CopyFromTracer = queryTracer{}
+)
+
+func (t queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, traceKey{}, traceStart{verb: sqlVerb(data.SQL), start: time.Now()})
+}
+
+func (t queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	if s, ok := ctx.Value(traceKey{}).(traceStart); ok {
+		t.observe(s.verb, time.Since(s.start), data.CommandTag, data.Err)
+	}
+}
+
+// TraceBatchStart запоминает начало пачки: pgx не сообщает длительность отдельных
+// запросов в ней, поэтому время пачки учитывается целиком как verb "batch".
+func (t queryTracer) TraceBatchStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceBatchStartData) context.Context {
+	return context.WithValue(ctx, traceKey{}, traceStart{verb: "batch", start: time.Now()})
+}
+
+func (t queryTracer) TraceBatchQuery(_ context.Context, _ *pgx.Conn, data pgx.TraceBatchQueryData) {
+	t.count(sqlVerb(data.SQL), data.CommandTag, data.Err)
+}
+
+func (t queryTracer) TraceBatchEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchEndData) {
+	if s, ok := ctx.Value(traceKey{}).(traceStart); ok {
+		t.observe(s.verb, time.Since(s.start), pgconn.CommandTag{}, data.Err)
+	}
+}
+
+func (t queryTracer) TraceCopyFromStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceCopyFromStartData) context.Context {
+	return context.WithValue(ctx, traceKey{}, traceStart{verb: "copy", start: time.Now()})
+}
+
+func (t queryTracer) TraceCopyFromEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	if s, ok := ctx.Value(traceKey{}).(traceStart); ok {
+		t.observe(s.verb, time.Since(s.start), data.CommandTag, data.Err)
+	}
+}
+
+func (t queryTracer) observe(verb string, elapsed time.Duration, tag pgconn.CommandTag, err error) {
+	t.count(verb, tag, err)
+	sqlMetrics.AddFloat(verb+"_seconds", elapsed.Seconds())
+	if err != nil {
+		// Ошибку со всеми подробностями логирует вызывающий метод RDB.
+		t.logger.Debug("SQL failed", "verb", verb, "duration", elapsed, "error", err)
+		return
+	}
+	t.logger.Debug("SQL", "verb", verb, "duration", elapsed, "rows", tag.RowsAffected())
+}
+
+func (t queryTracer) count(verb string, tag pgconn.CommandTag, err error) {
+	sqlMetrics.Add(verb+"_queries", 1)
+	if err != nil {
+		sqlMetrics.Add(verb+"_errors", 1)
+		return
+	}
+	sqlMetrics.Add(verb+"_rows", tag.RowsAffected())
+}
+
+// sqlVerb — первое слово запроса в нижнем регистре: "select", "insert", "with" и т.п.
+func sqlVerb(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "unknown"
+	}
+	return strings.ToLower(fields[0])
+}
@@ -0,0 +1,154 @@
+// Internal/storemetrics/store.go.
+
+// Package storemetrics считает вызовы, ошибки и время каждого метода хранилища, чтобы
+// файловое, in-memory и Postgres хранилища можно было сравнить по одним метрикам.
+package storemetrics
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"net/url"
+	"time"
+
+	"github.com/dkolesni-prog/transformer/internal/config"
+	"github.com/dkolesni-prog/transformer/internal/store"
+)
+
+// metrics — по карте на вид хранилища: <Method>_calls, <Method>_errors и <Method>_seconds.
+// Публикуются через expvar под именем "store".
+var metrics = expvar.NewMap("store")
+
+// Store замеряет все методы вложенного хранилища под именем backend ("db", "file", "memory").
+// ErrNotFound и ErrConflict — обычные ответы, а не сбои, в ошибки они не попадают.
+type Store struct {
+	store.Store
+	m *expvar.Map
+}
+
+func NewStore(s store.Store, backend string) *Store {
+	m, ok := metrics.Get(backend).(*expvar.Map)
+	if !ok {
+		m = new(expvar.Map).Init()
+		metrics.Set(backend, m)
+	}
+	return &Store{Store: s, m: m}
+}
+
+// Unwrap возвращает вложенное хранилище (см. store.Unwrap).
+func (s *Store) Unwrap() store.Store {
+	return s.Store
+}
+
+func (s *Store) record(method string, start time.Time, err error) {
+	s.m.Add(method+"_calls", 1)
+	s.m.AddFloat(method+"_seconds", time.Since(start).Seconds())
+	if err != nil && !errors.Is(err, store.ErrNotFound) && !errors.Is(err, store.ErrConflict) {
+		s.m.Add(method+"_errors", 1)
+	}
+}
+
+func (s *Store) Save(ctx context.Context, userID string, u *url.URL, meta store.LinkMeta, cfg *config.Config) (string, error) {
+	start := time.Now()
+	res, err := s.Store.Save(ctx, userID, u, meta, cfg)
+	s.record("Save", start, err)
+	return res, err
+}
+
+func (s *Store) SaveBatch(ctx context.Context, userID string, urls []*url.URL, metas []store.LinkMeta, cfg *config.Config) ([]store.SavedURL, error) {
+	start := time.Now()
+	res, err := s.Store.SaveBatch(ctx, userID, urls, metas, cfg)
+	s.record("SaveBatch", start, err)
+	return res, err
+}
+
+func (s *Store) LoadFull(ctx context.Context, shortID string) (*url.URL, bool, error) {
+	start := time.Now()
+	u, isDeleted, err := s.Store.LoadFull(ctx, shortID)
+	s.record("LoadFull", start, err)
+	return u, isDeleted, err
+}
+
+func (s *Store) LoadUserURLs(ctx context.Context, userID string, baseURL string) ([]store.UserURL, error) {
+	start := time.Now()
+	res, err := s.Store.LoadUserURLs(ctx, userID, baseURL)
+	s.record("LoadUserURLs", start, err)
+	return res, err
+}
+
+func (s *Store) DeleteBatch(ctx context.Context, userID string, shortIDs []string) ([]store.DeleteResult, error) {
+	start := time.Now()
+	res, err := s.Store.DeleteBatch(ctx, userID, shortIDs)
+	s.record("DeleteBatch", start, err)
+	return res, err
+}
+
+func (s *Store) RestoreBatch(ctx context.Context, userID string, shortIDs []string) ([]string, error) {
+	start := time.Now()
+	res, err := s.Store.RestoreBatch(ctx, userID, shortIDs)
+	s.record("RestoreBatch", start, err)
+	return res, err
+}
+
+func (s *Store) UpdateURL(ctx context.Context, userID, shortID string, u *url.URL) error {
+	start := time.Now()
+	err := s.Store.UpdateURL(ctx, userID, shortID, u)
+	s.record("UpdateURL", start, err)
+	return err
+}
+
+func (s *Store) TransferOwner(ctx context.Context, fromUserID, shortID, toUserID string) error {
+	start := time.Now()
+	err := s.Store.TransferOwner(ctx, fromUserID, shortID, toUserID)
+	s.record("TransferOwner", start, err)
+	return err
+}
+
+func (s *Store) EraseUser(ctx context.Context, userID string) ([]string, error) {
+	start := time.Now()
+	res, err := s.Store.EraseUser(ctx, userID)
+	s.record("EraseUser", start, err)
+	return res, err
+}
+
+func (s *Store) ImportRecords(ctx context.Context, records []store.Record) error {
+	start := time.Now()
+	err := s.Store.ImportRecords(ctx, records)
+	s.record("ImportRecords", start, err)
+	return err
+}
+
+func (s *Store) ExportRecords(ctx context.Context, fn func(store.Record) error) error {
+	start := time.Now()
+	err := s.Store.ExportRecords(ctx, fn)
+	s.record("ExportRecords", start, err)
+	return err
+}
+
+func (s *Store) LoadOwner(ctx context.Context, shortID string) (string, error) {
+	start := time.Now()
+	userID, err := s.Store.LoadOwner(ctx, shortID)
+	s.record("LoadOwner", start, err)
+	return userID, err
+}
+
+func (s *Store) LoadMeta(ctx context.Context, shortID string) (store.LinkMeta, error) {
+	start := time.Now()
+	meta, err := s.Store.LoadMeta(ctx, shortID)
+	s.record("LoadMeta", start, err)
+	return meta, err
+}
+
+func (s *Store) SetMeta(ctx context.Context, userID, shortID string, meta store.LinkMeta) error {
+	start := time.Now()
+	err := s.Store.SetMeta(ctx, userID, shortID, meta)
+	s.record("SetMeta", start, err)
+	return err
+}
+
+func (s *Store) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := s.Store.Ping(ctx)
+	s.record("Ping", start, err)
+	return err
+}
@@ -0,0 +1,10 @@
+// Internal/upgrade/signal_other.go.
+
+//go:build !unix
+
+package upgrade
+
+import "os"
+
+// Notify ничего не делает: без unix-сокетов передавать нечего.
+func Notify(chan<- os.Signal) {}
@@ -0,0 +1,16 @@
+// Internal/upgrade/signal_unix.go.
+
+//go:build unix
+
+package upgrade
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Notify подписывает c на SIGUSR2 — сигнал передачи сокета новому процессу.
+func Notify(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR2)
+}
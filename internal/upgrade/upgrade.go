@@ -0,0 +1,121 @@
+// Internal/upgrade/upgrade.go.
+
+// Package upgrade передаёт слушающий сокет новому процессу, чтобы выкладка новой версии
+// не роняла соединения. Старый процесс по сигналу запускает новый бинарь с тем же
+// сокетом, ждёт, пока тот начнёт принимать запросы, и только потом уходит в обычный
+// graceful shutdown. До готовности нового процесса запросы обслуживает старый.
+package upgrade
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// Номера унаследованных дескрипторов передаются новому процессу через окружение.
+const (
+	envListenFD = "SHORTENER_LISTEN_FD"
+	envReadyFD  = "SHORTENER_READY_FD"
+)
+
+// ErrNotReady — новый процесс завершился или не успел начать работу; старый продолжает обслуживать запросы.
+var ErrNotReady = errors.New("new process did not become ready")
+
+// Listen возвращает сокет, унаследованный от предыдущего процесса, или слушает addr заново.
+func Listen(addr string) (net.Listener, error) {
+	fd, ok := inheritedFD(envListenFD)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	os.Unsetenv(envListenFD)
+	f := os.NewFile(fd, "listener")
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("inherit listener: %w", err)
+	}
+	return ln, nil
+}
+
+// Ready сообщает старому процессу, что новый принимает запросы. Без передачи сокета — no-op.
+func Ready() error {
+	fd, ok := inheritedFD(envReadyFD)
+	if !ok {
+		return nil
+	}
+	os.Unsetenv(envReadyFD)
+	f := os.NewFile(fd, "ready")
+	defer f.Close()
+	if _, err := f.Write([]byte{1}); err != nil {
+		return fmt.Errorf("report readiness: %w", err)
+	}
+	return nil
+}
+
+// Upgrade запускает текущий бинарь с теми же аргументами и сокетом ln и ждёт его Ready
+// не дольше timeout. Вернул nil — новый процесс работает и старому пора остановиться.
+func Upgrade(ln net.Listener, timeout time.Duration) error {
+	fl, ok := ln.(interface{ File() (*os.File, error) })
+	if !ok {
+		return fmt.Errorf("listener %T cannot be passed to another process", ln)
+	}
+	lnFile, err := fl.File()
+	if err != nil {
+		return fmt.Errorf("listener file: %w", err)
+	}
+	defer lnFile.Close()
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("ready pipe: %w", err)
+	}
+	defer readyR.Close()
+
+	// ExtraFiles[i] становится дескриптором 3+i.
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{lnFile, readyW}
+	cmd.Env = append(os.Environ(), envListenFD+"=3", envReadyFD+"=4")
+	startErr := cmd.Start()
+	readyW.Close()
+	if startErr != nil {
+		return fmt.Errorf("start new process: %w", startErr)
+	}
+
+	// Ready пишет в трубу один байт; EOF без него значит, что процесс завершился раньше.
+	ready := make(chan bool, 1)
+	go func() {
+		_, readErr := io.ReadFull(readyR, make([]byte, 1))
+		ready <- readErr == nil
+	}()
+	go func() { _ = cmd.Wait() }()
+
+	select {
+	case ok := <-ready:
+		if !ok {
+			return fmt.Errorf("%w: process exited", ErrNotReady)
+		}
+		return nil
+	case <-time.After(timeout):
+		_ = cmd.Process.Kill()
+		return fmt.Errorf("%w within %s", ErrNotReady, timeout)
+	}
+}
+
+func inheritedFD(env string) (uintptr, bool) {
+	v, ok := os.LookupEnv(env)
+	if !ok {
+		return 0, false
+	}
+	fd, err := strconv.ParseUint(v, 10, 0)
+	if err != nil {
+		return 0, false
+	}
+	return uintptr(fd), true
+}
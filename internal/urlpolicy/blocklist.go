@@ -0,0 +1,74 @@
+// Internal/urlpolicy/blocklist.go.
+
+package urlpolicy
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// domainList — набор шаблонов доменов. "example.com" совпадает с доменом и его
+// поддоменами, шаблоны со звёздочкой ("*.example.*") сверяются через path.Match.
+type domainList []string
+
+func (l domainList) match(host string) bool {
+	for _, pattern := range l {
+		if strings.Contains(pattern, "*") {
+			if ok, _ := path.Match(pattern, host); ok {
+				return true
+			}
+			continue
+		}
+		if host == pattern || strings.HasSuffix(host, "."+pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadDomainList собирает шаблоны из списка через запятую и из файла (по одному
+// на строку, # — комментарий).
+func loadDomainList(csv, filePath string) (domainList, error) {
+	var l domainList
+	for _, pattern := range strings.Split(csv, ",") {
+		l = l.add(pattern)
+	}
+	if filePath == "" {
+		return l, nil
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("open domain list: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		l = l.add(line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read domain list %s: %w", filePath, err)
+	}
+	return l, nil
+}
+
+func (l domainList) add(pattern string) domainList {
+	pattern = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(pattern), "."))
+	if pattern == "" {
+		return l
+	}
+	// Домены в списке тоже приводим к punycode, чтобы "пример.рф" ловил xn--e1afmkfd.xn--p1ai.
+	if !strings.Contains(pattern, "*") {
+		if ascii, err := idna.Lookup.ToASCII(pattern); err == nil {
+			pattern = strings.ToLower(ascii)
+		}
+	}
+	return append(l, pattern)
+}
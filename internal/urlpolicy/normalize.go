@@ -0,0 +1,103 @@
+// Internal/urlpolicy/normalize.go.
+
+package urlpolicy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// ErrInvalidHost — имя хоста не проходит правила IDNA.
+var ErrInvalidHost = errors.New("invalid host name")
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// normalize приводит URL к каноничному виду (RFC 3986, 6.2.2–6.2.3), чтобы
+// https://Example.com:443/a/../b/ и https://example.com/b/ давали одну короткую ссылку.
+func normalize(u *url.URL, trailingSlash string) (*url.URL, error) {
+	n := *u
+	n.Scheme = strings.ToLower(n.Scheme)
+
+	host, err := asciiHost(n.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	port := n.Port()
+	switch {
+	case port != "" && port != defaultPorts[n.Scheme]:
+		n.Host = net.JoinHostPort(host, port)
+	case strings.Contains(host, ":"):
+		n.Host = "[" + host + "]"
+	default:
+		n.Host = host
+	}
+
+	// ResolveReference на абсолютной ссылке только убирает "." и "..".
+	n = *n.ResolveReference(&n)
+	if n.Path == "" {
+		n.Path, n.RawPath = "/", ""
+	}
+	switch trailingSlash {
+	case TrailingSlashStrip:
+		if n.Path != "/" && strings.HasSuffix(n.Path, "/") {
+			n.Path = strings.TrimRight(n.Path, "/")
+			n.RawPath = ""
+		}
+	case TrailingSlashAdd:
+		if !strings.HasSuffix(n.Path, "/") {
+			n.Path += "/"
+			n.RawPath = ""
+		}
+	}
+	n.ForceQuery = false
+	return &n, nil
+}
+
+// asciiHost переводит имя хоста в нижний регистр и punycode (IDNA 2008):
+// в хранилище и во всех проверках домены живут только в ASCII-форме.
+func asciiHost(host string) (string, error) {
+	host = strings.TrimSuffix(host, ".")
+	if net.ParseIP(host) != nil {
+		return strings.ToLower(host), nil
+	}
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidHost, err)
+	}
+	return strings.ToLower(ascii), nil
+}
+
+// DisplayURL показывает сохранённый URL с доменом в Unicode — для списков,
+// которые видит пользователь. Редиректы по-прежнему идут на ASCII-форму.
+func DisplayURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || !strings.Contains(u.Host, "xn--") {
+		return raw
+	}
+	host, err := idna.Display.ToUnicode(u.Hostname())
+	if err != nil {
+		return raw
+	}
+	if port := u.Port(); port != "" {
+		host += ":" + port
+	}
+
+	var b strings.Builder
+	b.WriteString(u.Scheme + "://")
+	if u.User != nil {
+		b.WriteString(u.User.String() + "@")
+	}
+	b.WriteString(host)
+	rest := *u
+	rest.Scheme, rest.User, rest.Host = "", nil, ""
+	b.WriteString(rest.String())
+	return b.String()
+}
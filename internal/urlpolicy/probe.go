@@ -0,0 +1,119 @@
+// Internal/urlpolicy/probe.go.
+
+package urlpolicy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+const maxProbeRedirects = 5
+
+var (
+	// ErrUnreachable — назначение не отвечает или отвечает ошибкой.
+	ErrUnreachable = errors.New("destination is unreachable")
+	// ErrPrivateAddress — назначение указывает во внутреннюю сеть.
+	ErrPrivateAddress = errors.New("destination resolves to a private address")
+)
+
+// prober делает короткий HEAD (или GET, если HEAD не поддержан) к назначению.
+// Подключение к приватным, loopback и link-local адресам запрещено на уровне
+// dialer, поэтому ни DNS, ни редиректы не уводят запрос во внутреннюю сеть.
+type prober struct {
+	client *http.Client
+}
+
+func newProber(timeout time.Duration) *prober {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+				return ErrPrivateAddress
+			}
+			return nil
+		},
+	}
+	transport := &http.Transport{
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		DisableKeepAlives:     true,
+	}
+	return &prober{client: &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxProbeRedirects {
+				return fmt.Errorf("%w: too many redirects", ErrUnreachable)
+			}
+			for _, prev := range via {
+				if prev.URL.String() == req.URL.String() {
+					return fmt.Errorf("%w: redirect loop", ErrUnreachable)
+				}
+			}
+			return nil
+		},
+	}}
+}
+
+func (p *prober) probe(ctx context.Context, u *url.URL) error {
+	status, err := p.do(ctx, http.MethodHead, u)
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		status, err = p.do(ctx, http.MethodGet, u)
+	}
+	if err != nil {
+		if errors.Is(err, ErrPrivateAddress) {
+			return ErrPrivateAddress
+		}
+		if errors.Is(err, ErrUnreachable) {
+			return err
+		}
+		return fmt.Errorf("%w: %v", ErrUnreachable, err)
+	}
+	if isDeadStatus(status) {
+		return fmt.Errorf("%w: status %d", ErrUnreachable, status)
+	}
+	return nil
+}
+
+func (p *prober) do(ctx context.Context, method string, u *url.URL) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), http.NoBody)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "shortener-link-check/1.0")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// isDeadStatus: 401/403/429 значат, что ресурс есть, но закрыт, — такие ссылки пропускаем.
+func isDeadStatus(status int) bool {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+		return false
+	}
+	return status >= http.StatusBadRequest
+}
+
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		// 100.64.0.0/10 — carrier-grade NAT, часто внутренняя сеть облака.
+		(ip.To4() != nil && ip.To4()[0] == 100 && ip.To4()[1]&0xC0 == 64)
+}
@@ -0,0 +1,85 @@
+// Internal/urlpolicy/title.go.
+
+package urlpolicy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	// maxTitleBody — <title> ищем только в начале страницы.
+	maxTitleBody = 256 << 10
+	maxTitleLen  = 200
+)
+
+// TitleFetcher достаёт <title> страницы назначения. Клиент тот же, что у проверки
+// доступности, так что во внутреннюю сеть запрос не уйдёт.
+type TitleFetcher struct {
+	client *http.Client
+}
+
+func NewTitleFetcher(timeout time.Duration) *TitleFetcher {
+	return &TitleFetcher{client: newProber(timeout).client}
+}
+
+// Fetch возвращает заголовок страницы или пустую строку, если это не HTML или заголовка нет.
+func (f *TitleFetcher) Fetch(ctx context.Context, u *url.URL) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), http.NoBody)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "shortener-link-check/1.0")
+	req.Header.Set("Accept", "text/html")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fetch title: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return "", fmt.Errorf("fetch title: status %d", resp.StatusCode)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/html" {
+		return "", nil
+	}
+	return parseTitle(io.LimitReader(resp.Body, maxTitleBody)), nil
+}
+
+func parseTitle(r io.Reader) string {
+	z := html.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return ""
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			if atom.Lookup(name) == atom.Body {
+				return ""
+			}
+			if atom.Lookup(name) != atom.Title || z.Next() != html.TextToken {
+				continue
+			}
+			return cleanTitle(string(z.Text()))
+		}
+	}
+}
+
+// cleanTitle схлопывает пробелы и обрезает заголовок до maxTitleLen символов.
+func cleanTitle(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) <= maxTitleLen {
+		return s
+	}
+	return string([]rune(s)[:maxTitleLen])
+}
@@ -0,0 +1,38 @@
+// Internal/urlpolicy/tracking.go.
+
+package urlpolicy
+
+import (
+	"net/url"
+	"strings"
+)
+
+var trackingParams = map[string]bool{
+	"gclid":  true,
+	"fbclid": true,
+}
+
+func isTrackingParam(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasPrefix(name, "utm_") || trackingParams[name]
+}
+
+// stripTracking убирает из запроса рекламные метки (utm_*, gclid, fbclid).
+// Порядок остальных параметров не меняется.
+func stripTracking(u *url.URL) {
+	if u.RawQuery == "" {
+		return
+	}
+	parts := strings.Split(u.RawQuery, "&")
+	kept := parts[:0]
+	for _, part := range parts {
+		name, _, _ := strings.Cut(part, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if !isTrackingParam(name) {
+			kept = append(kept, part)
+		}
+	}
+	u.RawQuery = strings.Join(kept, "&")
+}
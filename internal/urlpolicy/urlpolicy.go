@@ -0,0 +1,90 @@
+// Internal/urlpolicy/urlpolicy.go.
+
+// Package urlpolicy приводит и проверяет ссылки перед сохранением.
+// Все эндпоинты создания ссылок пропускают URL через Policy.Apply.
+package urlpolicy
+
+import (
+	"context"
+	"errors"
+	"net/url"
+
+	"github.com/dkolesni-prog/transformer/internal/config"
+)
+
+// Политики завершающего слэша в пути.
+const (
+	TrailingSlashKeep  = "keep"
+	TrailingSlashStrip = "strip"
+	TrailingSlashAdd   = "add"
+)
+
+var (
+	// ErrBlocked — домен назначения запрещён оператором.
+	ErrBlocked = errors.New("destination is blocked")
+	// ErrNotAllowed — включён режим allowlist, а домена в нём нет.
+	ErrNotAllowed = errors.New("destination is not allowed")
+	// ErrTooLong — URL длиннее настроенного лимита.
+	ErrTooLong = errors.New("URL is too long")
+)
+
+type Policy struct {
+	maxLength     int
+	trailingSlash string
+	stripTracking bool
+	blocked       domainList
+	// allowed — если не пуст, сокращать можно только эти домены.
+	allowed domainList
+	// prober — проверка доступности назначения; nil, если выключена.
+	prober *prober
+}
+
+func New(cfg *config.Config) (*Policy, error) {
+	blocked, err := loadDomainList(cfg.BlockedDomains, cfg.BlocklistFile)
+	if err != nil {
+		return nil, err
+	}
+	allowed, err := loadDomainList(cfg.AllowedDomains, cfg.AllowlistFile)
+	if err != nil {
+		return nil, err
+	}
+	p := &Policy{
+		maxLength:     cfg.MaxURLLength,
+		trailingSlash: cfg.URLTrailingSlash,
+		stripTracking: cfg.StripTrackingParams,
+		blocked:       blocked,
+		allowed:       allowed,
+	}
+	if cfg.ProbeDestinations {
+		p.prober = newProber(cfg.ProbeTimeout)
+	}
+	return p, nil
+}
+
+// Apply возвращает нормализованную копию u, ErrTooLong, ErrBlocked или ErrNotAllowed.
+// При включённой проверке доступности ещё и ErrUnreachable или ErrPrivateAddress.
+func (p *Policy) Apply(ctx context.Context, u *url.URL) (*url.URL, error) {
+	n, err := normalize(u, p.trailingSlash)
+	if err != nil {
+		return nil, err
+	}
+	if p.blocked.match(n.Hostname()) {
+		return nil, ErrBlocked
+	}
+	if len(p.allowed) > 0 && !p.allowed.match(n.Hostname()) {
+		return nil, ErrNotAllowed
+	}
+	if p.stripTracking {
+		stripTracking(n)
+	}
+	// Длину меряем после нормализации: punycode и экранирование её меняют.
+	if p.maxLength > 0 && len(n.String()) > p.maxLength {
+		return nil, ErrTooLong
+	}
+	if p.prober != nil {
+		if err := p.prober.probe(ctx, n); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,87 @@
+// Internal/webhook/relay.go.
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dkolesni-prog/transformer/internal/logging"
+	"github.com/dkolesni-prog/transformer/internal/store"
+)
+
+const (
+	// relayInterval — как часто Relay заглядывает в outbox.
+	relayInterval = time.Second
+	// relayBatch — сколько событий забирается из outbox за раз.
+	relayBatch = 100
+)
+
+// Outbox — события, записанные в одной транзакции с изменением ссылки (store.RDB).
+type Outbox interface {
+	DrainOutbox(ctx context.Context, limit int, fn func([]store.Event) error) (int, error)
+}
+
+// Relay переносит события из outbox в очередь доставки Dispatcher. Событие удаляется из
+// outbox только после постановки в очередь, поэтому после сбоя оно может уйти повторно.
+type Relay struct {
+	dispatcher *Dispatcher
+	sources    []Outbox
+	logger     logging.Logger
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// StartRelay запускает по горутине на каждый outbox.
+func StartRelay(d *Dispatcher, sources []Outbox, logger logging.Logger) *Relay {
+	r := &Relay{dispatcher: d, sources: sources, logger: logger}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	for _, src := range sources {
+		r.wg.Add(1)
+		go r.loop(src)
+	}
+	return r
+}
+
+// Stop останавливает перенос и ждёт текущий проход.
+func (r *Relay) Stop() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+func (r *Relay) loop(src Outbox) {
+	defer r.wg.Done()
+	ticker := time.NewTicker(relayInterval)
+	defer ticker.Stop()
+	for {
+		if err := r.drain(r.ctx, src); err != nil && r.ctx.Err() == nil {
+			r.logger.Error("Could not relay outbox events", "error", err)
+		}
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// drain переносит из src всё, что там накопилось.
+func (r *Relay) drain(ctx context.Context, src Outbox) error {
+	for {
+		n, err := src.DrainOutbox(ctx, relayBatch, func(events []store.Event) error {
+			for _, e := range events {
+				if err := r.dispatcher.enqueue(ctx, e); err != nil {
+					return fmt.Errorf("queue %s for %s: %w", e.Type, e.ShortID, err)
+				}
+			}
+			return nil
+		})
+		if err != nil || n < relayBatch {
+			return err
+		}
+	}
+}
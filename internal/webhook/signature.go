@@ -0,0 +1,67 @@
+// Internal/webhook/signature.go.
+
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	signatureHeader = "X-Signature"
+	// timestampHeader — unix-время отправки в секундах; подписывается вместе с телом.
+	timestampHeader = "X-Signature-Timestamp"
+	// DefaultTolerance — насколько старую подпись Verify ещё принимает.
+	DefaultTolerance = 5 * time.Minute
+)
+
+var (
+	ErrNoSignature  = errors.New("webhook signature is missing")
+	ErrBadSignature = errors.New("webhook signature does not match")
+	// ErrStale — подпись верна, но отправлена слишком давно (или из будущего): возможен повтор.
+	ErrStale = errors.New("webhook signature timestamp is out of tolerance")
+)
+
+// Sign возвращает значение X-Signature: HMAC-SHA256 строки "<timestamp>.<body>".
+// Метка времени входит в подпись, поэтому перехваченный запрос нельзя повторить позже.
+func Sign(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	_, _ = mac.Write([]byte{'.'})
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify проверяет подпись тела webhook на стороне получателя. tolerance <= 0 — DefaultTolerance.
+func Verify(secret []byte, header http.Header, body []byte, tolerance time.Duration, now time.Time) error {
+	signature, rawTS := header.Get(signatureHeader), header.Get(timestampHeader)
+	if signature == "" || rawTS == "" {
+		return ErrNoSignature
+	}
+	ts, err := strconv.ParseInt(rawTS, 10, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+	if !hmac.Equal([]byte(signature), []byte(Sign(secret, ts, body))) {
+		return ErrBadSignature
+	}
+	if tolerance <= 0 {
+		tolerance = DefaultTolerance
+	}
+	if age := now.Sub(time.Unix(ts, 0)); age > tolerance || age < -tolerance {
+		return ErrStale
+	}
+	return nil
+}
+
+// signRequest подписывает исходящий запрос текущим временем: у каждой попытки доставки своя метка.
+func signRequest(req *http.Request, secret, body []byte) {
+	ts := time.Now().Unix()
+	req.Header.Set(timestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(signatureHeader, Sign(secret, ts, body))
+}
@@ -0,0 +1,86 @@
+// Internal/webhook/store.go.
+
+package webhook
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/dkolesni-prog/transformer/internal/config"
+	"github.com/dkolesni-prog/transformer/internal/store"
+)
+
+// Store оборачивает store.Store и публикует события жизненного цикла ссылок после
+// изменения. Для store.RDB вместо него используется outbox (см. Relay): там событие не
+// теряется, если процесс упадёт между изменением и публикацией.
+type Store struct {
+	store.Store
+	dispatcher *Dispatcher
+}
+
+func NewStore(s store.Store, d *Dispatcher) *Store {
+	return &Store{Store: s, dispatcher: d}
+}
+
+func (s *Store) Save(ctx context.Context, userID string, u *url.URL, meta store.LinkMeta, cfg *config.Config) (string, error) {
+	res, err := s.Store.Save(ctx, userID, u, meta, cfg)
+	if err == nil {
+		s.dispatcher.Publish(store.NewEvent(EventCreated, userID, store.ShortIDFromURL(res, cfg.BaseURL), u.String()))
+	}
+	return res, err
+}
+
+func (s *Store) SaveBatch(ctx context.Context, userID string, urls []*url.URL, metas []store.LinkMeta, cfg *config.Config) ([]store.SavedURL, error) {
+	res, err := s.Store.SaveBatch(ctx, userID, urls, metas, cfg)
+	if err == nil {
+		for i, saved := range res {
+			if saved.Existing {
+				continue
+			}
+			s.dispatcher.Publish(store.NewEvent(EventCreated, userID, store.ShortIDFromURL(saved.ShortURL, cfg.BaseURL), urls[i].String()))
+		}
+	}
+	return res, err
+}
+
+func (s *Store) DeleteBatch(ctx context.Context, userID string, shortIDs []string) ([]store.DeleteResult, error) {
+	results, err := s.Store.DeleteBatch(ctx, userID, shortIDs)
+	for _, res := range results {
+		if res.Status == store.Deleted {
+			s.dispatcher.Publish(store.NewEvent(store.DeleteEvent(ctx), userID, res.ShortID, ""))
+		}
+	}
+	return results, err
+}
+
+func (s *Store) RestoreBatch(ctx context.Context, userID string, shortIDs []string) ([]string, error) {
+	restored, err := s.Store.RestoreBatch(ctx, userID, shortIDs)
+	for _, sid := range restored {
+		s.dispatcher.Publish(store.NewEvent(EventRestored, userID, sid, ""))
+	}
+	return restored, err
+}
+
+func (s *Store) UpdateURL(ctx context.Context, userID, shortID string, u *url.URL) error {
+	err := s.Store.UpdateURL(ctx, userID, shortID, u)
+	if err == nil {
+		s.dispatcher.Publish(store.NewEvent(EventUpdated, userID, shortID, u.String()))
+	}
+	return err
+}
+
+func (s *Store) TransferOwner(ctx context.Context, fromUserID, shortID, toUserID string) error {
+	err := s.Store.TransferOwner(ctx, fromUserID, shortID, toUserID)
+	if err == nil {
+		s.dispatcher.Publish(store.NewEvent(EventTransferred, toUserID, shortID, ""))
+	}
+	return err
+}
+
+func (s *Store) EraseUser(ctx context.Context, userID string) ([]string, error) {
+	erased, err := s.Store.EraseUser(ctx, userID)
+	for _, sid := range erased {
+		s.dispatcher.Publish(store.NewEvent(EventErased, "", sid, ""))
+	}
+	return erased, err
+}
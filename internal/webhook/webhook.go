@@ -0,0 +1,154 @@
+// Internal/webhook/webhook.go.
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dkolesni-prog/transformer/internal/jobs"
+	"github.com/dkolesni-prog/transformer/internal/logging"
+	"github.com/dkolesni-prog/transformer/internal/store"
+)
+
+const (
+	EventCreated     = store.EventCreated
+	EventDeleted     = store.EventDeleted
+	EventUpdated     = store.EventUpdated
+	EventTransferred = store.EventTransferred
+	EventRestored    = store.EventRestored
+	EventErased      = store.EventErased
+	EventExpired     = store.EventExpired
+
+	// maxBackoff — больше этого между повторами доставки не ждём.
+	maxBackoff = time.Hour
+	// JobKind — задания доставки в очереди jobs.
+	JobKind = "webhook"
+	// enqueueTimeout ограничивает постановку доставки в очередь из запроса.
+	enqueueTimeout = 5 * time.Second
+)
+
+// Event — полезная нагрузка, уходящая на webhook.
+type Event = store.Event
+
+// Dispatcher доставляет события на все настроенные URL через очередь заданий:
+// доставка на каждый URL — отдельное задание с повторами, переживающее перезапуск.
+// Тело подписывается секретом своего URL (см. Sign).
+type Dispatcher struct {
+	urls []string
+	// secrets — секрет каждого URL; секреты не попадают в задания, а берутся при доставке.
+	secrets map[string][]byte
+	secret  []byte
+	client  *http.Client
+	runner  *jobs.Runner
+	logger  logging.Logger
+}
+
+// delivery — полезная нагрузка задания JobKind.
+type delivery struct {
+	URL   string `json:"url"`
+	Event Event  `json:"event"`
+}
+
+// NewDispatcher разбирает список URL через запятую и регистрирует доставку в runner с
+// повторами по retry; пустой список — nil. Элемент списка "url|секрет" задаёт свой секрет
+// подписи URL, без него используется secret ("|" в URL должен быть экранирован как %7C).
+// Доставка, исчерпавшая попытки, остаётся в очереди проваленной: её видно через
+// jobs.Runner.Failed и можно повторить через Requeue.
+func NewDispatcher(rawURLs, secret string, retry jobs.Retry, runner *jobs.Runner, logger logging.Logger) *Dispatcher {
+	var urls []string
+	secrets := make(map[string][]byte)
+	for _, item := range strings.Split(rawURLs, ",") {
+		u, own, hasOwn := strings.Cut(strings.TrimSpace(item), "|")
+		if u == "" {
+			continue
+		}
+		urls = append(urls, u)
+		if hasOwn {
+			secrets[u] = []byte(own)
+		}
+	}
+	if len(urls) == 0 {
+		return nil
+	}
+	d := &Dispatcher{
+		urls:    urls,
+		secrets: secrets,
+		secret:  []byte(secret),
+		client:  &http.Client{Timeout: 5 * time.Second},
+		runner:  runner,
+		logger:  logger,
+	}
+	if retry.MaxBackoff <= 0 {
+		retry.MaxBackoff = maxBackoff
+	}
+	runner.HandleRetry(JobKind, retry, d.deliver)
+	return d
+}
+
+// Publish ставит доставку события в очередь. Ошибка очереди только логируется:
+// изменение ссылки уже сделано и из-за webhook не откатывается.
+func (d *Dispatcher) Publish(e Event) {
+	ctx, cancel := context.WithTimeout(context.Background(), enqueueTimeout)
+	defer cancel()
+	if err := d.enqueue(ctx, e); err != nil {
+		d.logger.Error("Could not queue webhook delivery", "error", err, "event", e.Type, "short_id", e.ShortID)
+	}
+}
+
+// enqueue ставит доставку e на каждый URL.
+func (d *Dispatcher) enqueue(ctx context.Context, e Event) error {
+	for _, target := range d.urls {
+		if _, err := d.runner.Enqueue(ctx, JobKind, "", delivery{URL: target, Event: e}); err != nil {
+			return fmt.Errorf("%s: %w", target, err)
+		}
+	}
+	return nil
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, job jobs.Job) (any, error) {
+	var p delivery
+	if err := json.Unmarshal(job.Payload, &p); err != nil {
+		return nil, fmt.Errorf("decode webhook delivery: %w", err)
+	}
+	body, err := json.Marshal(p.Event)
+	if err != nil {
+		return nil, fmt.Errorf("marshal webhook event: %w", err)
+	}
+	return nil, d.post(ctx, p.URL, body)
+}
+
+func (d *Dispatcher) post(ctx context.Context, target string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, d.client.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("build webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signRequest(req, d.secretFor(target), body)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post webhook: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// secretFor — секрет target; для URL, убранного из настроек после постановки доставки, — общий.
+func (d *Dispatcher) secretFor(target string) []byte {
+	if own, ok := d.secrets[target]; ok {
+		return own
+	}
+	return d.secret
+}